@@ -0,0 +1,124 @@
+package sdwire
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+// AuditRecord describes one operation performed on a device.
+type AuditRecord struct {
+	Time     time.Time `json:"time"`
+	Op       string    `json:"op"`
+	Serial   string    `json:"serial"`
+	PortPath string    `json:"port_path,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Actor    string    `json:"actor,omitempty"`
+	// Detail holds operation arguments, e.g. the target mode or image path.
+	Detail string `json:"detail,omitempty"`
+	// Result is "ok" or "error".
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// AuditSink stores audit records. Implementations must be safe for
+// concurrent use.
+type AuditSink interface {
+	Record(AuditRecord) error
+}
+
+// AuditFile appends audit records to a file as JSON lines.
+type AuditFile struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenAuditFile opens path for appending, creating it if needed.
+func OpenAuditFile(path string) (*AuditFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &AuditFile{file: f}, nil
+}
+
+// Record appends r and syncs the file, so records survive a crash.
+func (a *AuditFile) Record(r AuditRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// A single write with O_APPEND keeps lines from several processes intact.
+	if _, err := a.file.Write(line); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return a.file.Sync()
+}
+
+// Close closes the file.
+func (a *AuditFile) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+var (
+	auditMu   sync.RWMutex
+	auditSink AuditSink
+)
+
+// SetAuditSink sets the sink used by devices opened without WithAudit.
+// Pass nil to disable auditing, which is the default.
+func SetAuditSink(sink AuditSink) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditSink = sink
+}
+
+// packageAuditSink returns the sink installed with SetAuditSink.
+func packageAuditSink() AuditSink {
+	auditMu.RLock()
+	defer auditMu.RUnlock()
+	return auditSink
+}
+
+// defaultActor is the login name of the current user.
+var defaultActor = sync.OnceValue(func() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+})
+
+// Audit records an operation performed on the device, such as a flash done
+// by a higher-level package. Mode switches are recorded automatically.
+// Failures to write the record are logged and otherwise ignored.
+func (s *SDWire) Audit(op, detail string, opErr error) {
+	if s == nil || s.audit == nil {
+		return
+	}
+	r := AuditRecord{
+		Time:     time.Now().UTC(),
+		Op:       op,
+		Serial:   s.serial,
+		PortPath: s.portPath,
+		Name:     s.identity.Name,
+		Actor:    s.actor,
+		Detail:   detail,
+		Result:   "ok",
+	}
+	if opErr != nil {
+		r.Result = "error"
+		r.Error = opErr.Error()
+	}
+	if err := s.audit.Record(r); err != nil {
+		s.log.Warn("failed to record audit entry", "op", op, "error", err)
+	}
+}
@@ -0,0 +1,263 @@
+// Package broker shares the devices attached to a lab host between
+// ephemeral CI jobs. Jobs claim a device matching a selector for a limited
+// time over a small HTTP API, renew the claim while they run and release it
+// when done; claims that are not renewed expire on their own.
+package broker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fcjr/sdwire"
+)
+
+// DefaultTTL is the claim lifetime used when a request does not specify one.
+const DefaultTTL = 30 * time.Minute
+
+// MaxTTL bounds the lifetime of a single claim or renewal.
+const MaxTTL = 24 * time.Hour
+
+// Claim is a time-limited reservation of one device.
+type Claim struct {
+	ID string `json:"id"`
+	// Device is the claimed device's sdwire.DeviceInfo.ID, which unlike
+	// the serial number is unique.
+	Device   string    `json:"device"`
+	Serial   string    `json:"serial"`
+	Name     string    `json:"name,omitempty"`
+	PortPath string    `json:"port_path"`
+	Holder   string    `json:"holder,omitempty"`
+	Expires  time.Time `json:"expires"`
+}
+
+// ClaimRequest asks for a device.
+type ClaimRequest struct {
+	// Selector restricts the claim to matching devices; see sdwire.Selector.
+	Selector string `json:"selector,omitempty"`
+	// TTL is the claim lifetime, e.g. "30m".
+	TTL string `json:"ttl,omitempty"`
+	// Holder describes the claimant, e.g. a CI job URL.
+	Holder string `json:"holder,omitempty"`
+}
+
+// RenewRequest extends a claim.
+type RenewRequest struct {
+	TTL string `json:"ttl,omitempty"`
+}
+
+var errClaimNotFound = sdwire.WithCode(sdwire.CodeNotFound, errors.New("claim not found or expired"))
+
+type errorResponse struct {
+	Error string `json:"error"`
+	// Code is the sdwire.ErrorCode name, e.g. "NOT_FOUND".
+	Code string `json:"code,omitempty"`
+}
+
+// Server is an http.Handler serving the broker API:
+//
+//	POST   /v1/claims            claim a device (ClaimRequest → Claim)
+//	GET    /v1/claims            list active claims
+//	POST   /v1/claims/{id}/renew extend a claim (RenewRequest → Claim)
+//	DELETE /v1/claims/{id}       release a claim
+//	GET    /healthz              liveness
+//	GET    /readyz               readiness (USB access and lock store)
+type Server struct {
+	// Devices lists the devices available for claiming.
+	// It defaults to sdwire.ListDevices.
+	Devices func() ([]*sdwire.DeviceInfo, error)
+	// LockDir is the device lock directory checked by /readyz.
+	// It defaults to sdwire.DefaultLockDir.
+	LockDir string
+	// Now returns the current time, against which claims expire.
+	// It defaults to time.Now.
+	Now func() time.Time
+
+	mux *http.ServeMux
+
+	mu     sync.Mutex
+	claims map[string]*Claim
+}
+
+// NewServer creates a broker serving the devices attached to this host.
+func NewServer() *Server {
+	s := &Server{
+		Devices: sdwire.ListDevices,
+		LockDir: sdwire.DefaultLockDir,
+		Now:     time.Now,
+		mux:     http.NewServeMux(),
+		claims:  make(map[string]*Claim),
+	}
+	s.mux.HandleFunc("POST /v1/claims", s.handleClaim)
+	s.mux.HandleFunc("GET /v1/claims", s.handleList)
+	s.mux.HandleFunc("POST /v1/claims/{id}/renew", s.handleRenew)
+	s.mux.HandleFunc("DELETE /v1/claims/{id}", s.handleRelease)
+	s.mux.HandleFunc("GET /healthz", s.handleHealthz)
+	s.mux.HandleFunc("GET /readyz", s.handleReadyz)
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleClaim(w http.ResponseWriter, r *http.Request) {
+	var req ClaimRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ttl, err := parseTTL(req.TTL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	sel, err := sdwire.ParseSelector(req.Selector)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	devices, err := s.Devices()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	candidates := sel.Filter(devices)
+	if len(candidates) == 0 {
+		writeError(w, http.StatusNotFound, sdwire.WithCode(sdwire.CodeNotFound, errors.New("no device matches the selector")))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	for _, info := range candidates {
+		if s.claimedLocked(info.ID) {
+			continue
+		}
+		c := &Claim{
+			ID:       newID(),
+			Device:   info.ID,
+			Serial:   info.Serial,
+			Name:     info.Name,
+			PortPath: info.PortPath,
+			Holder:   req.Holder,
+			Expires:  s.Now().Add(ttl),
+		}
+		s.claims[c.ID] = c
+		writeJSON(w, http.StatusOK, c)
+		return
+	}
+	writeError(w, http.StatusConflict, ErrUnavailable)
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	claims := make([]*Claim, 0, len(s.claims))
+	for _, c := range s.claims {
+		claims = append(claims, c)
+	}
+	sort.Slice(claims, func(i, j int) bool {
+		return claims[i].Expires.Before(claims[j].Expires)
+	})
+	writeJSON(w, http.StatusOK, claims)
+}
+
+func (s *Server) handleRenew(w http.ResponseWriter, r *http.Request) {
+	var req RenewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	ttl, err := parseTTL(req.TTL)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	c, ok := s.claims[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, errClaimNotFound)
+		return
+	}
+	c.Expires = s.Now().Add(ttl)
+	writeJSON(w, http.StatusOK, c)
+}
+
+func (s *Server) handleRelease(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	id := r.PathValue("id")
+	if _, ok := s.claims[id]; !ok {
+		writeError(w, http.StatusNotFound, errClaimNotFound)
+		return
+	}
+	delete(s.claims, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// expire drops claims past their expiry. Callers must hold s.mu.
+func (s *Server) expire() {
+	now := s.Now()
+	for id, c := range s.claims {
+		if now.After(c.Expires) {
+			delete(s.claims, id)
+		}
+	}
+}
+
+// claimedLocked reports whether a device is claimed. Callers must hold s.mu.
+func (s *Server) claimedLocked(device string) bool {
+	for _, c := range s.claims {
+		if c.Device == device {
+			return true
+		}
+	}
+	return false
+}
+
+func parseTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return DefaultTTL, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 || d > MaxTTL {
+		return 0, sdwire.WithCode(sdwire.CodeInvalidArgument, errors.New("ttl out of range"))
+	}
+	return d, nil
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	code := sdwire.CodeOf(err)
+	if code == sdwire.CodeUnknown && status == http.StatusBadRequest {
+		code = sdwire.CodeInvalidArgument
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error(), Code: code.String()})
+}
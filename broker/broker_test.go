@@ -0,0 +1,184 @@
+package broker_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/broker"
+)
+
+// clock is a manually advanced time source for Server.Now.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func testDevices() ([]*sdwire.DeviceInfo, error) {
+	return []*sdwire.DeviceInfo{
+		{ID: "sdwire-1", Serial: "sdwire-1", PortPath: "1-1", Identity: sdwire.Identity{Rack: "1"}},
+		{ID: "sdwire-2", Serial: "sdwire-2", PortPath: "1-2", Identity: sdwire.Identity{Rack: "2"}},
+	}, nil
+}
+
+// step is one operation against the broker. Renew and release act on the
+// claim made by step claim, or on an unknown claim if claim is -1.
+type step struct {
+	op       string // claim, renew, release, list or advance
+	selector string
+	ttl      time.Duration
+	claim    int
+	d        time.Duration
+	// wantCode is the name of the expected error code, "" for success.
+	wantCode string
+	// wantClaims is the number of claims list should return.
+	wantClaims int
+}
+
+func TestClaims(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"double claim", []step{
+			{op: "claim", selector: "serial=sdwire-1"},
+			{op: "claim", selector: "serial=sdwire-1", wantCode: "BUSY"},
+			{op: "claim", selector: "rack=2"},
+		}},
+		{"claim every device", []step{
+			{op: "claim"},
+			{op: "claim"},
+			{op: "claim", wantCode: "BUSY"},
+			{op: "list", wantClaims: 2},
+		}},
+		{"no matching device", []step{
+			{op: "claim", selector: "rack=9", wantCode: "NOT_FOUND"},
+		}},
+		{"ttl out of range", []step{
+			{op: "claim", ttl: broker.MaxTTL + time.Hour, wantCode: "INVALID_ARGUMENT"},
+		}},
+		{"claim after expiry", []step{
+			{op: "claim", selector: "serial=sdwire-1", ttl: time.Minute},
+			{op: "advance", d: 2 * time.Minute},
+			{op: "list", wantClaims: 0},
+			{op: "claim", selector: "serial=sdwire-1"},
+		}},
+		{"renew before expiry", []step{
+			{op: "claim", selector: "serial=sdwire-1", ttl: time.Minute},
+			{op: "advance", d: 30 * time.Second},
+			{op: "renew", claim: 0, ttl: time.Minute},
+			{op: "advance", d: 45 * time.Second},
+			{op: "claim", selector: "serial=sdwire-1", wantCode: "BUSY"},
+		}},
+		{"renew after expiry", []step{
+			{op: "claim", ttl: time.Minute},
+			{op: "advance", d: 2 * time.Minute},
+			{op: "renew", claim: 0, ttl: time.Minute, wantCode: "NOT_FOUND"},
+		}},
+		{"renew unknown claim", []step{
+			{op: "renew", claim: -1, ttl: time.Minute, wantCode: "NOT_FOUND"},
+		}},
+		{"release and reclaim", []step{
+			{op: "claim", selector: "serial=sdwire-1"},
+			{op: "release", claim: 0},
+			{op: "list", wantClaims: 0},
+			{op: "claim", selector: "serial=sdwire-1"},
+		}},
+		{"double release", []step{
+			{op: "claim"},
+			{op: "release", claim: 0},
+			{op: "release", claim: 0, wantCode: "NOT_FOUND"},
+		}},
+		{"release unknown claim", []step{
+			{op: "release", claim: -1, wantCode: "NOT_FOUND"},
+		}},
+		{"release after expiry", []step{
+			{op: "claim", ttl: time.Minute},
+			{op: "advance", d: 2 * time.Minute},
+			{op: "release", claim: 0, wantCode: "NOT_FOUND"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := &clock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+			srv := broker.NewServer()
+			srv.Devices = testDevices
+			srv.Now = clk.Now
+			ts := httptest.NewServer(srv)
+			defer ts.Close()
+			c := &broker.Client{BaseURL: ts.URL}
+			ctx := context.Background()
+
+			var claims []*broker.Claim
+			claimID := func(i int) string {
+				if i < 0 {
+					return "unknown"
+				}
+				return claims[i].ID
+			}
+			for i, s := range tt.steps {
+				var err error
+				switch s.op {
+				case "advance":
+					clk.advance(s.d)
+					continue
+				case "claim":
+					req := broker.ClaimRequest{Selector: s.selector}
+					if s.ttl != 0 {
+						req.TTL = s.ttl.String()
+					}
+					var claim *broker.Claim
+					if claim, err = c.Claim(ctx, req); err == nil {
+						if want := clk.Now().Add(ttlOrDefault(s.ttl)); !claim.Expires.Equal(want) {
+							t.Errorf("step %d: claim expires %v, want %v", i, claim.Expires, want)
+						}
+						claims = append(claims, claim)
+					}
+				case "renew":
+					var claim *broker.Claim
+					if claim, err = c.Renew(ctx, claimID(s.claim), s.ttl); err == nil {
+						if want := clk.Now().Add(s.ttl); !claim.Expires.Equal(want) {
+							t.Errorf("step %d: renewed claim expires %v, want %v", i, claim.Expires, want)
+						}
+					}
+				case "release":
+					err = c.Release(ctx, claimID(s.claim))
+				case "list":
+					var list []broker.Claim
+					if list, err = c.Claims(ctx); err == nil && len(list) != s.wantClaims {
+						t.Errorf("step %d: %d claims, want %d", i, len(list), s.wantClaims)
+					}
+				}
+
+				switch {
+				case s.wantCode == "" && err != nil:
+					t.Fatalf("step %d (%s): %v", i, s.op, err)
+				case s.wantCode != "" && sdwire.CodeOf(err).String() != s.wantCode:
+					t.Fatalf("step %d (%s): error %v, want code %s", i, s.op, err, s.wantCode)
+				}
+			}
+		})
+	}
+}
+
+func ttlOrDefault(ttl time.Duration) time.Duration {
+	if ttl == 0 {
+		return broker.DefaultTTL
+	}
+	return ttl
+}
@@ -0,0 +1,112 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fcjr/sdwire"
+)
+
+// ErrUnavailable is returned by Claim when all matching devices are claimed.
+var ErrUnavailable = sdwire.WithCode(sdwire.CodeBusy, errors.New("all matching devices are claimed"))
+
+// Client talks to a broker Server.
+type Client struct {
+	// BaseURL is the broker address, e.g. "http://lab-host:8420".
+	BaseURL string
+	// HTTP is used for requests; nil means http.DefaultClient.
+	HTTP *http.Client
+}
+
+// Claim reserves a device.
+func (c *Client) Claim(ctx context.Context, req ClaimRequest) (*Claim, error) {
+	var claim Claim
+	if err := c.do(ctx, http.MethodPost, "/v1/claims", req, &claim); err != nil {
+		return nil, err
+	}
+	return &claim, nil
+}
+
+// ClaimWait retries Claim every interval while devices are busy, until a
+// device is claimed or ctx is done.
+func (c *Client) ClaimWait(ctx context.Context, req ClaimRequest, interval time.Duration) (*Claim, error) {
+	for {
+		claim, err := c.Claim(ctx, req)
+		if !errors.Is(err, ErrUnavailable) {
+			return claim, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Renew extends a claim by ttl from now.
+func (c *Client) Renew(ctx context.Context, id string, ttl time.Duration) (*Claim, error) {
+	var claim Claim
+	req := RenewRequest{TTL: ttl.String()}
+	if err := c.do(ctx, http.MethodPost, "/v1/claims/"+url.PathEscape(id)+"/renew", req, &claim); err != nil {
+		return nil, err
+	}
+	return &claim, nil
+}
+
+// Release gives a claimed device back.
+func (c *Client) Release(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/claims/"+url.PathEscape(id), nil, nil)
+}
+
+// Claims lists the active claims.
+func (c *Client) Claims(ctx context.Context) ([]Claim, error) {
+	var claims []Claim
+	if err := c.do(ctx, http.MethodGet, "/v1/claims", nil, &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.BaseURL, "/")+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		var e errorResponse
+		json.NewDecoder(resp.Body).Decode(&e)
+		if resp.StatusCode == http.StatusConflict {
+			return ErrUnavailable
+		}
+		return sdwire.WithCode(sdwire.ParseErrorCode(e.Code), fmt.Errorf("broker: %s: %s", resp.Status, e.Error))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,88 @@
+package broker
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/fcjr/sdwire"
+)
+
+// livenessTimeout bounds how long /healthz waits for the claim table.
+const livenessTimeout = 2 * time.Second
+
+// Health is the body of /healthz and /readyz responses.
+type Health struct {
+	// Status is "ok" or "fail".
+	Status string `json:"status"`
+	// Checks maps each check to "ok" or the reason it failed.
+	Checks map[string]string `json:"checks"`
+	// Devices is the number of connected devices, reported by /readyz.
+	// Zero devices does not make the broker unready.
+	Devices *int `json:"devices,omitempty"`
+	// Claims is the number of active claims.
+	Claims int `json:"claims"`
+}
+
+// handleHealthz reports whether the broker is still serving, i.e. the claim
+// table is not wedged by a stuck request.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	h := Health{Status: "ok", Checks: make(map[string]string)}
+	if n, ok := s.claimCount(); ok {
+		h.Checks["claims"] = "ok"
+		h.Claims = n
+	} else {
+		h.Checks["claims"] = "claim table locked for over " + livenessTimeout.String()
+	}
+	writeHealth(w, h)
+}
+
+// handleReadyz reports whether the broker can serve claims: USB
+// enumeration works and device locks can be taken.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	h := Health{Status: "ok", Checks: make(map[string]string)}
+	if n, ok := s.claimCount(); ok {
+		h.Checks["claims"] = "ok"
+		h.Claims = n
+	} else {
+		h.Checks["claims"] = "claim table locked for over " + livenessTimeout.String()
+	}
+	if devices, err := s.Devices(); err != nil {
+		h.Checks["usb"] = err.Error()
+	} else {
+		h.Checks["usb"] = "ok"
+		n := len(devices)
+		h.Devices = &n
+	}
+	if err := sdwire.CheckLockDir(s.LockDir); err != nil {
+		h.Checks["locks"] = err.Error()
+	} else {
+		h.Checks["locks"] = "ok"
+	}
+	writeHealth(w, h)
+}
+
+// claimCount returns the number of active claims, giving up if the claim
+// table cannot be locked within livenessTimeout.
+func (s *Server) claimCount() (int, bool) {
+	deadline := time.Now().Add(livenessTimeout)
+	for !s.mu.TryLock() {
+		if time.Now().After(deadline) {
+			return 0, false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer s.mu.Unlock()
+	s.expire()
+	return len(s.claims), true
+}
+
+func writeHealth(w http.ResponseWriter, h Health) {
+	status := http.StatusOK
+	for _, result := range h.Checks {
+		if result != "ok" {
+			h.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, h)
+}
@@ -0,0 +1,39 @@
+package sdwire
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCardBusy is returned when switching the card away from the host while
+// it is in use there: a partition is mounted or a process has the block
+// device open. Switching away mid-write corrupts the card. Use WithForce
+// to switch anyway.
+var ErrCardBusy = errors.New("SD card is in use on the host")
+
+// cardBusy reports an error wrapping ErrCardBusy if the card's block
+// devices are in use on the host.
+func (s *SDWire) cardBusy() error {
+	devices, err := blockDevices(s.generation, s.portPath)
+	if err != nil || len(devices) == 0 {
+		// Without a block device there is nothing to protect.
+		s.log.Debug("skipping card busy check", "error", err)
+		return nil
+	}
+	use, err := blockDevicesInUse(devices)
+	if err != nil {
+		s.log.Debug("card busy check failed", "error", err)
+		return nil
+	}
+	if use != "" {
+		return WithCode(CodeBusy, fmt.Errorf("%w: %s", ErrCardBusy, use))
+	}
+	return nil
+}
+
+// BlockDevices returns the block devices, such as /dev/sdb and its
+// partitions, that the card appears as while switched to the host. It is
+// supported on Linux and FreeBSD and returns nil elsewhere.
+func (s *SDWire) BlockDevices() ([]string, error) {
+	return blockDevices(s.generation, s.portPath)
+}
@@ -0,0 +1,104 @@
+package sdwire
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// blockDevices finds the disks of the card reader belonging to a device
+// through CAM. umass instances are located in the device tree, and
+// camcontrol maps their SCSI buses to da disks. The SDWire3 is itself the
+// card reader; the SDWireC card reader sits next to the FTDI chip behind
+// the device's internal hub.
+func blockDevices(generation DeviceGeneration, portPath string) ([]string, error) {
+	match := func(path string) bool {
+		return path == portPath
+	}
+	if generation == GenerationSDWireC {
+		i := strings.LastIndex(portPath, ".")
+		if i < 0 {
+			return nil, nil
+		}
+		hub := portPath[:i]
+		match = func(path string) bool {
+			return strings.HasPrefix(path, hub+".") && path != portPath
+		}
+	}
+
+	attachments, err := usbAttachments()
+	if err != nil {
+		return nil, err
+	}
+	sims := make(map[string]bool)
+	for i, path := range portPaths(attachments) {
+		if a := attachments[i]; a.driver == "umass" && match(path) {
+			sims["umass-sim"+strconv.Itoa(a.unit)] = true
+		}
+	}
+	if len(sims) == 0 {
+		return nil, nil
+	}
+
+	out, err := exec.Command("camcontrol", "devlist", "-v").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list CAM devices: %w", err)
+	}
+	var devices []string
+	inSim := false
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		// scbus0 on umass-sim0 bus 0:
+		// <Generic- SD/MMC 1.00>   at scbus0 target 0 lun 0 (da0,pass0)
+		line := sc.Text()
+		if strings.HasPrefix(line, "scbus") {
+			fields := strings.Fields(line)
+			inSim = len(fields) >= 3 && sims[fields[2]]
+			continue
+		}
+		if !inSim {
+			continue
+		}
+		open, close := strings.LastIndex(line, "("), strings.LastIndex(line, ")")
+		if open < 0 || close < open {
+			continue
+		}
+		for _, periph := range strings.Split(line[open+1:close], ",") {
+			if !strings.HasPrefix(periph, "da") {
+				continue
+			}
+			devices = append(devices, "/dev/"+periph)
+			// Slices (da0s1) and GPT partitions (da0p1).
+			parts, _ := filepath.Glob("/dev/" + periph + "[sp]*")
+			devices = append(devices, parts...)
+		}
+	}
+	return devices, sc.Err()
+}
+
+// blockDevicesInUse describes how the given block devices are in use. Only
+// mounts are detected.
+func blockDevicesInUse(devices []string) (string, error) {
+	out, err := exec.Command("mount", "-p").Output()
+	if err != nil {
+		return "", err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		// Fields as in fstab: device, mount point, type, options, ...
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		for _, dev := range devices {
+			if fields[0] == dev {
+				return fmt.Sprintf("%s is mounted at %s", dev, fields[1]), nil
+			}
+		}
+	}
+	return "", sc.Err()
+}
@@ -0,0 +1,106 @@
+package sdwire
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// blockDevices finds the block devices of the card reader belonging to a
+// device through sysfs. The SDWire3 is itself the card reader; the SDWireC
+// card reader sits next to the FTDI chip behind the device's internal hub.
+func blockDevices(generation DeviceGeneration, portPath string) ([]string, error) {
+	match := func(component string) bool {
+		return component == portPath
+	}
+	if generation == GenerationSDWireC {
+		i := strings.LastIndex(portPath, ".")
+		if i < 0 {
+			return nil, nil
+		}
+		hub := portPath[:i]
+		match = func(component string) bool {
+			return strings.HasPrefix(component, hub+".") && !strings.Contains(component, ":") && component != portPath
+		}
+	}
+
+	entries, err := os.ReadDir("/sys/block")
+	if err != nil {
+		return nil, err
+	}
+	var devices []string
+	for _, e := range entries {
+		target, err := filepath.EvalSymlinks(filepath.Join("/sys/block", e.Name()))
+		if err != nil {
+			continue
+		}
+		found := false
+		for _, component := range strings.Split(target, "/") {
+			if match(component) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+		devices = append(devices, "/dev/"+e.Name())
+		parts, _ := filepath.Glob(filepath.Join("/sys/block", e.Name(), e.Name()+"*"))
+		for _, p := range parts {
+			devices = append(devices, "/dev/"+filepath.Base(p))
+		}
+	}
+	return devices, nil
+}
+
+// blockDevicesInUse describes how the given block devices are in use:
+// mounted, or held open by a process. It returns "" if they are not.
+func blockDevicesInUse(devices []string) (string, error) {
+	numbers := make(map[string]string, len(devices))
+	for _, dev := range devices {
+		data, err := os.ReadFile(filepath.Join("/sys/class/block", filepath.Base(dev), "dev"))
+		if err != nil {
+			continue
+		}
+		numbers[strings.TrimSpace(string(data))] = dev
+	}
+
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// Fields: mount ID, parent ID, major:minor, root, mount point, ...
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		if dev, ok := numbers[fields[2]]; ok {
+			return fmt.Sprintf("%s is mounted at %s", dev, fields[4]), nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+
+	// Processes of other users cannot be inspected without privileges, so
+	// this check is best effort.
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	for _, fd := range fds {
+		target, err := os.Readlink(fd)
+		if err != nil {
+			continue
+		}
+		for _, dev := range devices {
+			if target == dev {
+				pid := strings.Split(fd, "/")[2]
+				return fmt.Sprintf("%s is open in process %s", dev, pid), nil
+			}
+		}
+	}
+	return "", nil
+}
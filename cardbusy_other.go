@@ -0,0 +1,11 @@
+//go:build !linux && !freebsd
+
+package sdwire
+
+func blockDevices(DeviceGeneration, string) ([]string, error) {
+	return nil, nil
+}
+
+func blockDevicesInUse([]string) (string, error) {
+	return "", nil
+}
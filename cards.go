@@ -0,0 +1,218 @@
+package sdwire
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CardEntry labels a physical SD card, identified by its CID register.
+type CardEntry struct {
+	// CID is the card identification register in hex; see CardCID.
+	CID string `json:"cid"`
+	// Label is what the card is called in the lab, e.g. "SanDisk-17".
+	Label string `json:"label"`
+	// Notes holds free-form details, e.g. "purchased 2024-03".
+	Notes string `json:"notes,omitempty"`
+}
+
+// CardRegistry maps card CIDs to labels.
+type CardRegistry struct {
+	byCID map[string]CardEntry
+}
+
+// NewCardRegistry creates a card registry from the given entries. Every
+// entry must have a CID and a label, and CIDs must be unique.
+func NewCardRegistry(entries []CardEntry) (*CardRegistry, error) {
+	r := &CardRegistry{byCID: make(map[string]CardEntry, len(entries))}
+	for i, e := range entries {
+		e.CID = normalizeCID(e.CID)
+		switch {
+		case e.CID == "":
+			return nil, fmt.Errorf("card entry %d: missing cid", i)
+		case e.Label == "":
+			return nil, fmt.Errorf("card %s: missing label", e.CID)
+		}
+		if _, ok := r.byCID[e.CID]; ok {
+			return nil, fmt.Errorf("duplicate card %s", e.CID)
+		}
+		r.byCID[e.CID] = e
+	}
+	return r, nil
+}
+
+// ParseCardRegistry reads a card registry from JSON of the form:
+//
+//	{"cards": [{"cid": "035344534331364780b5a1c2d30138e1", "label": "SanDisk-17", "notes": "purchased 2024-03"}]}
+func ParseCardRegistry(rd io.Reader) (*CardRegistry, error) {
+	var doc struct {
+		Cards []CardEntry `json:"cards"`
+	}
+	if err := json.NewDecoder(rd).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse card registry: %w", err)
+	}
+	return NewCardRegistry(doc.Cards)
+}
+
+// LoadCardRegistry reads a card registry from a JSON file; see
+// ParseCardRegistry.
+func LoadCardRegistry(path string) (*CardRegistry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open card registry: %w", err)
+	}
+	defer f.Close()
+	return ParseCardRegistry(f)
+}
+
+// Lookup returns the entry for the card with the given CID. Cards not in
+// the registry get an entry with only the CID set.
+func (r *CardRegistry) Lookup(cid string) (CardEntry, bool) {
+	cid = normalizeCID(cid)
+	if r != nil {
+		if e, ok := r.byCID[cid]; ok {
+			return e, true
+		}
+	}
+	return CardEntry{CID: cid}, false
+}
+
+// normalizeCID makes CIDs compare equal whatever their case.
+func normalizeCID(cid string) string {
+	return strings.ToLower(strings.TrimSpace(cid))
+}
+
+var (
+	cardRegistryMu sync.RWMutex
+	cardRegistry   *CardRegistry
+)
+
+// SetCardRegistry installs the registry used to label cards. Pass nil to
+// remove it.
+func SetCardRegistry(r *CardRegistry) {
+	cardRegistryMu.Lock()
+	defer cardRegistryMu.Unlock()
+	cardRegistry = r
+}
+
+// lookupCard resolves a card against the installed card registry.
+func lookupCard(cid string) CardEntry {
+	cardRegistryMu.RLock()
+	defer cardRegistryMu.RUnlock()
+	e, _ := cardRegistry.Lookup(cid)
+	return e
+}
+
+// CardChange reports a device found holding a different card than the
+// last time its card was identified.
+type CardChange struct {
+	Serial   string
+	PortPath string
+	Name     string
+	// Previous and Current are the cards before and after the swap.
+	Previous CardEntry
+	Current  CardEntry
+	Time     time.Time
+}
+
+// globalCardListeners receives card swaps from every device in the process.
+var globalCardListeners listeners[CardChange]
+
+// OnCardChange registers fn to be called when a device in this process is
+// found holding a different card, and returns a function that unregisters
+// it. fn runs on the goroutine that identified the card and should return
+// quickly.
+func OnCardChange(fn func(CardChange)) (cancel func()) {
+	return globalCardListeners.add(fn)
+}
+
+// IdentifyCard reads the CID of the card in the device and labels it from
+// the card registry. If the device held a different card when it was last
+// identified, the swap is logged and reported to OnCardChange listeners.
+// The previous card is remembered for the life of the process, or taken
+// from the wear store if one is installed, so swaps made between runs are
+// caught too. Flashes reported through RecordFlash identify the card.
+func (s *SDWire) IdentifyCard() (CardEntry, error) {
+	cid, err := s.CardCID()
+	if err != nil {
+		return CardEntry{}, err
+	}
+	cid = normalizeCID(cid)
+	card := lookupCard(cid)
+
+	prev := s.stats.swapCard(cid)
+	if prev == "" {
+		if store := packageWearStore(); store != nil {
+			if cards, err := store.Cards(); err == nil {
+				if w, ok := lastCardIn(cards, s.serial); ok {
+					prev = normalizeCID(w.CID)
+				}
+			}
+		}
+	}
+	if prev != "" && prev != cid {
+		c := CardChange{
+			Serial:   s.serial,
+			PortPath: s.portPath,
+			Name:     s.identity.Name,
+			Previous: lookupCard(prev),
+			Current:  card,
+			Time:     time.Now(),
+		}
+		s.log.Warn("SD card was swapped", "previous", cardName(c.Previous), "current", cardName(c.Current))
+		globalCardListeners.notify(c)
+	}
+	return card, nil
+}
+
+// cardName returns the label of a card, or its CID if it has none.
+func cardName(e CardEntry) string {
+	if e.Label != "" {
+		return e.Label
+	}
+	return e.CID
+}
+
+// MarkCardDirty records that the card holds a partly written image, e.g.
+// after a canceled flash, so it is not mistaken for a good one. Stats
+// reports it until MarkCardClean, as does the card's wear record if a
+// wear store is installed and the card can be identified.
+func (s *SDWire) MarkCardDirty(reason string) {
+	if s == nil {
+		return
+	}
+	s.log.Warn("SD card marked dirty", "reason", reason)
+	s.stats.setDirty(reason)
+	s.storeDirty(reason)
+}
+
+// MarkCardClean records that the card holds a complete image again, e.g.
+// after a successful flash.
+func (s *SDWire) MarkCardClean() {
+	if s == nil {
+		return
+	}
+	s.stats.setDirty("")
+	s.storeDirty("")
+}
+
+// storeDirty records the card's state in the wear store, if one is
+// installed.
+func (s *SDWire) storeDirty(reason string) {
+	store := packageWearStore()
+	if store == nil {
+		return
+	}
+	cid, err := s.CardCID()
+	if err != nil {
+		s.log.Debug("not storing card state", "error", err)
+		return
+	}
+	if err := store.SetDirty(normalizeCID(cid), reason); err != nil {
+		s.log.Warn("failed to store card state", "error", err)
+	}
+}
@@ -0,0 +1,267 @@
+// Command libsdwire builds the SDK as a C shared library for non-Go
+// consumers:
+//
+//	go build -buildmode=c-shared -o libsdwire.so ./cmd/libsdwire
+//
+// which also writes the libsdwire.h header. sdwire.py in this directory
+// wraps the library for Python with ctypes.
+//
+// Functions returning int return 0 on success and -1 on failure, and
+// functions returning handles return -1 on failure. After a failure,
+// sdwire_last_error and sdwire_last_error_code describe the error of the
+// most recent failed call from any thread. Strings returned by the library
+// must be released with sdwire_free.
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+// sdwire_progress_fn is called during sdwire_flash with the bytes written
+// so far, the image size and the user pointer passed to sdwire_flash.
+typedef void (*sdwire_progress_fn)(int64_t written, int64_t total, void *user);
+
+static inline void sdwire_call_progress(sdwire_progress_fn fn, int64_t written, int64_t total, void *user) {
+	fn(written, total, user);
+}
+*/
+import "C"
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/workflow"
+)
+
+func main() {}
+
+var (
+	mu      sync.Mutex
+	handles = make(map[int64]*sdwire.SDWire)
+	next    int64
+	lastErr error
+)
+
+// fail records err as the last error and returns -1.
+func fail(err error) C.int {
+	mu.Lock()
+	defer mu.Unlock()
+	lastErr = err
+	return -1
+}
+
+// recovered converts a panic into an error, since a panic would abort the
+// host process. gousb panics when libusb cannot be initialized.
+func recovered(r any) C.int {
+	return fail(fmt.Errorf("sdwire: %v", r))
+}
+
+func lookup(h C.int64_t) (*sdwire.SDWire, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	s, ok := handles[int64(h)]
+	if !ok {
+		return nil, sdwire.WithCode(sdwire.CodeInvalidArgument, errors.New("invalid device handle"))
+	}
+	return s, nil
+}
+
+// listedDevice is the JSON form of a device returned by sdwire_list.
+type listedDevice struct {
+	ID         string            `json:"id"`
+	Serial     string            `json:"serial"`
+	Name       string            `json:"name,omitempty"`
+	PortPath   string            `json:"port_path"`
+	Generation string            `json:"generation"`
+	Firmware   string            `json:"firmware,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+// sdwire_list returns the connected devices as a JSON array, or NULL on
+// failure.
+//
+//export sdwire_list
+func sdwire_list() (list *C.char) {
+	defer func() {
+		if r := recover(); r != nil {
+			recovered(r)
+			list = nil
+		}
+	}()
+	infos, err := sdwire.ListDevices()
+	if err != nil {
+		fail(err)
+		return nil
+	}
+	devices := make([]listedDevice, 0, len(infos))
+	for _, info := range infos {
+		devices = append(devices, listedDevice{
+			ID:         info.ID,
+			Serial:     info.Serial,
+			Name:       info.Name,
+			PortPath:   info.PortPath,
+			Generation: info.Generation.String(),
+			Firmware:   info.FirmwareVersion,
+			Tags:       info.Tags,
+		})
+	}
+	data, err := json.Marshal(devices)
+	if err != nil {
+		fail(err)
+		return nil
+	}
+	return C.CString(string(data))
+}
+
+// sdwire_open opens the device with the given ID (see sdwire_list), or the
+// first available device if id is NULL or empty, and returns a handle for
+// it.
+//
+//export sdwire_open
+func sdwire_open(id *C.char) (handle C.int64_t) {
+	defer func() {
+		if r := recover(); r != nil {
+			handle = C.int64_t(recovered(r))
+		}
+	}()
+	var (
+		s   *sdwire.SDWire
+		err error
+	)
+	if id == nil || C.GoString(id) == "" {
+		s, err = sdwire.New()
+	} else {
+		s, err = sdwire.NewWithID(C.GoString(id))
+	}
+	if err != nil {
+		return C.int64_t(fail(err))
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	next++
+	handles[next] = s
+	return C.int64_t(next)
+}
+
+// sdwire_close closes a device handle.
+//
+//export sdwire_close
+func sdwire_close(h C.int64_t) C.int {
+	s, err := lookup(h)
+	if err != nil {
+		return fail(err)
+	}
+	mu.Lock()
+	delete(handles, int64(h))
+	mu.Unlock()
+	if err := s.Close(); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+// sdwire_set_mode switches a device: 0 connects the card to the target,
+// 1 to the host.
+//
+//export sdwire_set_mode
+func sdwire_set_mode(h C.int64_t, mode C.int) C.int {
+	s, err := lookup(h)
+	if err != nil {
+		return fail(err)
+	}
+	if err := s.SetMode(sdwire.SwitchMode(mode)); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+// sdwire_get_mode returns the mode of a device, 0 for target or 1 for
+// host, or -1 on failure.
+//
+//export sdwire_get_mode
+func sdwire_get_mode(h C.int64_t) C.int {
+	s, err := lookup(h)
+	if err != nil {
+		return fail(err)
+	}
+	mode, err := s.GetMode()
+	if err != nil {
+		return fail(err)
+	}
+	return C.int(mode)
+}
+
+// sdwire_flash writes the image at image_path to the card of a device,
+// which must be switched to the host. device_path names the card's block
+// device; if it is NULL or empty, the card is located automatically where
+// the platform supports it. progress, if not NULL, is called after each
+// chunk with user passed through; total is -1 for compressed images.
+//
+//export sdwire_flash
+func sdwire_flash(h C.int64_t, imagePath, devicePath *C.char, progress C.sdwire_progress_fn, user unsafe.Pointer) C.int {
+	s, err := lookup(h)
+	if err != nil {
+		return fail(err)
+	}
+	dst := ""
+	if devicePath != nil {
+		dst = C.GoString(devicePath)
+	}
+	if dst == "" {
+		devices, err := s.BlockDevices()
+		if err != nil {
+			return fail(err)
+		}
+		if len(devices) == 0 {
+			return fail(sdwire.WithCode(sdwire.CodeCardMissing, errors.New("no block device found for the card")))
+		}
+		dst = devices[0]
+	}
+	var report func(written, total int64)
+	if progress != nil {
+		report = func(written, total int64) {
+			C.sdwire_call_progress(progress, C.int64_t(written), C.int64_t(total), user)
+		}
+	}
+	step := workflow.FlashWithProgress(C.GoString(imagePath), dst, report)
+	if err := step.Run(context.Background(), s); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+// sdwire_last_error returns the message of the most recent error, or NULL
+// if there was none.
+//
+//export sdwire_last_error
+func sdwire_last_error() *C.char {
+	mu.Lock()
+	defer mu.Unlock()
+	if lastErr == nil {
+		return nil
+	}
+	return C.CString(lastErr.Error())
+}
+
+// sdwire_last_error_code returns the sdwire.ErrorCode of the most recent
+// error, e.g. 5 for a permission problem.
+//
+//export sdwire_last_error_code
+func sdwire_last_error_code() C.int {
+	mu.Lock()
+	defer mu.Unlock()
+	return C.int(sdwire.CodeOf(lastErr))
+}
+
+// sdwire_free releases a string returned by the library.
+//
+//export sdwire_free
+func sdwire_free(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
@@ -0,0 +1,127 @@
+"""ctypes bindings for libsdwire, the SDWire SDK built as a C library.
+
+Build the library with
+
+    go build -buildmode=c-shared -o libsdwire.so ./cmd/libsdwire
+
+and point SDWIRE_LIB at it if it is not on the loader path.
+
+    import sdwire
+    for dev in sdwire.list_devices():
+        print(dev["id"], dev["generation"])
+    with sdwire.open() as dev:
+        dev.set_mode(sdwire.HOST)
+        dev.flash("image.img", progress=lambda done, total: print(done, total))
+        dev.set_mode(sdwire.TARGET)
+"""
+
+import ctypes
+import json
+import os
+
+TARGET = 0
+HOST = 1
+
+_lib = ctypes.CDLL(os.environ.get("SDWIRE_LIB", "libsdwire.so"))
+
+_PROGRESS = ctypes.CFUNCTYPE(None, ctypes.c_int64, ctypes.c_int64, ctypes.c_void_p)
+
+# Strings are returned as void pointers so they can be passed back to
+# sdwire_free.
+_lib.sdwire_list.restype = ctypes.c_void_p
+_lib.sdwire_list.argtypes = []
+_lib.sdwire_open.restype = ctypes.c_int64
+_lib.sdwire_open.argtypes = [ctypes.c_char_p]
+_lib.sdwire_close.restype = ctypes.c_int
+_lib.sdwire_close.argtypes = [ctypes.c_int64]
+_lib.sdwire_set_mode.restype = ctypes.c_int
+_lib.sdwire_set_mode.argtypes = [ctypes.c_int64, ctypes.c_int]
+_lib.sdwire_get_mode.restype = ctypes.c_int
+_lib.sdwire_get_mode.argtypes = [ctypes.c_int64]
+_lib.sdwire_flash.restype = ctypes.c_int
+_lib.sdwire_flash.argtypes = [ctypes.c_int64, ctypes.c_char_p, ctypes.c_char_p, _PROGRESS, ctypes.c_void_p]
+_lib.sdwire_last_error.restype = ctypes.c_void_p
+_lib.sdwire_last_error.argtypes = []
+_lib.sdwire_last_error_code.restype = ctypes.c_int
+_lib.sdwire_last_error_code.argtypes = []
+_lib.sdwire_free.restype = None
+_lib.sdwire_free.argtypes = [ctypes.c_void_p]
+
+
+class Error(Exception):
+    """An error reported by the library. code is the sdwire.ErrorCode."""
+
+    def __init__(self, message, code):
+        super().__init__(message)
+        self.code = code
+
+
+def _take_string(ptr):
+    if not ptr:
+        return None
+    try:
+        return ctypes.string_at(ptr).decode()
+    finally:
+        _lib.sdwire_free(ptr)
+
+
+def _error():
+    return Error(_take_string(_lib.sdwire_last_error()) or "unknown error", _lib.sdwire_last_error_code())
+
+
+def _check(result):
+    if result < 0:
+        raise _error()
+    return result
+
+
+def list_devices():
+    """Returns the connected devices as dicts with id, serial, name,
+    port_path, generation, firmware and tags."""
+    ptr = _lib.sdwire_list()
+    if not ptr:
+        raise _error()
+    return json.loads(_take_string(ptr))
+
+
+def open(id=None):
+    """Opens the device with the given ID, or the first available one."""
+    return Device(_check(_lib.sdwire_open(id.encode() if id else None)))
+
+
+class Device:
+    """An open device. Close it, or use it as a context manager."""
+
+    def __init__(self, handle):
+        self._handle = handle
+
+    def close(self):
+        if self._handle is not None:
+            handle, self._handle = self._handle, None
+            _check(_lib.sdwire_close(handle))
+
+    def __enter__(self):
+        return self
+
+    def __exit__(self, *exc):
+        self.close()
+
+    def set_mode(self, mode):
+        """Connects the card to the target (TARGET) or host (HOST)."""
+        _check(_lib.sdwire_set_mode(self._handle, mode))
+
+    def get_mode(self):
+        return _check(_lib.sdwire_get_mode(self._handle))
+
+    def flash(self, image_path, device_path=None, progress=None):
+        """Writes an image to the card, which must be switched to the host.
+        progress, if given, is called with the bytes written and the image
+        size, which is -1 for compressed images."""
+        callback = _PROGRESS(lambda done, total, _: progress(done, total)) if progress else _PROGRESS()
+        _check(_lib.sdwire_flash(
+            self._handle,
+            image_path.encode(),
+            device_path.encode() if device_path else None,
+            callback,
+            None,
+        ))
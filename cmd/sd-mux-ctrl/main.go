@@ -0,0 +1,13 @@
+// Command sd-mux-ctrl is a drop-in replacement for the sd-mux-ctrl tool
+// used by LAVA, supporting SDWireC and SDWire3 devices.
+package main
+
+import (
+	"os"
+
+	"github.com/fcjr/sdwire/lava"
+)
+
+func main() {
+	os.Exit(lava.Run(os.Args[1:], os.Stdout, os.Stderr))
+}
@@ -0,0 +1,99 @@
+// Command sdwire-agent serves the remote agent protocol for hosts too
+// small for the full sdwire command, such as OpenWrt routers next to the
+// DUTs. It answers list, switch and status requests on standard input and
+// output, for use over ssh, or on a TCP address.
+//
+// The TCP protocol has no authentication or encryption: anyone who can
+// connect can switch every mux. An address without a host, such as ":7000",
+// listens on the loopback interface only; reach it through an ssh tunnel,
+// or firewall any other address so that only the lab servers can connect.
+//
+// Built with CGO_ENABLED=0 it is a static binary using usbfs:
+//
+//	CGO_ENABLED=0 GOOS=linux GOARCH=arm GOARM=7 go build -ldflags="-s -w" ./cmd/sdwire-agent
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/remote"
+)
+
+type stdio struct{}
+
+func (stdio) Read(p []byte) (int, error)  { return os.Stdin.Read(p) }
+func (stdio) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+
+func main() {
+	listen := flag.String("listen", "", "serve on this TCP `address` instead of standard input and output; without a host, on loopback only")
+	registry := flag.String("registry", "", "load device names and tags from this registry `file`")
+	configPath := flag.String("config", os.Getenv("SDWIRE_CONFIG"), "load the site configuration from this `file`")
+	flag.Parse()
+
+	if *configPath != "" {
+		c, err := sdwire.LoadConfig(*configPath)
+		if err == nil {
+			err = c.Apply()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sdwire-agent: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *registry != "" {
+		reg, err := sdwire.LoadRegistry(*registry)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sdwire-agent: %v\n", err)
+			os.Exit(1)
+		}
+		sdwire.SetRegistry(reg)
+	}
+
+	s := remote.NewServer()
+	if *listen == "" {
+		if err := s.ServeConn(stdio{}); err != nil {
+			fmt.Fprintf(os.Stderr, "sdwire-agent: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	ln, exposed, err := remote.ListenTCP(*listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sdwire-agent: %v\n", err)
+		os.Exit(1)
+	}
+	log.Printf("serving agent protocol on %s", ln.Addr())
+	if exposed {
+		log.Printf("warning: %s is not a loopback address and the protocol is unauthenticated; firewall it", ln.Addr())
+	}
+	var delay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			log.Fatal(err)
+		}
+		if err != nil {
+			// Out of file descriptors, say; back off until it clears.
+			delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+			log.Printf("accept failed, retrying in %v: %v", delay, err)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+		go func() {
+			defer conn.Close()
+			if err := s.ServeConn(conn); err != nil {
+				log.Printf("%s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
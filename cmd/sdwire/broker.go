@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/fcjr/sdwire/broker"
+	"github.com/fcjr/sdwire/remote"
+)
+
+func runBroker(args []string) error {
+	fs, registry := newFlagSet("broker")
+	listen := fs.String("listen", ":8420", "`address` to serve the claim API on; without a host, on loopback only")
+	fs.Parse(args)
+
+	if err := loadRegistry(*registry); err != nil {
+		return err
+	}
+	ln, exposed, err := remote.ListenTCP(*listen)
+	if err != nil {
+		return err
+	}
+	log.Printf("serving claim API on %s", ln.Addr())
+	if exposed {
+		log.Printf("warning: %s is not a loopback address and the API is unauthenticated; firewall it", ln.Addr())
+	}
+	return http.Serve(ln, broker.NewServer())
+}
+
+// brokerClient returns a client for the broker at url.
+func brokerClient(url string) (*broker.Client, error) {
+	if url == "" {
+		return nil, errors.New("no broker address, set -broker or SDWIRE_BROKER")
+	}
+	return &broker.Client{BaseURL: url}, nil
+}
+
+func runClaim(args []string) error {
+	fs, _ := newFlagSet("claim")
+	url := fs.String("broker", os.Getenv("SDWIRE_BROKER"), "broker `URL`")
+	tags := fs.String("tags", "", "selector `expression` the device must match")
+	ttl := fs.Duration("ttl", broker.DefaultTTL, "claim lifetime")
+	holder := fs.String("holder", os.Getenv("CI_JOB_URL"), "description of the claimant")
+	wait := fs.Duration("wait", 0, "wait up to this long for a device to become free")
+	fs.Parse(args)
+
+	client, err := brokerClient(*url)
+	if err != nil {
+		return err
+	}
+	req := broker.ClaimRequest{Selector: *tags, TTL: ttl.String(), Holder: *holder}
+
+	ctx := context.Background()
+	var claim *broker.Claim
+	if *wait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *wait)
+		defer cancel()
+		claim, err = client.ClaimWait(ctx, req, 5*time.Second)
+	} else {
+		claim, err = client.Claim(ctx, req)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("export SDWIRE_BROKER=%s\n", shellQuote(*url))
+	fmt.Printf("export SDWIRE_CLAIM_ID=%s\n", shellQuote(claim.ID))
+	fmt.Printf("export SDWIRE_DEVICE_ID=%s\n", shellQuote(claim.Device))
+	fmt.Printf("export SDWIRE_SERIAL=%s\n", shellQuote(claim.Serial))
+	fmt.Printf("export SDWIRE_NAME=%s\n", shellQuote(claim.Name))
+	fmt.Printf("export SDWIRE_PORT_PATH=%s\n", shellQuote(claim.PortPath))
+	fmt.Printf("export SDWIRE_CLAIM_EXPIRES=%s\n", shellQuote(claim.Expires.Format(time.RFC3339)))
+	return nil
+}
+
+func runRenew(args []string) error {
+	fs, _ := newFlagSet("renew")
+	url := fs.String("broker", os.Getenv("SDWIRE_BROKER"), "broker `URL`")
+	id := fs.String("id", os.Getenv("SDWIRE_CLAIM_ID"), "claim `ID`")
+	ttl := fs.Duration("ttl", broker.DefaultTTL, "new claim lifetime from now")
+	fs.Parse(args)
+
+	client, err := brokerClient(*url)
+	if err != nil {
+		return err
+	}
+	claim, err := client.Renew(context.Background(), *id, *ttl)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("export SDWIRE_CLAIM_EXPIRES=%s\n", shellQuote(claim.Expires.Format(time.RFC3339)))
+	return nil
+}
+
+func runRelease(args []string) error {
+	fs, _ := newFlagSet("release")
+	url := fs.String("broker", os.Getenv("SDWIRE_BROKER"), "broker `URL`")
+	id := fs.String("id", os.Getenv("SDWIRE_CLAIM_ID"), "claim `ID`")
+	fs.Parse(args)
+
+	client, err := brokerClient(*url)
+	if err != nil {
+		return err
+	}
+	return client.Release(context.Background(), *id)
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	out := "'"
+	for _, r := range s {
+		if r == '\'' {
+			out += `'\''`
+		} else {
+			out += string(r)
+		}
+	}
+	return out + "'"
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/fcjr/sdwire"
+)
+
+func runDiagnose(args []string) error {
+	fs, registry := newFlagSet("diagnose")
+	serial := fs.String("serial", "", "diagnose the device with this serial number")
+	port := fs.String("port", "", "diagnose the device at this USB port `path`")
+	fs.Parse(args)
+
+	if err := loadRegistry(*registry); err != nil {
+		return err
+	}
+	var dev *sdwire.SDWire
+	var err error
+	switch {
+	case *port != "":
+		dev, err = sdwire.NewWithPortPath(*port, sdwire.WithBlockingLock())
+	case *serial != "":
+		dev, err = sdwire.NewWithSerial(*serial, sdwire.WithBlockingLock())
+	default:
+		dev, err = sdwire.New()
+	}
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+
+	d, err := dev.Diagnose()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Device:   %s (%s)\n", dev.GetSerial(), dev.GetPortPath())
+	fmt.Printf("Chip:     %s (bcdDevice 0x%04x)\n", d.Chip, d.Release)
+	fmt.Printf("Genuine:  %t\n", d.Genuine)
+	fmt.Printf("Quirks:   %s\n", d.Quirks)
+	for _, w := range d.Warnings {
+		fmt.Printf("warning: %s\n", w)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fcjr/sdwire"
+)
+
+func runDoctor(args []string) error {
+	fs, _ := newFlagSet("doctor")
+	fs.Parse(args)
+
+	failed := false
+	for _, f := range sdwire.Diagnose() {
+		fmt.Printf("[%s] %s: %s\n", strings.ToUpper(f.Severity.String()), f.Check, f.Message)
+		if f.Fix != "" {
+			for _, line := range strings.Split(strings.TrimSpace(f.Fix), "\n") {
+				fmt.Printf("    fix: %s\n", line)
+			}
+		}
+		if f.Severity == sdwire.SeverityError {
+			failed = true
+		}
+	}
+	if failed {
+		return errors.New("problems found")
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/eeprom"
+)
+
+func runEEPROM(args []string) error {
+	fs, registry := newFlagSet("eeprom")
+	serial := fs.String("serial", "", "program the device with this serial number")
+	port := fs.String("port", "", "program the device at this USB port `path`, e.g. for duplicate serials")
+	manufacturer := fs.String("manufacturer", "", "new manufacturer string for set-strings")
+	product := fs.String("product", "", "new product string for set-strings")
+	defaults := fs.Bool("defaults", false, "set-strings: use the stock SDWireC manufacturer and product strings")
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	raw := fs.Bool("raw", false, "dump: include a hex dump of the image")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sdwire eeprom [-serial serial | -port path] [flags] action\n\nactions:\n"+
+			"  backup file\n"+
+			"  cbus [function function function function]\n"+
+			"  dump [-raw]\n"+
+			"  reset-defaults\n"+
+			"  restore file\n"+
+			"  set-serial new-serial\n"+
+			"  set-strings [-defaults] [-manufacturer name] [-product name]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("expected an action")
+	}
+	if err := loadRegistry(*registry); err != nil {
+		return err
+	}
+
+	dev, err := openEEPROMDevice(*serial, *port)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+
+	switch action := fs.Arg(0); action {
+	case "backup":
+		if fs.NArg() != 2 {
+			return errors.New("backup expects a file name")
+		}
+		img, err := eeprom.Backup(dev, fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		if !img.ChecksumValid() {
+			fmt.Println("warning: the EEPROM checksum is invalid")
+		}
+		fmt.Printf("EEPROM of %s saved to %s\n", dev.GetPortPath(), fs.Arg(1))
+		return nil
+
+	case "restore":
+		if fs.NArg() != 2 {
+			return errors.New("restore expects a file name")
+		}
+		img, err := eeprom.LoadImage(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("restoring EEPROM of %s from %s:\n", dev.GetPortPath(), fs.Arg(1))
+		img.Decode().WriteTo(os.Stdout)
+		if !*yes && !confirm("write EEPROM?") {
+			return errors.New("aborted")
+		}
+		if err := eeprom.Restore(dev, fs.Arg(1)); err != nil {
+			return err
+		}
+		fmt.Println("EEPROM restored, replug the device to apply")
+		return nil
+
+	case "cbus":
+		img, err := eeprom.Read(dev)
+		if err != nil {
+			return err
+		}
+		current := img.CBUS()
+		if fs.NArg() == 1 {
+			for i, f := range current {
+				fmt.Printf("CBUS%d: %s\n", i, f)
+			}
+			return nil
+		}
+		if fs.NArg() != 1+eeprom.CBUSPins {
+			return fmt.Errorf("cbus expects %d functions, e.g. %s", eeprom.CBUSPins, formatCBUS(eeprom.SDWireCBUS))
+		}
+		var next [eeprom.CBUSPins]eeprom.CBUSFunction
+		for i := range next {
+			if next[i], err = eeprom.ParseCBUSFunction(fs.Arg(1 + i)); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("device %s: CBUS %s -> %s\n", dev.GetPortPath(), formatCBUS(current), formatCBUS(next))
+		if next[0] != eeprom.CBUSIOMode {
+			fmt.Println("warning: switching needs CBUS0 set to IOMODE")
+		}
+		if !*yes && !confirm("write EEPROM?") {
+			return errors.New("aborted")
+		}
+		if err := img.SetCBUS(next); err != nil {
+			return err
+		}
+		if err := eeprom.Write(dev, img); err != nil {
+			return err
+		}
+		fmt.Println("CBUS functions written, replug the device to apply")
+		return nil
+
+	case "reset-defaults":
+		fmt.Printf("device %s: restore CBUS %s and strings %q, %q\n", dev.GetPortPath(),
+			formatCBUS(eeprom.SDWireCBUS), eeprom.DefaultStrings.Manufacturer, eeprom.DefaultStrings.Product)
+		if !*yes && !confirm("write EEPROM?") {
+			return errors.New("aborted")
+		}
+		if err := eeprom.ResetToSDWireDefaults(dev); err != nil {
+			return err
+		}
+		fmt.Println("defaults written, replug the device to apply")
+		return nil
+
+	case "dump":
+		img, cfg, err := eeprom.ReadConfig(dev)
+		if err != nil {
+			return err
+		}
+		cfg.WriteTo(os.Stdout)
+		if *raw {
+			fmt.Print("\n" + img.Dump())
+		}
+		return nil
+
+	case "set-serial":
+		if fs.NArg() != 2 {
+			return errors.New("set-serial expects the new serial number")
+		}
+		fmt.Printf("device %s: serial %q -> %q\n", dev.GetPortPath(), dev.GetSerial(), fs.Arg(1))
+		if !*yes && !confirm("write EEPROM?") {
+			return errors.New("aborted")
+		}
+		if err := eeprom.SetSerial(dev, fs.Arg(1)); err != nil {
+			return err
+		}
+		fmt.Printf("serial of %s set to %s, replug the device to apply\n", dev.GetPortPath(), fs.Arg(1))
+		return nil
+
+	case "set-strings":
+		update := eeprom.Strings{Manufacturer: *manufacturer, Product: *product}
+		if *defaults {
+			update = eeprom.MergeStrings(eeprom.DefaultStrings, update)
+		}
+		if update == (eeprom.Strings{}) {
+			return errors.New("set-strings needs -defaults, -manufacturer or -product")
+		}
+		img, err := eeprom.Read(dev)
+		if err != nil {
+			return err
+		}
+		current := img.Strings()
+		next := eeprom.MergeStrings(current, update)
+		fmt.Printf("device %s:\n", dev.GetPortPath())
+		fmt.Printf("  manufacturer: %q -> %q\n", current.Manufacturer, next.Manufacturer)
+		fmt.Printf("  product:      %q -> %q\n", current.Product, next.Product)
+		if next.Product != eeprom.DefaultStrings.Product {
+			fmt.Printf("warning: tools that look for product %q will no longer find this device\n", eeprom.DefaultStrings.Product)
+		}
+		if !*yes && !confirm("write EEPROM?") {
+			return errors.New("aborted")
+		}
+		if err := img.SetStrings(next.Manufacturer, next.Product, next.Serial); err != nil {
+			return err
+		}
+		if err := eeprom.Write(dev, img); err != nil {
+			return err
+		}
+		fmt.Println("strings written, replug the device to apply")
+		return nil
+
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+}
+
+func formatCBUS(fns [eeprom.CBUSPins]eeprom.CBUSFunction) string {
+	names := make([]string, len(fns))
+	for i, f := range fns {
+		names[i] = f.String()
+	}
+	return strings.Join(names, " ")
+}
+
+// confirm asks a yes/no question on the terminal, defaulting to no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// openEEPROMDevice opens the device with the given serial or port path, or
+// the only connected device, and checks that it has an EEPROM.
+func openEEPROMDevice(serial, port string) (*sdwire.SDWire, error) {
+	var dev *sdwire.SDWire
+	var err error
+	switch {
+	case serial != "" && port != "":
+		return nil, errors.New("-serial and -port are mutually exclusive")
+	case port != "":
+		dev, err = sdwire.NewWithPortPath(port, sdwire.WithBlockingLock())
+	case serial != "":
+		dev, err = sdwire.NewWithSerial(serial, sdwire.WithBlockingLock())
+		var merr *sdwire.MultipleMatchesError
+		if errors.As(err, &merr) {
+			err = fmt.Errorf("%w; select one with -port", err)
+		}
+	default:
+		devices, lerr := sdwire.ListDevices()
+		if lerr != nil {
+			return nil, lerr
+		}
+		if len(devices) > 1 {
+			return nil, fmt.Errorf("%d devices connected, select one with -serial or -port", len(devices))
+		}
+		dev, err = sdwire.New()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !eeprom.Supported(dev) {
+		dev.Close()
+		return nil, sdwire.WithCode(sdwire.CodeUnsupported,
+			fmt.Errorf("%s devices have no programmable EEPROM", dev.GetGeneration()))
+	}
+	return dev, nil
+}
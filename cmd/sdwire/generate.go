@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/fcjr/sdwire"
+)
+
+func runGenerate(args []string) error {
+	fs, registry := newFlagSet("generate")
+	group := fs.String("group", "", "restrict access to members of this `group`")
+	user := fs.String("user", "", "run the service as this `user` (systemd)")
+	sysfs := fs.Bool("sysfs", true, "allow switching SDWire3 through sysfs (udev)")
+	env := fs.String("env", "", "comma-separated `NAME=value` variables for the service (systemd)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sdwire generate [flags] udev|systemd [command...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var (
+		out string
+		err error
+	)
+	switch fs.Arg(0) {
+	case "udev":
+		out, err = sdwire.GenerateUdevRules(sdwire.UdevOptions{Group: *group, SysfsControl: *sysfs})
+	case "systemd":
+		command := fs.Args()[1:]
+		opts := sdwire.SystemdOptions{User: *user, Group: *group, Command: command}
+		// sdwire serve notifies systemd; other commands may not.
+		opts.Notify = len(command) > 1 && filepath.Base(command[0]) == "sdwire" && command[1] == "serve"
+		if *registry != "" {
+			opts.Environment = map[string]string{"SDWIRE_REGISTRY": *registry}
+		}
+		if *env != "" {
+			if opts.Environment == nil {
+				opts.Environment = make(map[string]string)
+			}
+			for _, kv := range strings.Split(*env, ",") {
+				k, v, ok := strings.Cut(kv, "=")
+				if !ok {
+					return fmt.Errorf("invalid variable %q, want NAME=value", kv)
+				}
+				opts.Environment[k] = v
+			}
+		}
+		out, err = sdwire.GenerateSystemdUnit(opts)
+	default:
+		fs.Usage()
+		return errors.New("specify udev or systemd")
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Print(out)
+	return nil
+}
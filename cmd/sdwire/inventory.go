@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fcjr/sdwire"
+)
+
+func runInventory(args []string) error {
+	fs, registry := newFlagSet("inventory")
+	format := fs.String("format", "json", "output format: json or csv")
+	state := fs.String("state", defaultInventoryState(), "inventory history `file`; empty disables history")
+	wear := fs.String("wear", "", "card wear store `file` to report from, instead of the config's")
+	fs.Parse(args)
+
+	if err := loadRegistry(*registry); err != nil {
+		return err
+	}
+	if *wear != "" {
+		var thresholds sdwire.WearThresholds
+		if config.Wear != nil {
+			thresholds = config.Wear.WearThresholds
+		}
+		sdwire.SetWearStore(sdwire.NewWearStore(*wear, thresholds))
+	}
+	records, err := sdwire.Inventory(*state)
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "json":
+		return sdwire.WriteInventoryJSON(os.Stdout, records)
+	case "csv":
+		return sdwire.WriteInventoryCSV(os.Stdout, records)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
+
+func defaultInventoryState() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "sdwire", "inventory.json")
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fcjr/sdwire"
+)
+
+func runList(args []string) error {
+	fs, registry := newFlagSet("list")
+	selector := fs.String("select", "", "only list devices matching the selector `expression`")
+	fs.Parse(args)
+
+	if err := loadRegistry(*registry); err != nil {
+		return err
+	}
+	c, err := remoteClient()
+	if err != nil {
+		return err
+	}
+	var devices []*sdwire.DeviceInfo
+	if c != nil {
+		defer c.Close()
+		devices, err = selectRemote(c, *selector)
+	} else {
+		devices, err = sdwire.SelectDevices(*selector)
+	}
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERIAL\tNAME\tGENERATION\tFIRMWARE\tPORT\tTAGS")
+	for _, d := range devices {
+		serial := d.ID
+		if d.DuplicateSerial {
+			serial += " (duplicate)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", serial, d.Name, d.Generation, d.FirmwareVersion, d.PortPath, formatTags(d.Tags))
+	}
+	return w.Flush()
+}
+
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,153 @@
+// Command sdwire manages SDWire devices from the command line.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/fcjr/sdwire"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"broker":    {"serve the device claim API", runBroker},
+	"claim":     {"claim a device from a broker and print shell exports", runClaim},
+	"diagnose":  {"check a device for clone chips and quirks", runDiagnose},
+	"doctor":    {"check the host setup for using devices", runDoctor},
+	"eeprom":    {"program SDWireC EEPROM settings", runEEPROM},
+	"generate":  {"print udev rules or a systemd unit for this host", runGenerate},
+	"inventory": {"export all known devices as JSON or CSV", runInventory},
+	"list":      {"list connected devices", runList},
+	"provision": {"program serial numbers into blank units as they are plugged in", runProvision},
+	"release":   {"release a claimed device", runRelease},
+	"renew":     {"extend a device claim", runRenew},
+	"serve":     {"serve the remote control API, e.g. for containers", runServe},
+	"switch":    {"switch devices to host or target", runSwitch},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "sdwire: unknown command %q\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if path := os.Getenv("SDWIRE_CONFIG"); path != "" {
+		c, err := sdwire.LoadConfig(path)
+		if err == nil {
+			err = c.Apply()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sdwire: %v\n", err)
+			os.Exit(1)
+		}
+		config = c
+	}
+	auditLog := os.Getenv("SDWIRE_AUDIT_LOG")
+	if auditLog == "" {
+		auditLog = config.Server.AuditLog
+	}
+	if path := auditLog; path != "" {
+		audit, err := sdwire.OpenAuditFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "sdwire: %v\n", err)
+			os.Exit(1)
+		}
+		defer audit.Close()
+		sdwire.SetAuditSink(audit)
+	}
+	err := cmd.run(os.Args[2:])
+	if printPerf {
+		sdwire.Perf().WriteTo(os.Stderr)
+	}
+	if err != nil {
+		if code := sdwire.CodeOf(err); code != sdwire.CodeUnknown {
+			fmt.Fprintf(os.Stderr, "sdwire %s: %v [%s]\n", os.Args[1], err, code)
+		} else {
+			fmt.Fprintf(os.Stderr, "sdwire %s: %v\n", os.Args[1], err)
+		}
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCodes maps error codes to exit statuses. Statuses 1 (other errors)
+// and 2 (usage) are reserved.
+var exitCodes = map[sdwire.ErrorCode]int{
+	sdwire.CodeNotFound:        3,
+	sdwire.CodeBusy:            4,
+	sdwire.CodePermission:      5,
+	sdwire.CodeUSBTimeout:      6,
+	sdwire.CodeTimeout:         6,
+	sdwire.CodeUSBPipe:         7,
+	sdwire.CodeUSBIO:           7,
+	sdwire.CodeDeviceGone:      8,
+	sdwire.CodeVerifyFailed:    9,
+	sdwire.CodeCardMissing:     10,
+	sdwire.CodeCanceled:        11,
+	sdwire.CodeInvalidArgument: 2,
+	sdwire.CodeUnsupported:     12,
+	sdwire.CodeAmbiguous:       13,
+}
+
+func exitCode(err error) int {
+	if code, ok := exitCodes[sdwire.CodeOf(err)]; ok {
+		return code
+	}
+	return 1
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: sdwire <command> [flags]\n\ncommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nexit status: 0 ok, 1 error, 2 usage, 3 not found, 4 busy, 5 permission,\n"+
+		"6 timeout, 7 USB error, 8 device gone, 9 verify failed, 10 card missing,\n"+
+		"11 canceled, 12 unsupported, 13 ambiguous serial")
+}
+
+// config is the site configuration named by SDWIRE_CONFIG, if any.
+var config = &sdwire.Config{}
+
+// printPerf is set by the -perf flag shared by all commands.
+var printPerf bool
+
+// newFlagSet returns a flag set for a command with the flags shared by all commands.
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet("sdwire "+name, flag.ExitOnError)
+	registry := fs.String("registry", os.Getenv("SDWIRE_REGISTRY"), "path to the device registry `file`")
+	fs.BoolFunc("perf", "print a latency report to standard error when done", func(string) error {
+		printPerf = true
+		sdwire.EnablePerf()
+		return nil
+	})
+	return fs, registry
+}
+
+// loadRegistry installs the registry at path, if any.
+func loadRegistry(path string) error {
+	if path == "" {
+		return nil
+	}
+	reg, err := sdwire.LoadRegistry(path)
+	if err != nil {
+		return err
+	}
+	sdwire.SetRegistry(reg)
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/fcjr/sdwire/eeprom"
+	"github.com/fcjr/sdwire/provision"
+)
+
+func runProvision(args []string) error {
+	fs, _ := newFlagSet("provision")
+	template := fs.String("template", "", "serial number `format` with a counter, e.g. sdw-%04d")
+	start := fs.Int("start", 1, "first counter value for -template")
+	serials := fs.String("serials", "", "CSV `file` with serial numbers in the first column")
+	results := fs.String("results", "", "append results as CSV to this `file` (default stdout)")
+	manufacturer := fs.String("manufacturer", eeprom.DefaultStrings.Manufacturer, "manufacturer string to program")
+	product := fs.String("product", eeprom.DefaultStrings.Product, "product string to program")
+	fs.Parse(args)
+
+	p := &provision.Provisioner{
+		Strings: eeprom.Strings{Manufacturer: *manufacturer, Product: *product},
+	}
+	switch {
+	case *template != "" && *serials != "":
+		return errors.New("-template and -serials are mutually exclusive")
+	case *template != "":
+		p.Serials = provision.Template(*template, *start)
+	case *serials != "":
+		f, err := os.Open(*serials)
+		if err != nil {
+			return err
+		}
+		p.Serials, err = provision.CSV(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New("set -template or -serials")
+	}
+
+	var out io.Writer = os.Stdout
+	if *results != "" {
+		f, err := os.OpenFile(*results, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	rw := provision.NewResultWriter(out)
+	p.OnResult = func(r provision.Result) {
+		rw.Write(r)
+		if r.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", r.PortPath, r.Err)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: programmed %s, replug to apply\n", r.PortPath, r.Serial)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Fprintln(os.Stderr, "waiting for blank units, press Ctrl-C to stop")
+	if err := p.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/jobs"
+	"github.com/fcjr/sdwire/remote"
+	"github.com/fcjr/sdwire/rules"
+	"github.com/fcjr/sdwire/topology"
+)
+
+func runServe(args []string) error {
+	fs, registry := newFlagSet("serve")
+	defaultSocket := cmp.Or(config.Server.Socket, remote.DefaultSocket)
+	socket := fs.String("socket", defaultSocket, "Unix socket `path` to serve on")
+	listen := fs.String("listen", config.Server.Listen, "serve on this TCP `address` instead of a socket; without a host, on loopback only")
+	rulesPath := fs.String("rules", config.Server.Rules, "automation rules `file` to run")
+	topoPath := fs.String("topology", config.Server.Topology, "topology `file` resolving the DUTs named by rules")
+	debounce := fs.Duration("debounce", time.Duration(config.Server.Debounce), "minimum `interval` between switches of a device")
+	statePath := fs.String("state", config.Server.State, "`file` keeping the mode last requested for each device, restored when it reappears")
+	jobsPath := fs.String("jobs", config.Server.Jobs, "history `file` of a flash job queue to serve under /v1/jobs")
+	shutdownMode := fs.String("shutdown-mode", config.Server.ShutdownMode, "switch every device to this `mode` (host or target) when stopped")
+	useStdio := fs.Bool("stdio", false, "serve the agent protocol on standard input and output, for ssh:// remotes")
+	fs.Parse(args)
+
+	if err := loadRegistry(*registry); err != nil {
+		return err
+	}
+	var (
+		finalMode sdwire.SwitchMode
+		err       error
+	)
+	if *shutdownMode != "" {
+		if finalMode, err = parseMode(*shutdownMode); err != nil {
+			return err
+		}
+	}
+	server := remote.NewServer()
+	server.Debounce = *debounce
+	if *statePath != "" {
+		state, err := remote.LoadStateFile(*statePath)
+		if err != nil {
+			return err
+		}
+		server.State = state
+	}
+	if *useStdio {
+		return server.ServeConn(stdio{})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if *rulesPath != "" {
+		engine, err := loadRules(*rulesPath, *topoPath)
+		if err != nil {
+			return err
+		}
+		go engine.Run(ctx)
+	}
+	if server.State != nil {
+		go server.Restore(ctx, sdwire.DefaultWatchInterval)
+	}
+	var handler http.Handler = server
+	jobsDone := make(chan struct{})
+	if *jobsPath != "" {
+		queue, err := jobs.Load(*jobsPath)
+		if err != nil {
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/v1/jobs", queue)
+		mux.Handle("/v1/jobs/", queue)
+		mux.Handle("/", server)
+		handler = mux
+		go func() {
+			queue.Run(ctx)
+			close(jobsDone)
+		}()
+	} else {
+		close(jobsDone)
+	}
+	var (
+		ln      net.Listener
+		exposed bool
+	)
+	ln, err = systemdListener()
+	switch {
+	case err != nil || ln != nil:
+	case *listen != "":
+		ln, exposed, err = remote.ListenTCP(*listen)
+	default:
+		// A socket left behind by a previous run would make Listen fail.
+		os.Remove(*socket)
+		ln, err = net.Listen("unix", *socket)
+		if err == nil {
+			err = os.Chmod(*socket, 0o660)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("serving remote control API on %s", ln.Addr())
+	if exposed {
+		log.Printf("warning: %s is not a loopback address and the API is unauthenticated; firewall it", ln.Addr())
+	}
+	if err := sdNotify("READY=1"); err != nil {
+		log.Print(err)
+	}
+	interval, err := watchdogInterval()
+	if err != nil {
+		return err
+	}
+	if interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					sdNotify("WATCHDOG=1")
+				}
+			}
+		}()
+	}
+
+	httpServer := &http.Server{Handler: handler}
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		log.Print("shutting down")
+		sdNotify("STOPPING=1")
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancelShutdown()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+	if err := httpServer.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	cancel()
+	// Interrupted jobs are queued again once the queue stops.
+	<-jobsDone
+	if *shutdownMode == "" {
+		return nil
+	}
+	devices, err := sdwire.ListDevices()
+	if err != nil {
+		return err
+	}
+	log.Printf("switching %d devices to %s", len(devices), *shutdownMode)
+	return sdwire.Group(devices).SetMode(finalMode)
+}
+
+// shutdownTimeout bounds how long requests in flight may take to finish
+// once the server is stopped.
+const shutdownTimeout = 30 * time.Second
+
+// loadRules creates an engine for the rules at path, resolving DUTs with
+// the topology at topoPath, if any.
+func loadRules(path, topoPath string) (*rules.Engine, error) {
+	list, err := rules.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	var opts []rules.Option
+	if topoPath != "" {
+		topo, err := topology.Load(topoPath)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, rules.WithTopology(topo))
+	}
+	engine, err := rules.New(list, opts...)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("running %d rules from %s", len(list), path)
+	return engine, nil
+}
+
+// stdio is standard input and output as one stream.
+type stdio struct{}
+
+func (stdio) Read(p []byte) (int, error)  { return os.Stdin.Read(p) }
+func (stdio) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+
+// defaultRemoteCommand is run on ssh:// remotes unless SDWIRE_REMOTE_COMMAND
+// names another, such as "sdwire serve -stdio".
+const defaultRemoteCommand = "sdwire-agent"
+
+// remoteClient returns a client for the server named by SDWIRE_REMOTE, or
+// nil if it is not set. The address is a socket path or URL of a server,
+// tcp://host[:port] of an sdwire-agent, or ssh://host, where the agent
+// protocol is served by the command in SDWIRE_REMOTE_COMMAND.
+func remoteClient() (remote.Controller, error) {
+	addr := os.Getenv("SDWIRE_REMOTE")
+	switch {
+	case addr == "":
+		return nil, nil
+	case strings.HasPrefix(addr, "tcp://"):
+		return remote.DialAgent(strings.TrimPrefix(addr, "tcp://"))
+	case strings.HasPrefix(addr, "ssh://"):
+		command := cmp.Or(os.Getenv("SDWIRE_REMOTE_COMMAND"), defaultRemoteCommand)
+		return remote.DialAgentCommand("ssh", strings.TrimPrefix(addr, "ssh://"), command)
+	}
+	return remote.Dial(addr), nil
+}
+
+// selectRemote lists the remote devices matching a selector expression.
+func selectRemote(c remote.Controller, expr string) ([]*sdwire.DeviceInfo, error) {
+	sel, err := sdwire.ParseSelector(expr)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := c.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	return sel.Filter(devices), nil
+}
+
+// switchRemote is runSwitch for devices controlled through a server.
+func switchRemote(c remote.Controller, serial, selector string, mode sdwire.SwitchMode) error {
+	ctx := context.Background()
+	if serial != "" {
+		return c.SetMode(ctx, serial, mode)
+	}
+	devices, err := selectRemote(c, selector)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(devices) == 0 && selector != "":
+		return fmt.Errorf("no devices match %q", selector)
+	case len(devices) == 0:
+		return sdwire.WithCode(sdwire.CodeNotFound, errors.New("no SDWire devices found"))
+	case selector == "":
+		devices = devices[:1]
+	}
+	var errs []error
+	for _, d := range devices {
+		if err := c.SetMode(ctx, d.ID, mode); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", d.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fcjr/sdwire"
+)
+
+func runSwitch(args []string) error {
+	fs, registry := newFlagSet("switch")
+	serial := fs.String("serial", "", "switch the device with this serial number, or port:PATH")
+	selector := fs.String("select", "", "switch all devices matching the selector `expression`")
+	force := fs.Bool("force", false, "switch to the target even if the card is in use on the host")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: sdwire switch [-force] [-serial serial | -select expression] host|target")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected exactly one mode")
+	}
+	mode, err := parseMode(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := loadRegistry(*registry); err != nil {
+		return err
+	}
+
+	if *serial != "" && *selector != "" {
+		return errors.New("-serial and -select are mutually exclusive")
+	}
+	c, err := remoteClient()
+	if err != nil {
+		return err
+	}
+	if c != nil {
+		defer c.Close()
+		return switchRemote(c, *serial, *selector, mode)
+	}
+
+	var opts []sdwire.Option
+	if *force {
+		opts = append(opts, sdwire.WithForce())
+	}
+
+	switch {
+	case *serial != "":
+		return sdwire.Group{{ID: *serial, Serial: *serial}}.SetMode(mode, append(opts, sdwire.WithBlockingLock())...)
+	case *selector != "":
+		group, err := sdwire.SelectDevices(*selector)
+		if err != nil {
+			return err
+		}
+		if len(group) == 0 {
+			return fmt.Errorf("no devices match %q", *selector)
+		}
+		return group.SetMode(mode, append(opts, sdwire.WithBlockingLock())...)
+	default:
+		dev, err := sdwire.New(opts...)
+		if err != nil {
+			return err
+		}
+		defer dev.Close()
+		return dev.SetMode(mode)
+	}
+}
+
+func parseMode(s string) (sdwire.SwitchMode, error) {
+	switch s {
+	case "host", "ts":
+		return sdwire.ModeHost, nil
+	case "target", "dut":
+		return sdwire.ModeTarget, nil
+	default:
+		return 0, fmt.Errorf("unknown mode %q, expected host or target", s)
+	}
+}
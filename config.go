@@ -0,0 +1,225 @@
+package sdwire
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/fcjr/sdwire/power"
+)
+
+// Config is a site configuration shared by the SDK, the sdwire command,
+// the servers and the pool, so that they all agree on device names,
+// quirks and defaults. It is read from YAML:
+//
+//	devices:
+//	  - serial: sdw-0001
+//	    name: rpi4-bench-03
+//	    tags: {rack: "3"}
+//	    quirks: ResetBitmode
+//	defaults:
+//	  blocking_lock: true
+//	  settle: 500ms
+//	  timeouts: {reset: 15s}
+//	detection:
+//	  mode: strict
+//	  products: [sd-wire]
+//	power_controllers:
+//	  pdu-rack3: {type: snmp, host: 10.0.3.2}
+//	pool:
+//	  strategy: LeastRecentlyUsed
+//	  max_failures: 5
+//	server:
+//	  socket: /run/sdwire.sock
+//	  audit_log: /var/log/sdwire/audit.log
+//	wear:
+//	  store: /var/lib/sdwire/wear.json
+//	  max_flash_cycles: 3000
+//	cards:
+//	  - cid: 035344534331364780b5a1c2d30138e1
+//	    label: SanDisk-17
+//
+// The keys are the json tags of the fields below; unknown keys are
+// rejected. A JSON object with the same keys is read too, like registry
+// and topology files. Every section is optional.
+type Config struct {
+	// Devices names devices and sets their tags, timeouts and quirks; see
+	// Registry.
+	Devices []RegistryEntry `json:"devices,omitempty"`
+	// Defaults apply to every device opened.
+	Defaults Defaults `json:"defaults,omitempty"`
+	// Detection, if set, replaces the default detection rules.
+	Detection *Detection `json:"detection,omitempty"`
+	// PowerControllers are named power controllers, as referenced by
+	// topology files.
+	PowerControllers map[string]power.Spec `json:"power_controllers,omitempty"`
+	Pool             PoolConfig            `json:"pool,omitempty"`
+	Server           ServerConfig          `json:"server,omitempty"`
+	Wear             *WearConfig           `json:"wear,omitempty"`
+	// Cards labels SD cards; see CardRegistry.
+	Cards []CardEntry `json:"cards,omitempty"`
+}
+
+// Defaults are options applied to every device opened; see the With
+// options of the same names.
+type Defaults struct {
+	LockDir            string    `json:"lock_dir,omitempty"`
+	BlockingLock       bool      `json:"blocking_lock,omitempty"`
+	Timeouts           *Timeouts `json:"timeouts,omitempty"`
+	Quirks             Quirks    `json:"quirks,omitempty"`
+	Settle             Duration  `json:"settle,omitempty"`
+	Debounce           Duration  `json:"debounce,omitempty"`
+	SkipNoop           bool      `json:"skip_noop,omitempty"`
+	SysfsControl       bool      `json:"sysfs_control,omitempty"`
+	DetachSerialDriver bool      `json:"detach_serial_driver,omitempty"`
+}
+
+// Options returns the defaults as options.
+func (d Defaults) Options() []Option {
+	var opts []Option
+	if d.LockDir != "" {
+		opts = append(opts, WithLockDir(d.LockDir))
+	}
+	if d.BlockingLock {
+		opts = append(opts, WithBlockingLock())
+	}
+	if d.Timeouts != nil {
+		opts = append(opts, WithTimeouts(*d.Timeouts))
+	}
+	if d.Quirks != 0 {
+		opts = append(opts, WithQuirks(d.Quirks))
+	}
+	if d.Settle > 0 {
+		opts = append(opts, WithSettleDelay(time.Duration(d.Settle)))
+	}
+	if d.Debounce > 0 {
+		opts = append(opts, WithDebounce(time.Duration(d.Debounce)))
+	}
+	if d.SkipNoop {
+		opts = append(opts, WithSkipNoop())
+	}
+	if d.SysfsControl {
+		opts = append(opts, WithSysfsControl())
+	}
+	if d.DetachSerialDriver {
+		opts = append(opts, WithDetachSerialDriver())
+	}
+	return opts
+}
+
+// PoolConfig configures device pools; see the pool package.
+type PoolConfig struct {
+	// Strategy names the pool strategy, e.g. "LeastRecentlyUsed".
+	Strategy string `json:"strategy,omitempty"`
+	// MaxFailures, if set, is how many consecutive failures evict a device.
+	MaxFailures *int `json:"max_failures,omitempty"`
+}
+
+// ServerConfig configures "sdwire serve".
+type ServerConfig struct {
+	// Socket is the Unix socket "sdwire serve" listens on.
+	Socket string `json:"socket,omitempty"`
+	// Listen is the TCP address "sdwire serve" listens on instead. An
+	// address without a host listens on loopback only.
+	Listen string `json:"listen,omitempty"`
+	// AuditLog is the file switches are audited to; see OpenAuditFile.
+	AuditLog string `json:"audit_log,omitempty"`
+	// Rules is a file of automation rules "sdwire serve" runs; see the
+	// rules package.
+	Rules string `json:"rules,omitempty"`
+	// Topology is the topology file resolving the DUTs named by rules.
+	Topology string `json:"topology,omitempty"`
+	// Debounce is the minimum interval between switches of a device
+	// through "sdwire serve"; see remote.Server.
+	Debounce Duration `json:"debounce,omitempty"`
+	// State is the file "sdwire serve" keeps the mode last requested for
+	// each device in, switching devices back to it when they reappear.
+	State string `json:"state,omitempty"`
+	// Jobs is the history file of the flash job queue "sdwire serve"
+	// serves; see the jobs package.
+	Jobs string `json:"jobs,omitempty"`
+	// ShutdownMode, "host" or "target", is the mode "sdwire serve"
+	// switches every device to when it is stopped.
+	ShutdownMode string `json:"shutdown_mode,omitempty"`
+}
+
+// WearConfig configures card wear tracking; see SetWearStore.
+type WearConfig struct {
+	// Store is the JSON file card wear is kept in.
+	Store string `json:"store"`
+	WearThresholds
+}
+
+// ParseConfig reads a configuration from YAML or JSON; see Config.
+func ParseConfig(r io.Reader) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	if !json.Valid(data) {
+		if data, err = yamlToJSON(data); err != nil {
+			return nil, fmt.Errorf("failed to parse config: %w", err)
+		}
+	}
+	var c Config
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if _, err := NewRegistry(c.Devices); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	if _, err := NewCardRegistry(c.Cards); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return &c, nil
+}
+
+// LoadConfig reads a configuration from a YAML or JSON file; see
+// ParseConfig.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config: %w", err)
+	}
+	defer f.Close()
+	return ParseConfig(f)
+}
+
+// Registry returns the registry of the configured devices.
+func (c *Config) Registry() (*Registry, error) {
+	return NewRegistry(c.Devices)
+}
+
+// Apply installs the configuration for the whole process: the device
+// registry, the detection rules, the default options, the card wear store
+// and the card registry. The pool, power and server settings are read by
+// their users.
+func (c *Config) Apply() error {
+	reg, err := c.Registry()
+	if err != nil {
+		return err
+	}
+	if len(c.Devices) > 0 {
+		SetRegistry(reg)
+	}
+	if c.Detection != nil {
+		SetDetection(*c.Detection)
+	}
+	SetDefaultOptions(c.Defaults.Options()...)
+	if c.Wear != nil && c.Wear.Store != "" {
+		SetWearStore(NewWearStore(c.Wear.Store, c.Wear.WearThresholds))
+	}
+	if len(c.Cards) > 0 {
+		cards, err := NewCardRegistry(c.Cards)
+		if err != nil {
+			return err
+		}
+		SetCardRegistry(cards)
+	}
+	return nil
+}
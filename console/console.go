@@ -0,0 +1,368 @@
+// Package console follows the serial console of a DUT after its card is
+// switched to the target, so tests can tell whether the image they just
+// flashed boots.
+package console
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/topology"
+)
+
+// ErrClosed is returned by WaitForPattern once the console is closed.
+var ErrClosed = errors.New("console closed")
+
+// DefaultBaud is used when a console does not set its baud rate.
+const DefaultBaud = 115200
+
+// maxLineLength bounds lines from consoles that never send a newline.
+const maxLineLength = 4096
+
+// Line is a line of console output.
+type Line struct {
+	Text string
+	Time time.Time
+}
+
+// Option configures a Console.
+type Option func(*Console)
+
+// WithHistory sets how many lines of the current boot are kept for
+// WaitForPattern and Lines. The default is 10000.
+func WithHistory(n int) Option {
+	return func(c *Console) {
+		c.history = n
+	}
+}
+
+// Switcher is a device whose mode changes a Console follows, such as an
+// *sdwire.SDWire.
+type Switcher interface {
+	OnModeChange(fn func(sdwire.ModeChange)) (cancel func())
+}
+
+// Console reads a DUT's serial console. Each time the port is attached a
+// new boot starts: the lines kept from the previous boot are dropped.
+type Console struct {
+	ref     topology.ConsoleRef
+	history int
+	detach  func()
+
+	mu       sync.Mutex
+	port     io.ReadCloser
+	boot     int
+	lines    []Line
+	dropped  int
+	partial  string
+	err      error
+	closed   bool
+	changed  chan struct{}
+	next     int
+	handlers map[int]func(Line)
+}
+
+func newConsole(ref topology.ConsoleRef, opts []Option) (*Console, error) {
+	if ref.Device == "" {
+		return nil, sdwire.WithCode(sdwire.CodeInvalidArgument, errors.New("console needs a device"))
+	}
+	if ref.Baud == 0 {
+		ref.Baud = DefaultBaud
+	}
+	c := &Console{
+		ref:      ref,
+		history:  10000,
+		changed:  make(chan struct{}),
+		handlers: make(map[int]func(Line)),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Open opens the console of ref and starts reading it.
+// The returned Console must be closed with Close() when done.
+func Open(ref topology.ConsoleRef, opts ...Option) (*Console, error) {
+	c, err := newConsole(ref, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.attach(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Attach follows dev: the console of ref is opened, starting a new boot,
+// whenever dev switches to the target, and closed when it switches back to
+// the host. Failures to open the port are returned by WaitForPattern.
+// The returned Console must be closed with Close() when done.
+func Attach(dev Switcher, ref topology.ConsoleRef, opts ...Option) (*Console, error) {
+	c, err := newConsole(ref, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.detach = dev.OnModeChange(func(m sdwire.ModeChange) {
+		if m.Mode == sdwire.ModeTarget {
+			c.attach()
+		} else {
+			c.release(nil)
+		}
+	})
+	return c, nil
+}
+
+// ForDUT follows dev with the console of the named DUT; see Attach.
+func ForDUT(t *topology.Topology, dut string, dev Switcher, opts ...Option) (*Console, error) {
+	d, ok := t.DUT(dut)
+	if !ok {
+		return nil, sdwire.WithCode(sdwire.CodeNotFound, fmt.Errorf("unknown DUT %q", dut))
+	}
+	if d.Console == nil {
+		return nil, sdwire.WithCode(sdwire.CodeInvalidArgument, fmt.Errorf("DUT %q has no console", dut))
+	}
+	return Attach(dev, *d.Console, opts...)
+}
+
+// attach opens the port, replacing any open one, and starts a new boot.
+func (c *Console) attach() error {
+	c.release(nil)
+	port, err := openPort(c.ref.Device, c.ref.Baud)
+	if err != nil {
+		err = fmt.Errorf("failed to open console %s: %w", c.ref.Device, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		if port != nil {
+			port.Close()
+		}
+		return ErrClosed
+	}
+	c.boot++
+	c.lines, c.dropped, c.partial, c.err = nil, 0, "", err
+	if err == nil {
+		c.port = port
+		go c.read(port, c.boot)
+	}
+	c.broadcast()
+	return err
+}
+
+// release closes the port, recording err as the reason.
+func (c *Console) release(err error) {
+	c.mu.Lock()
+	port := c.port
+	c.port = nil
+	if port != nil {
+		c.err = err
+		c.broadcast()
+	}
+	c.mu.Unlock()
+	if port != nil {
+		port.Close()
+	}
+}
+
+// broadcast wakes WaitForPattern calls. c.mu must be held.
+func (c *Console) broadcast() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *Console) read(port io.ReadCloser, boot int) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := port.Read(buf)
+		if n > 0 {
+			c.received(boot, buf[:n])
+		}
+		if err != nil {
+			c.mu.Lock()
+			current := c.port == port
+			c.mu.Unlock()
+			if current {
+				c.release(fmt.Errorf("failed to read console %s: %w", c.ref.Device, err))
+			}
+			return
+		}
+	}
+}
+
+// received splits data into lines and hands them to the handlers.
+func (c *Console) received(boot int, data []byte) {
+	now := time.Now()
+	c.mu.Lock()
+	if c.boot != boot {
+		c.mu.Unlock()
+		return
+	}
+	text := c.partial + string(data)
+	var lines []Line
+	for {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 && len(text) < maxLineLength {
+			break
+		}
+		if i < 0 {
+			i = maxLineLength
+		}
+		lines = append(lines, Line{Text: strings.TrimRight(text[:i], "\r"), Time: now})
+		if i < len(text) && text[i] == '\n' {
+			i++
+		}
+		text = text[i:]
+	}
+	c.partial = text
+	c.lines = append(c.lines, lines...)
+	if over := len(c.lines) - c.history; c.history > 0 && over > 0 {
+		c.lines = c.lines[over:]
+		c.dropped += over
+	}
+	handlers := make([]func(Line), 0, len(c.handlers))
+	for _, fn := range c.handlers {
+		handlers = append(handlers, fn)
+	}
+	c.broadcast()
+	c.mu.Unlock()
+
+	for _, line := range lines {
+		for _, fn := range handlers {
+			fn(line)
+		}
+	}
+}
+
+// OnLine registers fn to be called with each line read from the console,
+// and returns a function that unregisters it. fn runs on the goroutine
+// reading the console and should return quickly.
+func (c *Console) OnLine(fn func(Line)) (cancel func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.next
+	c.next++
+	c.handlers[id] = fn
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			delete(c.handlers, id)
+		})
+	}
+}
+
+// Subscribe returns a channel receiving lines read from the console, which
+// is closed once ctx is done. Lines are dropped while the channel's buffer
+// is full, so a slow reader never holds up the console; Lines keeps them.
+func (c *Console) Subscribe(ctx context.Context) <-chan Line {
+	ch := make(chan Line, 64)
+	// mu keeps a handler that is already running from sending on ch after
+	// it is closed.
+	var (
+		mu     sync.Mutex
+		closed bool
+	)
+	cancel := c.OnLine(func(l Line) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- l:
+		default:
+		}
+	})
+	context.AfterFunc(ctx, func() {
+		cancel()
+		mu.Lock()
+		defer mu.Unlock()
+		closed = true
+		close(ch)
+	})
+	return ch
+}
+
+// Lines returns the lines of the current boot, without the oldest ones
+// beyond the history limit.
+func (c *Console) Lines() []Line {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Line(nil), c.lines...)
+}
+
+// WaitForPattern waits until a line of the current boot matches re, and
+// returns it. Lines read before the call count, so it can be called after
+// switching to the target. A line still waiting for its newline, such as a
+// login prompt, matches too. It fails if the console cannot be read, or
+// when ctx is done.
+func (c *Console) WaitForPattern(ctx context.Context, re *regexp.Regexp) (Line, error) {
+	boot, seen := -1, 0
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return Line{}, ErrClosed
+		}
+		if c.boot != boot {
+			boot, seen = c.boot, c.dropped
+		}
+		start := max(seen-c.dropped, 0)
+		for _, line := range c.lines[start:] {
+			if re.MatchString(line.Text) {
+				c.mu.Unlock()
+				return line, nil
+			}
+		}
+		seen = c.dropped + len(c.lines)
+		if c.partial != "" && re.MatchString(c.partial) {
+			line := Line{Text: c.partial, Time: time.Now()}
+			c.mu.Unlock()
+			return line, nil
+		}
+		if c.port == nil && c.err != nil {
+			err := c.err
+			c.mu.Unlock()
+			return Line{}, err
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return Line{}, fmt.Errorf("waiting for %q on console %s: %w", re, c.ref.Device, ctx.Err())
+		}
+	}
+}
+
+// Close stops following the device and closes the port.
+func (c *Console) Close() error {
+	if c.detach != nil {
+		c.detach()
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	port := c.port
+	c.port = nil
+	c.broadcast()
+	c.mu.Unlock()
+	if port != nil {
+		return port.Close()
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package console
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/fcjr/sdwire"
+)
+
+var baudRates = map[int]uint32{
+	9600:    syscall.B9600,
+	19200:   syscall.B19200,
+	38400:   syscall.B38400,
+	57600:   syscall.B57600,
+	115200:  syscall.B115200,
+	230400:  syscall.B230400,
+	460800:  syscall.B460800,
+	921600:  syscall.B921600,
+	1500000: syscall.B1500000,
+	3000000: syscall.B3000000,
+}
+
+// cbaud masks the baud rate bits of Cflag, which syscall does not export.
+func cbaud() uint32 {
+	switch runtime.GOARCH {
+	case "ppc64", "ppc64le":
+		return 0xff
+	}
+	return 0x100f
+}
+
+// openPort opens a serial port for reading in raw mode at the given baud
+// rate.
+func openPort(device string, baud int) (io.ReadCloser, error) {
+	speed, ok := baudRates[baud]
+	if !ok {
+		return nil, sdwire.WithCode(sdwire.CodeInvalidArgument, fmt.Errorf("unsupported baud rate %d", baud))
+	}
+	// O_NONBLOCK keeps the open from waiting for carrier detect and lets
+	// Close interrupt reads.
+	f, err := os.OpenFile(device, os.O_RDONLY|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	var termErr error
+	err = conn.Control(func(fd uintptr) {
+		var t syscall.Termios
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCGETS, uintptr(unsafe.Pointer(&t))); errno != 0 {
+			termErr = errno
+			return
+		}
+		// Raw mode, as cfmakeraw sets it.
+		t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+		t.Oflag &^= syscall.OPOST
+		t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+		t.Cflag &^= syscall.CSIZE | syscall.PARENB | cbaud()
+		t.Cflag |= syscall.CS8 | syscall.CREAD | syscall.CLOCAL | speed
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCSETS, uintptr(unsafe.Pointer(&t))); errno != 0 {
+			termErr = errno
+		}
+	})
+	if err == nil {
+		err = termErr
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to configure serial port: %w", err)
+	}
+	return f, nil
+}
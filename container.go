@@ -0,0 +1,123 @@
+package sdwire
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// InContainer reports whether the process runs in a Docker, Podman or
+// Kubernetes container.
+func InContainer() bool {
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+	cg, err := os.Open("/proc/1/cgroup")
+	if err != nil {
+		return false
+	}
+	defer cg.Close()
+	sc := bufio.NewScanner(cg)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.Contains(line, "docker") || strings.Contains(line, "kubepods") || strings.Contains(line, "containerd") {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainerRunArgs returns docker run (or podman run) arguments that give
+// a container access to SDWire devices and the block devices of their
+// card readers. Devices re-enumerate when switched, so rather than passing
+// individual device nodes, the host's /dev is shared and the cgroup allows
+// all USB devices (major 189) and SCSI disks (major 8).
+//
+// Containers that cannot be given raw device access can control the
+// devices through a host-side server instead; see package remote.
+func ContainerRunArgs() []string {
+	return []string{
+		"-v", "/dev:/dev",
+		"--device-cgroup-rule", "c 189:* rmw",
+		"--device-cgroup-rule", "b 8:* rmw",
+	}
+}
+
+// checkContainer reports whether the device nodes of connected SDWire
+// devices are present and accessible from inside a container.
+func checkContainer() []Finding {
+	fix := "run the container with: " + strings.Join(ContainerRunArgs(), " ")
+	entries, err := os.ReadDir("/dev/bus/usb")
+	if err != nil || len(entries) == 0 {
+		return []Finding{{
+			Check:    "container",
+			Severity: SeverityError,
+			Message:  "running in a container without /dev/bus/usb",
+			Fix:      fix + ", or control the devices through a host-side server (sdwire serve)",
+		}}
+	}
+
+	var findings []Finding
+	dirs, _ := filepath.Glob("/sys/bus/usb/devices/*")
+	for _, dir := range dirs {
+		vendor, _ := sysfsHex(dir, "idVendor")
+		product, _ := sysfsHex(dir, "idProduct")
+		if !isSDWire(&deviceDesc{Vendor: vendor, Product: product}) {
+			continue
+		}
+		bus, berr := sysfsDecimal(dir, "busnum")
+		addr, aerr := sysfsDecimal(dir, "devnum")
+		if berr != nil || aerr != nil {
+			continue
+		}
+		node := fmt.Sprintf("/dev/bus/usb/%03d/%03d", bus, addr)
+		f := Finding{Check: "container", Message: fmt.Sprintf("device node %s for port %s is accessible", node, filepath.Base(dir))}
+		file, err := os.OpenFile(node, os.O_RDWR, 0)
+		switch {
+		case err == nil:
+			file.Close()
+		case errors.Is(err, os.ErrNotExist):
+			f.Severity = SeverityError
+			f.Message = fmt.Sprintf("device node %s for port %s is missing", node, filepath.Base(dir))
+			f.Fix = fix
+		case errors.Is(err, syscall.EPERM):
+			// Unlike file permissions (EACCES), the device cgroup denies
+			// access with EPERM.
+			f.Severity = SeverityError
+			f.Message = fmt.Sprintf("the container's device cgroup denies access to %s", node)
+			f.Fix = fix
+		default:
+			f.Severity = SeverityWarning
+			f.Message = fmt.Sprintf("device node %s cannot be opened: %v", node, err)
+		}
+		findings = append(findings, f)
+	}
+	if len(findings) == 0 {
+		findings = append(findings, Finding{Check: "container", Message: "running in a container with /dev/bus/usb available"})
+	}
+	return findings
+}
+
+func sysfsHex(dir, attr string) (uint16, error) {
+	data, err := os.ReadFile(filepath.Join(dir, attr))
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(strings.TrimSpace(string(data)), 16, 16)
+	return uint16(v), err
+}
+
+func sysfsDecimal(dir, attr string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(dir, attr))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,129 @@
+package sdwire
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DetectionMode selects how strictly devices are recognized as SDWires.
+type DetectionMode int
+
+const (
+	// DetectLenient treats every device with SDWire vendor and product IDs
+	// as an SDWire, whatever its product string. This is the default, and
+	// suits clones with their own product strings.
+	DetectLenient DetectionMode = iota
+	// DetectStrict also requires SDWireC devices to report one of
+	// Detection.Products, so other boards sharing their FTDI IDs are left
+	// alone.
+	DetectStrict
+)
+
+// MarshalText implements encoding.TextMarshaler.
+func (m DetectionMode) MarshalText() ([]byte, error) {
+	if m == DetectStrict {
+		return []byte("strict"), nil
+	}
+	return []byte("lenient"), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, accepting "lenient"
+// and "strict".
+func (m *DetectionMode) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "lenient":
+		*m = DetectLenient
+	case "strict":
+		*m = DetectStrict
+	default:
+		return fmt.Errorf("unknown detection mode %q", text)
+	}
+	return nil
+}
+
+// Detection configures which devices are recognized as SDWires. SDWire3
+// devices are recognized by their IDs alone; the product strings only
+// apply to SDWireC devices, whose IDs are shared with other boards.
+type Detection struct {
+	Mode DetectionMode `json:"mode,omitempty"`
+	// Products lists the product strings accepted in strict mode, compared
+	// ignoring case and surrounding space. Empty means SDWireCProductName.
+	Products []string `json:"products,omitempty"`
+	// Exclude lists product strings that are never accepted, even in
+	// lenient mode.
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+var (
+	detectionMu sync.RWMutex
+	detection   Detection
+)
+
+// SetDetection installs the detection rules used to find devices. The zero
+// Detection restores the default, lenient rules.
+func SetDetection(d Detection) {
+	detectionMu.Lock()
+	defer detectionMu.Unlock()
+	detection = d
+}
+
+// detectionRules returns the rules installed with SetDetection.
+func detectionRules() Detection {
+	detectionMu.RLock()
+	defer detectionMu.RUnlock()
+	return detection
+}
+
+// accepts reports whether the rules recognize dev as an SDWire, reading
+// its product string if they need it.
+func (d Detection) accepts(dev usbDevice) bool {
+	if d.Mode == DetectLenient && len(d.Exclude) == 0 {
+		return true
+	}
+	if generationOf(dev.Descriptor()) != GenerationSDWireC {
+		return true
+	}
+	product, err := dev.Product()
+	if err != nil {
+		// Without a product string only lenient rules can accept it.
+		return d.Mode == DetectLenient
+	}
+	if containsProduct(d.Exclude, product) {
+		return false
+	}
+	if d.Mode == DetectLenient {
+		return true
+	}
+	if len(d.Products) == 0 {
+		return containsProduct([]string{SDWireCProductName}, product)
+	}
+	return containsProduct(d.Products, product)
+}
+
+func containsProduct(products []string, product string) bool {
+	product = strings.TrimSpace(product)
+	for _, p := range products {
+		if strings.EqualFold(strings.TrimSpace(p), product) {
+			return true
+		}
+	}
+	return false
+}
+
+// openDetected opens the connected devices that match and that the
+// detection rules recognize as SDWires, closing the others.
+func openDetected(match func(*deviceDesc) bool) ([]usbDevice, error) {
+	devs, err := openSDWiresWhere(match)
+	rules := detectionRules()
+	kept := devs[:0]
+	for _, dev := range devs {
+		if rules.accepts(dev) {
+			kept = append(kept, dev)
+			continue
+		}
+		packageLogger().Debug("skipping device rejected by detection rules", "port", portPathOf(dev.Descriptor()))
+		dev.Close()
+	}
+	return kept, err
+}
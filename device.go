@@ -0,0 +1,39 @@
+package sdwire
+
+// Device is the switching interface of an open device. *SDWire implements
+// it, as do the fakes in package sdwiretest, so code that only switches
+// cards can be tested without hardware.
+type Device interface {
+	SetMode(mode SwitchMode) error
+	Probe() error
+	GetSerial() string
+	GetPortPath() string
+	GetGeneration() DeviceGeneration
+	Close() error
+}
+
+// Manager discovers and opens devices.
+type Manager interface {
+	ListDevices() ([]*DeviceInfo, error)
+	// Open opens a device by DeviceInfo.ID.
+	Open(id string, opts ...Option) (Device, error)
+}
+
+var _ Device = (*SDWire)(nil)
+
+// USB is the Manager for devices attached to this host.
+var USB Manager = usbManager{}
+
+type usbManager struct{}
+
+func (usbManager) ListDevices() ([]*DeviceInfo, error) {
+	return ListDevices()
+}
+
+func (usbManager) Open(id string, opts ...Option) (Device, error) {
+	s, err := NewWithID(id, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,71 @@
+package sdwire
+
+import "cmp"
+
+// DeviceID identifies a connected device by serial number, port path and
+// USB vendor and product IDs. Unlike a serial number alone it tells apart
+// clones sharing a serial number and devices whose serial number cannot be
+// read. DeviceIDs are comparable, so they can be used as map keys.
+type DeviceID struct {
+	// Serial is the serial number, or empty if it cannot be read.
+	Serial   string
+	PortPath string
+	// Vendor and Product are the USB IDs, or zero for GPIO muxes.
+	Vendor  uint16
+	Product uint16
+}
+
+// newDeviceID makes the ID of a device, dropping the placeholder serial
+// number reported for devices whose serial cannot be read.
+func newDeviceID(serial, portPath string, generation DeviceGeneration) DeviceID {
+	if serial == "unknown" {
+		serial = ""
+	}
+	id := DeviceID{Serial: serial, PortPath: portPath}
+	switch generation {
+	case GenerationSDWireC:
+		id.Vendor, id.Product = SDWireCVID, SDWireCPID
+	case GenerationSDWire3:
+		id.Vendor, id.Product = SDWire3VID, SDWire3PID
+	}
+	return id
+}
+
+// HasSerial reports whether the device's serial number is known.
+func (id DeviceID) HasSerial() bool {
+	return id.Serial != ""
+}
+
+// Equal reports whether id and other identify the same device.
+func (id DeviceID) Equal(other DeviceID) bool {
+	return id == other
+}
+
+// Less orders IDs by port path, then serial number, then vendor and
+// product, matching SortByPortPath.
+func (id DeviceID) Less(other DeviceID) bool {
+	if c := ComparePortPaths(id.PortPath, other.PortPath); c != 0 {
+		return c < 0
+	}
+	return cmp.Or(
+		cmp.Compare(id.Serial, other.Serial),
+		cmp.Compare(id.Vendor, other.Vendor),
+		cmp.Compare(id.Product, other.Product),
+	) < 0
+}
+
+// String formats the ID as the serial number and port path, e.g.
+// "bdgrd_sdwirec_522@1-2.3", or "@1-2.3" if the serial number is unknown.
+func (id DeviceID) String() string {
+	return id.Serial + "@" + id.PortPath
+}
+
+// DeviceID returns the ID of the listed device.
+func (d *DeviceInfo) DeviceID() DeviceID {
+	return newDeviceID(d.Serial, d.PortPath, d.Generation)
+}
+
+// DeviceID returns the ID of the device.
+func (s *SDWire) DeviceID() DeviceID {
+	return newDeviceID(s.serial, s.portPath, s.generation)
+}
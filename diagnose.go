@@ -0,0 +1,121 @@
+package sdwire
+
+import (
+	"fmt"
+)
+
+const (
+	ftdiSioReadEEPROMRequest = 0x90
+
+	// bcdDevice values of FTDI chip families.
+	ftdiReleaseFTX   = 0x1000
+	ftdiReleaseFT232 = 0x0600
+
+	// Words 0x40-0x4F of an FT-X EEPROM hold factory data that genuine
+	// chips always populate.
+	ftxFactoryStart = 0x40
+	ftxFactoryEnd   = 0x50
+)
+
+// Diagnosis is the result of Diagnose.
+type Diagnosis struct {
+	// Chip describes the detected controller chip, e.g. "FT230X".
+	Chip string
+	// Release is the bcdDevice value.
+	Release uint16
+	// Genuine is false if any check suggests a clone or unexpected chip.
+	Genuine bool
+	// Quirks are the workarounds enabled for this device.
+	Quirks Quirks
+	// Warnings explain each failed check.
+	Warnings []string
+}
+
+// Diagnose checks the device's controller chip for signs of a clone or
+// unexpected part and enables workarounds for the quirks it finds, so
+// later calls to SetMode adapt. It does not change the switch state.
+//
+// SDWireC boards use an FTDI FT230X. Clones are recognized by their
+// bcdDevice release, an EEPROM that cannot be read or disagrees with the
+// USB descriptor, or a missing factory data area.
+func (s *SDWire) Diagnose() (*Diagnosis, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.device == nil {
+		return nil, fmt.Errorf("device not initialized")
+	}
+	desc := s.device.Descriptor()
+	d := &Diagnosis{Release: desc.Device, Genuine: true}
+	warn := func(format string, args ...any) {
+		d.Genuine = false
+		d.Warnings = append(d.Warnings, fmt.Sprintf(format, args...))
+	}
+
+	if s.generation == GenerationSDWire3 {
+		d.Chip = "Realtek card reader"
+		return d, nil
+	}
+
+	switch d.Release {
+	case ftdiReleaseFTX:
+		d.Chip = "FT230X"
+	case ftdiReleaseFT232:
+		d.Chip = "FT232R"
+		warn("FT232R chip (bcdDevice 0x%04x) where an FT230X is expected", d.Release)
+	default:
+		d.Chip = "unknown"
+		warn("unrecognized bcdDevice 0x%04x, expected 0x%04x for an FT230X", d.Release, ftdiReleaseFTX)
+	}
+
+	vid, err := s.readEEPROMWord(1)
+	if err == nil {
+		var pid uint16
+		pid, err = s.readEEPROMWord(2)
+		if err == nil && (vid != desc.Vendor || pid != desc.Product) {
+			warn("EEPROM IDs %04x:%04x differ from the USB descriptor %04x:%04x", vid, pid, desc.Vendor, desc.Product)
+			d.Quirks |= QuirkResetBitmode
+		}
+	}
+	if err != nil {
+		warn("EEPROM cannot be read: %v", err)
+		d.Quirks |= QuirkNoEEPROM | QuirkResetBitmode
+	} else if d.Release == ftdiReleaseFTX {
+		populated := false
+		for word := ftxFactoryStart; word < ftxFactoryEnd; word++ {
+			v, err := s.readEEPROMWord(word)
+			if err != nil {
+				break
+			}
+			if v != 0x0000 && v != 0xFFFF {
+				populated = true
+				break
+			}
+		}
+		if !populated {
+			warn("FT-X factory data area is empty")
+			d.Quirks |= QuirkResetBitmode
+		}
+	}
+
+	s.setQuirks(s.quirks | d.Quirks)
+	d.Quirks = s.quirks
+	if !d.Genuine {
+		s.log.Warn("device looks like a clone", "chip", d.Chip, "quirks", d.Quirks, "warnings", d.Warnings)
+	}
+	return d, nil
+}
+
+// readEEPROMWord reads one word of the FTDI EEPROM. Callers must hold s.mu.
+func (s *SDWire) readEEPROMWord(word int) (uint16, error) {
+	buf := make([]byte, 2)
+	n, err := s.device.Control(controlIn|controlVendor|controlDevice,
+		ftdiSioReadEEPROMRequest, 0, uint16(word), buf)
+	if err != nil {
+		return 0, err
+	}
+	if n != 2 {
+		return 0, fmt.Errorf("short EEPROM read")
+	}
+	return uint16(buf[0]) | uint16(buf[1])<<8, nil
+}
@@ -0,0 +1,127 @@
+package sdwire
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transfer records one USB operation issued to a device.
+type Transfer struct {
+	Time time.Time
+	// Op is "control" for control transfers, "reset" for port resets,
+	// "attach" for binding the card reader's driver, "authorize" for
+	// writing its sysfs authorization (Value 1 to authorize, 0 not to), or
+	// "gpio" for setting the lines of a GPIO mux (Value 1 for the host, 0
+	// for the target). Only control transfers set the other request fields.
+	Op          string
+	RequestType uint8
+	Request     uint8
+	Value       uint16
+	Index       uint16
+	// Length is the number of bytes transferred.
+	Length   int
+	Duration time.Duration
+	Err      error
+}
+
+// String formats the transfer as a single log line.
+func (t Transfer) String() string {
+	result := "ok"
+	if t.Err != nil {
+		result = t.Err.Error()
+	}
+	at := t.Time.Format(time.RFC3339Nano)
+	switch t.Op {
+	case "control":
+		return fmt.Sprintf("%s control type=0x%02x req=0x%02x value=0x%04x index=0x%04x len=%d %v: %s",
+			at, t.RequestType, t.Request, t.Value, t.Index, t.Length, t.Duration, result)
+	case "authorize":
+		return fmt.Sprintf("%s authorize authorized=%t %v: %s", at, t.Value != 0, t.Duration, result)
+	case "gpio":
+		side := "target"
+		if t.Value != 0 {
+			side = "host"
+		}
+		return fmt.Sprintf("%s gpio %s %v: %s", at, side, t.Duration, result)
+	}
+	// "reset", "attach" and anything newer carry no arguments.
+	return fmt.Sprintf("%s %s %v: %s", at, t.Op, t.Duration, result)
+}
+
+// transferRing keeps the most recent transfers. A nil ring records nothing.
+type transferRing struct {
+	mu   sync.Mutex
+	buf  []Transfer
+	next int
+	full bool
+}
+
+func newTransferRing(size int) *transferRing {
+	if size <= 0 {
+		return nil
+	}
+	return &transferRing{buf: make([]Transfer, size)}
+}
+
+func (r *transferRing) add(t Transfer) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buf[r.next] = t
+	r.next = (r.next + 1) % len(r.buf)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// snapshot returns the recorded transfers, oldest first.
+func (r *transferRing) snapshot() []Transfer {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]Transfer(nil), r.buf[:r.next]...)
+	}
+	return append(append([]Transfer(nil), r.buf[r.next:]...), r.buf[:r.next]...)
+}
+
+// Diagnostics returns the most recent USB transfers issued to the device,
+// oldest first. It returns nil unless the device was opened with
+// WithDiagnostics.
+func (s *SDWire) Diagnostics() []Transfer {
+	return s.diag.snapshot()
+}
+
+// DiagnosticsError wraps an error from a device opened with WithDiagnostics,
+// carrying the transfers that led up to it.
+type DiagnosticsError struct {
+	Err       error
+	Transfers []Transfer
+}
+
+func (e *DiagnosticsError) Error() string { return e.Err.Error() }
+func (e *DiagnosticsError) Unwrap() error { return e.Err }
+
+// Dump formats the transfers, one per line.
+func (e *DiagnosticsError) Dump() string {
+	var b strings.Builder
+	for _, t := range e.Transfers {
+		b.WriteString(t.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// withDiagnostics attaches the recorded transfers to err, if enabled.
+func (s *SDWire) withDiagnostics(err error) error {
+	if err == nil || s.diag == nil {
+		return err
+	}
+	return &DiagnosticsError{Err: err, Transfers: s.diag.snapshot()}
+}
@@ -0,0 +1,194 @@
+package sdwire
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Severity grades a Finding.
+type Severity int
+
+const (
+	// SeverityOK reports a check that passed.
+	SeverityOK Severity = iota
+	// SeverityWarning reports something that may cause problems.
+	SeverityWarning
+	// SeverityError reports something that prevents using devices.
+	SeverityError
+)
+
+// String returns a human-readable description of the severity.
+func (s Severity) String() string {
+	switch s {
+	case SeverityOK:
+		return "OK"
+	case SeverityWarning:
+		return "Warning"
+	case SeverityError:
+		return "Error"
+	default:
+		return "Unknown"
+	}
+}
+
+// Finding is the result of one environment check made by Diagnose.
+type Finding struct {
+	// Check names the check, e.g. "udev".
+	Check    string
+	Severity Severity
+	Message  string
+	// Fix suggests how to resolve a problem, if there is one.
+	Fix string
+}
+
+// udevRuleDirs are searched for SDWire udev rules.
+var udevRuleDirs = []string{"/etc/udev/rules.d", "/run/udev/rules.d", "/lib/udev/rules.d", "/usr/lib/udev/rules.d"}
+
+// Diagnose checks that the host is set up to use SDWire devices: the USB
+// backend (libusb, or usbfs in cgo-free builds), device access, udev
+// rules, group membership, kernel drivers claiming the SDWireC and
+// container device access. It is meant
+// for a "doctor" report when devices cannot be found or opened; to check a
+// single device for clone chips, use SDWire.Diagnose.
+func Diagnose() []Finding {
+	findings := []Finding{checkBackend()}
+	if findings[0].Severity == SeverityError {
+		return findings
+	}
+	findings = append(findings, checkDevices())
+	if runtime.GOOS == "linux" {
+		findings = append(findings, checkUdevRules(), checkGroups())
+		findings = append(findings, checkKernelDrivers()...)
+		if InContainer() {
+			findings = append(findings, checkContainer()...)
+		}
+	}
+	return findings
+}
+
+func checkDevices() Finding {
+	f := Finding{Check: "devices"}
+	devices, err := ListDevices()
+	var perr *PermissionError
+	switch {
+	case errors.As(err, &perr):
+		f.Severity = SeverityError
+		f.Message = fmt.Sprintf("no access to device %04x:%04x at port %s", perr.Vendor, perr.Product, perr.PortPath)
+		f.Fix = fmt.Sprintf("add this rule to %s and replug the device: %s", UdevRulePath, perr.Rule)
+	case err != nil:
+		f.Severity = SeverityError
+		f.Message = err.Error()
+	case len(devices) == 0:
+		f.Severity = SeverityWarning
+		f.Message = "no SDWire devices found"
+		f.Fix = "check the USB cable and that the device is listed by lsusb"
+	default:
+		f.Message = fmt.Sprintf("%d SDWire devices found", len(devices))
+	}
+	return f
+}
+
+func checkUdevRules() Finding {
+	f := Finding{Check: "udev"}
+	want := map[string]string{
+		"SDWireC": fmt.Sprintf("%04x", SDWireCPID),
+		"SDWire3": fmt.Sprintf("%04x", SDWire3PID),
+	}
+	var found []string
+	for _, dir := range udevRuleDirs {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.rules"))
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			text := strings.ToLower(string(data))
+			for name, pid := range want {
+				if strings.Contains(text, pid) {
+					found = append(found, name+" in "+file)
+					delete(want, name)
+				}
+			}
+		}
+	}
+	if len(want) > 0 {
+		f.Severity = SeverityWarning
+		f.Message = "no udev rule found for some devices"
+		if len(found) > 0 {
+			f.Message += "; found " + strings.Join(found, ", ")
+		}
+		f.Fix = "add these rules to " + UdevRulePath + ":\n" + UdevRules()
+		return f
+	}
+	f.Message = "udev rules found: " + strings.Join(found, ", ")
+	return f
+}
+
+func checkGroups() Finding {
+	f := Finding{Check: "groups"}
+	u, err := user.Current()
+	if err != nil {
+		f.Severity = SeverityWarning
+		f.Message = fmt.Sprintf("cannot determine the current user: %v", err)
+		return f
+	}
+	if u.Uid == "0" {
+		f.Message = "running as root"
+		return f
+	}
+	ids, _ := u.GroupIds()
+	var names []string
+	member := false
+	for _, id := range ids {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			continue
+		}
+		names = append(names, g.Name)
+		if g.Name == "plugdev" {
+			member = true
+		}
+	}
+	if _, err := user.LookupGroup("plugdev"); err == nil && !member {
+		f.Severity = SeverityWarning
+		f.Message = fmt.Sprintf("user %s is not in the plugdev group (groups: %s)", u.Username, strings.Join(names, ", "))
+		f.Fix = "sudo usermod -a -G plugdev " + u.Username + ", then log in again"
+		return f
+	}
+	f.Message = fmt.Sprintf("user %s, groups: %s", u.Username, strings.Join(names, ", "))
+	return f
+}
+
+// checkKernelDrivers reports SDWireC devices claimed by the ftdi_sio serial
+// driver, which makes bitmode commands fail intermittently.
+func checkKernelDrivers() []Finding {
+	devices, err := ListDevices()
+	if err != nil {
+		return nil
+	}
+	var findings []Finding
+	for _, d := range devices {
+		if d.Generation != GenerationSDWireC || storageDriversOnly {
+			continue
+		}
+		driver, err := interfaceDriver(d.PortPath, 0)
+		if err != nil || driver == "" {
+			continue
+		}
+		findings = append(findings, Finding{
+			Check:    "kernel-driver",
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("%s at port %s is claimed by the %s driver", d.ID, d.PortPath, driver),
+			Fix:      fmt.Sprintf("open the device with WithDetachSerialDriver, or run: echo %s:1.0 | sudo tee /sys/bus/usb/drivers/%s/unbind", d.PortPath, driver),
+		})
+	}
+	if len(findings) == 0 {
+		findings = append(findings, Finding{Check: "kernel-driver", Message: "no SDWireC is claimed by a kernel driver"})
+	}
+	return findings
+}
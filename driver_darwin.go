@@ -0,0 +1,82 @@
+package sdwire
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// ioregMaxAge is how long interfaceDriver reuses the output of ioreg,
+// which dumps every USB device with its properties and takes tens of
+// milliseconds: polls for a binding to settle read it at most this often.
+// Switches made by this process discard it at once.
+const ioregMaxAge = 300 * time.Millisecond
+
+var ioregCache struct {
+	sync.Mutex
+	out     []byte
+	at      time.Time
+	changes uint64
+}
+
+// storageDriversOnly is set where interfaceDriver only reports mass
+// storage drivers, so that other devices need no lookup.
+const storageDriversOnly = true
+
+// interfaceDriver reports the IOKit mass storage driver attached to the
+// device at portPath, or "" if none is, by reading the I/O Registry with
+// ioreg. macOS has no per-interface driver names like Linux, so intf is
+// ignored; the SDWire3 has a single interface.
+func interfaceDriver(portPath string, intf int) (string, error) {
+	location, err := locationID(portPath)
+	if err != nil {
+		return "", err
+	}
+	out, err := ioregUSB()
+	if err != nil {
+		return "", fmt.Errorf("failed to read driver binding: %w", err)
+	}
+	driver, found := parseIORegDriver(out, location)
+	if !found {
+		return "", fmt.Errorf("failed to read driver binding: device at port %s not in the I/O Registry", portPath)
+	}
+	return driver, nil
+}
+
+// ioregUSB returns the USB devices in the I/O Registry, from the cache if
+// it is recent enough.
+func ioregUSB() ([]byte, error) {
+	c := &ioregCache
+	c.Lock()
+	defer c.Unlock()
+	changes := bindingChanges.Load()
+	if c.out != nil && c.changes == changes && time.Since(c.at) < ioregMaxAge {
+		return c.out, nil
+	}
+	out, err := exec.Command("ioreg", "-r", "-c", "IOUSBHostDevice", "-l", "-w0").Output()
+	if err != nil {
+		return nil, err
+	}
+	c.out, c.at, c.changes = out, time.Now(), changes
+	return out, nil
+}
+
+// setInterfaceAuthorized is only supported on Linux.
+func setInterfaceAuthorized(string, int, bool) error {
+	return WithCode(CodeUnsupported, errors.New("interface authorization is only supported on Linux"))
+}
+
+// probeInterfaceDriver is only supported on Linux. Asking IOKit to
+// re-probe the mass storage service needs cgo and the IOKit framework, so
+// switching an SDWire3 to the host resets it on macOS instead.
+func probeInterfaceDriver(string, int) error {
+	return WithCode(CodeUnsupported, errors.New("probing interface drivers is only supported on Linux"))
+}
+
+// serialPortPaths reports false: there is no sysfs to find devices by
+// serial number without opening them.
+func serialPortPaths(string) ([]string, bool) {
+	return nil, false
+}
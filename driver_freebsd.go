@@ -0,0 +1,126 @@
+package sdwire
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// storageDriversOnly is set where interfaceDriver only reports mass
+// storage drivers; the sysctl tree shows every driver.
+const storageDriversOnly = false
+
+// usbAttachment is a driver instance attached to a USB device, as
+// described by its dev.<driver>.<unit>.%location sysctl.
+type usbAttachment struct {
+	driver string
+	unit   int
+	// bus, hubAddr, port and devAddr locate the device; hubAddr is 0 for
+	// root hubs.
+	bus, hubAddr, port, devAddr int
+	intf                        int
+}
+
+// usbAttachments lists the driver instances attached to USB devices.
+func usbAttachments() ([]usbAttachment, error) {
+	out, err := exec.Command("sysctl", "-e", "dev").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read device tree: %w", err)
+	}
+	var attachments []usbAttachment
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		// dev.umass.0.%location=bus=0 hubaddr=2 port=1 devaddr=3 interface=0 ugen=ugen0.3
+		key, value, ok := strings.Cut(sc.Text(), "=")
+		if !ok || !strings.HasSuffix(key, ".%location") || !strings.Contains(value, "devaddr=") {
+			continue
+		}
+		parts := strings.Split(key, ".")
+		if len(parts) != 4 {
+			continue
+		}
+		a := usbAttachment{driver: parts[1]}
+		a.unit, _ = strconv.Atoi(parts[2])
+		for _, field := range strings.Fields(value) {
+			k, v, _ := strings.Cut(field, "=")
+			n, _ := strconv.Atoi(v)
+			switch k {
+			case "bus":
+				a.bus = n
+			case "hubaddr":
+				a.hubAddr = n
+			case "port":
+				a.port = n
+			case "devaddr":
+				a.devAddr = n
+			case "interface":
+				a.intf = n
+			}
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, sc.Err()
+}
+
+// portPaths computes the port path of each attachment by walking up the
+// hubs, in the form portPathOf uses.
+func portPaths(attachments []usbAttachment) []string {
+	hubs := make(map[[2]int]usbAttachment)
+	for _, a := range attachments {
+		if a.driver == "uhub" {
+			hubs[[2]int{a.bus, a.devAddr}] = a
+		}
+	}
+	paths := make([]string, len(attachments))
+	for i, a := range attachments {
+		var ports []string
+		for cur, depth := a, 0; cur.hubAddr != 0 && depth < 8; depth++ {
+			ports = append([]string{strconv.Itoa(cur.port)}, ports...)
+			hub, ok := hubs[[2]int{cur.bus, cur.hubAddr}]
+			if !ok {
+				break
+			}
+			cur = hub
+		}
+		if len(ports) == 0 {
+			ports = []string{"0"}
+		}
+		paths[i] = strconv.Itoa(a.bus) + "-" + strings.Join(ports, ".")
+	}
+	return paths
+}
+
+// interfaceDriver returns the name of the driver attached to interface
+// intf of the device at portPath, or "" if none is, from the device tree.
+func interfaceDriver(portPath string, intf int) (string, error) {
+	attachments, err := usbAttachments()
+	if err != nil {
+		return "", fmt.Errorf("failed to read driver binding: %w", err)
+	}
+	for i, path := range portPaths(attachments) {
+		if a := attachments[i]; path == portPath && a.intf == intf && a.driver != "uhub" {
+			return a.driver, nil
+		}
+	}
+	return "", nil
+}
+
+// setInterfaceAuthorized is only supported on Linux.
+func setInterfaceAuthorized(string, int, bool) error {
+	return WithCode(CodeUnsupported, errors.New("interface authorization is only supported on Linux"))
+}
+
+// probeInterfaceDriver is only supported on Linux.
+func probeInterfaceDriver(string, int) error {
+	return WithCode(CodeUnsupported, errors.New("probing interface drivers is only supported on Linux"))
+}
+
+// serialPortPaths reports false: there is no sysfs to find devices by
+// serial number without opening them.
+func serialPortPaths(string) ([]string, bool) {
+	return nil, false
+}
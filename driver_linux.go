@@ -0,0 +1,88 @@
+package sdwire
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// storageDriversOnly is set where interfaceDriver only reports mass
+// storage drivers; sysfs shows every driver.
+const storageDriversOnly = false
+
+// interfaceDriver returns the name of the kernel driver bound to interface
+// intf of configuration 1 of the device at portPath, or "" if none is
+// bound, by reading sysfs.
+func interfaceDriver(portPath string, intf int) (string, error) {
+	dir := filepath.Join("/sys/bus/usb/devices", fmt.Sprintf("%s:1.%d", portPath, intf))
+	target, err := os.Readlink(filepath.Join(dir, "driver"))
+	if errors.Is(err, os.ErrNotExist) {
+		if _, serr := os.Stat(dir); serr != nil {
+			// The device is gone or still re-enumerating.
+			return "", fmt.Errorf("failed to read driver binding: %w", serr)
+		}
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read driver binding: %w", err)
+	}
+	return filepath.Base(target), nil
+}
+
+// serialPortPaths returns the port paths of the SDWire devices whose serial
+// number, as cached by the kernel in sysfs, is serial. It reports false if
+// sysfs is not available, in which case the devices must be opened to read
+// their serial numbers.
+func serialPortPaths(serial string) ([]string, bool) {
+	dirs, _ := filepath.Glob("/sys/bus/usb/devices/*")
+	if len(dirs) == 0 {
+		return nil, false
+	}
+	var paths []string
+	for _, dir := range dirs {
+		// Skip interfaces ("1-2:1.0") and root hubs ("usb1").
+		name := filepath.Base(dir)
+		if strings.ContainsRune(name, ':') || strings.HasPrefix(name, "usb") {
+			continue
+		}
+		vendor, _ := sysfsHex(dir, "idVendor")
+		product, _ := sysfsHex(dir, "idProduct")
+		if !isSDWire(&deviceDesc{Vendor: vendor, Product: product}) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "serial"))
+		if err == nil && strings.TrimSuffix(string(data), "\n") == serial {
+			paths = append(paths, name)
+		}
+	}
+	return paths, true
+}
+
+// setInterfaceAuthorized writes the authorized attribute of interface intf
+// of configuration 1 of the device at portPath. Deauthorizing an interface
+// unbinds its driver and keeps drivers from binding until it is authorized
+// again.
+func setInterfaceAuthorized(portPath string, intf int, authorized bool) error {
+	value := "0"
+	if authorized {
+		value = "1"
+	}
+	path := filepath.Join("/sys/bus/usb/devices", fmt.Sprintf("%s:1.%d", portPath, intf), "authorized")
+	if err := os.WriteFile(path, []byte(value), 0); err != nil {
+		return fmt.Errorf("failed to write interface authorization: %w", err)
+	}
+	return nil
+}
+
+// probeInterfaceDriver asks the kernel to probe drivers for interface intf
+// of configuration 1 of the device at portPath, binding its driver again
+// without resetting the device.
+func probeInterfaceDriver(portPath string, intf int) error {
+	name := fmt.Sprintf("%s:1.%d", portPath, intf)
+	if err := os.WriteFile("/sys/bus/usb/drivers_probe", []byte(name), 0); err != nil {
+		return fmt.Errorf("failed to probe interface driver: %w", err)
+	}
+	return nil
+}
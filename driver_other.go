@@ -0,0 +1,29 @@
+//go:build !linux && !darwin && !freebsd
+
+package sdwire
+
+import "errors"
+
+// storageDriversOnly is set where interfaceDriver only reports mass
+// storage drivers; no drivers are visible here.
+const storageDriversOnly = false
+
+func interfaceDriver(string, int) (string, error) {
+	return "", errDriverUnknown
+}
+
+// setInterfaceAuthorized is only supported on Linux.
+func setInterfaceAuthorized(string, int, bool) error {
+	return WithCode(CodeUnsupported, errors.New("interface authorization is only supported on Linux"))
+}
+
+// probeInterfaceDriver is only supported on Linux.
+func probeInterfaceDriver(string, int) error {
+	return WithCode(CodeUnsupported, errors.New("probing interface drivers is only supported on Linux"))
+}
+
+// serialPortPaths reports false: there is no sysfs to find devices by
+// serial number without opening them.
+func serialPortPaths(string) ([]string, bool) {
+	return nil, false
+}
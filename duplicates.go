@@ -0,0 +1,40 @@
+package sdwire
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MultipleMatchesError is returned when more than one connected device
+// reports the requested serial number. Open one of them with
+// NewWithSerialAt or NewWithPortPath, and give it a unique serial with the
+// eeprom package.
+type MultipleMatchesError struct {
+	Serial    string
+	PortPaths []string
+}
+
+func (e *MultipleMatchesError) Error() string {
+	return fmt.Sprintf("%d SDWire devices share serial %s (ports %s)",
+		len(e.PortPaths), e.Serial, strings.Join(e.PortPaths, ", "))
+}
+
+// ErrorCode implements the interface used by CodeOf.
+func (e *MultipleMatchesError) ErrorCode() ErrorCode {
+	return CodeAmbiguous
+}
+
+// markDuplicateSerials flags devices whose serial number is not unique, and
+// identifies them by port path instead.
+func markDuplicateSerials(devices []*DeviceInfo) {
+	count := make(map[string]int, len(devices))
+	for _, d := range devices {
+		count[d.Serial]++
+	}
+	for _, d := range devices {
+		d.DuplicateSerial = count[d.Serial] > 1
+		if d.DuplicateSerial {
+			d.ID = PortIDPrefix + d.PortPath
+		}
+	}
+}
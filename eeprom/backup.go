@@ -0,0 +1,51 @@
+package eeprom
+
+import (
+	"fmt"
+	"os"
+)
+
+// Backup reads the EEPROM and saves the raw image to path, in the same
+// format as libftdi's ftdi_eeprom --read.
+func Backup(dev Device, path string) (*Image, error) {
+	img, err := Read(dev)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, img[:], 0o644); err != nil {
+		return nil, fmt.Errorf("failed to save EEPROM backup: %w", err)
+	}
+	return img, nil
+}
+
+// LoadImage reads an image saved by Backup.
+func LoadImage(path string) (*Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read EEPROM backup: %w", err)
+	}
+	if len(data) != Size {
+		return nil, fmt.Errorf("EEPROM backup %s is %d bytes, expected %d", path, len(data), Size)
+	}
+	var img Image
+	copy(img[:], data)
+	return &img, nil
+}
+
+// Restore writes the image saved at path back to the EEPROM. The factory
+// data area is taken from the chip rather than the backup, and the
+// checksum is recomputed, so a backup from another unit can also be used
+// as a template.
+func Restore(dev Device, path string) error {
+	backup, err := LoadImage(path)
+	if err != nil {
+		return err
+	}
+	img, err := Read(dev)
+	if err != nil {
+		return err
+	}
+	copy(img[:userAreaEnd*2], backup[:userAreaEnd*2])
+	copy(img[factoryAreaEnd*2:], backup[factoryAreaEnd*2:])
+	return Write(dev, img)
+}
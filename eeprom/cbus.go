@@ -0,0 +1,77 @@
+package eeprom
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CBUSPins is the number of CBUS pins on the FT230X.
+const CBUSPins = 4
+
+// SDWireCBUS is the CBUS configuration SDWireC boards need: CBUS0 drives
+// the multiplexer and must be a GPIO for the CBUS bitbang mode used by
+// SetMode. The other pins keep the FT230X factory functions.
+var SDWireCBUS = [CBUSPins]CBUSFunction{CBUSIOMode, CBUSRxLED, CBUSTxLED, CBUSSleep}
+
+// ParseCBUSFunction returns the function with the given name, as printed
+// by CBUSFunction.String. Names are case-insensitive.
+func ParseCBUSFunction(name string) (CBUSFunction, error) {
+	for i, n := range cbusNames {
+		if strings.EqualFold(n, name) {
+			return CBUSFunction(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown CBUS function %q", name)
+}
+
+// CBUS returns the functions assigned to the CBUS pins.
+func (img *Image) CBUS() [CBUSPins]CBUSFunction {
+	var fns [CBUSPins]CBUSFunction
+	for i := range fns {
+		fns[i] = CBUSFunction(img[offsetCBUS+i])
+	}
+	return fns
+}
+
+// SetCBUS assigns functions to the CBUS pins.
+func (img *Image) SetCBUS(fns [CBUSPins]CBUSFunction) error {
+	for i, f := range fns {
+		if int(f) >= len(cbusNames) {
+			return fmt.Errorf("invalid function %d for CBUS%d", f, i)
+		}
+	}
+	for i, f := range fns {
+		img[offsetCBUS+i] = byte(f)
+	}
+	return nil
+}
+
+// WriteCBUS programs the CBUS pin functions, keeping all other settings.
+func WriteCBUS(dev Device, fns [CBUSPins]CBUSFunction) error {
+	img, err := Read(dev)
+	if err != nil {
+		return err
+	}
+	if err := img.SetCBUS(fns); err != nil {
+		return err
+	}
+	return Write(dev, img)
+}
+
+// ResetToSDWireDefaults restores the CBUS functions and the manufacturer
+// and product strings SDWireC boards ship with, keeping the serial number.
+// Use it to recover a board whose EEPROM was reset or overwritten by other
+// FTDI tools.
+func ResetToSDWireDefaults(dev Device) error {
+	img, err := Read(dev)
+	if err != nil {
+		return err
+	}
+	if err := img.SetCBUS(SDWireCBUS); err != nil {
+		return err
+	}
+	if err := img.SetStrings(DefaultStrings.Manufacturer, DefaultStrings.Product, img.Serial()); err != nil {
+		return err
+	}
+	return Write(dev, img)
+}
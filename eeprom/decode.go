@@ -0,0 +1,142 @@
+package eeprom
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// Header fields.
+const (
+	offsetVendorID   = 0x02
+	offsetProductID  = 0x04
+	offsetRelease    = 0x06
+	offsetAttributes = 0x08
+	offsetMaxPower   = 0x09
+	offsetInvert     = 0x0B
+	offsetCBUS       = 0x1A
+
+	attrSelfPowered  = 0x40
+	attrRemoteWakeup = 0x20
+)
+
+// CBUSFunction is the function assigned to an FT230X CBUS pin.
+type CBUSFunction byte
+
+// CBUS pin functions, as numbered in the EEPROM.
+const (
+	CBUSTristate CBUSFunction = iota
+	CBUSTxLED
+	CBUSRxLED
+	CBUSTxRxLED
+	CBUSPowerEnable
+	CBUSSleep
+	CBUSDrive0
+	CBUSDrive1
+	CBUSIOMode
+	CBUSTxDEN
+	CBUSClock24
+	CBUSClock12
+	CBUSClock6
+	CBUSBatteryDetect
+	CBUSBatteryDetectNeg
+	CBUSI2CTxE
+	CBUSI2CRxF
+	CBUSVBusSense
+	CBUSBitbangWrite
+	CBUSBitbangRead
+	CBUSTimestamp
+	CBUSKeepAwake
+)
+
+var cbusNames = []string{
+	"Tristate", "TXLED", "RXLED", "TXRXLED", "PWREN", "SLEEP", "Drive0", "Drive1",
+	"IOMODE", "TXDEN", "CLK24", "CLK12", "CLK6", "BAT_DETECT", "BAT_DETECT_NEG",
+	"I2C_TXE", "I2C_RXF", "VBUS_SENSE", "BB_WR", "BB_RD", "TIMESTAMP", "KEEP_AWAKE",
+}
+
+// String returns the FTDI name of the function.
+func (f CBUSFunction) String() string {
+	if int(f) < len(cbusNames) {
+		return cbusNames[f]
+	}
+	return "Unknown"
+}
+
+// Config is the decoded EEPROM contents.
+type Config struct {
+	VendorID  uint16
+	ProductID uint16
+	// Release is the bcdDevice value reported by the chip.
+	Release      uint16
+	SelfPowered  bool
+	RemoteWakeup bool
+	// MaxPower is the maximum bus current in mA.
+	MaxPower int
+	Strings
+	// InvertedSignals has a bit set for each inverted UART signal.
+	InvertedSignals byte
+	CBUS            [CBUSPins]CBUSFunction
+	ChecksumValid   bool
+}
+
+// Decode decodes the image.
+func (img *Image) Decode() *Config {
+	c := &Config{
+		VendorID:        img.word(offsetVendorID),
+		ProductID:       img.word(offsetProductID),
+		Release:         img.word(offsetRelease),
+		SelfPowered:     img[offsetAttributes]&attrSelfPowered != 0,
+		RemoteWakeup:    img[offsetAttributes]&attrRemoteWakeup != 0,
+		MaxPower:        int(img[offsetMaxPower]) * 2,
+		Strings:         img.Strings(),
+		InvertedSignals: img[offsetInvert],
+		CBUS:            img.CBUS(),
+		ChecksumValid:   img.ChecksumValid(),
+	}
+	return c
+}
+
+func (img *Image) word(offset int) uint16 {
+	return uint16(img[offset]) | uint16(img[offset+1])<<8
+}
+
+// ReadConfig reads the EEPROM and returns both the raw image and its
+// decoded contents.
+func ReadConfig(dev Device) (*Image, *Config, error) {
+	img, err := Read(dev)
+	if err != nil {
+		return nil, nil, err
+	}
+	return img, img.Decode(), nil
+}
+
+// WriteTo writes a human-readable description of the configuration.
+func (c *Config) WriteTo(w io.Writer) (int64, error) {
+	checksum := "valid"
+	if !c.ChecksumValid {
+		checksum = "INVALID"
+	}
+	n, err := fmt.Fprintf(w,
+		"Vendor ID:       0x%04x\n"+
+			"Product ID:      0x%04x\n"+
+			"Release:         0x%04x\n"+
+			"Manufacturer:    %s\n"+
+			"Product:         %s\n"+
+			"Serial:          %s\n"+
+			"Self powered:    %t\n"+
+			"Remote wakeup:   %t\n"+
+			"Max power:       %d mA\n"+
+			"Inverted:        0x%02x\n"+
+			"CBUS0..3:        %s %s %s %s\n"+
+			"Checksum:        %s\n",
+		c.VendorID, c.ProductID, c.Release, c.Manufacturer, c.Product, c.Serial,
+		c.SelfPowered, c.RemoteWakeup, c.MaxPower, c.InvertedSignals,
+		c.CBUS[0], c.CBUS[1], c.CBUS[2], c.CBUS[3], checksum)
+	return int64(n), err
+}
+
+// Dump returns a hex dump of the image.
+func (img *Image) Dump() string {
+	return hex.Dump(img[:])
+}
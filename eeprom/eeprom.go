@@ -0,0 +1,158 @@
+// Package eeprom reads and programs the configuration EEPROM of the FTDI
+// FT230X chip on SDWireC boards: USB strings such as the serial number,
+// and the CBUS pin functions used for switching.
+//
+// The layout and checksum follow libftdi, which sd-mux-ctrl uses. New
+// settings take effect once the device re-enumerates, e.g. after it is
+// unplugged and plugged back in.
+package eeprom
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Size is the size of the FT230X EEPROM image in bytes.
+const Size = 0x100
+
+// FTDI vendor requests.
+const (
+	sioResetRequest           = 0x00
+	sioPollModemStatusRequest = 0x05
+	sioSetLatencyTimerRequest = 0x09
+	sioReadEEPROMRequest      = 0x90
+	sioWriteEEPROMRequest     = 0x91
+)
+
+// Request types for FTDI vendor requests: vendor, device recipient.
+const (
+	requestTypeIn  = 0xC0
+	requestTypeOut = 0x40
+)
+
+// Image layout.
+const (
+	offsetConfig       = 0x0A // chip configuration bits
+	offsetManufacturer = 0x0E // string offset, then descriptor length
+	offsetProduct      = 0x10
+	offsetSerial       = 0x12
+	stringsStart       = 0xA0
+	checksumOffset     = Size - 2
+
+	useSerialBit = 0x08
+
+	// Words 0x12-0x3F are a user area outside the checksum; words
+	// 0x40-0x4F hold factory configuration data that must not be written.
+	userAreaStart  = 0x12
+	userAreaEnd    = 0x40
+	factoryAreaEnd = 0x50
+)
+
+const (
+	// interfaceIndex addresses the FT230X's only UART interface.
+	interfaceIndex = 1
+	// writeLatency is the latency timer FTDI's tools set before writing.
+	writeLatency = 0x77
+	// verifyReadDelay lets the chip finish its last write before verifying.
+	verifyReadDelay = 10 * time.Millisecond
+)
+
+// ErrVerify is returned when the EEPROM does not read back as written.
+var ErrVerify = errors.New("EEPROM contents do not match what was written")
+
+// Device issues USB control transfers to an FT230X. *sdwire.SDWire
+// satisfies it.
+type Device interface {
+	Control(rType, request uint8, value, index uint16, data []byte) (int, error)
+}
+
+// Image is the raw EEPROM contents.
+type Image [Size]byte
+
+// Read reads the full EEPROM image.
+func Read(dev Device) (*Image, error) {
+	var img Image
+	for word := 0; word < Size/2; word++ {
+		buf := img[word*2 : word*2+2]
+		n, err := dev.Control(requestTypeIn, sioReadEEPROMRequest, 0, uint16(word), buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read EEPROM word 0x%02x: %w", word, err)
+		}
+		if n != 2 {
+			return nil, fmt.Errorf("failed to read EEPROM word 0x%02x: short read", word)
+		}
+	}
+	return &img, nil
+}
+
+// Write programs img into the EEPROM after updating its checksum, then
+// reads it back to verify it. The factory data area is never written, so
+// img should come from Read.
+func Write(dev Device, img *Image) error {
+	img.UpdateChecksum()
+
+	// The setup sequence mirrors what FTDI's own tools send before writing.
+	if _, err := dev.Control(requestTypeOut, sioResetRequest, 0, interfaceIndex, nil); err != nil {
+		return fmt.Errorf("failed to reset FTDI chip: %w", err)
+	}
+	status := make([]byte, 2)
+	if _, err := dev.Control(requestTypeIn, sioPollModemStatusRequest, 0, interfaceIndex, status); err != nil {
+		return fmt.Errorf("failed to poll FTDI modem status: %w", err)
+	}
+	if _, err := dev.Control(requestTypeOut, sioSetLatencyTimerRequest, writeLatency, interfaceIndex, nil); err != nil {
+		return fmt.Errorf("failed to set FTDI latency timer: %w", err)
+	}
+
+	for word := 0; word < Size/2; word++ {
+		if word >= userAreaEnd && word < factoryAreaEnd {
+			continue
+		}
+		value := uint16(img[word*2]) | uint16(img[word*2+1])<<8
+		if _, err := dev.Control(requestTypeOut, sioWriteEEPROMRequest, value, uint16(word), nil); err != nil {
+			return fmt.Errorf("failed to write EEPROM word 0x%02x: %w", word, err)
+		}
+	}
+
+	time.Sleep(verifyReadDelay)
+	got, err := Read(dev)
+	if err != nil {
+		return err
+	}
+	for word := 0; word < Size/2; word++ {
+		if word >= userAreaEnd && word < factoryAreaEnd {
+			continue
+		}
+		if got[word*2] != img[word*2] || got[word*2+1] != img[word*2+1] {
+			return fmt.Errorf("%w: word 0x%02x", ErrVerify, word)
+		}
+	}
+	return nil
+}
+
+// Checksum computes the checksum of the image as the chip expects it.
+func (img *Image) Checksum() uint16 {
+	checksum := uint16(0xAAAA)
+	for word := 0; word < Size/2-1; word++ {
+		if word >= userAreaStart && word < userAreaEnd {
+			continue
+		}
+		value := uint16(img[word*2]) | uint16(img[word*2+1])<<8
+		checksum ^= value
+		checksum = checksum<<1 | checksum>>15
+	}
+	return checksum
+}
+
+// ChecksumValid reports whether the stored checksum matches the contents.
+func (img *Image) ChecksumValid() bool {
+	stored := uint16(img[checksumOffset]) | uint16(img[checksumOffset+1])<<8
+	return stored == img.Checksum()
+}
+
+// UpdateChecksum stores the checksum of the current contents.
+func (img *Image) UpdateChecksum() {
+	checksum := img.Checksum()
+	img[checksumOffset] = byte(checksum)
+	img[checksumOffset+1] = byte(checksum >> 8)
+}
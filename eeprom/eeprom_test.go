@@ -0,0 +1,59 @@
+package eeprom
+
+import "testing"
+
+func TestSetStrings(t *testing.T) {
+	var img Image
+	if err := img.SetStrings("SRPOL", "sd-wire", "sdwire_11"); err != nil {
+		t.Fatalf("SetStrings: %v", err)
+	}
+	if got := img.Manufacturer(); got != "SRPOL" {
+		t.Errorf("Manufacturer = %q, want %q", got, "SRPOL")
+	}
+	if got := img.Product(); got != "sd-wire" {
+		t.Errorf("Product = %q, want %q", got, "sd-wire")
+	}
+	if got := img.Serial(); got != "sdwire_11" {
+		t.Errorf("Serial = %q, want %q", got, "sdwire_11")
+	}
+
+	if err := img.SetStrings("SRPOL", "sd-wire", ""); err != nil {
+		t.Fatalf("SetStrings: %v", err)
+	}
+	if got := img.Serial(); got != "" {
+		t.Errorf("Serial = %q after clearing it", got)
+	}
+}
+
+func TestSetStringsTooLong(t *testing.T) {
+	var img Image
+	long := string(make([]byte, 40))
+	if err := img.SetStrings(long, "sd-wire", "sdwire_11"); err == nil {
+		t.Error("SetStrings accepted strings longer than the string area")
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	var img Image
+	if err := img.SetStrings("SRPOL", "sd-wire", "sdwire_11"); err != nil {
+		t.Fatalf("SetStrings: %v", err)
+	}
+	if img.ChecksumValid() {
+		t.Fatal("checksum valid before UpdateChecksum")
+	}
+	img.UpdateChecksum()
+	if !img.ChecksumValid() {
+		t.Fatal("checksum invalid after UpdateChecksum")
+	}
+
+	// The user area is outside the checksum.
+	img[userAreaStart*2] ^= 0xFF
+	if !img.ChecksumValid() {
+		t.Error("changing the user area invalidated the checksum")
+	}
+
+	img[offsetConfig] ^= 0x01
+	if img.ChecksumValid() {
+		t.Error("changing the configuration did not invalidate the checksum")
+	}
+}
@@ -0,0 +1,73 @@
+package eeprom
+
+import (
+	"fmt"
+
+	"github.com/fcjr/sdwire"
+)
+
+// Strings are the USB strings stored in the EEPROM.
+type Strings struct {
+	Manufacturer string
+	Product      string
+	Serial       string
+}
+
+// DefaultStrings are the manufacturer and product strings genuine SDWireC
+// boards ship with. sd-mux-ctrl and other tools find devices by product.
+var DefaultStrings = Strings{Manufacturer: "SRPOL", Product: "sd-wire"}
+
+// Strings returns the USB strings stored in the image.
+func (img *Image) Strings() Strings {
+	return Strings{
+		Manufacturer: img.Manufacturer(),
+		Product:      img.Product(),
+		Serial:       img.Serial(),
+	}
+}
+
+// SetSerial programs a new USB serial number, keeping the other strings.
+func SetSerial(dev Device, serial string) error {
+	if serial == "" {
+		return fmt.Errorf("serial number must not be empty")
+	}
+	_, err := WriteStrings(dev, Strings{Serial: serial})
+	return err
+}
+
+// WriteStrings programs the USB strings. Empty fields keep their current
+// value. It returns the strings that were replaced.
+func WriteStrings(dev Device, s Strings) (old Strings, err error) {
+	img, err := Read(dev)
+	if err != nil {
+		return Strings{}, err
+	}
+	old = img.Strings()
+	next := MergeStrings(old, s)
+	if err := img.SetStrings(next.Manufacturer, next.Product, next.Serial); err != nil {
+		return old, err
+	}
+	return old, Write(dev, img)
+}
+
+// MergeStrings returns current with the non-empty fields of update applied.
+func MergeStrings(current, update Strings) Strings {
+	if update.Manufacturer != "" {
+		current.Manufacturer = update.Manufacturer
+	}
+	if update.Product != "" {
+		current.Product = update.Product
+	}
+	if update.Serial != "" {
+		current.Serial = update.Serial
+	}
+	return current
+}
+
+// Supported reports whether dev has an FT230X EEPROM. Only SDWireC boards
+// do; SDWire3 devices have no programmable EEPROM, and neither do clones
+// that Diagnose found without a readable one.
+func Supported(dev *sdwire.SDWire) bool {
+	return dev.GetGeneration() == sdwire.GenerationSDWireC &&
+		!dev.GetQuirks().Has(sdwire.QuirkNoEEPROM)
+}
@@ -0,0 +1,85 @@
+package eeprom
+
+import (
+	"fmt"
+	"unicode/utf16"
+)
+
+// stringsEnd is the end of the string area; the checksum follows it.
+const stringsEnd = checksumOffset
+
+// Manufacturer returns the USB manufacturer string.
+func (img *Image) Manufacturer() string {
+	return img.stringAt(offsetManufacturer)
+}
+
+// Product returns the USB product string.
+func (img *Image) Product() string {
+	return img.stringAt(offsetProduct)
+}
+
+// Serial returns the USB serial number, or "" if the serial number is
+// disabled.
+func (img *Image) Serial() string {
+	if img[offsetConfig]&useSerialBit == 0 {
+		return ""
+	}
+	return img.stringAt(offsetSerial)
+}
+
+// stringAt decodes the USB string descriptor referenced by the offset and
+// length bytes at field.
+func (img *Image) stringAt(field int) string {
+	start := int(img[field])
+	length := int(img[field+1])
+	if length < 2 || start+length > Size {
+		return ""
+	}
+	desc := img[start : start+length]
+	units := make([]uint16, 0, (length-2)/2)
+	for i := 2; i+1 < len(desc); i += 2 {
+		units = append(units, uint16(desc[i])|uint16(desc[i+1])<<8)
+	}
+	return string(utf16.Decode(units))
+}
+
+// SetStrings rewrites the manufacturer, product and serial strings. The
+// three strings share the string area, which holds 44 UTF-16 code units
+// in total. An empty serial disables the serial number.
+func (img *Image) SetStrings(manufacturer, product, serial string) error {
+	fields := []struct {
+		offset int
+		value  string
+	}{
+		{offsetManufacturer, manufacturer},
+		{offsetProduct, product},
+		{offsetSerial, serial},
+	}
+
+	var area [stringsEnd - stringsStart]byte
+	pos := 0
+	for _, f := range fields {
+		units := utf16.Encode([]rune(f.value))
+		length := 2 + 2*len(units)
+		if pos+length > len(area) {
+			return fmt.Errorf("strings too long for EEPROM: at most %d characters in total", (len(area)-6)/2)
+		}
+		area[pos] = byte(length)
+		area[pos+1] = 0x03 // string descriptor
+		for i, u := range units {
+			area[pos+2+2*i] = byte(u)
+			area[pos+3+2*i] = byte(u >> 8)
+		}
+		img[f.offset] = byte(stringsStart + pos)
+		img[f.offset+1] = byte(length)
+		pos += length
+	}
+
+	copy(img[stringsStart:stringsEnd], area[:])
+	if serial == "" {
+		img[offsetConfig] &^= useSerialBit
+	} else {
+		img[offsetConfig] |= useSerialBit
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package sdwire
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+// ErrorCode classifies errors for automated triage. The numeric values and
+// names are stable.
+type ErrorCode int
+
+const (
+	CodeUnknown ErrorCode = iota
+	CodeUSBTimeout
+	CodeUSBPipe
+	CodeUSBIO
+	CodePermission
+	CodeNotFound
+	CodeDeviceGone
+	CodeBusy
+	CodeVerifyFailed
+	CodeCardMissing
+	CodeCanceled
+	CodeTimeout
+	CodeInvalidArgument
+	CodeUnsupported
+	CodeAmbiguous
+)
+
+var errorCodeNames = map[ErrorCode]string{
+	CodeUnknown:         "UNKNOWN",
+	CodeUSBTimeout:      "USB_TIMEOUT",
+	CodeUSBPipe:         "USB_PIPE",
+	CodeUSBIO:           "USB_IO",
+	CodePermission:      "PERMISSION",
+	CodeNotFound:        "NOT_FOUND",
+	CodeDeviceGone:      "DEVICE_GONE",
+	CodeBusy:            "BUSY",
+	CodeVerifyFailed:    "VERIFY_FAILED",
+	CodeCardMissing:     "CARD_MISSING",
+	CodeCanceled:        "CANCELED",
+	CodeTimeout:         "TIMEOUT",
+	CodeInvalidArgument: "INVALID_ARGUMENT",
+	CodeUnsupported:     "UNSUPPORTED",
+	CodeAmbiguous:       "AMBIGUOUS",
+}
+
+// String returns the stable name of the code, e.g. "USB_TIMEOUT".
+func (c ErrorCode) String() string {
+	if name, ok := errorCodeNames[c]; ok {
+		return name
+	}
+	return "UNKNOWN"
+}
+
+// ParseErrorCode returns the code with the given name, or CodeUnknown.
+func ParseErrorCode(name string) ErrorCode {
+	for c, n := range errorCodeNames {
+		if n == name {
+			return c
+		}
+	}
+	return CodeUnknown
+}
+
+// Error is an error annotated with an ErrorCode.
+type Error struct {
+	Code ErrorCode
+	Err  error
+}
+
+// WithCode annotates err with code. It returns nil if err is nil.
+func WithCode(code ErrorCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: code, Err: err}
+}
+
+func (e *Error) Error() string        { return e.Err.Error() }
+func (e *Error) Unwrap() error        { return e.Err }
+func (e *Error) ErrorCode() ErrorCode { return e.Code }
+
+// CodeOf classifies err. Codes attached with WithCode take precedence;
+// otherwise USB errors, lock contention, permission and context errors
+// are recognized anywhere in the chain. It returns CodeUnknown for nil and
+// unrecognized errors.
+func CodeOf(err error) ErrorCode {
+	if err == nil {
+		return CodeUnknown
+	}
+	var coded interface{ ErrorCode() ErrorCode }
+	if errors.As(err, &coded) {
+		return coded.ErrorCode()
+	}
+
+	if usbErr, ok := usbErrorOf(err); ok {
+		switch usbErr {
+		case errUSBTimeout:
+			return CodeUSBTimeout
+		case errUSBPipe:
+			return CodeUSBPipe
+		case errUSBAccess:
+			return CodePermission
+		case errUSBNoDevice:
+			return CodeDeviceGone
+		case errUSBNotFound:
+			return CodeNotFound
+		case errUSBBusy:
+			return CodeBusy
+		case errUSBNotSupported:
+			return CodeUnsupported
+		case errUSBInvalidParam:
+			return CodeInvalidArgument
+		case errUSBInterrupted:
+			return CodeCanceled
+		default:
+			return CodeUSBIO
+		}
+	}
+
+	switch {
+	case errors.Is(err, ErrDeviceLocked):
+		return CodeBusy
+	case errors.Is(err, os.ErrPermission):
+		return CodePermission
+	case errors.Is(err, os.ErrNotExist):
+		return CodeNotFound
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return CodeTimeout
+	case errors.Is(err, context.Canceled), errors.Is(err, ErrSwitchCanceled):
+		return CodeCanceled
+	}
+	return CodeUnknown
+}
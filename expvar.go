@@ -0,0 +1,69 @@
+package sdwire
+
+import (
+	"expvar"
+	"time"
+)
+
+// expvarDevice is the expvar form of a device's Stats.
+type expvarDevice struct {
+	OpenHandles     int     `json:"open_handles"`
+	Switches        uint64  `json:"switches"`
+	Errors          uint64  `json:"errors"`
+	Mode            string  `json:"mode,omitempty"`
+	LastError       string  `json:"last_error,omitempty"`
+	LastErrorTime   string  `json:"last_error_time,omitempty"`
+	BytesFlashed    int64   `json:"bytes_flashed"`
+	FlashRate       float64 `json:"flash_bytes_per_second,omitempty"`
+	SinceModeChange float64 `json:"seconds_since_mode_change,omitempty"`
+}
+
+// PublishExpvar publishes the statistics of every device used by this
+// process under the given expvar name, served with the other expvar
+// variables at /debug/vars:
+//
+//	{"open_handles": 1, "devices": {"serial-bdgrd_sdwirec_522": {"switches": 4, ...}}}
+//
+// Each device reports its open handles, switch and error counts, last
+// error and flash throughput; see Stats. Values are computed when read, so
+// publishing costs nothing until /debug/vars is fetched. Like
+// expvar.Publish, it panics if the name is already in use.
+func PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(expvarSnapshot))
+}
+
+func expvarSnapshot() any {
+	statsMu.Lock()
+	all := make(map[string]*deviceStats, len(statsByDevice))
+	for key, d := range statsByDevice {
+		all[key] = d
+	}
+	statsMu.Unlock()
+
+	open := 0
+	devices := make(map[string]expvarDevice, len(all))
+	for key, d := range all {
+		s := d.snapshot()
+		open += s.OpenHandles
+		v := expvarDevice{
+			OpenHandles:  s.OpenHandles,
+			Switches:     s.Switches,
+			Errors:       s.Errors,
+			LastError:    s.LastError,
+			BytesFlashed: s.BytesFlashed,
+			FlashRate:    d.flashRate(),
+		}
+		if !s.LastModeChange.IsZero() {
+			v.Mode = s.Mode.String()
+			v.SinceModeChange = time.Since(s.LastModeChange).Seconds()
+		}
+		if !s.LastErrorTime.IsZero() {
+			v.LastErrorTime = s.LastErrorTime.Format(time.RFC3339)
+		}
+		devices[key] = v
+	}
+	return map[string]any{
+		"open_handles": open,
+		"devices":      devices,
+	}
+}
@@ -0,0 +1,165 @@
+package sdwire
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Fault describes misbehavior to inject into USB operations. A fault
+// applies to operations named Op: "control", "reset", "set_auto_detach"
+// or "config", or every operation if Op is empty.
+type Fault struct {
+	Op string
+	// After skips the first After matching operations, so After: 2 with
+	// Count: 1 fails only the third.
+	After int
+	// Count limits how many operations fail; 0 means no limit.
+	Count int
+	// Probability fails each matching operation at random with the given
+	// probability; 0 fails every matching operation.
+	Probability float64
+	// Delay slows down matching operations. A fault with a Delay and no Err
+	// only slows operations down.
+	Delay time.Duration
+	// Err is returned by failing operations.
+	Err error
+}
+
+// FailNth fails the nth (1-based) operation named op with err.
+func FailNth(op string, n int, err error) Fault {
+	return Fault{Op: op, After: n - 1, Count: 1, Err: err}
+}
+
+// RandomPipeErrors fails each control transfer with EPIPE with the given
+// probability.
+func RandomPipeErrors(probability float64) Fault {
+	return Fault{Op: "control", Probability: probability, Err: errUSBPipe}
+}
+
+// SlowTransfers delays every control transfer by d.
+func SlowTransfers(d time.Duration) Fault {
+	return Fault{Op: "control", Delay: d}
+}
+
+// FaultInjector makes a device's USB operations fail in configurable ways,
+// for testing retry and recovery logic against realistic hardware
+// misbehavior. Install it with WithFaults. It is safe for concurrent use,
+// so faults can be added while operations are in flight.
+type FaultInjector struct {
+	mu        sync.Mutex
+	faults    []*faultState
+	rand      *rand.Rand
+	unplugged bool
+}
+
+type faultState struct {
+	Fault
+	seen   int
+	failed int
+}
+
+// NewFaultInjector creates an injector with the given faults.
+func NewFaultInjector(faults ...Fault) *FaultInjector {
+	f := &FaultInjector{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	for _, fault := range faults {
+		f.Add(fault)
+	}
+	return f
+}
+
+// Seed makes random faults reproducible.
+func (f *FaultInjector) Seed(seed int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rand = rand.New(rand.NewSource(seed))
+}
+
+// Add installs another fault.
+func (f *FaultInjector) Add(fault Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, &faultState{Fault: fault})
+}
+
+// Unplug makes the device disappear: every later operation fails with
+// LIBUSB_ERROR_NO_DEVICE, as if it had been unplugged, e.g. mid-flash.
+func (f *FaultInjector) Unplug() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unplugged = true
+}
+
+// Clear removes all faults and plugs the device back in.
+func (f *FaultInjector) Clear() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = nil
+	f.unplugged = false
+}
+
+// inject applies the faults to one operation, returning the error it
+// should fail with.
+func (f *FaultInjector) inject(op string) error {
+	f.mu.Lock()
+	if f.unplugged {
+		f.mu.Unlock()
+		return errUSBNoDevice
+	}
+	var delay time.Duration
+	var err error
+	for _, s := range f.faults {
+		if s.Op != "" && s.Op != op {
+			continue
+		}
+		s.seen++
+		if s.seen <= s.After || (s.Count > 0 && s.failed >= s.Count) {
+			continue
+		}
+		if s.Probability > 0 && f.rand.Float64() >= s.Probability {
+			continue
+		}
+		s.failed++
+		delay += s.Delay
+		if err == nil {
+			err = s.Err
+		}
+	}
+	f.mu.Unlock()
+	time.Sleep(delay)
+	return err
+}
+
+// faultDevice wraps a device with a FaultInjector.
+type faultDevice struct {
+	usbDevice
+	faults *FaultInjector
+}
+
+func (d *faultDevice) Control(rType, request uint8, value, index uint16, data []byte) (int, error) {
+	if err := d.faults.inject("control"); err != nil {
+		return 0, err
+	}
+	return d.usbDevice.Control(rType, request, value, index, data)
+}
+
+func (d *faultDevice) Reset() error {
+	if err := d.faults.inject("reset"); err != nil {
+		return err
+	}
+	return d.usbDevice.Reset()
+}
+
+func (d *faultDevice) SetAutoDetach(autodetach bool) error {
+	if err := d.faults.inject("set_auto_detach"); err != nil {
+		return err
+	}
+	return d.usbDevice.SetAutoDetach(autodetach)
+}
+
+func (d *faultDevice) Config(cfgNum int) (usbConfig, error) {
+	if err := d.faults.inject("config"); err != nil {
+		return nil, err
+	}
+	return d.usbDevice.Config(cfgNum)
+}
@@ -0,0 +1,55 @@
+package sdwire
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Firmware describes the firmware version a device reports.
+//
+// SDWire3 boards are built around a USB card reader whose firmware
+// version is its bcdDevice release number. On SDWireC boards the
+// release number identifies the FTDI chip revision instead.
+type Firmware struct {
+	// Version is the bcdDevice release number, e.g. "2.04".
+	Version string
+	// BCD is the raw bcdDevice value.
+	BCD uint16
+	// Descriptions holds the non-empty configuration and interface
+	// strings the device exposes, which vendors sometimes use for build
+	// information.
+	Descriptions []string
+}
+
+// Firmware returns the device's firmware information.
+func (s *SDWire) Firmware() (Firmware, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.device == nil {
+		return Firmware{}, fmt.Errorf("device not initialized")
+	}
+	desc := s.device.Descriptor()
+	fw := Firmware{
+		Version: bcdString(desc.Device),
+		BCD:     desc.Device,
+	}
+
+	cfgNums := make([]int, 0, len(desc.Configs))
+	for n := range desc.Configs {
+		cfgNums = append(cfgNums, n)
+	}
+	sort.Ints(cfgNums)
+	for _, n := range cfgNums {
+		if d, err := s.device.ConfigDescription(n); err == nil && d != "" {
+			fw.Descriptions = append(fw.Descriptions, d)
+		}
+		for _, intf := range desc.Configs[n] {
+			d, err := s.device.InterfaceDescription(n, intf.Number, intf.Alternate)
+			if err == nil && d != "" {
+				fw.Descriptions = append(fw.Descriptions, d)
+			}
+		}
+	}
+	return fw, nil
+}
@@ -2,4 +2,7 @@ module github.com/fcjr/sdwire
 
 go 1.23
 
-require github.com/google/gousb v1.1.3
+require (
+	github.com/google/gousb v1.1.3
+	gopkg.in/yaml.v3 v3.0.1
+)
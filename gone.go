@@ -0,0 +1,82 @@
+package sdwire
+
+import (
+	"fmt"
+	"time"
+)
+
+// DeviceGoneError is returned when the device was unplugged or otherwise
+// disappeared from the bus. Once it has been returned, every later
+// operation on the handle fails fast with it; reopen the device once it is
+// back.
+type DeviceGoneError struct {
+	// Info is the last known information about the device.
+	Info DeviceInfo
+	// Op is the operation during which the device disappeared.
+	Op  string
+	Err error
+}
+
+func (e *DeviceGoneError) Error() string {
+	return fmt.Sprintf("SDWire device %s at port %s is gone (during %s): %v", e.Info.Serial, e.Info.PortPath, e.Op, e.Err)
+}
+
+func (e *DeviceGoneError) Unwrap() error { return e.Err }
+
+// ErrorCode implements the interface used by CodeOf.
+func (e *DeviceGoneError) ErrorCode() ErrorCode {
+	return CodeDeviceGone
+}
+
+// globalGoneListeners receives removals detected on open devices.
+var globalGoneListeners listeners[DeviceEvent]
+
+// OnDeviceGone registers fn to be called with a DeviceRemoved event when an
+// open device in this process disappears during an operation, and returns
+// a function that unregisters it. fn runs on its own goroutine.
+func OnDeviceGone(fn func(DeviceEvent)) (cancel func()) {
+	return globalGoneListeners.add(fn)
+}
+
+// info returns the device's information as ListDevices would report it.
+func (s *SDWire) info() DeviceInfo {
+	info := DeviceInfo{
+		Serial:       s.serial,
+		Product:      s.product,
+		Manufacturer: s.manufacturer,
+		PortPath:     s.portPath,
+		Generation:   s.generation,
+		Identity:     s.identity,
+	}
+	if s.device != nil {
+		info.FirmwareVersion = bcdString(s.device.Descriptor().Device)
+	}
+	return info
+}
+
+// checkGone turns an error reporting that the device disappeared into a
+// DeviceGoneError, marks the handle dead and notifies OnDeviceGone
+// listeners. Other errors are returned unchanged. Callers must hold s.mu.
+func (s *SDWire) checkGone(op string, err error) error {
+	if err == nil || s.gone != nil || CodeOf(err) != CodeDeviceGone {
+		return err
+	}
+	s.gone = &DeviceGoneError{Info: s.info(), Op: op, Err: err}
+	s.log.Debug("device gone", "op", op, "error", err)
+	s.metrics.Error(s.serial, op)
+	ev := DeviceEvent{Type: DeviceRemoved, Info: &s.gone.Info, Time: time.Now()}
+	go globalGoneListeners.notify(ev)
+	return s.gone
+}
+
+// usable returns ErrClosed if the device was closed, and the error that
+// marked it gone if it was unplugged. Callers must hold s.mu.
+func (s *SDWire) usable() error {
+	if s.closed {
+		return ErrClosed
+	}
+	if s.gone != nil {
+		return s.gone
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package sdwire
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// GPIOMux describes a home-made SD mux, such as a relay board or an analog
+// switch, driven by Linux GPIO lines. Opened with OpenGPIO it behaves like
+// an SDWire, so pools, servers and workflows work with it unchanged.
+type GPIOMux struct {
+	// Name identifies the mux in place of a serial number, e.g.
+	// "bench-mux-1".
+	Name string
+	// Chip is the GPIO chip, e.g. "/dev/gpiochip0" or "gpiochip0".
+	Chip string
+	// Lines are the offsets of the lines switching the mux. They are all
+	// driven together: active for the host and inactive for the target.
+	Lines []int
+	// ActiveLow makes low the active level, as relay boards that switch
+	// on a low input need.
+	ActiveLow bool
+	// ReaderPortPath is the USB port path of the card reader on the
+	// host side of the mux, e.g. "1-1.3". BlockDevices uses it to find
+	// the card.
+	ReaderPortPath string
+}
+
+func (m *GPIOMux) validate() error {
+	switch {
+	case m.Name == "":
+		return WithCode(CodeInvalidArgument, errors.New("GPIO mux needs a name"))
+	case m.Chip == "":
+		return WithCode(CodeInvalidArgument, fmt.Errorf("GPIO mux %s needs a chip", m.Name))
+	case len(m.Lines) == 0:
+		return WithCode(CodeInvalidArgument, fmt.Errorf("GPIO mux %s needs at least one line", m.Name))
+	}
+	return nil
+}
+
+// Info describes the mux as ListDevices describes SDWires.
+func (m *GPIOMux) Info() *DeviceInfo {
+	return &DeviceInfo{
+		ID:         m.Name,
+		Serial:     m.Name,
+		Product:    gpioProduct,
+		PortPath:   m.ReaderPortPath,
+		Generation: GenerationGPIO,
+		Identity:   lookupIdentity(m.Name, m.ReaderPortPath),
+	}
+}
+
+const gpioProduct = "GPIO SD mux"
+
+// OpenGPIO opens a GPIO-driven mux. The lines are held until Close, and
+// opening leaves them at their current levels, so the card stays where it
+// is.
+// The returned SDWire must be closed with Close() when done.
+func OpenGPIO(mux *GPIOMux, opts ...Option) (*SDWire, error) {
+	if err := mux.validate(); err != nil {
+		return nil, err
+	}
+	lines, err := openGPIOLines(mux.Chip, mux.Lines, mux.ActiveLow, "sdwire")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GPIO mux %s: %w", mux.Name, err)
+	}
+	return open(&gpioDevice{mux: mux, lines: lines}, mux.Name, newOptions(opts))
+}
+
+// GPIOManager returns a Manager for GPIO-driven muxes, e.g. to serve them
+// with the remote package.
+func GPIOManager(muxes ...*GPIOMux) Manager {
+	return gpioManager(muxes)
+}
+
+type gpioManager []*GPIOMux
+
+func (m gpioManager) ListDevices() ([]*DeviceInfo, error) {
+	infos := make([]*DeviceInfo, len(m))
+	for i, mux := range m {
+		infos[i] = mux.Info()
+	}
+	return infos, nil
+}
+
+func (m gpioManager) Open(id string, opts ...Option) (Device, error) {
+	for _, mux := range m {
+		if mux.Name == id {
+			return OpenGPIO(mux, opts...)
+		}
+	}
+	return nil, WithCode(CodeNotFound, fmt.Errorf("GPIO mux %s not found", id))
+}
+
+// gpioLines is a request for GPIO lines, driven together.
+type gpioLines interface {
+	set(active bool) error
+	get() (active bool, err error)
+	Close() error
+}
+
+// gpioDevice presents a GPIO mux as a USB device, so that open gives it
+// the same handling as SDWires.
+type gpioDevice struct {
+	mux   *GPIOMux
+	lines gpioLines
+}
+
+func (d *gpioDevice) Descriptor() *deviceDesc {
+	desc := &deviceDesc{}
+	if nums := portPathNumbers(d.mux.ReaderPortPath); len(nums) > 1 {
+		desc.Bus, desc.Path = nums[0], nums[1:]
+	}
+	return desc
+}
+
+// Control answers probes by reading the lines back.
+func (d *gpioDevice) Control(rType, request uint8, value, index uint16, data []byte) (int, error) {
+	if request != usbRequestGetStatus {
+		return 0, errUSBNotSupported
+	}
+	if _, err := d.lines.get(); err != nil {
+		return 0, err
+	}
+	clear(data)
+	return len(data), nil
+}
+
+func (d *gpioDevice) Reset() error                        { return nil }
+func (d *gpioDevice) SetControlTimeout(time.Duration)     {}
+func (d *gpioDevice) InterfaceDriver(int) (string, error) { return "", errDriverUnknown }
+func (d *gpioDevice) SetAutoDetach(bool) error            { return nil }
+
+func (d *gpioDevice) Config(int) (usbConfig, error) {
+	return nil, errUSBNotSupported
+}
+
+func (d *gpioDevice) SerialNumber() (string, error)                      { return d.mux.Name, nil }
+func (d *gpioDevice) Product() (string, error)                           { return gpioProduct, nil }
+func (d *gpioDevice) Manufacturer() (string, error)                      { return "", nil }
+func (d *gpioDevice) ConfigDescription(int) (string, error)              { return "", nil }
+func (d *gpioDevice) InterfaceDescription(int, int, int) (string, error) { return "", nil }
+
+func (d *gpioDevice) Close() error {
+	return d.lines.Close()
+}
+
+// gpioController implements DeviceController for GPIO muxes: the lines
+// are active while the card is on the host.
+type gpioController struct {
+	lines gpioLines
+	log   *slog.Logger
+	diag  *transferRing
+}
+
+func (c *gpioController) SetMode(mode SwitchMode) error {
+	if mode != ModeHost && mode != ModeTarget {
+		return WithCode(CodeInvalidArgument, fmt.Errorf("invalid switch mode: %v", mode))
+	}
+	start := time.Now()
+	err := c.lines.set(mode == ModeHost)
+	value := uint16(0)
+	if mode == ModeHost {
+		value = 1
+	}
+	c.diag.add(Transfer{Time: start, Op: "gpio", Value: value, Duration: time.Since(start), Err: err})
+	c.log.Debug("set GPIO lines", "mode", mode, "error", err)
+	return err
+}
+
+// GetMode reads the line levels back.
+func (c *gpioController) GetMode() (SwitchMode, error) {
+	active, err := c.lines.get()
+	if err != nil {
+		return 0, err
+	}
+	if active {
+		return ModeHost, nil
+	}
+	return ModeTarget, nil
+}
@@ -0,0 +1,170 @@
+package sdwire
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Structures and ioctls of the GPIO character device v2 uAPI, from
+// linux/gpio.h.
+const (
+	gpioV2LinesMax          = 64
+	gpioV2LineNumAttrsMax   = 10
+	gpioV2LineFlagActiveLow = 1 << 1
+	gpioV2LineFlagOutput    = 1 << 3
+	gpioV2LineAttrIDValues  = 2
+
+	gpioV2GetLineIoctl       = 0x07
+	gpioV2LineSetConfigIoctl = 0x0D
+	gpioV2LineGetValuesIoctl = 0x0E
+	gpioV2LineSetValuesIoctl = 0x0F
+)
+
+type gpioV2LineAttribute struct {
+	ID      uint32
+	Padding uint32
+	Value   uint64
+}
+
+type gpioV2LineConfigAttribute struct {
+	Attr gpioV2LineAttribute
+	Mask uint64
+}
+
+type gpioV2LineConfig struct {
+	Flags    uint64
+	NumAttrs uint32
+	Padding  [5]uint32
+	Attrs    [gpioV2LineNumAttrsMax]gpioV2LineConfigAttribute
+}
+
+type gpioV2LineRequest struct {
+	Offsets         [gpioV2LinesMax]uint32
+	Consumer        [32]byte
+	Config          gpioV2LineConfig
+	NumLines        uint32
+	EventBufferSize uint32
+	Padding         [5]uint32
+	FD              int32
+}
+
+type gpioV2LineValues struct {
+	Bits uint64
+	Mask uint64
+}
+
+// gpioRequest encodes a GPIO ioctl request number, like the kernel's
+// _IOWR(0xB4, nr, size).
+func gpioRequest(nr, size uintptr) uintptr {
+	const (
+		iocWrite, iocRead = 1, 2
+		iocSizeBits       = 14
+	)
+	dir, sizeBits := uintptr(iocRead|iocWrite), uintptr(iocSizeBits)
+	switch runtime.GOARCH {
+	case "mips", "mipsle", "mips64", "mips64le", "ppc64", "ppc64le":
+		dir, sizeBits = 4|2, 13
+	}
+	return dir<<(16+sizeBits) | size<<16 | 0xB4<<8 | nr
+}
+
+func gpioIoctl(fd int, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// cdevLines is a line request on a GPIO character device.
+type cdevLines struct {
+	fd   int
+	mask uint64
+}
+
+// openGPIOLines requests the lines as they are, reads their levels and
+// then makes them outputs driving those same levels, so that taking the
+// lines does not switch the mux.
+func openGPIOLines(chip string, offsets []int, activeLow bool, consumer string) (gpioLines, error) {
+	if len(offsets) > gpioV2LinesMax {
+		return nil, WithCode(CodeInvalidArgument, fmt.Errorf("at most %d lines are supported", gpioV2LinesMax))
+	}
+	if filepath.Dir(chip) == "." {
+		chip = filepath.Join("/dev", chip)
+	}
+	f, err := os.OpenFile(chip, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var req gpioV2LineRequest
+	for i, off := range offsets {
+		req.Offsets[i] = uint32(off)
+	}
+	copy(req.Consumer[:len(req.Consumer)-1], consumer)
+	req.NumLines = uint32(len(offsets))
+	if activeLow {
+		req.Config.Flags = gpioV2LineFlagActiveLow
+	}
+	if err := gpioIoctl(int(f.Fd()), gpioRequest(gpioV2GetLineIoctl, unsafe.Sizeof(req)), unsafe.Pointer(&req)); err != nil {
+		return nil, fmt.Errorf("failed to request GPIO lines: %w", err)
+	}
+	l := &cdevLines{fd: int(req.FD), mask: 1<<len(offsets) - 1}
+
+	levels, err := l.values()
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	cfg := req.Config
+	cfg.Flags |= gpioV2LineFlagOutput
+	cfg.NumAttrs = 1
+	cfg.Attrs[0] = gpioV2LineConfigAttribute{
+		Attr: gpioV2LineAttribute{ID: gpioV2LineAttrIDValues, Value: levels},
+		Mask: l.mask,
+	}
+	if err := gpioIoctl(l.fd, gpioRequest(gpioV2LineSetConfigIoctl, unsafe.Sizeof(cfg)), unsafe.Pointer(&cfg)); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to configure GPIO lines as outputs: %w", err)
+	}
+	return l, nil
+}
+
+func (l *cdevLines) bits(active bool) uint64 {
+	if active {
+		return l.mask
+	}
+	return 0
+}
+
+func (l *cdevLines) set(active bool) error {
+	v := gpioV2LineValues{Bits: l.bits(active), Mask: l.mask}
+	if err := gpioIoctl(l.fd, gpioRequest(gpioV2LineSetValuesIoctl, unsafe.Sizeof(v)), unsafe.Pointer(&v)); err != nil {
+		return fmt.Errorf("failed to set GPIO lines: %w", err)
+	}
+	return nil
+}
+
+// get reports whether the lines are active. The mux is only considered
+// switched to the host if every line is.
+func (l *cdevLines) get() (bool, error) {
+	bits, err := l.values()
+	return bits == l.mask, err
+}
+
+// values reads the line levels, one bit per line.
+func (l *cdevLines) values() (uint64, error) {
+	v := gpioV2LineValues{Mask: l.mask}
+	if err := gpioIoctl(l.fd, gpioRequest(gpioV2LineGetValuesIoctl, unsafe.Sizeof(v)), unsafe.Pointer(&v)); err != nil {
+		return 0, fmt.Errorf("failed to read GPIO lines: %w", err)
+	}
+	return v.Bits & l.mask, nil
+}
+
+func (l *cdevLines) Close() error {
+	return syscall.Close(l.fd)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package sdwire
+
+import "errors"
+
+// openGPIOLines is only supported on Linux.
+func openGPIOLines(string, []int, bool, string) (gpioLines, error) {
+	return nil, WithCode(CodeUnsupported, errors.New("GPIO muxes are only supported on Linux"))
+}
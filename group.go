@@ -0,0 +1,53 @@
+package sdwire
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Group is a set of devices operated on together.
+type Group []*DeviceInfo
+
+// SelectDevices returns the connected devices matching a selector expression.
+// See Selector for the syntax.
+func SelectDevices(expr string) (Group, error) {
+	sel, err := ParseSelector(expr)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	return sel.Filter(devices), nil
+}
+
+// SetMode switches every device in the group to mode. All devices are
+// attempted; failures are joined into the returned error.
+func (g Group) SetMode(mode SwitchMode, opts ...Option) error {
+	var errs []error
+	for _, info := range g {
+		if err := setModeOf(info, mode, opts); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", info.Serial, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Serials returns the serial numbers of the devices in the group.
+func (g Group) Serials() []string {
+	serials := make([]string, len(g))
+	for i, info := range g {
+		serials[i] = info.Serial
+	}
+	return serials
+}
+
+func setModeOf(info *DeviceInfo, mode SwitchMode, opts []Option) error {
+	dev, err := OpenInfo(info, opts...)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+	return dev.SetMode(mode)
+}
@@ -0,0 +1,164 @@
+package sdwire
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SystemdUnitPath is where GenerateSystemdUnit output is conventionally
+// installed.
+const SystemdUnitPath = "/etc/systemd/system/sdwire.service"
+
+// UdevOptions configures GenerateUdevRules.
+type UdevOptions struct {
+	// Group, if set, owns the devices, which are then only accessible to
+	// its members rather than to all users.
+	Group string
+	// Generations limits the rules to these generations. Empty means all.
+	Generations []DeviceGeneration
+	// SysfsControl adds a rule making the SDWire3 interface authorization
+	// writable, as needed by WithSysfsControl.
+	SysfsControl bool
+}
+
+// nameRE matches user and group names safe to embed in generated files.
+var nameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.-]*\$?$`)
+
+// GenerateUdevRules returns udev rules granting access to SDWire devices,
+// in the form expected in UdevRulePath.
+func GenerateUdevRules(opts UdevOptions) (string, error) {
+	if opts.Group != "" && !nameRE.MatchString(opts.Group) {
+		return "", WithCode(CodeInvalidArgument, fmt.Errorf("invalid group name %q", opts.Group))
+	}
+	generations := opts.Generations
+	if len(generations) == 0 {
+		generations = []DeviceGeneration{GenerationSDWireC, GenerationSDWire3}
+	}
+
+	var b strings.Builder
+	for _, g := range generations {
+		var vendor, product uint16
+		switch g {
+		case GenerationSDWireC:
+			vendor, product = SDWireCVID, SDWireCPID
+		case GenerationSDWire3:
+			vendor, product = SDWire3VID, SDWire3PID
+		default:
+			return "", WithCode(CodeInvalidArgument, fmt.Errorf("unsupported device generation: %v", g))
+		}
+		fmt.Fprintf(&b, "# %s\n", g)
+		if opts.Group == "" {
+			b.WriteString(UdevRule(vendor, product) + "\n")
+		} else {
+			fmt.Fprintf(&b, `SUBSYSTEM=="usb", ATTR{idVendor}=="%04x", ATTR{idProduct}=="%04x", MODE="0660", GROUP="%s"`+"\n", vendor, product, opts.Group)
+		}
+		if g != GenerationSDWire3 || !opts.SysfsControl {
+			continue
+		}
+		if opts.Group == "" {
+			b.WriteString(UdevAuthorizeRule(vendor, product) + "\n")
+		} else {
+			fmt.Fprintf(&b, `ACTION=="add", SUBSYSTEM=="usb", ENV{DEVTYPE}=="usb_interface", ATTRS{idVendor}=="%04x", ATTRS{idProduct}=="%04x", RUN+="/bin/chgrp %s /sys%%p/authorized", RUN+="/bin/chmod 0660 /sys%%p/authorized"`+"\n", vendor, product, opts.Group)
+		}
+	}
+	return b.String(), nil
+}
+
+// SystemdOptions configures GenerateSystemdUnit.
+type SystemdOptions struct {
+	// Description defaults to "SDWire remote control API".
+	Description string
+	// Command is the service's command line. It defaults to
+	// "/usr/local/bin/sdwire serve".
+	Command []string
+	// User and Group run the service as someone other than root.
+	User  string
+	Group string
+	// Environment sets variables for the service, e.g. SDWIRE_REGISTRY or
+	// SDWIRE_AUDIT_LOG.
+	Environment map[string]string
+	// Notify says the command tells systemd when it is ready and pings its
+	// watchdog, as "sdwire serve" does. It is implied by the default
+	// Command.
+	Notify bool
+}
+
+// GenerateSystemdUnit returns a systemd service unit running an SDWire
+// server, in the form expected in SystemdUnitPath.
+func GenerateSystemdUnit(opts SystemdOptions) (string, error) {
+	for _, name := range []string{opts.User, opts.Group} {
+		if name != "" && !nameRE.MatchString(name) {
+			return "", WithCode(CodeInvalidArgument, fmt.Errorf("invalid user or group name %q", name))
+		}
+	}
+	description := opts.Description
+	if description == "" {
+		description = "SDWire remote control API"
+	}
+	if strings.ContainsAny(description, "\n\r") {
+		return "", WithCode(CodeInvalidArgument, errors.New("description must be a single line"))
+	}
+	command := opts.Command
+	if len(command) == 0 {
+		command = []string{"/usr/local/bin/sdwire", "serve"}
+	}
+	if !strings.HasPrefix(command[0], "/") {
+		return "", WithCode(CodeInvalidArgument, fmt.Errorf("command %q must be an absolute path", command[0]))
+	}
+
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=%s\n", systemdEscape(description))
+	b.WriteString("After=network.target\n\n")
+	b.WriteString("[Service]\n")
+	args := make([]string, len(command))
+	for i, arg := range command {
+		args[i] = systemdQuote(arg)
+	}
+	if opts.Notify || len(opts.Command) == 0 {
+		b.WriteString("Type=notify\n")
+		b.WriteString("WatchdogSec=30\n")
+	}
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(args, " "))
+	b.WriteString("Restart=on-failure\n")
+	if opts.User != "" {
+		fmt.Fprintf(&b, "User=%s\n", opts.User)
+	}
+	if opts.Group != "" {
+		fmt.Fprintf(&b, "Group=%s\n", opts.Group)
+	}
+	keys := make([]string, 0, len(opts.Environment))
+	for k := range opts.Environment {
+		if k == "" || strings.ContainsAny(k, "= \t\n\"") {
+			return "", WithCode(CodeInvalidArgument, fmt.Errorf("invalid environment variable name %q", k))
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "Environment=%s\n", systemdQuote(k+"="+opts.Environment[k]))
+	}
+	b.WriteString("\n[Install]\n")
+	b.WriteString("WantedBy=multi-user.target\n")
+	return b.String(), nil
+}
+
+// systemdEscape escapes the specifier and variable characters systemd
+// expands in unit settings.
+func systemdEscape(s string) string {
+	return strings.NewReplacer("%", "%%", "$", "$$").Replace(s)
+}
+
+// systemdQuote quotes a command line argument or assignment for systemd
+// if it needs it.
+func systemdQuote(s string) string {
+	s = systemdEscape(s)
+	if s != "" && !strings.ContainsAny(s, " \t\n\"'\\;") {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,174 @@
+package sdwire
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// InventoryRecord describes a device known to the inventory, whether or not
+// it is currently connected.
+type InventoryRecord struct {
+	// ID is the device's DeviceInfo.ID, which tells apart devices sharing
+	// a serial number.
+	ID           string            `json:"id"`
+	Serial       string            `json:"serial"`
+	Name         string            `json:"name,omitempty"`
+	Product      string            `json:"product"`
+	Manufacturer string            `json:"manufacturer"`
+	Generation   string            `json:"generation"`
+	Firmware     string            `json:"firmware,omitempty"`
+	PortPath     string            `json:"port_path"`
+	Model        string            `json:"model,omitempty"`
+	Rack         string            `json:"rack,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	Present      bool              `json:"present"`
+	FirstSeen    time.Time         `json:"first_seen"`
+	LastSeen     time.Time         `json:"last_seen"`
+	// Card is the wear of the card last flashed in the device, CardWear
+	// its level and CardLabel its label from the card registry, if a store
+	// is installed with SetWearStore.
+	Card      *CardWear `json:"card,omitempty"`
+	CardWear  string    `json:"card_wear,omitempty"`
+	CardLabel string    `json:"card_label,omitempty"`
+}
+
+// Inventory returns all known devices. Connected devices are merged with
+// the history kept in the JSON file at statePath, which is then updated
+// with the current last-seen times. If statePath is empty only connected
+// devices are reported. Records are sorted by serial number, then port
+// path.
+func Inventory(statePath string) ([]InventoryRecord, error) {
+	known := make(map[string]InventoryRecord)
+	if statePath != "" {
+		data, err := os.ReadFile(statePath)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("failed to read inventory state: %w", err)
+		default:
+			var records []InventoryRecord
+			if err := json.Unmarshal(data, &records); err != nil {
+				return nil, fmt.Errorf("failed to parse inventory state: %w", err)
+			}
+			for _, r := range records {
+				if r.ID == "" {
+					// Written before records had IDs.
+					r.ID = r.Serial
+				}
+				r.Present = false
+				known[r.ID] = r
+			}
+		}
+	}
+
+	devices, err := ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	for _, d := range devices {
+		r, ok := known[d.ID]
+		if !ok {
+			r.FirstSeen = now
+		}
+		r.ID = d.ID
+		r.Serial = d.Serial
+		r.Name = d.Name
+		r.Product = d.Product
+		r.Manufacturer = d.Manufacturer
+		r.Generation = d.Generation.String()
+		r.Firmware = d.FirmwareVersion
+		r.PortPath = d.PortPath
+		r.Model = d.Model
+		r.Rack = d.Rack
+		r.Tags = d.Tags
+		r.Present = true
+		r.LastSeen = now
+		known[d.ID] = r
+	}
+
+	var cards []CardWear
+	store := packageWearStore()
+	if store != nil {
+		if cards, err = store.Cards(); err != nil {
+			return nil, err
+		}
+	}
+	records := make([]InventoryRecord, 0, len(known))
+	for _, r := range known {
+		r.Card, r.CardWear, r.CardLabel = nil, "", ""
+		if w, ok := lastCardIn(cards, r.Serial); ok {
+			r.Card = &w
+			r.CardWear = store.thresholds.Level(w).String()
+			r.CardLabel = lookupCard(w.CID).Label
+		}
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Serial != records[j].Serial {
+			return records[i].Serial < records[j].Serial
+		}
+		return ComparePortPaths(records[i].PortPath, records[j].PortPath) < 0
+	})
+
+	if statePath != "" {
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(statePath), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to write inventory state: %w", err)
+		}
+		if err := os.WriteFile(statePath, data, 0o644); err != nil {
+			return nil, fmt.Errorf("failed to write inventory state: %w", err)
+		}
+	}
+	return records, nil
+}
+
+// WriteInventoryJSON writes records as an indented JSON array.
+func WriteInventoryJSON(w io.Writer, records []InventoryRecord) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+// WriteInventoryCSV writes records as CSV with a header row. Tags are
+// written as semicolon-separated key=value pairs, and card wear in the
+// card_ columns.
+func WriteInventoryCSV(w io.Writer, records []InventoryRecord) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"serial", "name", "product", "manufacturer", "generation", "firmware", "port_path",
+		"model", "rack", "tags", "present", "first_seen", "last_seen",
+		"card_cid", "card_bytes_written", "card_flash_cycles", "card_wear", "card_label", "card_dirty",
+	})
+	for _, r := range records {
+		tags := make([]string, 0, len(r.Tags))
+		for k, v := range r.Tags {
+			tags = append(tags, k+"="+v)
+		}
+		sort.Strings(tags)
+		var card [4]string
+		if r.Card != nil {
+			card = [4]string{r.Card.CID, fmt.Sprint(r.Card.BytesWritten), fmt.Sprint(r.Card.FlashCycles), r.Card.Dirty}
+		}
+		cw.Write([]string{
+			r.Serial, r.Name, r.Product, r.Manufacturer, r.Generation, r.Firmware, r.PortPath,
+			r.Model, r.Rack, strings.Join(tags, ";"), fmt.Sprint(r.Present),
+			r.FirstSeen.Format(time.RFC3339), r.LastSeen.Format(time.RFC3339),
+			card[0], card[1], card[2], r.CardWear, r.CardLabel, card[3],
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
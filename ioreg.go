@@ -0,0 +1,100 @@
+package sdwire
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// macStorageDrivers are the IOKit classes that attach to a USB mass
+// storage interface on macOS.
+var macStorageDrivers = []string{"IOUSBMassStorageDriver", "IOUSBMassStorageInterfaceNub", "IOUSBMassStorageUASDriver"}
+
+// parseIORegDriver finds the USB device with the given location ID in the
+// output of "ioreg -l -w0" and reports the mass storage driver attached
+// below it, or "" if none is, ignoring devices behind it. found is false
+// if the device is not listed.
+//
+// Each object starts with "+-o Name  <class Class, ...>", indented by its
+// depth; its properties follow on lines indented deeper.
+func parseIORegDriver(out []byte, location uint32) (driver string, found bool) {
+	want := fmt.Sprintf(`"locationID" = %d`, location)
+	var (
+		// column is where "+-o" starts for the last object, device where
+		// it starts for the matching device, and nested where it starts
+		// for a device behind it, such as one on a hub, or -1.
+		column int
+		device = -1
+		nested = -1
+	)
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.Index(line, "+-o "); i >= 0 && strings.Trim(line[:i], " |") == "" {
+			column = i
+			if device < 0 {
+				continue
+			}
+			if column <= device {
+				// Past the device's subtree.
+				return "", true
+			}
+			if nested >= 0 && column > nested {
+				continue
+			}
+			nested = -1
+			class := ioregClass(line[i:])
+			if class == "IOUSBHostDevice" {
+				nested = column
+				continue
+			}
+			for _, d := range macStorageDrivers {
+				if class == d {
+					return d, true
+				}
+			}
+			continue
+		}
+		if device < 0 && strings.HasSuffix(strings.TrimSpace(line), want) {
+			device = column
+		}
+	}
+	return "", device >= 0
+}
+
+// ioregClass returns the class of an ioreg object line, e.g.
+// "IOUSBMassStorageDriver" for
+// "+-o IOUSBMassStorageDriver  <class IOUSBMassStorageDriver, id 0x1, ...>".
+func ioregClass(line string) string {
+	_, rest, ok := strings.Cut(line, "<class ")
+	if !ok {
+		return ""
+	}
+	class, _, _ := strings.Cut(rest, ",")
+	return strings.TrimSuffix(class, ">")
+}
+
+// locationID computes the macOS USB location ID of a port path: the bus
+// number in the top byte followed by one nibble per port.
+func locationID(portPath string) (uint32, error) {
+	bus, ports, ok := strings.Cut(portPath, "-")
+	if !ok {
+		return 0, fmt.Errorf("invalid port path %q", portPath)
+	}
+	b, err := strconv.Atoi(bus)
+	if err != nil || b > 255 {
+		return 0, fmt.Errorf("invalid port path %q", portPath)
+	}
+	id := uint32(b) << 24
+	for i, p := range strings.Split(ports, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || i > 5 || n < 1 || n > 15 {
+			return 0, fmt.Errorf("invalid port path %q", portPath)
+		}
+		id |= uint32(n) << (20 - 4*i)
+	}
+	return id, nil
+}
@@ -0,0 +1,75 @@
+package sdwire
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocationID(t *testing.T) {
+	tests := []struct {
+		portPath string
+		want     uint32
+	}{
+		{"1-1", 0x01100000},
+		{"1-1.2", 0x01120000},
+		{"2-1.4.3", 0x02143000},
+		{"20-3.1.2.4.15.1", 0x1431_24f1},
+	}
+	for _, tt := range tests {
+		if got, err := locationID(tt.portPath); err != nil || got != tt.want {
+			t.Errorf("locationID(%q) = %#x, %v, want %#x", tt.portPath, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "1", "x-1", "1-", "1-x", "1-16", "1-0", "256-1", "1-1.1.1.1.1.1.1"} {
+		if got, err := locationID(bad); err == nil {
+			t.Errorf("locationID(%q) = %#x, want an error", bad, got)
+		}
+	}
+}
+
+func TestParseIORegDriver(t *testing.T) {
+	tests := []struct {
+		file     string
+		portPath string
+		driver   string
+		found    bool
+	}{
+		{"host.txt", "1-1.2", "IOUSBMassStorageInterfaceNub", true},
+		{"host.txt", "1-1.3", "IOUSBMassStorageUASDriver", true},
+		{"host.txt", "1-1", "", true},
+		{"host.txt", "2-1", "", true},
+		{"host.txt", "1-1.4", "", false},
+		// The detached SDWire3 must not report the driver of the card
+		// reader beside it.
+		{"target.txt", "1-1.2", "", true},
+		{"target.txt", "1-1.3", "IOUSBMassStorageUASDriver", true},
+	}
+	for _, tt := range tests {
+		out, err := os.ReadFile(filepath.Join("testdata", "ioreg", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		location, err := locationID(tt.portPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		driver, found := parseIORegDriver(out, location)
+		if driver != tt.driver || found != tt.found {
+			t.Errorf("%s, %s: driver %q, found %t, want %q, %t", tt.file, tt.portPath, driver, found, tt.driver, tt.found)
+		}
+	}
+}
+
+func TestIORegClass(t *testing.T) {
+	tests := map[string]string{
+		"+-o IOUSBMassStorageDriver  <class IOUSBMassStorageDriver, id 0x1, registered>": "IOUSBMassStorageDriver",
+		"+-o Foo  <class Bar>": "Bar",
+		"+-o Foo":              "",
+	}
+	for line, want := range tests {
+		if got := ioregClass(line); got != want {
+			t.Errorf("ioregClass(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
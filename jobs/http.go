@@ -0,0 +1,85 @@
+package jobs
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/fcjr/sdwire"
+)
+
+type errorResponse struct {
+	Error string `json:"error"`
+	// Code is the sdwire.ErrorCode name, e.g. "NOT_FOUND".
+	Code string `json:"code,omitempty"`
+}
+
+// ServeHTTP implements http.Handler.
+func (q *Queue) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q.mux.ServeHTTP(w, r)
+}
+
+func (q *Queue) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, sdwire.WithCode(sdwire.CodeInvalidArgument, err))
+		return
+	}
+	j, err := q.Submit(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, j)
+}
+
+func (q *Queue) handleList(w http.ResponseWriter, r *http.Request) {
+	list := q.List(State(r.URL.Query().Get("state")))
+	if list == nil {
+		list = []Job{}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (q *Queue) handleGet(w http.ResponseWriter, r *http.Request) {
+	j, err := q.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+func (q *Queue) handleCancel(w http.ResponseWriter, r *http.Request) {
+	j, err := q.Cancel(r.PathValue("id"))
+	if err == errJobFinished {
+		writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error(), Code: sdwire.CodeOf(err).String()})
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+// statusCodes maps error codes to HTTP statuses; others are 500.
+var statusCodes = map[sdwire.ErrorCode]int{
+	sdwire.CodeNotFound:        http.StatusNotFound,
+	sdwire.CodeInvalidArgument: http.StatusBadRequest,
+	sdwire.CodeAmbiguous:       http.StatusConflict,
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := sdwire.CodeOf(err)
+	status, ok := statusCodes[code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error(), Code: code.String()})
+}
@@ -0,0 +1,457 @@
+// Package jobs queues flash-and-switch jobs on the devices of a lab host.
+// A job writes an image to a device's card and switches the card to the
+// target. Jobs on the same device run one at a time, highest priority
+// first; a failed job is retried as often as it asks, and every job is
+// kept in a history file that outlives restarts. "sdwire serve -jobs"
+// serves the queue next to the remote control API.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/workflow"
+)
+
+// DefaultRetryDelay is the pause before a failed job is retried.
+const DefaultRetryDelay = 10 * time.Second
+
+// DefaultMaxHistory is how many finished jobs are kept.
+const DefaultMaxHistory = 1000
+
+// State is where a job is in its life.
+type State string
+
+const (
+	Queued   State = "queued"
+	Running  State = "running"
+	Done     State = "done"
+	Failed   State = "failed"
+	Canceled State = "canceled"
+)
+
+// finished reports whether a job in state s will not run again.
+func (s State) finished() bool {
+	return s == Done || s == Failed || s == Canceled
+}
+
+// Job is a queued, running or finished job.
+type Job struct {
+	ID string `json:"id"`
+	// Device is the device's sdwire.DeviceInfo.ID, resolved when the job
+	// was submitted.
+	Device    string     `json:"device"`
+	Serial    string     `json:"serial"`
+	Image     string     `json:"image"`
+	Priority  int        `json:"priority,omitempty"`
+	Retries   int        `json:"retries,omitempty"`
+	State     State      `json:"state"`
+	Attempts  int        `json:"attempts,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Submitted time.Time  `json:"submitted"`
+	Started   *time.Time `json:"started,omitempty"`
+	Finished  *time.Time `json:"finished,omitempty"`
+
+	// notBefore delays a retry.
+	notBefore time.Time
+	// cancel stops the job while it runs.
+	cancel context.CancelFunc
+	// canceled is set when the job is canceled while it runs.
+	canceled bool
+}
+
+// Request submits a job.
+type Request struct {
+	// Device is a device ID, serial number or stable ID ("serial@port").
+	Device string `json:"device"`
+	// Image is the path of the image on the host, possibly compressed;
+	// see workflow.OpenImage.
+	Image string `json:"image"`
+	// Priority orders the jobs queued for a device, highest first.
+	Priority int `json:"priority,omitempty"`
+	// Retries is how many more times the job runs after failing.
+	Retries int `json:"retries,omitempty"`
+}
+
+// Queue runs jobs. It is an http.Handler serving the jobs API:
+//
+//	POST   /v1/jobs       submit a job (Request → Job)
+//	GET    /v1/jobs       list jobs, oldest first; ?state=queued filters
+//	GET    /v1/jobs/{id}  describe a job
+//	DELETE /v1/jobs/{id}  cancel a job
+//
+// A device is opened for as long as its job runs, so remote control
+// requests for it fail as busy in the meantime.
+type Queue struct {
+	// Devices lists the devices jobs can run on. It defaults to
+	// sdwire.ListDevices.
+	Devices func() ([]*sdwire.DeviceInfo, error)
+	// Flash runs a job, writing the image to the device's card and
+	// switching it to the target. It defaults to workflow.FlashAll.
+	Flash func(ctx context.Context, info *sdwire.DeviceInfo, image string) error
+	// Options are used when opening devices.
+	Options []sdwire.Option
+	// RetryDelay is the pause before a failed job is retried. It defaults
+	// to DefaultRetryDelay.
+	RetryDelay time.Duration
+	// MaxHistory is how many finished jobs are kept. It defaults to
+	// DefaultMaxHistory.
+	MaxHistory int
+	// ErrorLog logs failures no client hears of, such as failing to save
+	// the history. If nil, the log package's standard logger is used.
+	ErrorLog *log.Logger
+
+	path string
+	mux  *http.ServeMux
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	running map[string]*Job
+	// ctx is set while Run runs.
+	ctx context.Context
+	wg  sync.WaitGroup
+}
+
+// Load returns a queue keeping its jobs in the history file at path,
+// which is created when first saved. Jobs that were running when the
+// history was last saved are queued again. Call Run to start running
+// them.
+func Load(path string) (*Queue, error) {
+	q := &Queue{
+		Devices:    sdwire.ListDevices,
+		RetryDelay: DefaultRetryDelay,
+		MaxHistory: DefaultMaxHistory,
+		path:       path,
+		mux:        http.NewServeMux(),
+		jobs:       make(map[string]*Job),
+		running:    make(map[string]*Job),
+	}
+	q.Flash = q.flash
+	q.mux.HandleFunc("POST /v1/jobs", q.handleSubmit)
+	q.mux.HandleFunc("GET /v1/jobs", q.handleList)
+	q.mux.HandleFunc("GET /v1/jobs/{id}", q.handleGet)
+	q.mux.HandleFunc("DELETE /v1/jobs/{id}", q.handleCancel)
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return q, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read job history: %w", err)
+	}
+	var list []*Job
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse job history: %w", err)
+	}
+	for _, j := range list {
+		if j.State == Running {
+			j.State = Queued
+		}
+		q.jobs[j.ID] = j
+	}
+	return q, nil
+}
+
+// Submit queues a job.
+func (q *Queue) Submit(req Request) (Job, error) {
+	if req.Image == "" {
+		return Job{}, sdwire.WithCode(sdwire.CodeInvalidArgument, errors.New("job without an image"))
+	}
+	if req.Retries < 0 {
+		return Job{}, sdwire.WithCode(sdwire.CodeInvalidArgument, errors.New("negative retries"))
+	}
+	info, err := q.find(req.Device)
+	if err != nil {
+		return Job{}, err
+	}
+	j := &Job{
+		ID:        newID(),
+		Device:    info.ID,
+		Serial:    info.Serial,
+		Image:     req.Image,
+		Priority:  req.Priority,
+		Retries:   req.Retries,
+		State:     Queued,
+		Submitted: time.Now(),
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs[j.ID] = j
+	if err := q.save(); err != nil {
+		delete(q.jobs, j.ID)
+		return Job{}, err
+	}
+	q.schedule()
+	return *j, nil
+}
+
+// Get returns a job.
+func (q *Queue) Get(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, errJobNotFound
+	}
+	return *j, nil
+}
+
+// List returns the jobs, oldest first, restricted to the given state
+// unless it is "".
+func (q *Queue) List(state State) []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var list []Job
+	for _, j := range q.sorted() {
+		if state == "" || j.State == state {
+			list = append(list, *j)
+		}
+	}
+	return list
+}
+
+// Cancel cancels a queued job, or stops a running one. Finished jobs
+// cannot be canceled.
+func (q *Queue) Cancel(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	switch {
+	case !ok:
+		return Job{}, errJobNotFound
+	case j.State.finished():
+		return *j, errJobFinished
+	case j.State == Running:
+		// finish records the cancellation once the job stops.
+		j.canceled = true
+		j.cancel()
+		return *j, nil
+	}
+	j.State = Canceled
+	j.Finished = now()
+	q.saveOrLog()
+	return *j, nil
+}
+
+var (
+	errJobNotFound = sdwire.WithCode(sdwire.CodeNotFound, errors.New("job not found"))
+	errJobFinished = sdwire.WithCode(sdwire.CodeInvalidArgument, errors.New("job already finished"))
+)
+
+// Run runs the queued jobs until ctx is done, then stops the running jobs
+// and waits for them. Jobs stopped this way are queued again.
+func (q *Queue) Run(ctx context.Context) error {
+	q.mu.Lock()
+	q.ctx = ctx
+	q.schedule()
+	q.mu.Unlock()
+
+	<-ctx.Done()
+	q.wg.Wait()
+	q.mu.Lock()
+	q.ctx = nil
+	q.mu.Unlock()
+	return ctx.Err()
+}
+
+// schedule starts the most urgent queued job of every idle device. Callers
+// must hold q.mu.
+func (q *Queue) schedule() {
+	if q.ctx == nil || q.ctx.Err() != nil {
+		return
+	}
+	queued := q.sorted()
+	sort.SliceStable(queued, func(a, b int) bool {
+		return queued[a].Priority > queued[b].Priority
+	})
+	now := time.Now()
+	for _, j := range queued {
+		if j.State != Queued || q.running[j.Device] != nil || now.Before(j.notBefore) {
+			continue
+		}
+		q.start(j)
+	}
+}
+
+// sorted returns the jobs, oldest first.
+func (q *Queue) sorted() []*Job {
+	list := make([]*Job, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		list = append(list, j)
+	}
+	sort.Slice(list, func(a, b int) bool {
+		if !list[a].Submitted.Equal(list[b].Submitted) {
+			return list[a].Submitted.Before(list[b].Submitted)
+		}
+		return list[a].ID < list[b].ID
+	})
+	return list
+}
+
+// start runs a job in the background. Callers must hold q.mu.
+func (q *Queue) start(j *Job) {
+	ctx, cancel := context.WithCancel(q.ctx)
+	j.cancel = cancel
+	j.State = Running
+	j.Attempts++
+	j.Error = ""
+	j.Started = now()
+	q.running[j.Device] = j
+	q.saveOrLog()
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		defer cancel()
+		err := q.run(ctx, j.Device, j.Image)
+		q.finish(j, err)
+	}()
+}
+
+func (q *Queue) run(ctx context.Context, device, image string) error {
+	info, err := q.find(device)
+	if err != nil {
+		return err
+	}
+	return q.Flash(ctx, info, image)
+}
+
+// finish records the outcome of a job and starts the next ones.
+func (q *Queue) finish(j *Job, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.running, j.Device)
+	j.cancel = nil
+	if err != nil {
+		j.Error = err.Error()
+	}
+	switch {
+	case err == nil:
+		j.State = Done
+	case j.canceled:
+		j.State = Canceled
+	case q.ctx.Err() != nil:
+		// Stopped by Run returning; run it again next time.
+		j.State = Queued
+		j.Attempts--
+	case j.Attempts <= j.Retries:
+		j.State = Queued
+		j.notBefore = time.Now().Add(q.RetryDelay)
+		time.AfterFunc(q.RetryDelay, func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			q.schedule()
+		})
+	default:
+		j.State = Failed
+	}
+	if j.State.finished() {
+		j.Finished = now()
+	}
+	q.prune()
+	q.saveOrLog()
+	q.schedule()
+}
+
+// prune drops the oldest finished jobs beyond MaxHistory. Callers must
+// hold q.mu.
+func (q *Queue) prune() {
+	var finished []*Job
+	for _, j := range q.sorted() {
+		if j.State.finished() {
+			finished = append(finished, j)
+		}
+	}
+	for i := 0; i < len(finished)-q.MaxHistory; i++ {
+		delete(q.jobs, finished[i].ID)
+	}
+}
+
+// save replaces the history file, so readers never see a partial write.
+// Callers must hold q.mu.
+func (q *Queue) save() error {
+	data, err := json.MarshalIndent(q.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(q.path), 0o755); err != nil {
+		return fmt.Errorf("failed to write job history: %w", err)
+	}
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write job history: %w", err)
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		return fmt.Errorf("failed to write job history: %w", err)
+	}
+	return nil
+}
+
+// saveOrLog saves the history, logging a failure. Callers must hold q.mu.
+func (q *Queue) saveOrLog() {
+	if err := q.save(); err != nil {
+		if q.ErrorLog != nil {
+			q.ErrorLog.Print(err)
+		} else {
+			log.Print(err)
+		}
+	}
+}
+
+// find looks up a device by ID, stable ID or serial number.
+func (q *Queue) find(id string) (*sdwire.DeviceInfo, error) {
+	infos, err := q.Devices()
+	if err != nil {
+		return nil, err
+	}
+	var bySerial []*sdwire.DeviceInfo
+	for _, info := range infos {
+		if info.ID == id || info.StableID() == id {
+			return info, nil
+		}
+		if info.Serial == id {
+			bySerial = append(bySerial, info)
+		}
+	}
+	switch len(bySerial) {
+	case 0:
+		return nil, sdwire.WithCode(sdwire.CodeNotFound, errors.New("device "+id+" not found"))
+	case 1:
+		return bySerial[0], nil
+	}
+	merr := &sdwire.MultipleMatchesError{Serial: id}
+	for _, info := range bySerial {
+		merr.PortPaths = append(merr.PortPaths, info.PortPath)
+	}
+	return nil, merr
+}
+
+// flash is the default Flash.
+func (q *Queue) flash(ctx context.Context, info *sdwire.DeviceInfo, image string) error {
+	results := workflow.FlashAll(ctx, []*sdwire.DeviceInfo{info}, image, workflow.Limits{}, q.Options...)
+	return results[0].Err
+}
+
+func now() *time.Time {
+	t := time.Now()
+	return &t
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,267 @@
+package jobs_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/jobs"
+)
+
+// flasher is a fake Queue.Flash whose flashes run until released.
+type flasher struct {
+	mu      sync.Mutex
+	started []string
+	release map[string]chan error
+}
+
+func newFlasher() *flasher {
+	return &flasher{release: make(map[string]chan error)}
+}
+
+// gate returns the channel releasing the flashes of an image.
+func (f *flasher) gate(image string) chan error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch, ok := f.release[image]
+	if !ok {
+		ch = make(chan error, 10)
+		f.release[image] = ch
+	}
+	return ch
+}
+
+func (f *flasher) flash(ctx context.Context, info *sdwire.DeviceInfo, image string) error {
+	f.mu.Lock()
+	f.started = append(f.started, info.Serial+":"+image)
+	f.mu.Unlock()
+	select {
+	case err := <-f.gate(image):
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (f *flasher) runs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.started)
+}
+
+// newQueue returns a queue of the fake devices a and b, keeping its history
+// at path.
+func newQueue(t *testing.T, path string, f *flasher) *jobs.Queue {
+	t.Helper()
+	q, err := jobs.Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	q.Devices = func() ([]*sdwire.DeviceInfo, error) {
+		return []*sdwire.DeviceInfo{
+			{ID: "a", Serial: "a", PortPath: "1-1"},
+			{ID: "b", Serial: "b", PortPath: "1-2"},
+		}, nil
+	}
+	q.Flash = f.flash
+	q.RetryDelay = 10 * time.Millisecond
+	q.ErrorLog = log.New(io.Discard, "", 0)
+	return q
+}
+
+// run runs q until the test ends.
+func run(t *testing.T, q *jobs.Queue) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(done)
+	}()
+	stop = func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func submit(t *testing.T, q *jobs.Queue, req jobs.Request) string {
+	t.Helper()
+	j, err := q.Submit(req)
+	if err != nil {
+		t.Fatalf("Submit(%+v): %v", req, err)
+	}
+	return j.ID
+}
+
+// waitForState polls until the job reaches state.
+func waitForState(t *testing.T, q *jobs.Queue, id string, state jobs.State) jobs.Job {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(2 * time.Millisecond) {
+		j, err := q.Get(id)
+		if err == nil && j.State == state {
+			return j
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s", id, j.State, state)
+		}
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	f := newFlasher()
+	q := newQueue(t, filepath.Join(t.TempDir(), "jobs.json"), f)
+	first := submit(t, q, jobs.Request{Device: "a", Image: "first"})
+	run(t, q)
+	waitForState(t, q, first, jobs.Running)
+
+	low := submit(t, q, jobs.Request{Device: "a", Image: "low"})
+	high := submit(t, q, jobs.Request{Device: "a@1-1", Image: "high", Priority: 5})
+	other := submit(t, q, jobs.Request{Device: "b", Image: "other"})
+	waitForState(t, q, other, jobs.Running)
+	if j, _ := q.Get(high); j.State != jobs.Queued {
+		t.Errorf("second job on a is %s while the first runs", j.State)
+	}
+
+	for _, image := range []string{"first", "low", "high", "other"} {
+		f.gate(image) <- nil
+	}
+	for _, id := range []string{first, low, high, other} {
+		waitForState(t, q, id, jobs.Done)
+	}
+	var onA []string
+	for _, r := range f.runs() {
+		if strings.HasPrefix(r, "a:") {
+			onA = append(onA, r)
+		}
+	}
+	if want := []string{"a:first", "a:high", "a:low"}; !slices.Equal(onA, want) {
+		t.Errorf("ran %q on a, want %q", onA, want)
+	}
+}
+
+func TestQueueRetries(t *testing.T) {
+	f := newFlasher()
+	q := newQueue(t, filepath.Join(t.TempDir(), "jobs.json"), f)
+	run(t, q)
+
+	f.gate("flaky") <- errors.New("card write failed")
+	f.gate("flaky") <- nil
+	flaky := submit(t, q, jobs.Request{Device: "a", Image: "flaky", Retries: 2})
+	if j := waitForState(t, q, flaky, jobs.Done); j.Attempts != 2 || j.Error != "" {
+		t.Errorf("retried job: %d attempts, error %q, want 2 and none", j.Attempts, j.Error)
+	}
+
+	f.gate("broken") <- errors.New("card write failed")
+	broken := submit(t, q, jobs.Request{Device: "b", Image: "broken"})
+	if j := waitForState(t, q, broken, jobs.Failed); j.Attempts != 1 || j.Error != "card write failed" || j.Finished == nil {
+		t.Errorf("failed job: %+v", j)
+	}
+}
+
+func TestQueueCancel(t *testing.T) {
+	f := newFlasher()
+	q := newQueue(t, filepath.Join(t.TempDir(), "jobs.json"), f)
+	run(t, q)
+	running := submit(t, q, jobs.Request{Device: "a", Image: "running"})
+	waitForState(t, q, running, jobs.Running)
+	queued := submit(t, q, jobs.Request{Device: "a", Image: "queued"})
+
+	if j, err := q.Cancel(queued); err != nil || j.State != jobs.Canceled {
+		t.Fatalf("Cancel(queued) = %+v, %v", j, err)
+	}
+	if _, err := q.Cancel(running); err != nil {
+		t.Fatalf("Cancel(running): %v", err)
+	}
+	waitForState(t, q, running, jobs.Canceled)
+	if _, err := q.Cancel(running); err == nil {
+		t.Error("canceled a finished job")
+	}
+	if _, err := q.Cancel("nope"); sdwire.CodeOf(err) != sdwire.CodeNotFound {
+		t.Errorf("Cancel(nope) = %v, want CodeNotFound", err)
+	}
+	if got := f.runs(); !slices.Equal(got, []string{"a:running"}) {
+		t.Errorf("ran %q, want only the running job", got)
+	}
+}
+
+// TestQueueHistory checks that jobs outlive the queue, and that a job
+// stopped by shutting down runs again after a restart.
+func TestQueueHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history", "jobs.json")
+	f := newFlasher()
+	q := newQueue(t, path, f)
+	stop := run(t, q)
+	f.gate("done") <- nil
+	done := submit(t, q, jobs.Request{Device: "a", Image: "done"})
+	waitForState(t, q, done, jobs.Done)
+	interrupted := submit(t, q, jobs.Request{Device: "b", Image: "interrupted"})
+	waitForState(t, q, interrupted, jobs.Running)
+	stop()
+
+	q = newQueue(t, path, f)
+	list := q.List("")
+	if len(list) != 2 || list[0].ID != done || list[0].State != jobs.Done || list[1].ID != interrupted || list[1].State != jobs.Queued {
+		t.Fatalf("reloaded %+v, want the done job and the interrupted one queued", list)
+	}
+	if queued := q.List(jobs.Queued); len(queued) != 1 || queued[0].ID != interrupted {
+		t.Errorf("List(queued) = %+v", queued)
+	}
+	f.gate("interrupted") <- nil
+	run(t, q)
+	if j := waitForState(t, q, interrupted, jobs.Done); j.Attempts != 1 {
+		t.Errorf("interrupted job took %d attempts, want 1", j.Attempts)
+	}
+}
+
+func TestQueueHTTP(t *testing.T) {
+	f := newFlasher()
+	q := newQueue(t, filepath.Join(t.TempDir(), "jobs.json"), f)
+	ts := httptest.NewServer(q)
+	defer ts.Close()
+	running := submit(t, q, jobs.Request{Device: "a", Image: "running"})
+	f.gate("done") <- nil
+	done := submit(t, q, jobs.Request{Device: "b", Image: "done"})
+	run(t, q)
+	waitForState(t, q, done, jobs.Done)
+
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"POST", "/v1/jobs", `{"device": "a", "image": "next"}`, http.StatusCreated},
+		{"POST", "/v1/jobs", `{"device": "z", "image": "next"}`, http.StatusNotFound},
+		{"POST", "/v1/jobs", `{"device": "a"}`, http.StatusBadRequest},
+		{"POST", "/v1/jobs", `{`, http.StatusBadRequest},
+		{"GET", "/v1/jobs", "", http.StatusOK},
+		{"GET", "/v1/jobs?state=done", "", http.StatusOK},
+		{"GET", "/v1/jobs/" + running, "", http.StatusOK},
+		{"GET", "/v1/jobs/nope", "", http.StatusNotFound},
+		{"DELETE", "/v1/jobs/" + done, "", http.StatusConflict},
+		{"DELETE", "/v1/jobs/" + running, "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, resp.StatusCode, tt.status)
+		}
+	}
+}
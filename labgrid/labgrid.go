@@ -0,0 +1,144 @@
+// Package labgrid exports SDWire devices as labgrid resources, so labs running
+// a labgrid coordinator can pick up devices discovered by this SDK without
+// hand-writing exporter configuration.
+//
+// The generated configuration targets labgrid's USBSDWireDevice resource,
+// which is driven by USBSDWireDriver and USBStorageDriver. labgrid has no
+// resource for SDWire3 devices, so those are skipped.
+package labgrid
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fcjr/sdwire"
+)
+
+// SysfsUSBDevices is where Linux exposes USB devices by port path.
+var SysfsUSBDevices = "/sys/bus/usb/devices"
+
+// Resource is a single labgrid resource in an exporter group.
+type Resource struct {
+	// Group is the exporter group (place) the resource belongs to.
+	Group string
+	// Class is the labgrid resource class, e.g. "USBSDWireDevice".
+	Class string
+	// Match holds the udev properties labgrid uses to find the device.
+	Match map[string]string
+}
+
+// Resources builds labgrid resources for the given devices. Each device gets
+// its own group named after its registry name, or "sdwire-<serial>" if it is
+// not registered.
+func Resources(devices []*sdwire.DeviceInfo) ([]Resource, error) {
+	var resources []Resource
+	for _, info := range devices {
+		if info.Generation != sdwire.GenerationSDWireC {
+			continue
+		}
+		// The SDWireC's FTDI and card reader sit behind an on-board hub;
+		// labgrid matches on that hub's path.
+		hub := parentPortPath(info.PortPath)
+		if hub == "" {
+			return nil, fmt.Errorf("device %s: no hub above port path %q", info.Serial, info.PortPath)
+		}
+		idPath, err := IDPath(hub)
+		if err != nil {
+			return nil, fmt.Errorf("device %s: %w", info.Serial, err)
+		}
+
+		group := info.Name
+		if group == "" {
+			group = "sdwire-" + info.Serial
+		}
+		resources = append(resources, Resource{
+			Group: group,
+			Class: "USBSDWireDevice",
+			Match: map[string]string{"@ID_PATH": idPath},
+		})
+	}
+	return resources, nil
+}
+
+// WriteExporterConfig writes resources as a labgrid exporter YAML file.
+func WriteExporterConfig(w io.Writer, resources []Resource) error {
+	var b strings.Builder
+	for _, r := range resources {
+		fmt.Fprintf(&b, "%s:\n  %s:\n    match:\n", quote(r.Group), r.Class)
+		keys := make([]string, 0, len(r.Match))
+		for k := range r.Match {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "      %s: %s\n", quote(k), quote(r.Match[k]))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var pciAddress = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-9a-f]$`)
+
+// IDPath computes the udev ID_PATH of the USB device at portPath, e.g.
+// "pci-0000:00:14.0-usb-0:1.2" for port path "1-1.2". It requires Linux sysfs.
+func IDPath(portPath string) (string, error) {
+	target, err := filepath.EvalSymlinks(filepath.Join(SysfsUSBDevices, portPath))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve sysfs path for %s: %w", portPath, err)
+	}
+
+	_, ports, ok := strings.Cut(portPath, "-")
+	if !ok {
+		return "", fmt.Errorf("invalid port path %q", portPath)
+	}
+
+	// Walk up from the device to the PCI function hosting its root hub.
+	for dir := filepath.Dir(target); dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		if name := filepath.Base(dir); pciAddress.MatchString(name) {
+			return "pci-" + name + "-usb-0:" + ports, nil
+		}
+	}
+	return "", fmt.Errorf("no PCI host controller above %s", target)
+}
+
+// parentPortPath returns the port path of the hub a device is attached to,
+// or "" if the device is attached directly to a root hub.
+func parentPortPath(portPath string) string {
+	i := strings.LastIndex(portPath, ".")
+	if i < 0 {
+		return ""
+	}
+	return portPath[:i]
+}
+
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// WriteExporterConfigFile discovers all connected devices and writes their
+// exporter configuration to path.
+func WriteExporterConfigFile(path string) error {
+	devices, err := sdwire.ListDevices()
+	if err != nil {
+		return err
+	}
+	resources, err := Resources(devices)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteExporterConfig(f, resources); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
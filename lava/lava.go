@@ -0,0 +1,207 @@
+// Package lava provides drop-in compatibility with the sd-mux-ctrl tool used
+// by LAVA device dictionaries, along with helpers for emitting LAVA test
+// signals.
+//
+// Only the sd-wire device type is supported. Flags that control other
+// sd-mux hardware (power ticks, USB muxes, DyPers) are rejected.
+//
+// Beyond sd-mux-ctrl, --flash writes an image to the card before switching,
+// reporting each step as a LAVA test case.
+package lava
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/eeprom"
+	"github.com/fcjr/sdwire/workflow"
+)
+
+// Exit codes returned by Run.
+const (
+	ExitSuccess = 0
+	ExitFailure = 1
+	ExitUsage   = 2
+)
+
+// Run executes an sd-mux-ctrl command line and returns its exit code.
+// args excludes the program name.
+func Run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("sd-mux-ctrl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	var (
+		list, info, showSerial, dut, ts, quiet bool
+		deviceID                               int
+		deviceSerial, deviceType, setSerial    string
+		flashImage, blockDevice                string
+	)
+	boolFlag := func(p *bool, short, long, usage string) {
+		fs.BoolVar(p, short, false, usage)
+		fs.BoolVar(p, long, false, usage)
+	}
+	boolFlag(&list, "l", "list", "list all sd-wire devices connected to this host")
+	boolFlag(&info, "i", "info", "display information about the device")
+	boolFlag(&showSerial, "o", "show-serial", "display the serial number of the device")
+	boolFlag(&dut, "d", "dut", "connect the SD card to the DUT")
+	boolFlag(&ts, "s", "ts", "connect the SD card to the test server")
+	boolFlag(&quiet, "q", "quiet", "do not print messages")
+	fs.IntVar(&deviceID, "v", -1, "use the device with the given number")
+	fs.IntVar(&deviceID, "device-id", -1, "use the device with the given number")
+	fs.StringVar(&deviceSerial, "e", "", "use the device with the given serial number")
+	fs.StringVar(&deviceSerial, "device-serial", "", "use the device with the given serial number")
+	fs.StringVar(&setSerial, "r", "", "write a new serial number to the device EEPROM")
+	fs.StringVar(&setSerial, "set-serial", "", "write a new serial number to the device EEPROM")
+	fs.StringVar(&deviceType, "b", "sd-wire", "device type (only sd-wire is supported)")
+	fs.StringVar(&deviceType, "device-type", "sd-wire", "device type (only sd-wire is supported)")
+	fs.StringVar(&flashImage, "flash", "", "write this image to the card first, emitting LAVA test signals")
+	fs.StringVar(&blockDevice, "block-device", "", "the card's block device, for --flash")
+
+	if err := fs.Parse(args); err != nil {
+		return ExitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "unexpected argument: %s\n", fs.Arg(0))
+		return ExitUsage
+	}
+	if deviceType != "sd-wire" {
+		fmt.Fprintf(stderr, "unsupported device type: %s\n", deviceType)
+		return ExitUsage
+	}
+	if dut && ts {
+		fmt.Fprintln(stderr, "--dut and --ts are mutually exclusive")
+		return ExitUsage
+	}
+	if flashImage != "" && blockDevice == "" {
+		fmt.Fprintln(stderr, "--flash needs --block-device")
+		return ExitUsage
+	}
+
+	devices, err := sdwire.ListDevices()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitFailure
+	}
+
+	if list {
+		if !quiet {
+			fmt.Fprintf(stdout, "Number of FTDI devices found: %d\n", len(devices))
+		}
+		for i, d := range devices {
+			fmt.Fprintf(stdout, "Dev: %d, Manufacturer: %s, Serial: %s, Description: %s\n",
+				i, d.Manufacturer, d.Serial, d.Product)
+		}
+		return ExitSuccess
+	}
+
+	target, err := selectDevice(devices, deviceID, deviceSerial)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitFailure
+	}
+
+	if showSerial {
+		fmt.Fprintln(stdout, target.Serial)
+	}
+	if info {
+		fmt.Fprintf(stdout, "Manufacturer: %s\nSerial: %s\nDescription: %s\nGeneration: %s\nPort: %s\n",
+			target.Manufacturer, target.Serial, target.Product, target.Generation, target.PortPath)
+	}
+	if !dut && !ts && setSerial == "" && flashImage == "" {
+		return ExitSuccess
+	}
+
+	device, err := sdwire.OpenInfo(target, sdwire.WithBlockingLock())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitFailure
+	}
+	defer device.Close()
+
+	if setSerial != "" {
+		if !eeprom.Supported(device) {
+			fmt.Fprintf(stderr, "%s devices have no programmable EEPROM\n", device.GetGeneration())
+			return ExitFailure
+		}
+		if err := eeprom.SetSerial(device, setSerial); err != nil {
+			fmt.Fprintln(stderr, err)
+			return ExitFailure
+		}
+		if !quiet {
+			fmt.Fprintf(stdout, "Serial number set to %s, replug the device to apply\n", setSerial)
+		}
+		if !dut && !ts && flashImage == "" {
+			return ExitSuccess
+		}
+	}
+
+	if flashImage != "" {
+		sig := NewSignaler(stdout)
+		_, err := workflow.New(
+			workflow.SwitchToHost(),
+			workflow.FlashWith(flashImage, blockDevice, workflow.FlashConfig{Stats: sig.FlashStats}),
+		).WithHooks(sig.Hooks()).Run(context.Background(), device)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return ExitFailure
+		}
+		if !dut && !ts {
+			return ExitSuccess
+		}
+	}
+
+	mode := sdwire.ModeHost
+	if dut {
+		mode = sdwire.ModeTarget
+	}
+	if err := device.SetMode(mode); err != nil {
+		fmt.Fprintln(stderr, err)
+		return ExitFailure
+	}
+	if !quiet {
+		fmt.Fprintf(stdout, "SD connected to: %s\n", modeName(mode))
+	}
+	return ExitSuccess
+}
+
+// selectDevice resolves the device addressed by --device-id or --device-serial.
+func selectDevice(devices []*sdwire.DeviceInfo, id int, serial string) (*sdwire.DeviceInfo, error) {
+	switch {
+	case serial != "":
+		var found []*sdwire.DeviceInfo
+		for _, d := range devices {
+			if d.Serial == serial {
+				found = append(found, d)
+			}
+		}
+		switch len(found) {
+		case 0:
+			return nil, fmt.Errorf("device with serial %s not found", serial)
+		case 1:
+			return found[0], nil
+		}
+		return nil, fmt.Errorf("%d devices share serial %s, select one with --device-id", len(found), serial)
+	case id >= 0:
+		if id >= len(devices) {
+			return nil, fmt.Errorf("device %d not found (%d devices connected)", id, len(devices))
+		}
+		return devices[id], nil
+	case len(devices) == 1:
+		return devices[0], nil
+	case len(devices) == 0:
+		return nil, fmt.Errorf("no sd-wire devices found")
+	default:
+		return nil, fmt.Errorf("%d devices connected, select one with --device-serial or --device-id", len(devices))
+	}
+}
+
+// modeName returns the sd-mux-ctrl name of a mode.
+func modeName(mode sdwire.SwitchMode) string {
+	if mode == sdwire.ModeTarget {
+		return "DUT"
+	}
+	return "TS"
+}
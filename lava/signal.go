@@ -0,0 +1,96 @@
+package lava
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fcjr/sdwire/workflow"
+)
+
+// Result is the outcome of a LAVA test case.
+type Result string
+
+// Test case results understood by LAVA.
+const (
+	Pass Result = "pass"
+	Fail Result = "fail"
+	Skip Result = "skip"
+)
+
+// Signaler writes LAVA test signals, which the dispatcher parses from the
+// console or command output to record test cases.
+type Signaler struct {
+	w io.Writer
+}
+
+// NewSignaler returns a Signaler writing to w.
+func NewSignaler(w io.Writer) *Signaler {
+	return &Signaler{w: w}
+}
+
+// StartTestCase marks the beginning of a test case.
+func (s *Signaler) StartTestCase(name string) {
+	fmt.Fprintf(s.w, "<LAVA_SIGNAL_STARTTC %s>\n", caseID(name))
+}
+
+// EndTestCase marks the end of a test case.
+func (s *Signaler) EndTestCase(name string) {
+	fmt.Fprintf(s.w, "<LAVA_SIGNAL_ENDTC %s>\n", caseID(name))
+}
+
+// TestCase records the result of a test case.
+func (s *Signaler) TestCase(name string, result Result) {
+	fmt.Fprintf(s.w, "<LAVA_SIGNAL_TESTCASE TEST_CASE_ID=%s RESULT=%s>\n", caseID(name), result)
+}
+
+// Measurement records the result of a test case along with a measured value.
+func (s *Signaler) Measurement(name string, result Result, value float64, units string) {
+	fmt.Fprintf(s.w, "<LAVA_SIGNAL_TESTCASE TEST_CASE_ID=%s RESULT=%s MEASUREMENT=%g UNITS=%s>\n",
+		caseID(name), result, value, units)
+}
+
+// Hooks returns workflow hooks that report each step, such as a flash, as
+// a test case measuring how long it took:
+//
+//	workflow.New(steps...).WithHooks(sig.Hooks())
+func (s *Signaler) Hooks() workflow.Hooks {
+	return workflow.Hooks{
+		BeforeStep: s.StartTestCase,
+		AfterStep: func(r workflow.StepResult) {
+			s.EndTestCase(r.Name)
+			result := Pass
+			if r.Err != nil {
+				result = Fail
+			}
+			s.Measurement(r.Name, result, r.Duration.Seconds(), "seconds")
+		},
+	}
+}
+
+// FlashStats records the throughput of a flash as a test case. It suits
+// workflow.FlashConfig.Stats.
+func (s *Signaler) FlashStats(stats workflow.FlashStats) {
+	if stats.Total <= 0 {
+		return
+	}
+	s.Measurement("flash-throughput", Pass, float64(stats.Bytes)/stats.Total.Seconds()/1e6, "MB/s")
+}
+
+// caseID makes name usable as a test case ID, which LAVA splits on spaces.
+func caseID(name string) string {
+	return strings.ReplaceAll(name, " ", "-")
+}
+
+// Step runs fn as a test case, emitting start, end and result signals.
+func (s *Signaler) Step(name string, fn func() error) error {
+	s.StartTestCase(name)
+	err := fn()
+	s.EndTestCase(name)
+	if err != nil {
+		s.TestCase(name, Fail)
+	} else {
+		s.TestCase(name, Pass)
+	}
+	return err
+}
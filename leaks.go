@@ -0,0 +1,96 @@
+package sdwire
+
+import (
+	"log/slog"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OpenHandle describes a device handle that has not been closed, as
+// reported by OpenHandles.
+type OpenHandle struct {
+	Serial   string
+	PortPath string
+	Opened   time.Time
+	// Stack is the stack trace of the goroutine that opened the handle.
+	Stack string
+}
+
+var (
+	leakTracking atomic.Bool
+
+	handlesMu sync.Mutex
+	handles   = make(map[*handleRecord]struct{})
+)
+
+// handleRecord tracks one open handle. It is kept apart from the SDWire so
+// that tracking does not keep leaked handles reachable.
+type handleRecord struct {
+	OpenHandle
+	log *slog.Logger
+}
+
+// TrackLeaks enables or disables leak tracking for devices opened
+// afterwards. Tracked handles record the stack that opened them, are
+// listed by OpenHandles until closed, and are logged with that stack if
+// they are garbage collected without being closed. A leaked handle keeps
+// the device and its lock held, blocking other processes from the mux.
+//
+// Capturing stacks makes opening slower, so tracking is off by default.
+func TrackLeaks(enabled bool) {
+	leakTracking.Store(enabled)
+}
+
+// OpenHandles returns the tracked handles that have not been closed, oldest
+// first. Tests can call it after closing everything to check for leaks.
+func OpenHandles() []OpenHandle {
+	handlesMu.Lock()
+	defer handlesMu.Unlock()
+	open := make([]OpenHandle, 0, len(handles))
+	for r := range handles {
+		open = append(open, r.OpenHandle)
+	}
+	sort.Slice(open, func(i, j int) bool {
+		return open[i].Opened.Before(open[j].Opened)
+	})
+	return open
+}
+
+// trackHandle starts tracking s if leak tracking is enabled.
+func trackHandle(s *SDWire) {
+	if !leakTracking.Load() {
+		return
+	}
+	buf := make([]byte, 16<<10)
+	buf = buf[:runtime.Stack(buf, false)]
+	r := &handleRecord{
+		OpenHandle: OpenHandle{
+			Serial:   s.serial,
+			PortPath: s.portPath,
+			Opened:   time.Now(),
+			Stack:    string(buf),
+		},
+		log: s.log,
+	}
+	handlesMu.Lock()
+	handles[r] = struct{}{}
+	handlesMu.Unlock()
+	s.handle = r
+	runtime.SetFinalizer(s, func(s *SDWire) {
+		r.log.Warn("device handle was never closed", "opened", r.Opened, "stack", r.Stack)
+		untrackHandle(r)
+	})
+}
+
+// untrackHandle stops tracking a handle. It is safe to call with nil.
+func untrackHandle(r *handleRecord) {
+	if r == nil {
+		return
+	}
+	handlesMu.Lock()
+	defer handlesMu.Unlock()
+	delete(handles, r)
+}
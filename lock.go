@@ -0,0 +1,127 @@
+package sdwire
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// DefaultLockDir is the directory holding per-device lock files:
+// /run/lock/sdwire on Linux and /var/tmp/sdwire on macOS, where the
+// temporary directory is private to each user. Elsewhere it is an sdwire
+// directory in the temporary directory.
+var DefaultLockDir = defaultLockDir()
+
+func defaultLockDir() string {
+	switch runtime.GOOS {
+	case "linux":
+		return "/run/lock/sdwire"
+	case "darwin":
+		return "/var/tmp/sdwire"
+	}
+	return filepath.Join(os.TempDir(), "sdwire")
+}
+
+// lockPollInterval is how often a bounded lock wait retries.
+const lockPollInterval = 100 * time.Millisecond
+
+// ErrDeviceLocked is returned when a device is held by another process.
+var ErrDeviceLocked = errors.New("device is locked by another process")
+
+// deviceLock is an advisory lock on a single device shared by all processes
+// on the host. The lock is released when the file is closed or the process exits.
+type deviceLock struct {
+	file *os.File
+}
+
+// lockKey identifies a device for locking. The port path is always part of
+// it, since clones can share a serial number and must still be usable in
+// parallel.
+func lockKey(id DeviceID) string {
+	if !id.HasSerial() {
+		return "port-" + id.PortPath
+	}
+	return "serial-" + id.Serial + "-port-" + id.PortPath
+}
+
+// lockDevice acquires the lock for key, waiting for other holders if wait is
+// set. A positive timeout bounds the wait.
+func lockDevice(dir, key string, wait bool, timeout time.Duration) (*deviceLock, error) {
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	// The umask strips the mode MkdirAll sets, and other users need to
+	// create lock files too. This fails harmlessly if another user owns
+	// the directory.
+	os.Chmod(dir, 0o777|os.ModeSticky)
+
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+
+	// flock only needs a read-only descriptor, which other users can open
+	// whatever the umask left of the file's mode.
+	f, err := os.OpenFile(filepath.Join(dir, name+".lock"), os.O_RDONLY|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := flockTimeout(f, wait, timeout); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &deviceLock{file: f}, nil
+}
+
+// flockTimeout is flock with the wait bounded by timeout, polling since
+// flock itself cannot time out.
+func flockTimeout(f *os.File, wait bool, timeout time.Duration) error {
+	if !wait || timeout <= 0 {
+		return flock(f, wait)
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := flock(f, false)
+		if !errors.Is(err, ErrDeviceLocked) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return WithCode(CodeTimeout, fmt.Errorf("gave up after %v: %w", timeout, err))
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// unlock releases the lock. It is safe to call on a nil lock.
+func (l *deviceLock) unlock() error {
+	if l == nil {
+		return nil
+	}
+	err := funlock(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// CheckLockDir verifies that device locks can be taken in dir, for use in
+// health checks.
+func CheckLockDir(dir string) error {
+	l, err := lockDevice(dir, "healthcheck", false, 0)
+	if errors.Is(err, ErrDeviceLocked) {
+		// Another process is checking concurrently; the store works.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return l.unlock()
+}
@@ -0,0 +1,21 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly || windows)
+
+package sdwire
+
+import (
+	"errors"
+	"os"
+)
+
+// errLockUnsupported is returned when opening a device with locking enabled
+// on a platform without advisory file locks. Open devices with WithoutLock
+// there.
+var errLockUnsupported = WithCode(CodeUnsupported, errors.New("device locking is not supported on this platform, use WithoutLock"))
+
+func flock(f *os.File, wait bool) error {
+	return errLockUnsupported
+}
+
+func funlock(f *os.File) error {
+	return nil
+}
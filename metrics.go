@@ -0,0 +1,296 @@
+package sdwire
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsRecorder receives counters and latencies from open devices.
+// Implementations must be safe for concurrent use.
+type MetricsRecorder interface {
+	// Switch counts a successful mode switch.
+	Switch(serial string, mode SwitchMode)
+	// Error counts a failed operation such as "open", "set_mode" or "probe".
+	Error(serial, op string)
+	// Retry counts a retried operation.
+	Retry(serial, op string)
+	// Latency records how long an operation took, whether or not it failed.
+	Latency(op string, d time.Duration)
+}
+
+var (
+	metricsMu sync.RWMutex
+	metrics   MetricsRecorder = NopMetrics{}
+)
+
+// SetMetrics sets the recorder used by devices opened without WithMetrics.
+// Pass nil to disable metrics, which is the default.
+func SetMetrics(m MetricsRecorder) {
+	if m == nil {
+		m = NopMetrics{}
+	}
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metrics = m
+}
+
+// Metrics returns the recorder installed with SetMetrics, for packages such
+// as monitor that report on devices they do not hold open.
+func Metrics() MetricsRecorder {
+	return packageMetrics()
+}
+
+// HealthRecorder is implemented by recorders that also track device
+// health, as checked by the monitor package. Implementations must be safe
+// for concurrent use.
+type HealthRecorder interface {
+	// Health records the outcome of the latest check of a device and how
+	// many checks in a row have failed.
+	Health(serial string, healthy bool, consecutiveFailures int)
+}
+
+// packageMetrics returns the recorder installed with SetMetrics.
+func packageMetrics() MetricsRecorder {
+	metricsMu.RLock()
+	defer metricsMu.RUnlock()
+	return metrics
+}
+
+// NopMetrics discards all metrics.
+type NopMetrics struct{}
+
+func (NopMetrics) Switch(string, SwitchMode)     {}
+func (NopMetrics) Error(string, string)          {}
+func (NopMetrics) Retry(string, string)          {}
+func (NopMetrics) Latency(string, time.Duration) {}
+
+// ExpvarMetrics publishes metrics as expvar maps, served with the other
+// expvar variables at /debug/vars.
+type ExpvarMetrics struct {
+	switches *expvar.Map // "serial/mode" → count
+	errors   *expvar.Map // "serial/op" → count
+	retries  *expvar.Map // "serial/op" → count
+	latency  *expvar.Map // op → {count, total_seconds}
+	health   *expvar.Map // serial → {healthy, consecutive_failures}
+}
+
+// NewExpvarMetrics publishes metrics under the given expvar name. Like
+// expvar.Publish, it panics if the name is already in use.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	m := &ExpvarMetrics{
+		switches: new(expvar.Map),
+		errors:   new(expvar.Map),
+		retries:  new(expvar.Map),
+		latency:  new(expvar.Map),
+		health:   new(expvar.Map),
+	}
+	root := expvar.NewMap(name)
+	root.Set("switches", m.switches)
+	root.Set("errors", m.errors)
+	root.Set("retries", m.retries)
+	root.Set("latency", m.latency)
+	root.Set("health", m.health)
+	return m
+}
+
+func (m *ExpvarMetrics) Switch(serial string, mode SwitchMode) {
+	m.switches.Add(serial+"/"+mode.String(), 1)
+}
+
+func (m *ExpvarMetrics) Error(serial, op string) {
+	m.errors.Add(serial+"/"+op, 1)
+}
+
+func (m *ExpvarMetrics) Retry(serial, op string) {
+	m.retries.Add(serial+"/"+op, 1)
+}
+
+func (m *ExpvarMetrics) Latency(op string, d time.Duration) {
+	v, ok := m.latency.Get(op).(*expvar.Map)
+	if !ok {
+		// Concurrent first observations may race here; the loser's sample
+		// lands in the map that wins.
+		v = new(expvar.Map)
+		m.latency.Set(op, v)
+	}
+	v.Add("count", 1)
+	v.AddFloat("total_seconds", d.Seconds())
+}
+
+func (m *ExpvarMetrics) Health(serial string, healthy bool, consecutiveFailures int) {
+	v := new(expvar.Map)
+	up := new(expvar.Int)
+	if healthy {
+		up.Set(1)
+	}
+	failures := new(expvar.Int)
+	failures.Set(int64(consecutiveFailures))
+	v.Set("healthy", up)
+	v.Set("consecutive_failures", failures)
+	m.health.Set(serial, v)
+}
+
+// DefaultLatencyBuckets are the histogram bucket upper bounds, in seconds,
+// used by PrometheusMetrics.
+var DefaultLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// PrometheusMetrics collects metrics and serves them in the Prometheus text
+// exposition format. Mount it on a mux, e.g. at /metrics.
+type PrometheusMetrics struct {
+	buckets []float64
+
+	mu        sync.Mutex
+	switches  map[[2]string]uint64 // serial, mode
+	errors    map[[2]string]uint64 // serial, op
+	retries   map[[2]string]uint64 // serial, op
+	latencies map[string]*histogram
+	healthy   map[string]bool // serial
+	failures  map[string]int  // serial, consecutive
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewPrometheusMetrics creates a collector using DefaultLatencyBuckets.
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		buckets:   DefaultLatencyBuckets,
+		switches:  make(map[[2]string]uint64),
+		errors:    make(map[[2]string]uint64),
+		retries:   make(map[[2]string]uint64),
+		latencies: make(map[string]*histogram),
+		healthy:   make(map[string]bool),
+		failures:  make(map[string]int),
+	}
+}
+
+func (m *PrometheusMetrics) Switch(serial string, mode SwitchMode) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.switches[[2]string{serial, mode.String()}]++
+}
+
+func (m *PrometheusMetrics) Error(serial, op string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[[2]string{serial, op}]++
+}
+
+func (m *PrometheusMetrics) Retry(serial, op string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retries[[2]string{serial, op}]++
+}
+
+func (m *PrometheusMetrics) Latency(op string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.latencies[op]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.latencies[op] = h
+	}
+	s := d.Seconds()
+	for i, le := range m.buckets {
+		if s <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += s
+}
+
+func (m *PrometheusMetrics) Health(serial string, healthy bool, consecutiveFailures int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.healthy[serial] = healthy
+	m.failures[serial] = consecutiveFailures
+}
+
+// ServeHTTP implements http.Handler.
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	writeCounter(&b, "sdwire_switches_total", "Successful mode switches.", "serial", "mode", m.switches)
+	writeCounter(&b, "sdwire_errors_total", "Failed device operations.", "serial", "op", m.errors)
+	writeCounter(&b, "sdwire_retries_total", "Retried device operations.", "serial", "op", m.retries)
+
+	b.WriteString("# HELP sdwire_operation_duration_seconds Device operation latency.\n")
+	b.WriteString("# TYPE sdwire_operation_duration_seconds histogram\n")
+	ops := make([]string, 0, len(m.latencies))
+	for op := range m.latencies {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		h := m.latencies[op]
+		var cumulative uint64
+		for i, le := range m.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "sdwire_operation_duration_seconds_bucket{op=%q,le=\"%g\"} %d\n", op, le, cumulative)
+		}
+		fmt.Fprintf(&b, "sdwire_operation_duration_seconds_bucket{op=%q,le=\"+Inf\"} %d\n", op, h.count)
+		fmt.Fprintf(&b, "sdwire_operation_duration_seconds_sum{op=%q} %g\n", op, h.sum)
+		fmt.Fprintf(&b, "sdwire_operation_duration_seconds_count{op=%q} %d\n", op, h.count)
+	}
+
+	if len(m.healthy) > 0 {
+		b.WriteString("# HELP sdwire_device_healthy Whether the latest health checks of a device passed.\n")
+		b.WriteString("# TYPE sdwire_device_healthy gauge\n")
+		serials := make([]string, 0, len(m.healthy))
+		for serial := range m.healthy {
+			serials = append(serials, serial)
+		}
+		sort.Strings(serials)
+		for _, serial := range serials {
+			up := 0
+			if m.healthy[serial] {
+				up = 1
+			}
+			fmt.Fprintf(&b, "sdwire_device_healthy{serial=%q} %d\n", serial, up)
+		}
+		b.WriteString("# HELP sdwire_device_consecutive_failures Health checks of a device failed in a row.\n")
+		b.WriteString("# TYPE sdwire_device_consecutive_failures gauge\n")
+		for _, serial := range serials {
+			fmt.Fprintf(&b, "sdwire_device_consecutive_failures{serial=%q} %d\n", serial, m.failures[serial])
+		}
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func writeCounter(b *strings.Builder, name, help, label1, label2 string, values map[[2]string]uint64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	keys := make([][2]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s{%s=%q,%s=%q} %d\n", name, label1, k[0], label2, k[1], values[k])
+	}
+}
@@ -0,0 +1,130 @@
+package sdwire
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ModeChange reports a successful mode switch.
+type ModeChange struct {
+	Serial   string
+	PortPath string
+	Name     string
+	Mode     SwitchMode
+	Time     time.Time
+}
+
+// listeners is a set of event callbacks.
+type listeners[T any] struct {
+	mu   sync.Mutex
+	next int
+	fns  map[int]func(T)
+}
+
+// modeListeners is a set of mode change callbacks.
+type modeListeners = listeners[ModeChange]
+
+func (l *listeners[T]) add(fn func(T)) func() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.fns == nil {
+		l.fns = make(map[int]func(T))
+	}
+	id := l.next
+	l.next++
+	l.fns[id] = fn
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			delete(l.fns, id)
+		})
+	}
+}
+
+func (l *listeners[T]) notify(c T) {
+	l.mu.Lock()
+	fns := make([]func(T), 0, len(l.fns))
+	for _, fn := range l.fns {
+		fns = append(fns, fn)
+	}
+	l.mu.Unlock()
+	for _, fn := range fns {
+		fn(c)
+	}
+}
+
+// globalModeListeners receives changes from every device in the process.
+var globalModeListeners modeListeners
+
+// OnModeChange registers fn to be called after any device in this process
+// switches mode, and returns a function that unregisters it. fn runs on the
+// goroutine that switched the device and should return quickly.
+func OnModeChange(fn func(ModeChange)) (cancel func()) {
+	return globalModeListeners.add(fn)
+}
+
+// Subscribe returns a channel receiving mode changes from every device in
+// this process, which is closed once ctx is done. Changes are dropped while
+// the channel's buffer is full, so a slow reader never holds up a switch.
+func Subscribe(ctx context.Context) <-chan ModeChange {
+	return subscribe(ctx, &globalModeListeners)
+}
+
+// OnModeChange registers fn to be called after this device switches mode,
+// and returns a function that unregisters it. fn runs on the goroutine that
+// switched the device and should return quickly.
+func (s *SDWire) OnModeChange(fn func(ModeChange)) (cancel func()) {
+	return s.listeners.add(fn)
+}
+
+// Subscribe returns a channel receiving this device's mode changes, which
+// is closed once ctx is done. Changes are dropped while the channel's
+// buffer is full, so a slow reader never holds up a switch.
+func (s *SDWire) Subscribe(ctx context.Context) <-chan ModeChange {
+	return subscribe(ctx, &s.listeners)
+}
+
+func subscribe(ctx context.Context, l *modeListeners) <-chan ModeChange {
+	ch := make(chan ModeChange, 16)
+	// mu keeps a notification that is already running from sending on ch
+	// after it is closed.
+	var (
+		mu     sync.Mutex
+		closed bool
+	)
+	cancel := l.add(func(c ModeChange) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- c:
+		default:
+		}
+	})
+	context.AfterFunc(ctx, func() {
+		cancel()
+		mu.Lock()
+		defer mu.Unlock()
+		closed = true
+		close(ch)
+	})
+	return ch
+}
+
+// notifyModeChange informs listeners that the device switched to mode.
+func (s *SDWire) notifyModeChange(mode SwitchMode) {
+	c := ModeChange{
+		Serial:   s.serial,
+		PortPath: s.portPath,
+		Name:     s.identity.Name,
+		Mode:     mode,
+		Time:     time.Now(),
+	}
+	s.listeners.notify(c)
+	globalModeListeners.notify(c)
+}
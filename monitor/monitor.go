@@ -0,0 +1,280 @@
+// Package monitor periodically checks the health of connected SDWire devices
+// so failing hubs and muxes are noticed before they break a test run.
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/pool"
+)
+
+// Health is the health state of a single device.
+type Health struct {
+	// ID identifies the device as in sdwire.DeviceInfo.ID, which unlike
+	// the serial number is unique.
+	ID       string
+	Serial   string
+	PortPath string
+	Healthy  bool
+	// ConsecutiveFailures counts failed checks since the last success.
+	ConsecutiveFailures int
+	Checks              int
+	Failures            int
+	LastCheck           time.Time
+	LastError           error
+}
+
+// Event reports a device changing between healthy and unhealthy.
+type Event struct {
+	ID      string
+	Serial  string
+	Healthy bool
+	Err     error
+	Time    time.Time
+}
+
+// Option configures a Monitor.
+type Option func(*Monitor)
+
+// WithInterval sets the time between checks. The default is 30 seconds.
+func WithInterval(d time.Duration) Option {
+	return func(m *Monitor) {
+		m.interval = d
+	}
+}
+
+// WithThreshold sets how many consecutive failures mark a device unhealthy.
+// The default is 3.
+func WithThreshold(n int) Option {
+	return func(m *Monitor) {
+		m.threshold = n
+	}
+}
+
+// WithProbe replaces the default probe, which calls Probe on each device.
+// Devices are opened without taking the device lock, so a check never
+// makes another process fail to open a device with ErrDeviceLocked; probes
+// must therefore not disturb a device in use.
+func WithProbe(probe func(*sdwire.SDWire) error) Option {
+	return func(m *Monitor) {
+		m.probe = probe
+	}
+}
+
+// WithModeReadback also reads the switch position back with GetMode after
+// each probe, which fails on muxes whose USB side answers but whose switch
+// does not. Devices that cannot report their mode are only probed.
+func WithModeReadback() Option {
+	return func(m *Monitor) {
+		m.readback = true
+	}
+}
+
+// WithPool keeps the health of devices in p in sync with the monitor.
+func WithPool(p *pool.Pool) Option {
+	return func(m *Monitor) {
+		m.pool = p
+	}
+}
+
+// WithMetrics sets the recorder that check results are reported to. The
+// default is the one installed with sdwire.SetMetrics; nil disables them. Each check records
+// its latency as "monitor_check", failures as errors of that operation, and
+// device health if the recorder implements sdwire.HealthRecorder.
+func WithMetrics(r sdwire.MetricsRecorder) Option {
+	if r == nil {
+		r = sdwire.NopMetrics{}
+	}
+	return func(m *Monitor) {
+		m.metrics = r
+	}
+}
+
+// WithEventHandler calls fn whenever a device becomes healthy or unhealthy.
+// fn is called synchronously from the monitor loop.
+func WithEventHandler(fn func(Event)) Option {
+	return func(m *Monitor) {
+		m.onEvent = fn
+	}
+}
+
+// Monitor checks devices periodically.
+type Monitor struct {
+	interval  time.Duration
+	threshold int
+	probe     func(*sdwire.SDWire) error
+	readback  bool
+	pool      *pool.Pool
+	metrics   sdwire.MetricsRecorder
+	onEvent   func(Event)
+	// list and open find and open devices.
+	list func() ([]*sdwire.DeviceInfo, error)
+	open func(*sdwire.DeviceInfo, ...sdwire.Option) (*sdwire.SDWire, error)
+
+	mu     sync.Mutex
+	health map[string]*Health
+}
+
+// New creates a monitor. Call Run to start checking.
+func New(opts ...Option) *Monitor {
+	m := &Monitor{
+		interval:  30 * time.Second,
+		threshold: 3,
+		probe:     (*sdwire.SDWire).Probe,
+		metrics:   sdwire.Metrics(),
+		list:      sdwire.ListDevices,
+		open:      sdwire.OpenInfo,
+		health:    make(map[string]*Health),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Run checks all devices every interval until ctx is done.
+func (m *Monitor) Run(ctx context.Context) error {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.Check()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check runs a single round of checks. Devices seen in earlier rounds that
+// have disappeared count as failed.
+func (m *Monitor) Check() {
+	seen := make(map[string]bool)
+	devices, err := m.list()
+	if err != nil {
+		// Enumeration itself failed; count it against every known device.
+		m.mu.Lock()
+		ids := make([]string, 0, len(m.health))
+		for id := range m.health {
+			ids = append(ids, id)
+		}
+		m.mu.Unlock()
+		for _, id := range ids {
+			m.record(id, nil, err)
+		}
+		return
+	}
+
+	for _, info := range devices {
+		seen[info.ID] = true
+		start := time.Now()
+		err := m.check(info)
+		m.metrics.Latency("monitor_check", time.Since(start))
+		m.record(info.ID, info, err)
+	}
+
+	m.mu.Lock()
+	var missing []string
+	for id := range m.health {
+		if !seen[id] {
+			missing = append(missing, id)
+		}
+	}
+	m.mu.Unlock()
+	for _, id := range missing {
+		m.record(id, nil, errors.New("device not present"))
+	}
+}
+
+func (m *Monitor) check(info *sdwire.DeviceInfo) error {
+	dev, err := m.open(info, sdwire.WithoutLock())
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+	if err := m.probe(dev); err != nil {
+		return err
+	}
+	if m.readback {
+		if _, err := dev.GetMode(); err != nil && sdwire.CodeOf(err) != sdwire.CodeUnsupported {
+			return fmt.Errorf("failed to read the mode back: %w", err)
+		}
+	}
+	return nil
+}
+
+// record adds the result of a check of the device with the given ID.
+// info is nil if the device could not be listed.
+func (m *Monitor) record(id string, info *sdwire.DeviceInfo, err error) {
+	m.mu.Lock()
+	h, ok := m.health[id]
+	if !ok {
+		h = &Health{ID: id, Healthy: true}
+		m.health[id] = h
+	}
+	if info != nil {
+		h.Serial = info.Serial
+		h.PortPath = info.PortPath
+	}
+	serial := h.Serial
+	h.Checks++
+	h.LastCheck = time.Now()
+	h.LastError = err
+	wasHealthy := h.Healthy
+	if err != nil {
+		h.Failures++
+		h.ConsecutiveFailures++
+		if h.ConsecutiveFailures >= m.threshold {
+			h.Healthy = false
+		}
+	} else {
+		h.ConsecutiveFailures = 0
+		h.Healthy = true
+	}
+	healthy, consecutive := h.Healthy, h.ConsecutiveFailures
+	m.mu.Unlock()
+
+	if err != nil {
+		m.metrics.Error(id, "monitor_check")
+	}
+	if r, ok := m.metrics.(sdwire.HealthRecorder); ok {
+		r.Health(id, healthy, consecutive)
+	}
+
+	if healthy == wasHealthy {
+		return
+	}
+	if m.pool != nil {
+		if healthy {
+			m.pool.MarkHealthy(id)
+		} else {
+			m.pool.MarkUnhealthy(id)
+		}
+	}
+	if m.onEvent != nil {
+		if !healthy {
+			err = fmt.Errorf("%d consecutive failed checks: %w", m.threshold, err)
+		}
+		m.onEvent(Event{ID: id, Serial: serial, Healthy: healthy, Err: err, Time: time.Now()})
+	}
+}
+
+// Health returns the health of every device seen so far, sorted by ID.
+func (m *Monitor) Health() []Health {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	health := make([]Health, 0, len(m.health))
+	for _, h := range m.health {
+		health = append(health, *h)
+	}
+	sort.Slice(health, func(i, j int) bool {
+		return health[i].ID < health[j].ID
+	})
+	return health
+}
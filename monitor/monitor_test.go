@@ -0,0 +1,217 @@
+package monitor
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/pool"
+)
+
+// recorder collects the monitor's metrics.
+type recorder struct {
+	sdwire.NopMetrics
+
+	mu      sync.Mutex
+	errors  int
+	healthy map[string]bool
+}
+
+func (r *recorder) Error(serial, op string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors++
+}
+
+func (r *recorder) Health(serial string, healthy bool, consecutiveFailures int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.healthy[serial] = healthy
+}
+
+func TestRecordTransitions(t *testing.T) {
+	info := &sdwire.DeviceInfo{ID: "a", Serial: "a", PortPath: "1-1"}
+	p := pool.New([]*sdwire.DeviceInfo{info})
+	defer p.Close()
+	metrics := &recorder{healthy: make(map[string]bool)}
+	var events []Event
+	m := New(
+		WithThreshold(2),
+		WithPool(p),
+		WithMetrics(metrics),
+		WithEventHandler(func(ev Event) { events = append(events, ev) }),
+	)
+	failure := errors.New("no response")
+
+	steps := []struct {
+		err         error
+		healthy     bool
+		consecutive int
+		// event is the health reported by a new event, if any.
+		event *bool
+	}{
+		{failure, true, 1, nil},
+		{failure, false, 2, ptr(false)},
+		{failure, false, 3, nil},
+		{nil, true, 0, ptr(true)},
+		{nil, true, 0, nil},
+		{failure, true, 1, nil},
+	}
+	for i, s := range steps {
+		before := len(events)
+		m.record(info.ID, info, s.err)
+
+		h := m.Health()[0]
+		if h.Healthy != s.healthy || h.ConsecutiveFailures != s.consecutive {
+			t.Fatalf("step %d: healthy %t, %d consecutive failures, want %t, %d", i, h.Healthy, h.ConsecutiveFailures, s.healthy, s.consecutive)
+		}
+		if got := p.Devices()[0].Healthy; got != s.healthy {
+			t.Errorf("step %d: pool healthy %t, want %t", i, got, s.healthy)
+		}
+		if got := metrics.healthy[info.ID]; got != s.healthy {
+			t.Errorf("step %d: health metric %t, want %t", i, got, s.healthy)
+		}
+		switch {
+		case s.event == nil && len(events) != before:
+			t.Errorf("step %d: unexpected event %+v", i, events[len(events)-1])
+		case s.event != nil && len(events) != before+1:
+			t.Errorf("step %d: no event", i)
+		case s.event != nil && events[before].Healthy != *s.event:
+			t.Errorf("step %d: event healthy %t, want %t", i, events[before].Healthy, *s.event)
+		}
+	}
+
+	if !errors.Is(events[0].Err, failure) || events[0].ID != "a" || events[0].Serial != "a" {
+		t.Errorf("unhealthy event %+v, want ID and serial a wrapping the failure", events[0])
+	}
+	h := m.Health()[0]
+	if h.Checks != 6 || h.Failures != 4 {
+		t.Errorf("%d checks, %d failures, want 6, 4", h.Checks, h.Failures)
+	}
+	if metrics.errors != 4 {
+		t.Errorf("%d errors recorded, want 4", metrics.errors)
+	}
+}
+
+func ptr(b bool) *bool { return &b }
+
+// simulated returns a monitor checking simulated devices, and a function
+// setting which of them are present.
+func simulated(t *testing.T, opts ...Option) (*Monitor, func(...*sdwire.DeviceInfo)) {
+	dir := t.TempDir()
+	var (
+		mu      sync.Mutex
+		present []*sdwire.DeviceInfo
+	)
+	m := New(append([]Option{WithMetrics(nil), WithThreshold(1)}, opts...)...)
+	m.list = func() ([]*sdwire.DeviceInfo, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return present, nil
+	}
+	m.open = func(info *sdwire.DeviceInfo, opts ...sdwire.Option) (*sdwire.SDWire, error) {
+		return openSim(dir, info, opts...)
+	}
+	return m, func(devices ...*sdwire.DeviceInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		present = devices
+	}
+}
+
+func openSim(dir string, info *sdwire.DeviceInfo, opts ...sdwire.Option) (*sdwire.SDWire, error) {
+	opts = append([]sdwire.Option{sdwire.WithLockDir(dir)}, opts...)
+	return sdwire.OpenSimulator(&sdwire.Simulator{
+		Serial:     info.Serial,
+		CardPath:   filepath.Join(dir, info.ID+".img"),
+		DevicePath: filepath.Join(dir, info.ID+".dev"),
+	}, opts...)
+}
+
+func TestCheckMissingDevice(t *testing.T) {
+	m, setPresent := simulated(t)
+	a := &sdwire.DeviceInfo{ID: "a", Serial: "a"}
+	setPresent(a)
+	m.Check()
+	if h := m.Health(); len(h) != 1 || !h[0].Healthy || h[0].Checks != 1 {
+		t.Fatalf("after a good check: %+v", h)
+	}
+
+	setPresent()
+	m.Check()
+	h := m.Health()
+	if len(h) != 1 || h[0].Healthy || h[0].LastError == nil {
+		t.Fatalf("after the device disappeared: %+v", h)
+	}
+
+	setPresent(a)
+	m.Check()
+	if h := m.Health(); !h[0].Healthy {
+		t.Fatalf("after the device came back: %+v", h)
+	}
+}
+
+// TestCheckDoesNotLock checks that a device being checked can still be
+// opened, and that a device held elsewhere is still checked.
+func TestCheckDoesNotLock(t *testing.T) {
+	dir := t.TempDir()
+	a := &sdwire.DeviceInfo{ID: "a", Serial: "a"}
+	var openErr error
+	probe := func(dev *sdwire.SDWire) error {
+		// A test harness opening the device mid-check.
+		other, err := openSim(dir, a)
+		if err == nil {
+			other.Close()
+		}
+		openErr = err
+		return dev.Probe()
+	}
+	m, setPresent := simulated(t, WithProbe(probe))
+	m.open = func(info *sdwire.DeviceInfo, opts ...sdwire.Option) (*sdwire.SDWire, error) {
+		return openSim(dir, info, opts...)
+	}
+	setPresent(a)
+
+	m.Check()
+	if openErr != nil {
+		t.Fatalf("opening a device during a check: %v", openErr)
+	}
+
+	held, err := openSim(dir, a)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer held.Close()
+	probed := false
+	m.probe = func(dev *sdwire.SDWire) error {
+		probed = true
+		return dev.Probe()
+	}
+	m.Check()
+	if !probed {
+		t.Error("device held by another handle was not probed")
+	}
+	if h := m.Health(); !h[0].Healthy {
+		t.Errorf("held device unhealthy: %+v", h[0])
+	}
+}
+
+func TestCheckModeReadback(t *testing.T) {
+	m, setPresent := simulated(t, WithModeReadback())
+	setPresent(&sdwire.DeviceInfo{ID: "a", Serial: "a"})
+	m.Check()
+	if h := m.Health(); !h[0].Healthy || h[0].LastError != nil {
+		t.Fatalf("readback of a working device failed: %+v", h[0])
+	}
+
+	m.probe = func(dev *sdwire.SDWire) error {
+		// Closing the handle makes the readback fail.
+		return dev.Close()
+	}
+	m.Check()
+	if h := m.Health(); h[0].Healthy || !errors.Is(h[0].LastError, sdwire.ErrClosed) {
+		t.Fatalf("failed readback not reported: %+v", h[0])
+	}
+}
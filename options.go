@@ -0,0 +1,257 @@
+package sdwire
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Option configures how New and NewWithSerial open a device.
+type Option func(*options)
+
+type options struct {
+	lock         bool
+	lockWait     bool
+	lockDir      string
+	logger       *slog.Logger
+	metrics      MetricsRecorder
+	audit        AuditSink
+	actor        string
+	diag         int
+	quirks       Quirks
+	record       string
+	faults       *FaultInjector
+	timeouts     Timeouts
+	stallRetries int
+	settle       time.Duration
+	debounce     time.Duration
+	force        bool
+	skipNoop     bool
+	initialMode  *SwitchMode
+	watchdog     time.Duration
+	detachSerial bool
+	sysfsControl bool
+}
+
+var (
+	defaultOptionsMu sync.RWMutex
+	defaultOptions   []Option
+)
+
+// SetDefaultOptions sets options applied to every device opened
+// afterwards, before the options passed to New and the like. Pass none to
+// clear them.
+func SetDefaultOptions(opts ...Option) {
+	defaultOptionsMu.Lock()
+	defer defaultOptionsMu.Unlock()
+	defaultOptions = slices.Clone(opts)
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		lock:         true,
+		lockDir:      DefaultLockDir,
+		logger:       packageLogger(),
+		metrics:      packageMetrics(),
+		audit:        packageAuditSink(),
+		stallRetries: 1,
+	}
+	defaultOptionsMu.RLock()
+	defaults := defaultOptions
+	defaultOptionsMu.RUnlock()
+	for _, opt := range defaults {
+		opt(&o)
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.audit != nil && o.actor == "" {
+		o.actor = defaultActor()
+	}
+	return o
+}
+
+// WithoutLock disables the cross-process device lock. Use this only when
+// coordination between processes is handled elsewhere.
+func WithoutLock() Option {
+	return func(o *options) {
+		o.lock = false
+	}
+}
+
+// WithBlockingLock waits for another process to release the device instead
+// of failing with ErrDeviceLocked.
+func WithBlockingLock() Option {
+	return func(o *options) {
+		o.lockWait = true
+	}
+}
+
+// WithLockDir overrides the directory holding device lock files.
+// All processes sharing devices must use the same directory.
+func WithLockDir(dir string) Option {
+	return func(o *options) {
+		o.lockDir = dir
+	}
+}
+
+// WithLogger sets the logger for this device, overriding SetLogger.
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// WithMetrics sets the metrics recorder for this device, overriding
+// SetMetrics.
+func WithMetrics(m MetricsRecorder) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// WithAudit sets the audit sink for this device, overriding SetAuditSink.
+func WithAudit(sink AuditSink) Option {
+	return func(o *options) {
+		o.audit = sink
+	}
+}
+
+// WithActor sets who is recorded as performing audited operations, such
+// as a CI job or API token name. It defaults to the current user.
+func WithActor(actor string) Option {
+	return func(o *options) {
+		o.actor = actor
+	}
+}
+
+// WithDiagnostics records the last n USB transfers issued to the device.
+// They are available from SDWire.Diagnostics and are attached to errors
+// returned by SetMode and Probe as a *DiagnosticsError.
+func WithDiagnostics(n int) Option {
+	return func(o *options) {
+		o.diag = n
+	}
+}
+
+// WithQuirks enables workarounds for known hardware quirks, e.g. ones
+// previously found by Diagnose.
+func WithQuirks(q Quirks) Option {
+	return func(o *options) {
+		o.quirks = q
+	}
+}
+
+// WithRecording records every USB operation on the device and writes them
+// to a fixture file at path when the device is closed. Replay the fixture
+// with OpenReplay.
+func WithRecording(path string) Option {
+	return func(o *options) {
+		o.record = path
+	}
+}
+
+// WithFaults injects the faults configured on f into the device's USB
+// operations.
+func WithFaults(f *FaultInjector) Option {
+	return func(o *options) {
+		o.faults = f
+	}
+}
+
+// WithTimeouts sets the device's timeouts. Zero fields fall back to the
+// device's registry entry and then to DefaultTimeouts.
+func WithTimeouts(t Timeouts) Option {
+	return func(o *options) {
+		o.timeouts = t
+	}
+}
+
+// WithStallRecovery sets how many times an SDWireC control transfer that
+// fails with a pipe error (EPIPE) is retried after resetting the FTDI chip.
+// The default is one retry; 0 surfaces stalls immediately.
+func WithStallRecovery(retries int) Option {
+	return func(o *options) {
+		o.stallRetries = retries
+	}
+}
+
+// WithSettleDelay makes SetMode wait d after each switch before returning,
+// giving the card and DUT time to stabilize once the mux has flipped.
+func WithSettleDelay(d time.Duration) Option {
+	return func(o *options) {
+		o.settle = d
+	}
+}
+
+// WithDebounce enforces a minimum interval between switches. A switch
+// issued within window of the previous one waits for the window to pass,
+// unless it repeats the previous switch, in which case it is skipped.
+// Rapid consecutive switches can corrupt the card.
+func WithDebounce(window time.Duration) Option {
+	return func(o *options) {
+		o.debounce = window
+	}
+}
+
+// WithForce lets SetMode switch the card to the target even while it is in
+// use on the host; see ErrCardBusy.
+func WithForce() Option {
+	return func(o *options) {
+		o.force = true
+	}
+}
+
+// WithSkipNoop makes SetMode read the current mode back first (see
+// GetMode) and return without issuing any transfers or resets if the device
+// is already in the requested mode. On generations that cannot read the
+// mode back, every switch is performed.
+func WithSkipNoop() Option {
+	return func(o *options) {
+		o.skipNoop = true
+	}
+}
+
+// WithInitialMode switches the device to mode as soon as it is opened,
+// verifying the switch where the mode can be read back (see GetMode), and
+// fails the open if that does not succeed. After a rack powers up the
+// switch position is unknown, and a half-booted DUT may fight the host for
+// the card.
+func WithInitialMode(mode SwitchMode) Option {
+	return func(o *options) {
+		o.initialMode = &mode
+	}
+}
+
+// WithWatchdog starts a watchdog on the device when it is opened; see
+// SDWire.StartWatchdog.
+func WithWatchdog(interval time.Duration) Option {
+	return func(o *options) {
+		o.watchdog = interval
+	}
+}
+
+// WithDetachSerialDriver detaches the Linux ftdi_sio serial driver from an
+// SDWireC while it is open, and reattaches it on Close. When ftdi_sio is
+// bound, bitmode commands intermittently fail. Opening fails with
+// CodePermission if the driver cannot be detached.
+func WithDetachSerialDriver() Option {
+	return func(o *options) {
+		o.detachSerial = true
+	}
+}
+
+// WithSysfsControl switches SDWire3 devices by writing the sysfs
+// authorized attribute of the card reader interface, instead of detaching
+// its driver through USB and resetting the device. Only the attribute
+// needs to be writable, which a udev rule from UdevRules arranges. A card
+// reader deauthorized this way stays deauthorized across resets, so
+// devices switched to the target with this option must be switched back
+// with it too. It is Linux only; elsewhere switching fails with
+// CodeUnsupported.
+func WithSysfsControl() Option {
+	return func(o *options) {
+		o.sysfsControl = true
+	}
+}
@@ -0,0 +1,136 @@
+package sdwire
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPerfSamples bounds the samples kept per operation; older ones are
+// overwritten.
+const maxPerfSamples = 4096
+
+var perf struct {
+	mu      sync.Mutex
+	enabled bool
+	samples map[string]*perfSamples
+}
+
+type perfSamples struct {
+	values []time.Duration
+	next   int
+	count  int
+}
+
+// EnablePerf starts collecting the latency distributions reported by
+// PerfReport: mode switches and probes per generation, and the flash stage
+// timings recorded by the workflow package. Collection is off by default.
+func EnablePerf() {
+	perf.mu.Lock()
+	defer perf.mu.Unlock()
+	perf.enabled = true
+	if perf.samples == nil {
+		perf.samples = make(map[string]*perfSamples)
+	}
+}
+
+// DisablePerf stops collecting and discards the samples.
+func DisablePerf() {
+	perf.mu.Lock()
+	defer perf.mu.Unlock()
+	perf.enabled = false
+	perf.samples = nil
+}
+
+// RecordPerf adds a latency sample for op if collection is enabled. Ops are
+// slash-separated, e.g. "set_mode/SDWire3" or "flash/write".
+func RecordPerf(op string, d time.Duration) {
+	perf.mu.Lock()
+	defer perf.mu.Unlock()
+	if !perf.enabled {
+		return
+	}
+	s, ok := perf.samples[op]
+	if !ok {
+		s = &perfSamples{}
+		perf.samples[op] = s
+	}
+	if len(s.values) < maxPerfSamples {
+		s.values = append(s.values, d)
+	} else {
+		s.values[s.next] = d
+	}
+	s.next = (s.next + 1) % maxPerfSamples
+	s.count++
+}
+
+// PerfStat summarizes the latency of one operation. Percentiles are over
+// the most recent samples.
+type PerfStat struct {
+	Op    string
+	Count int
+	Min   time.Duration
+	Mean  time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// PerfReport is a performance report, sorted by operation.
+type PerfReport []PerfStat
+
+// Perf returns the report of the samples collected since EnablePerf.
+func Perf() PerfReport {
+	perf.mu.Lock()
+	defer perf.mu.Unlock()
+	report := make(PerfReport, 0, len(perf.samples))
+	for op, s := range perf.samples {
+		values := slices.Clone(s.values)
+		slices.Sort(values)
+		var total time.Duration
+		for _, v := range values {
+			total += v
+		}
+		report = append(report, PerfStat{
+			Op:    op,
+			Count: s.count,
+			Min:   values[0],
+			Mean:  total / time.Duration(len(values)),
+			P50:   percentile(values, 0.50),
+			P90:   percentile(values, 0.90),
+			P99:   percentile(values, 0.99),
+			Max:   values[len(values)-1],
+		})
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Op < report[j].Op })
+	return report
+}
+
+// percentile returns the p-th quantile of sorted values by the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(p*float64(len(sorted))+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+// WriteTo writes the report as a table.
+func (r PerfReport) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-24s %7s %10s %10s %10s %10s %10s %10s\n", "OP", "COUNT", "MIN", "MEAN", "P50", "P90", "P99", "MAX")
+	for _, s := range r {
+		fmt.Fprintf(&b, "%-24s %7d %10s %10s %10s %10s %10s %10s\n", s.Op, s.Count,
+			perfDuration(s.Min), perfDuration(s.Mean), perfDuration(s.P50),
+			perfDuration(s.P90), perfDuration(s.P99), perfDuration(s.Max))
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func perfDuration(d time.Duration) string {
+	return d.Round(10 * time.Microsecond).String()
+}
@@ -0,0 +1,90 @@
+package sdwire
+
+import (
+	"fmt"
+	"os/user"
+	"strings"
+)
+
+// UdevRulePath is where UdevRule output is conventionally installed.
+const UdevRulePath = "/etc/udev/rules.d/99-sdwire.rules"
+
+// PermissionError is returned when a device cannot be opened because the
+// current user lacks access to it (LIBUSB_ERROR_ACCESS). It carries what is
+// needed to fix the problem.
+type PermissionError struct {
+	// Vendor and Product identify the device that could not be opened.
+	Vendor, Product uint16
+	// PortPath is the port of the device, if known.
+	PortPath string
+	// User and Groups describe the current user.
+	User   string
+	Groups []string
+	// Rule is a udev rule granting access to the device.
+	Rule string
+	Err  error
+}
+
+func (e *PermissionError) Error() string {
+	who := e.User
+	if who == "" {
+		who = "the current user"
+	}
+	if len(e.Groups) > 0 {
+		who += " (groups " + strings.Join(e.Groups, ", ") + ")"
+	}
+	return fmt.Sprintf("permission denied opening SDWire device %04x:%04x as %s; add this rule to %s and replug the device: %s",
+		e.Vendor, e.Product, who, UdevRulePath, e.Rule)
+}
+
+func (e *PermissionError) Unwrap() error { return e.Err }
+
+// ErrorCode implements the interface used by CodeOf.
+func (e *PermissionError) ErrorCode() ErrorCode {
+	return CodePermission
+}
+
+// UdevRule returns a udev rule that gives all users access to the USB
+// device with the given vendor and product IDs.
+func UdevRule(vendor, product uint16) string {
+	return fmt.Sprintf(`SUBSYSTEM=="usb", ATTR{idVendor}=="%04x", ATTR{idProduct}=="%04x", MODE="0666"`, vendor, product)
+}
+
+// UdevAuthorizeRule returns a udev rule that gives all users write access
+// to the authorized attribute of the interfaces of the USB device with the
+// given vendor and product IDs, as needed by WithSysfsControl.
+func UdevAuthorizeRule(vendor, product uint16) string {
+	return fmt.Sprintf(`ACTION=="add", SUBSYSTEM=="usb", ENV{DEVTYPE}=="usb_interface", ATTRS{idVendor}=="%04x", ATTRS{idProduct}=="%04x", RUN+="/bin/chmod 0666 /sys%%p/authorized"`, vendor, product)
+}
+
+// UdevRules returns udev rules for every supported SDWire generation, in
+// the form expected in UdevRulePath. See GenerateUdevRules for more
+// control over them.
+func UdevRules() string {
+	rules, _ := GenerateUdevRules(UdevOptions{SysfsControl: true})
+	return rules
+}
+
+// newPermissionError describes a failure to open desc.
+func newPermissionError(desc *deviceDesc, err error) *PermissionError {
+	e := &PermissionError{
+		Vendor:   desc.Vendor,
+		Product:  desc.Product,
+		PortPath: portPathOf(desc),
+		Rule:     UdevRule(desc.Vendor, desc.Product),
+		Err:      err,
+	}
+	if u, uerr := user.Current(); uerr == nil {
+		e.User = u.Username
+		if ids, gerr := u.GroupIds(); gerr == nil {
+			for _, id := range ids {
+				if g, lerr := user.LookupGroupId(id); lerr == nil {
+					e.Groups = append(e.Groups, g.Name)
+				} else {
+					e.Groups = append(e.Groups, id)
+				}
+			}
+		}
+	}
+	return e
+}
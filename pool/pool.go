@@ -0,0 +1,456 @@
+// Package pool hands out SDWire devices to concurrent users, such as test
+// shards running in parallel, so that each device is used by at most one
+// holder at a time.
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fcjr/sdwire"
+)
+
+// ErrClosed is returned by Acquire after the pool has been closed.
+var ErrClosed = errors.New("pool is closed")
+
+// Strategy decides which free device is handed out by Acquire.
+type Strategy int
+
+const (
+	// FIFO hands out the first free device in discovery order.
+	FIFO Strategy = iota
+	// LeastRecentlyUsed hands out the device that has been idle the longest,
+	// spreading switch cycles evenly across the pool.
+	LeastRecentlyUsed
+	// LabelMatch hands out the device carrying the fewest labels beyond the
+	// requested ones, keeping specialised devices free for callers that need them.
+	LabelMatch
+)
+
+// String returns a human-readable description of the strategy.
+func (s Strategy) String() string {
+	switch s {
+	case FIFO:
+		return "FIFO"
+	case LeastRecentlyUsed:
+		return "LeastRecentlyUsed"
+	case LabelMatch:
+		return "LabelMatch"
+	default:
+		return "Unknown"
+	}
+}
+
+// ParseStrategy returns the strategy with the given name, as returned by
+// String.
+func ParseStrategy(name string) (Strategy, error) {
+	for _, s := range []Strategy{FIFO, LeastRecentlyUsed, LabelMatch} {
+		if s.String() == name {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown pool strategy %q", name)
+}
+
+// Option configures a Pool.
+type Option func(*Pool)
+
+// WithStrategy selects how free devices are chosen. The default is FIFO.
+func WithStrategy(s Strategy) Option {
+	return func(p *Pool) {
+		p.strategy = s
+	}
+}
+
+// WithFilter restricts the pool to devices for which keep returns true.
+func WithFilter(keep func(*sdwire.DeviceInfo) bool) Option {
+	return func(p *Pool) {
+		p.filter = keep
+	}
+}
+
+// WithLabels sets the function used to derive labels for each device.
+// Labels are matched against those requested with AcquireMatching. By
+// default the registry tags of each device are used.
+func WithLabels(labels func(*sdwire.DeviceInfo) map[string]string) Option {
+	return func(p *Pool) {
+		p.labels = labels
+	}
+}
+
+// WithDeviceOptions sets the options passed to sdwire.OpenInfo when a
+// device is acquired.
+func WithDeviceOptions(opts ...sdwire.Option) Option {
+	return func(p *Pool) {
+		p.deviceOpts = opts
+	}
+}
+
+// WithOpen sets the function that opens acquired devices, e.g. to pool
+// simulated devices. The default is sdwire.OpenInfo.
+func WithOpen(open func(info *sdwire.DeviceInfo, opts ...sdwire.Option) (*sdwire.SDWire, error)) Option {
+	return func(p *Pool) {
+		p.open = open
+	}
+}
+
+// WithMaxFailures sets how many consecutive failures evict a device from
+// the pool. The default is 3; zero disables eviction.
+func WithMaxFailures(n int) Option {
+	return func(p *Pool) {
+		p.maxFailures = n
+	}
+}
+
+// FromConfig returns the options set in the pool section of a site
+// configuration; see sdwire.Config.
+func FromConfig(c sdwire.PoolConfig) ([]Option, error) {
+	var opts []Option
+	if c.Strategy != "" {
+		s, err := ParseStrategy(c.Strategy)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithStrategy(s))
+	}
+	if c.MaxFailures != nil {
+		opts = append(opts, WithMaxFailures(*c.MaxFailures))
+	}
+	return opts, nil
+}
+
+// Status describes a device in the pool.
+type Status struct {
+	Info     *sdwire.DeviceInfo
+	Labels   map[string]string
+	InUse    bool
+	Healthy  bool
+	Failures int
+	LastUsed time.Time
+}
+
+type entry struct {
+	info     *sdwire.DeviceInfo
+	labels   map[string]string
+	inUse    bool
+	healthy  bool
+	failures int
+	lastUsed time.Time
+}
+
+type waiter struct {
+	labels map[string]string
+	ch     chan *entry
+	// err is why nil was sent on ch.
+	err error
+}
+
+// Pool manages a fixed set of SDWire devices.
+type Pool struct {
+	strategy    Strategy
+	filter      func(*sdwire.DeviceInfo) bool
+	labels      func(*sdwire.DeviceInfo) map[string]string
+	deviceOpts  []sdwire.Option
+	open        func(*sdwire.DeviceInfo, ...sdwire.Option) (*sdwire.SDWire, error)
+	maxFailures int
+
+	mu      sync.Mutex
+	entries []*entry
+	waiters []*waiter
+	closed  bool
+}
+
+// New creates a pool from the given devices.
+func New(devices []*sdwire.DeviceInfo, opts ...Option) *Pool {
+	p := &Pool{
+		strategy:    FIFO,
+		open:        sdwire.OpenInfo,
+		maxFailures: 3,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	for _, info := range devices {
+		if p.filter != nil && !p.filter(info) {
+			continue
+		}
+		labels := info.Tags
+		if p.labels != nil {
+			labels = p.labels(info)
+		}
+		p.entries = append(p.entries, &entry{
+			info:    info,
+			labels:  labels,
+			healthy: true,
+		})
+	}
+	return p
+}
+
+// Discover creates a pool from all currently connected devices.
+func Discover(opts ...Option) (*Pool, error) {
+	devices, err := sdwire.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	return New(devices, opts...), nil
+}
+
+// Acquire waits for a free, healthy device and opens it.
+// The device is returned to the pool when the lease is released or ctx is done.
+func (p *Pool) Acquire(ctx context.Context) (*Lease, error) {
+	return p.AcquireMatching(ctx, nil)
+}
+
+// AcquireMatching is like Acquire but only considers devices carrying all of
+// the given labels. It fails with CodeNotFound if no healthy device carries
+// them, including when the last one is evicted while waiting.
+func (p *Pool) AcquireMatching(ctx context.Context, labels map[string]string) (*Lease, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if !p.satisfiable(labels) {
+		p.mu.Unlock()
+		return nil, noDeviceError(labels)
+	}
+	w := &waiter{labels: labels, ch: make(chan *entry, 1)}
+	p.waiters = append(p.waiters, w)
+	p.dispatch()
+	p.mu.Unlock()
+
+	var e *entry
+	select {
+	case e = <-w.ch:
+	case <-ctx.Done():
+		p.mu.Lock()
+		if !p.dequeue(w) {
+			// Lost the race: a device was assigned after ctx was done.
+			if e := <-w.ch; e != nil {
+				e.inUse = false
+				p.dispatch()
+			}
+		}
+		p.mu.Unlock()
+		return nil, ctx.Err()
+	}
+	if e == nil {
+		return nil, w.err
+	}
+
+	dev, err := p.open(e.info, p.deviceOpts...)
+	if err != nil {
+		p.mu.Lock()
+		p.put(e, true)
+		p.mu.Unlock()
+		return nil, fmt.Errorf("failed to open pooled device %s: %w", e.info.ID, err)
+	}
+
+	l := &Lease{pool: p, entry: e, device: dev}
+	l.stop = context.AfterFunc(ctx, func() {
+		l.Release()
+	})
+	return l, nil
+}
+
+// MarkUnhealthy evicts the device with the given ID, as in
+// sdwire.DeviceInfo.ID, until MarkHealthy is called. Waiters that no other
+// healthy device can satisfy fail.
+func (p *Pool) MarkUnhealthy(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e := p.find(id); e != nil {
+		e.healthy = false
+		p.dispatch()
+	}
+}
+
+// MarkHealthy returns an evicted device to service and clears its failure count.
+func (p *Pool) MarkHealthy(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e := p.find(id); e != nil {
+		e.healthy = true
+		e.failures = 0
+		p.dispatch()
+	}
+}
+
+// Devices returns a snapshot of every device in the pool.
+func (p *Pool) Devices() []Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := make([]Status, len(p.entries))
+	for i, e := range p.entries {
+		status[i] = Status{
+			Info:     e.info,
+			Labels:   e.labels,
+			InUse:    e.inUse,
+			Healthy:  e.healthy,
+			Failures: e.failures,
+			LastUsed: e.lastUsed,
+		}
+	}
+	return status
+}
+
+// Close fails all pending Acquire calls. Outstanding leases remain valid
+// and should still be released.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for _, w := range p.waiters {
+		w.err = ErrClosed
+		w.ch <- nil
+	}
+	p.waiters = nil
+}
+
+// dispatch hands free devices to waiters in arrival order, and fails
+// waiters no healthy device can satisfy any more. Callers must hold p.mu.
+func (p *Pool) dispatch() {
+	remaining := p.waiters[:0]
+	for _, w := range p.waiters {
+		if e := p.pick(w.labels); e != nil {
+			e.inUse = true
+			w.ch <- e
+			continue
+		}
+		if !p.satisfiable(w.labels) {
+			w.err = noDeviceError(w.labels)
+			w.ch <- nil
+			continue
+		}
+		remaining = append(remaining, w)
+	}
+	p.waiters = remaining
+}
+
+// pick selects a free device according to the strategy. Callers must hold p.mu.
+func (p *Pool) pick(labels map[string]string) *entry {
+	var best *entry
+	for _, e := range p.entries {
+		if e.inUse || !e.healthy || !matches(e.labels, labels) {
+			continue
+		}
+		switch {
+		case best == nil:
+			best = e
+		case p.strategy == LeastRecentlyUsed && e.lastUsed.Before(best.lastUsed):
+			best = e
+		case p.strategy == LabelMatch && len(e.labels) < len(best.labels):
+			best = e
+		}
+	}
+	return best
+}
+
+// satisfiable reports whether any healthy device, busy or not, carries
+// labels. Callers must hold p.mu.
+func (p *Pool) satisfiable(labels map[string]string) bool {
+	for _, e := range p.entries {
+		if e.healthy && matches(e.labels, labels) {
+			return true
+		}
+	}
+	return false
+}
+
+func noDeviceError(labels map[string]string) error {
+	return sdwire.WithCode(sdwire.CodeNotFound, fmt.Errorf("no healthy device in pool matches labels %v", labels))
+}
+
+// put returns a device to the pool, recording a failure if failed is set.
+// Callers must hold p.mu.
+func (p *Pool) put(e *entry, failed bool) {
+	e.inUse = false
+	e.lastUsed = time.Now()
+	if failed {
+		e.failures++
+		if p.maxFailures > 0 && e.failures >= p.maxFailures {
+			e.healthy = false
+		}
+	} else {
+		e.failures = 0
+	}
+	p.dispatch()
+}
+
+// dequeue removes w from the wait queue, reporting whether it was still queued.
+// Callers must hold p.mu.
+func (p *Pool) dequeue(w *waiter) bool {
+	for i, q := range p.waiters {
+		if q == w {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Pool) find(id string) *entry {
+	for _, e := range p.entries {
+		if e.info.ID == id {
+			return e
+		}
+	}
+	return nil
+}
+
+func matches(have, want map[string]string) bool {
+	for k, v := range want {
+		if have[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Lease is exclusive use of one pooled device.
+type Lease struct {
+	pool   *Pool
+	entry  *entry
+	device *sdwire.SDWire
+	stop   func() bool
+
+	once   sync.Once
+	failed bool
+	err    error
+}
+
+// Device returns the opened device.
+func (l *Lease) Device() *sdwire.SDWire {
+	return l.device
+}
+
+// Info returns the identifying information of the leased device.
+func (l *Lease) Info() *sdwire.DeviceInfo {
+	return l.entry.info
+}
+
+// Fail records that the device misbehaved while leased. The failure counts
+// towards eviction when the lease is released.
+func (l *Lease) Fail() {
+	l.pool.mu.Lock()
+	l.failed = true
+	l.pool.mu.Unlock()
+}
+
+// Release closes the device and returns it to the pool. It is safe to call
+// more than once.
+func (l *Lease) Release() error {
+	l.once.Do(func() {
+		l.stop()
+		l.err = l.device.Close()
+
+		l.pool.mu.Lock()
+		l.pool.put(l.entry, l.failed || l.err != nil)
+		l.pool.mu.Unlock()
+	})
+	return l.err
+}
@@ -0,0 +1,289 @@
+package pool_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/pool"
+)
+
+// device returns the information of a fabricated device.
+func device(id string, tags map[string]string) *sdwire.DeviceInfo {
+	return &sdwire.DeviceInfo{ID: id, Serial: id, PortPath: "1-1", Identity: sdwire.Identity{Tags: tags}}
+}
+
+// newPool creates a pool that opens simulated devices in place of the
+// given ones.
+func newPool(t *testing.T, devices []*sdwire.DeviceInfo, opts ...pool.Option) *pool.Pool {
+	dir := t.TempDir()
+	open := func(info *sdwire.DeviceInfo, opts ...sdwire.Option) (*sdwire.SDWire, error) {
+		return sdwire.OpenSimulator(&sdwire.Simulator{
+			Serial:     info.Serial,
+			CardPath:   filepath.Join(dir, info.ID+".img"),
+			DevicePath: filepath.Join(dir, info.ID+".dev"),
+		}, opts...)
+	}
+	opts = append([]pool.Option{pool.WithOpen(open), pool.WithDeviceOptions(sdwire.WithoutLock())}, opts...)
+	p := pool.New(devices, opts...)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func acquire(t *testing.T, p *pool.Pool, labels map[string]string) *pool.Lease {
+	t.Helper()
+	// Leases end with their context, so this one must outlive the call.
+	l, err := p.AcquireMatching(context.Background(), labels)
+	if err != nil {
+		t.Fatalf("AcquireMatching(%v): %v", labels, err)
+	}
+	return l
+}
+
+func release(t *testing.T, l *pool.Lease) {
+	t.Helper()
+	if err := l.Release(); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+}
+
+func TestStrategies(t *testing.T) {
+	devices := func() []*sdwire.DeviceInfo {
+		return []*sdwire.DeviceInfo{
+			device("a", map[string]string{"board": "rpi4", "usb3": "yes"}),
+			device("b", map[string]string{"board": "rpi4"}),
+			device("c", map[string]string{"board": "rpi5"}),
+		}
+	}
+	tests := []struct {
+		strategy pool.Strategy
+		labels   map[string]string
+		// released is acquired and released first; "" skips it.
+		released string
+		want     string
+	}{
+		{pool.FIFO, nil, "", "a"},
+		{pool.FIFO, map[string]string{"board": "rpi5"}, "", "c"},
+		{pool.FIFO, nil, "a", "a"},
+		{pool.LeastRecentlyUsed, nil, "", "a"},
+		{pool.LeastRecentlyUsed, nil, "a", "b"},
+		{pool.LabelMatch, map[string]string{"board": "rpi4"}, "", "b"},
+		{pool.LabelMatch, nil, "", "b"},
+	}
+	for _, tt := range tests {
+		p := newPool(t, devices(), pool.WithStrategy(tt.strategy))
+		if tt.released != "" {
+			release(t, acquire(t, p, map[string]string{}))
+		}
+		l := acquire(t, p, tt.labels)
+		if got := l.Info().ID; got != tt.want {
+			t.Errorf("%v, labels %v, after releasing %q: got %s, want %s", tt.strategy, tt.labels, tt.released, got, tt.want)
+		}
+		release(t, l)
+	}
+}
+
+func TestLeastRecentlyUsedRotates(t *testing.T) {
+	p := newPool(t, []*sdwire.DeviceInfo{device("a", nil), device("b", nil), device("c", nil)},
+		pool.WithStrategy(pool.LeastRecentlyUsed))
+	var got []string
+	for i := 0; i < 6; i++ {
+		l := acquire(t, p, nil)
+		got = append(got, l.Info().ID)
+		release(t, l)
+	}
+	want := []string{"a", "b", "c", "a", "b", "c"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("acquired %v, want %v", got, want)
+		}
+	}
+}
+
+func TestAcquireUnknownLabels(t *testing.T) {
+	p := newPool(t, []*sdwire.DeviceInfo{device("a", map[string]string{"board": "rpi4"})})
+	_, err := p.AcquireMatching(context.Background(), map[string]string{"board": "rpi5"})
+	if sdwire.CodeOf(err) != sdwire.CodeNotFound {
+		t.Fatalf("AcquireMatching = %v, want CodeNotFound", err)
+	}
+}
+
+func TestAcquireWaitsForRelease(t *testing.T) {
+	p := newPool(t, []*sdwire.DeviceInfo{device("a", nil)})
+	l := acquire(t, p, nil)
+
+	got := make(chan error, 1)
+	go func() {
+		l, err := p.Acquire(context.Background())
+		if err == nil {
+			err = l.Release()
+		}
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		t.Fatalf("Acquire returned %v while the only device was leased", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	release(t, l)
+	if err := <-got; err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+}
+
+func TestAcquireCanceled(t *testing.T) {
+	p := newPool(t, []*sdwire.DeviceInfo{device("a", nil)})
+	l := acquire(t, p, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire = %v, want DeadlineExceeded", err)
+	}
+	release(t, l)
+	release(t, acquire(t, p, nil))
+}
+
+// TestAcquireCancelRace cancels waiters while the device they wait for is
+// released, so that some are handed the device after their context is
+// done. The device must go back to the pool either way.
+func TestAcquireCancelRace(t *testing.T) {
+	p := newPool(t, []*sdwire.DeviceInfo{device("a", nil)})
+	for i := 0; i < 100; i++ {
+		l := acquire(t, p, nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if l, err := p.Acquire(ctx); err == nil {
+				l.Release()
+			}
+		}()
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { defer wg.Done(); cancel() }()
+		go func() { defer wg.Done(); l.Release() }()
+		wg.Wait()
+		<-done
+	}
+	for _, s := range p.Devices() {
+		if s.InUse {
+			t.Fatalf("device %s still in use", s.Info.ID)
+		}
+	}
+	release(t, acquire(t, p, nil))
+}
+
+func TestLeaseReleasedWithContext(t *testing.T) {
+	p := newPool(t, []*sdwire.DeviceInfo{device("a", nil)})
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := p.Acquire(ctx); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	cancel()
+	release(t, acquire(t, p, nil))
+}
+
+func TestEviction(t *testing.T) {
+	p := newPool(t, []*sdwire.DeviceInfo{device("a", nil)}, pool.WithMaxFailures(2))
+	for i := 0; i < 2; i++ {
+		if s := p.Devices()[0]; !s.Healthy {
+			t.Fatalf("evicted after %d failures", i)
+		}
+		l := acquire(t, p, nil)
+		l.Fail()
+		release(t, l)
+	}
+	s := p.Devices()[0]
+	if s.Healthy || s.Failures != 2 {
+		t.Fatalf("after 2 failures: healthy %t, failures %d", s.Healthy, s.Failures)
+	}
+
+	// With every device evicted, Acquire fails instead of waiting forever.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := p.Acquire(ctx); sdwire.CodeOf(err) != sdwire.CodeNotFound {
+		t.Fatalf("Acquire with every device evicted = %v, want CodeNotFound", err)
+	}
+
+	p.MarkHealthy("a")
+	if s := p.Devices()[0]; !s.Healthy || s.Failures != 0 {
+		t.Fatalf("after MarkHealthy: healthy %t, failures %d", s.Healthy, s.Failures)
+	}
+	release(t, acquire(t, p, nil))
+}
+
+func TestEvictionFailsWaiters(t *testing.T) {
+	p := newPool(t, []*sdwire.DeviceInfo{device("a", nil)}, pool.WithMaxFailures(1))
+	l := acquire(t, p, nil)
+
+	got := make(chan error, 1)
+	go func() {
+		_, err := p.Acquire(context.Background())
+		got <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	l.Fail()
+	release(t, l)
+	select {
+	case err := <-got:
+		if sdwire.CodeOf(err) != sdwire.CodeNotFound {
+			t.Fatalf("waiting Acquire = %v, want CodeNotFound", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting Acquire still blocked after the last device was evicted")
+	}
+}
+
+func TestMarkUnhealthy(t *testing.T) {
+	p := newPool(t, []*sdwire.DeviceInfo{device("a", nil), device("b", nil)})
+	p.MarkUnhealthy("a")
+	l := acquire(t, p, nil)
+	if got := l.Info().ID; got != "b" {
+		t.Errorf("acquired %s, want the healthy device b", got)
+	}
+	release(t, l)
+}
+
+func TestOpenFailureCounts(t *testing.T) {
+	failing := func(*sdwire.DeviceInfo, ...sdwire.Option) (*sdwire.SDWire, error) {
+		return nil, errors.New("open failed")
+	}
+	p := pool.New([]*sdwire.DeviceInfo{device("a", nil)}, pool.WithOpen(failing), pool.WithMaxFailures(1))
+	defer p.Close()
+	if _, err := p.Acquire(context.Background()); err == nil {
+		t.Fatal("Acquire succeeded with a failing open")
+	}
+	if s := p.Devices()[0]; s.InUse || s.Healthy {
+		t.Fatalf("after failed open: in use %t, healthy %t", s.InUse, s.Healthy)
+	}
+}
+
+func TestCloseWakesWaiters(t *testing.T) {
+	p := newPool(t, []*sdwire.DeviceInfo{device("a", nil)})
+	l := acquire(t, p, nil)
+	defer l.Release()
+
+	got := make(chan error, 1)
+	go func() {
+		_, err := p.Acquire(context.Background())
+		got <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	p.Close()
+	select {
+	case err := <-got:
+		if !errors.Is(err, pool.ErrClosed) {
+			t.Fatalf("waiting Acquire = %v, want ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not wake the waiting Acquire")
+	}
+	if _, err := p.Acquire(context.Background()); !errors.Is(err, pool.ErrClosed) {
+		t.Fatalf("Acquire after Close = %v, want ErrClosed", err)
+	}
+}
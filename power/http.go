@@ -0,0 +1,165 @@
+package power
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tasmota switches a relay of a smart plug running Tasmota firmware over
+// its HTTP command API.
+type Tasmota struct {
+	Host string
+	// Relay is the 1-based relay number.
+	Relay    int
+	User     string
+	Password string
+	OffTime  time.Duration
+	// Client is used for requests; nil means http.DefaultClient.
+	Client *http.Client
+}
+
+// On turns the relay on.
+func (t *Tasmota) On(ctx context.Context) error {
+	return t.command(ctx, "On")
+}
+
+// Off turns the relay off.
+func (t *Tasmota) Off(ctx context.Context) error {
+	return t.command(ctx, "Off")
+}
+
+// Cycle turns the relay off and on again.
+func (t *Tasmota) Cycle(ctx context.Context) error {
+	return cycle(ctx, t, t.OffTime)
+}
+
+// State reports whether the relay is on.
+func (t *Tasmota) State(ctx context.Context) (bool, error) {
+	q := url.Values{"cmnd": {"Power" + strconv.Itoa(t.Relay)}}
+	if t.User != "" {
+		q.Set("user", t.User)
+		q.Set("password", t.Password)
+	}
+	var status map[string]any
+	if err := getJSON(ctx, t.Client, "http://"+t.Host+"/cm?"+q.Encode(), "", "", &status); err != nil {
+		return false, err
+	}
+	// Single relay devices answer with "POWER" rather than "POWER1".
+	for key, value := range status {
+		if strings.HasPrefix(key, "POWER") {
+			return value == "ON", nil
+		}
+	}
+	return false, fmt.Errorf("unexpected Tasmota power status: %v", status)
+}
+
+func (t *Tasmota) command(ctx context.Context, state string) error {
+	q := url.Values{"cmnd": {"Power" + strconv.Itoa(t.Relay) + " " + state}}
+	if t.User != "" {
+		q.Set("user", t.User)
+		q.Set("password", t.Password)
+	}
+	return get(ctx, t.Client, "http://"+t.Host+"/cm?"+q.Encode(), "", "")
+}
+
+// Shelly switches a relay of a Shelly smart plug or relay over its local
+// HTTP API.
+type Shelly struct {
+	Host string
+	// Relay is the 0-based relay or switch id.
+	Relay int
+	// Gen2 selects the RPC API of Gen2 and later devices.
+	Gen2     bool
+	User     string
+	Password string
+	OffTime  time.Duration
+	// Client is used for requests; nil means http.DefaultClient.
+	Client *http.Client
+}
+
+// On turns the relay on.
+func (s *Shelly) On(ctx context.Context) error {
+	return s.set(ctx, true)
+}
+
+// Off turns the relay off.
+func (s *Shelly) Off(ctx context.Context) error {
+	return s.set(ctx, false)
+}
+
+// Cycle turns the relay off and on again.
+func (s *Shelly) Cycle(ctx context.Context) error {
+	return cycle(ctx, s, s.OffTime)
+}
+
+// State reports whether the relay is on.
+func (s *Shelly) State(ctx context.Context) (bool, error) {
+	var status struct {
+		IsOn   bool `json:"ison"`
+		Output bool `json:"output"`
+	}
+	u := fmt.Sprintf("http://%s/relay/%d", s.Host, s.Relay)
+	if s.Gen2 {
+		u = fmt.Sprintf("http://%s/rpc/Switch.GetStatus?id=%d", s.Host, s.Relay)
+	}
+	if err := getJSON(ctx, s.Client, u, s.User, s.Password, &status); err != nil {
+		return false, err
+	}
+	return status.IsOn || status.Output, nil
+}
+
+func (s *Shelly) set(ctx context.Context, on bool) error {
+	var u string
+	if s.Gen2 {
+		u = fmt.Sprintf("http://%s/rpc/Switch.Set?id=%d&on=%t", s.Host, s.Relay, on)
+	} else {
+		turn := "off"
+		if on {
+			turn = "on"
+		}
+		u = fmt.Sprintf("http://%s/relay/%d?turn=%s", s.Host, s.Relay, turn)
+	}
+	return get(ctx, s.Client, u, s.User, s.Password)
+}
+
+// get issues an HTTP GET and fails on non-2xx responses.
+func get(ctx context.Context, client *http.Client, u, user, password string) error {
+	return getJSON(ctx, client, u, user, password, nil)
+}
+
+// getJSON is like get but decodes the response into v, unless v is nil.
+func getJSON(ctx context.Context, client *http.Client, u, user, password string, v any) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("power request failed: %s: %s", resp.Status, body)
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse power status: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// Package power switches DUT power so workflows can power-cycle a board
+// around flashing. Each Controller drives a single outlet or port.
+package power
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultOffTime is how long Cycle keeps power off when no time is configured.
+const DefaultOffTime = 2 * time.Second
+
+// Controller switches power to a single DUT.
+type Controller interface {
+	On(ctx context.Context) error
+	Off(ctx context.Context) error
+	// Cycle turns power off, waits, and turns it back on.
+	Cycle(ctx context.Context) error
+}
+
+// StateReader is implemented by controllers that can report whether power
+// is on, such as smart plugs.
+type StateReader interface {
+	State(ctx context.Context) (on bool, err error)
+}
+
+// cycle implements Controller.Cycle in terms of On and Off.
+func cycle(ctx context.Context, c Controller, offTime time.Duration) error {
+	if offTime <= 0 {
+		offTime = DefaultOffTime
+	}
+	if err := c.Off(ctx); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(offTime):
+	}
+	return c.On(ctx)
+}
+
+// Spec describes a power controller in configuration files. Which fields
+// apply depends on Type.
+type Spec struct {
+	// Type is one of "uhubctl", "ykush", "tasmota", "shelly" or "snmp".
+	Type string `json:"type"`
+	// OffTime is how long Cycle keeps power off, e.g. "3s".
+	OffTime string `json:"off_time,omitempty"`
+
+	// Host is the address of network-attached controllers.
+	Host     string `json:"host,omitempty"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// Location is the uhubctl hub location, e.g. "1-1.4".
+	Location string `json:"location,omitempty"`
+
+	// Serial selects a YKUSH board; Board is "ykush", "ykush3" or "ykushxs".
+	Serial string `json:"serial,omitempty"`
+	Board  string `json:"board,omitempty"`
+
+	// Gen2 selects the Shelly Gen2+ RPC API.
+	Gen2 bool `json:"gen2,omitempty"`
+
+	// Community, OID, OnValue and OffValue configure SNMP PDUs. The outlet
+	// number is appended to OID.
+	Community string `json:"community,omitempty"`
+	OID       string `json:"oid,omitempty"`
+	OnValue   int    `json:"on_value,omitempty"`
+	OffValue  int    `json:"off_value,omitempty"`
+}
+
+// New creates a controller for one outlet of the controller described by spec.
+func New(spec Spec, outlet string) (Controller, error) {
+	var offTime time.Duration
+	if spec.OffTime != "" {
+		d, err := time.ParseDuration(spec.OffTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid off_time: %w", err)
+		}
+		offTime = d
+	}
+	port, err := strconv.Atoi(outlet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid outlet %q: %w", outlet, err)
+	}
+
+	switch spec.Type {
+	case "uhubctl":
+		return &Uhubctl{Location: spec.Location, Port: port, OffTime: offTime}, nil
+	case "ykush":
+		return &YKUSH{Board: spec.Board, Serial: spec.Serial, Port: port, OffTime: offTime}, nil
+	case "tasmota":
+		return &Tasmota{Host: spec.Host, Relay: port, User: spec.User, Password: spec.Password, OffTime: offTime}, nil
+	case "shelly":
+		return &Shelly{Host: spec.Host, Relay: port, Gen2: spec.Gen2, User: spec.User, Password: spec.Password, OffTime: offTime}, nil
+	case "snmp":
+		return &SNMP{
+			Host:      spec.Host,
+			Community: spec.Community,
+			OID:       spec.OID + "." + outlet,
+			OnValue:   spec.OnValue,
+			OffValue:  spec.OffValue,
+			OffTime:   offTime,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown power controller type %q", spec.Type)
+	}
+}
+
+// run executes an external command, including its output in any error.
+func run(ctx context.Context, name string, args ...string) error {
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package power
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// SNMP switches a PDU outlet by setting an integer OID with the net-snmp
+// snmpset tool, which must be installed. The defaults match APC PDUs.
+type SNMP struct {
+	Host string
+	// Community is the SNMPv2c write community. Empty means "private".
+	Community string
+	// OID is the full outlet control OID, including the outlet number.
+	OID string
+	// OnValue and OffValue are the values written to OID. Zero values
+	// mean 1 and 2 respectively.
+	OnValue  int
+	OffValue int
+	OffTime  time.Duration
+}
+
+// On powers the outlet on.
+func (s *SNMP) On(ctx context.Context) error {
+	return s.set(ctx, s.OnValue, 1)
+}
+
+// Off powers the outlet off.
+func (s *SNMP) Off(ctx context.Context) error {
+	return s.set(ctx, s.OffValue, 2)
+}
+
+// Cycle powers the outlet off and on again.
+func (s *SNMP) Cycle(ctx context.Context) error {
+	return cycle(ctx, s, s.OffTime)
+}
+
+func (s *SNMP) set(ctx context.Context, value, fallback int) error {
+	if value == 0 {
+		value = fallback
+	}
+	community := s.Community
+	if community == "" {
+		community = "private"
+	}
+	return run(ctx, "snmpset", "-v2c", "-c", community, s.Host, s.OID, "i", strconv.Itoa(value))
+}
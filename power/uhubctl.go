@@ -0,0 +1,35 @@
+package power
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// Uhubctl switches a port of a USB hub with per-port power switching using
+// the uhubctl tool, which must be installed.
+type Uhubctl struct {
+	// Location is the hub location as reported by uhubctl, e.g. "1-1.4".
+	Location string
+	Port     int
+	OffTime  time.Duration
+}
+
+// On powers the port on.
+func (u *Uhubctl) On(ctx context.Context) error {
+	return u.action(ctx, "on")
+}
+
+// Off powers the port off.
+func (u *Uhubctl) Off(ctx context.Context) error {
+	return u.action(ctx, "off")
+}
+
+// Cycle powers the port off and on again.
+func (u *Uhubctl) Cycle(ctx context.Context) error {
+	return cycle(ctx, u, u.OffTime)
+}
+
+func (u *Uhubctl) action(ctx context.Context, action string) error {
+	return run(ctx, "uhubctl", "-l", u.Location, "-p", strconv.Itoa(u.Port), "-a", action)
+}
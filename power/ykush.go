@@ -0,0 +1,45 @@
+package power
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// YKUSH switches a downstream port of a Yepkit YKUSH board using the
+// ykushcmd tool, which must be installed.
+type YKUSH struct {
+	// Board is "ykush", "ykush3" or "ykushxs". Empty means "ykush".
+	Board string
+	// Serial selects a board when several are attached.
+	Serial  string
+	Port    int
+	OffTime time.Duration
+}
+
+// On powers the port on.
+func (y *YKUSH) On(ctx context.Context) error {
+	return y.action(ctx, "-u")
+}
+
+// Off powers the port off.
+func (y *YKUSH) Off(ctx context.Context) error {
+	return y.action(ctx, "-d")
+}
+
+// Cycle powers the port off and on again.
+func (y *YKUSH) Cycle(ctx context.Context) error {
+	return cycle(ctx, y, y.OffTime)
+}
+
+func (y *YKUSH) action(ctx context.Context, flag string) error {
+	var args []string
+	if y.Board != "" && y.Board != "ykush" {
+		args = append(args, y.Board)
+	}
+	if y.Serial != "" {
+		args = append(args, "-s", y.Serial)
+	}
+	args = append(args, flag, strconv.Itoa(y.Port))
+	return run(ctx, "ykushcmd", args...)
+}
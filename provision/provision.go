@@ -0,0 +1,165 @@
+// Package provision commissions new SDWireC boards in bulk: each blank unit
+// that is plugged in gets the next serial number, its EEPROM strings are
+// programmed, it is put through a switching self-test, and the outcome is
+// recorded.
+package provision
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/eeprom"
+)
+
+// Result is the outcome of provisioning one unit.
+type Result struct {
+	Time      time.Time
+	PortPath  string
+	OldSerial string
+	Serial    string
+	// Err is nil if the unit was programmed and passed its self-test.
+	Err error
+}
+
+// Provisioner programs blank units as they are plugged in.
+type Provisioner struct {
+	// Serials supplies the serial numbers to assign. It is required.
+	Serials SerialSource
+	// Strings are the manufacturer and product strings to program.
+	// Empty fields default to eeprom.DefaultStrings.
+	Strings eeprom.Strings
+	// IsBlank reports whether a unit needs provisioning. By default units
+	// without a serial number are provisioned.
+	IsBlank func(info *sdwire.DeviceInfo) bool
+	// SelfTest checks a unit after programming. By default it switches
+	// the card to the host and back to the target and probes the device.
+	SelfTest func(dev *sdwire.SDWire) error
+	// OnResult is called for every unit processed. It may be nil.
+	OnResult func(Result)
+	// Interval is the device polling interval. Zero means
+	// sdwire.DefaultWatchInterval.
+	Interval time.Duration
+}
+
+// Run provisions blank units as they arrive until ctx is done. Units
+// connected when Run starts are processed first.
+func (p *Provisioner) Run(ctx context.Context) error {
+	interval := p.Interval
+	if interval == 0 {
+		interval = sdwire.DefaultWatchInterval
+	}
+	events, err := sdwire.WatchInterval(ctx, interval)
+	if err != nil {
+		return err
+	}
+	for ev := range events {
+		if ev.Type != sdwire.DeviceArrived || ev.Info.Generation != sdwire.GenerationSDWireC {
+			continue
+		}
+		if !p.isBlank(ev.Info) {
+			continue
+		}
+		r := p.Provision(ev.Info.PortPath)
+		if p.OnResult != nil {
+			p.OnResult(r)
+		}
+	}
+	return ctx.Err()
+}
+
+// Provision programs the unit at portPath with the next serial number and
+// runs the self-test. The new strings take effect once the unit is
+// re-plugged.
+func (p *Provisioner) Provision(portPath string) Result {
+	r := Result{Time: time.Now().UTC(), PortPath: portPath}
+
+	dev, err := sdwire.NewWithPortPath(portPath, sdwire.WithBlockingLock())
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	defer dev.Close()
+	r.OldSerial = dev.GetSerial()
+
+	if !eeprom.Supported(dev) {
+		r.Err = fmt.Errorf("%s devices have no programmable EEPROM", dev.GetGeneration())
+		return r
+	}
+	if r.Serial, err = p.Serials.Next(); err != nil {
+		r.Err = err
+		return r
+	}
+
+	defer func() {
+		detail := fmt.Sprintf("%s -> %s", r.OldSerial, r.Serial)
+		dev.Audit("provision", detail, r.Err)
+	}()
+
+	s := eeprom.MergeStrings(eeprom.DefaultStrings, p.Strings)
+	s.Serial = r.Serial
+	if _, err := eeprom.WriteStrings(dev, s); err != nil {
+		r.Err = fmt.Errorf("failed to program EEPROM: %w", err)
+		return r
+	}
+
+	selfTest := p.SelfTest
+	if selfTest == nil {
+		selfTest = DefaultSelfTest
+	}
+	if err := selfTest(dev); err != nil {
+		r.Err = fmt.Errorf("self-test failed: %w", err)
+	}
+	return r
+}
+
+func (p *Provisioner) isBlank(info *sdwire.DeviceInfo) bool {
+	if p.IsBlank != nil {
+		return p.IsBlank(info)
+	}
+	return info.Serial == "" || info.Serial == "unknown"
+}
+
+// DefaultSelfTest switches the card to the host and back to the target,
+// then checks that the device still responds.
+func DefaultSelfTest(dev *sdwire.SDWire) error {
+	if err := dev.SetMode(sdwire.ModeHost); err != nil {
+		return err
+	}
+	if err := dev.SetMode(sdwire.ModeTarget); err != nil {
+		return err
+	}
+	return dev.Probe()
+}
+
+// ResultWriter records results as CSV rows.
+type ResultWriter struct {
+	mu     sync.Mutex
+	w      *csv.Writer
+	header bool
+}
+
+// NewResultWriter writes results to w, starting with a header row.
+func NewResultWriter(w io.Writer) *ResultWriter {
+	return &ResultWriter{w: csv.NewWriter(w)}
+}
+
+// Write records r. It can be used as Provisioner.OnResult.
+func (rw *ResultWriter) Write(r Result) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if !rw.header {
+		rw.w.Write([]string{"time", "port_path", "old_serial", "serial", "result", "error"})
+		rw.header = true
+	}
+	result, msg := "ok", ""
+	if r.Err != nil {
+		result, msg = "error", r.Err.Error()
+	}
+	rw.w.Write([]string{r.Time.Format(time.RFC3339), r.PortPath, r.OldSerial, r.Serial, result, msg})
+	rw.w.Flush()
+}
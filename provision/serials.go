@@ -0,0 +1,86 @@
+package provision
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ErrNoSerials is returned when a SerialSource is exhausted.
+var ErrNoSerials = errors.New("no serial numbers left to assign")
+
+// SerialSource hands out serial numbers. Implementations must be safe for
+// concurrent use.
+type SerialSource interface {
+	Next() (string, error)
+}
+
+// Template generates serial numbers by formatting an increasing counter,
+// e.g. Template("sdw-%04d", 1) yields sdw-0001, sdw-0002, ...
+func Template(format string, start int) SerialSource {
+	return &templateSource{format: format, next: start}
+}
+
+type templateSource struct {
+	mu     sync.Mutex
+	format string
+	next   int
+}
+
+func (t *templateSource) Next() (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	serial := fmt.Sprintf(t.format, t.next)
+	t.next++
+	return serial, nil
+}
+
+// List hands out the given serial numbers in order.
+func List(serials []string) SerialSource {
+	return &listSource{serials: serials}
+}
+
+type listSource struct {
+	mu      sync.Mutex
+	serials []string
+}
+
+func (l *listSource) Next() (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.serials) == 0 {
+		return "", ErrNoSerials
+	}
+	serial := l.serials[0]
+	l.serials = l.serials[1:]
+	return serial, nil
+}
+
+// CSV reads serial numbers from the first column of a CSV file. A first row
+// whose first column is "serial" is treated as a header; blank entries are
+// skipped.
+func CSV(r io.Reader) (SerialSource, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read serial list: %w", err)
+	}
+	var serials []string
+	for i, row := range rows {
+		if len(row) == 0 {
+			continue
+		}
+		serial := strings.TrimSpace(row[0])
+		if i == 0 && strings.EqualFold(serial, "serial") {
+			continue
+		}
+		if serial != "" {
+			serials = append(serials, serial)
+		}
+	}
+	return List(serials), nil
+}
@@ -0,0 +1,78 @@
+package sdwire
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Quirks describe deviations from genuine hardware behavior that SetMode
+// works around. They are detected by Diagnose or set with WithQuirks.
+type Quirks uint32
+
+const (
+	// QuirkResetBitmode marks FTDI clones that ignore a CBUS bitmode
+	// change unless the bitmode is reset first.
+	QuirkResetBitmode Quirks = 1 << iota
+	// QuirkNoEEPROM marks chips whose EEPROM cannot be read, so EEPROM
+	// programming is not possible.
+	QuirkNoEEPROM
+)
+
+// Has reports whether all quirks in q are set.
+func (q Quirks) Has(quirk Quirks) bool {
+	return q&quirk == quirk
+}
+
+// String lists the quirk names, e.g. "ResetBitmode|NoEEPROM".
+func (q Quirks) String() string {
+	var names []string
+	if q.Has(QuirkResetBitmode) {
+		names = append(names, "ResetBitmode")
+	}
+	if q.Has(QuirkNoEEPROM) {
+		names = append(names, "NoEEPROM")
+	}
+	if len(names) == 0 {
+		return "None"
+	}
+	return strings.Join(names, "|")
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (q Quirks) MarshalText() ([]byte, error) {
+	return []byte(q.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, parsing the names
+// String returns.
+func (q *Quirks) UnmarshalText(text []byte) error {
+	*q = 0
+	for _, name := range strings.Split(string(text), "|") {
+		switch strings.TrimSpace(name) {
+		case "", "None":
+		case "ResetBitmode":
+			*q |= QuirkResetBitmode
+		case "NoEEPROM":
+			*q |= QuirkNoEEPROM
+		default:
+			return fmt.Errorf("unknown quirk %q", name)
+		}
+	}
+	return nil
+}
+
+// GetQuirks returns the quirks SetMode currently works around.
+func (s *SDWire) GetQuirks() Quirks {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.quirks
+}
+
+// setQuirks records quirks and passes them to the controller.
+// Callers must hold s.mu.
+func (s *SDWire) setQuirks(q Quirks) {
+	s.quirks = q
+	if c, ok := s.controller.(*sdwireCController); ok {
+		c.quirks = q
+	}
+}
@@ -0,0 +1,420 @@
+package sdwire
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrReplayMismatch is returned by a replayed device when the SDK issues an
+// operation that differs from the recording.
+var ErrReplayMismatch = errors.New("USB operation does not match the recording")
+
+// fixture is the on-disk form of a recorded session.
+type fixture struct {
+	Serial     string       `json:"serial"`
+	Descriptor fixtureDesc  `json:"descriptor"`
+	Ops        []recordedOp `json:"ops"`
+}
+
+type fixtureDesc struct {
+	Bus     int    `json:"bus"`
+	Address int    `json:"address"`
+	Port    int    `json:"port"`
+	Path    []int  `json:"path"`
+	Vendor  uint16 `json:"vendor"`
+	Product uint16 `json:"product"`
+	Device  uint16 `json:"device"`
+}
+
+// recordedOp is one USB operation and its outcome. Fields that do not apply
+// to an operation are omitted.
+type recordedOp struct {
+	Op          string `json:"op"`
+	RequestType uint8  `json:"request_type,omitempty"`
+	Request     uint8  `json:"request,omitempty"`
+	Value       uint16 `json:"value,omitempty"`
+	Index       uint16 `json:"index,omitempty"`
+	// Args holds integer arguments, e.g. configuration and interface numbers.
+	Args []int `json:"args,omitempty"`
+	// Out is data sent to the device, In data received, both hex encoded.
+	Out    string `json:"out,omitempty"`
+	In     string `json:"in,omitempty"`
+	N      int    `json:"n,omitempty"`
+	Result string `json:"result,omitempty"`
+	// USBError is the libusb error code, so replayed errors classify the
+	// same way as the originals. Err holds the message of other errors.
+	USBError int    `json:"usb_error,omitempty"`
+	Err      string `json:"error,omitempty"`
+}
+
+func (op *recordedOp) setErr(err error) {
+	if err == nil {
+		return
+	}
+	if usbErr, ok := usbErrorOf(err); ok {
+		op.USBError = int(usbErr)
+		return
+	}
+	op.Err = err.Error()
+}
+
+func (op *recordedOp) err() error {
+	switch {
+	case op.USBError != 0:
+		return usbError(op.USBError)
+	case op.Err == errDriverUnknown.Error():
+		return errDriverUnknown
+	case op.Err != "":
+		return errors.New(op.Err)
+	}
+	return nil
+}
+
+// recordingDevice passes operations through to a real device and records
+// them. The fixture is written when the device is closed.
+type recordingDevice struct {
+	usbDevice
+	path string
+
+	mu sync.Mutex
+	fx fixture
+}
+
+func newRecordingDevice(dev usbDevice, serial, path string) *recordingDevice {
+	d := dev.Descriptor()
+	return &recordingDevice{
+		usbDevice: dev,
+		path:      path,
+		fx: fixture{
+			Serial: serial,
+			Descriptor: fixtureDesc{
+				Bus: d.Bus, Address: d.Address, Port: d.Port, Path: d.Path,
+				Vendor: d.Vendor, Product: d.Product, Device: d.Device,
+			},
+		},
+	}
+}
+
+func (r *recordingDevice) record(op recordedOp, err error) {
+	op.setErr(err)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fx.Ops = append(r.fx.Ops, op)
+}
+
+func (r *recordingDevice) Control(rType, request uint8, value, index uint16, data []byte) (int, error) {
+	op := recordedOp{Op: "control", RequestType: rType, Request: request, Value: value, Index: index}
+	if rType&controlIn == 0 {
+		op.Out = hex.EncodeToString(data)
+	}
+	n, err := r.usbDevice.Control(rType, request, value, index, data)
+	op.N = n
+	if rType&controlIn != 0 && n > 0 {
+		op.In = hex.EncodeToString(data[:n])
+	}
+	r.record(op, err)
+	return n, err
+}
+
+func (r *recordingDevice) Reset() error {
+	err := r.usbDevice.Reset()
+	r.record(recordedOp{Op: "reset"}, err)
+	return err
+}
+
+func (r *recordingDevice) SetAutoDetach(autodetach bool) error {
+	arg := 0
+	if autodetach {
+		arg = 1
+	}
+	err := r.usbDevice.SetAutoDetach(autodetach)
+	r.record(recordedOp{Op: "set_auto_detach", Args: []int{arg}}, err)
+	return err
+}
+
+func (r *recordingDevice) Config(cfgNum int) (usbConfig, error) {
+	cfg, err := r.usbDevice.Config(cfgNum)
+	r.record(recordedOp{Op: "config", Args: []int{cfgNum}}, err)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingConfig{usbConfig: cfg, rec: r}, nil
+}
+
+func (r *recordingDevice) InterfaceDriver(intf int) (string, error) {
+	return r.recordString("interface_driver", []int{intf}, func() (string, error) {
+		return r.usbDevice.InterfaceDriver(intf)
+	})
+}
+
+func (r *recordingDevice) SerialNumber() (string, error) {
+	return r.recordString("serial_number", nil, r.usbDevice.SerialNumber)
+}
+
+func (r *recordingDevice) Product() (string, error) {
+	return r.recordString("product", nil, r.usbDevice.Product)
+}
+
+func (r *recordingDevice) Manufacturer() (string, error) {
+	return r.recordString("manufacturer", nil, r.usbDevice.Manufacturer)
+}
+
+func (r *recordingDevice) ConfigDescription(cfgNum int) (string, error) {
+	return r.recordString("config_description", []int{cfgNum}, func() (string, error) {
+		return r.usbDevice.ConfigDescription(cfgNum)
+	})
+}
+
+func (r *recordingDevice) InterfaceDescription(cfgNum, intfNum, altNum int) (string, error) {
+	return r.recordString("interface_description", []int{cfgNum, intfNum, altNum}, func() (string, error) {
+		return r.usbDevice.InterfaceDescription(cfgNum, intfNum, altNum)
+	})
+}
+
+func (r *recordingDevice) recordString(name string, args []int, fn func() (string, error)) (string, error) {
+	s, err := fn()
+	r.record(recordedOp{Op: name, Args: args, Result: s}, err)
+	return s, err
+}
+
+// Close closes the device and writes the fixture.
+func (r *recordingDevice) Close() error {
+	err := r.usbDevice.Close()
+	r.record(recordedOp{Op: "close"}, err)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, merr := json.MarshalIndent(r.fx, "", "  ")
+	if merr != nil {
+		return merr
+	}
+	if werr := os.WriteFile(r.path, data, 0o644); werr != nil && err == nil {
+		err = fmt.Errorf("failed to write USB recording: %w", werr)
+	}
+	return err
+}
+
+type recordingConfig struct {
+	usbConfig
+	rec *recordingDevice
+}
+
+func (c *recordingConfig) Interface(num, alt int) (io.Closer, error) {
+	intf, err := c.usbConfig.Interface(num, alt)
+	c.rec.record(recordedOp{Op: "interface", Args: []int{num, alt}}, err)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingInterface{Closer: intf, rec: c.rec}, nil
+}
+
+func (c *recordingConfig) Close() error {
+	err := c.usbConfig.Close()
+	c.rec.record(recordedOp{Op: "config_close"}, err)
+	return err
+}
+
+type recordingInterface struct {
+	io.Closer
+	rec *recordingDevice
+}
+
+func (i *recordingInterface) Close() error {
+	err := i.Closer.Close()
+	i.rec.record(recordedOp{Op: "interface_close"}, err)
+	return err
+}
+
+// replayDevice serves operations from a recording, failing with
+// ErrReplayMismatch as soon as the SDK deviates from it.
+type replayDevice struct {
+	desc *deviceDesc
+
+	mu  sync.Mutex
+	ops []recordedOp
+	pos int
+}
+
+// next consumes the next recorded operation, checking that it matches want.
+func (r *replayDevice) next(want recordedOp) (recordedOp, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pos >= len(r.ops) {
+		return recordedOp{}, fmt.Errorf("%w: unexpected %s after the end of the recording", ErrReplayMismatch, want.Op)
+	}
+	got := r.ops[r.pos]
+	if got.Op != want.Op || got.RequestType != want.RequestType || got.Request != want.Request ||
+		got.Value != want.Value || got.Index != want.Index || got.Out != want.Out ||
+		fmt.Sprint(got.Args) != fmt.Sprint(want.Args) {
+		return recordedOp{}, fmt.Errorf("%w: operation %d is %+v, recorded %+v", ErrReplayMismatch, r.pos, want, got)
+	}
+	r.pos++
+	return got, nil
+}
+
+func (r *replayDevice) Descriptor() *deviceDesc {
+	return r.desc
+}
+
+func (r *replayDevice) Control(rType, request uint8, value, index uint16, data []byte) (int, error) {
+	want := recordedOp{Op: "control", RequestType: rType, Request: request, Value: value, Index: index}
+	if rType&controlIn == 0 {
+		want.Out = hex.EncodeToString(data)
+	}
+	op, err := r.next(want)
+	if err != nil {
+		return 0, err
+	}
+	if op.In != "" {
+		in, err := hex.DecodeString(op.In)
+		if err != nil {
+			return 0, fmt.Errorf("invalid recording: %w", err)
+		}
+		copy(data, in)
+	}
+	return op.N, op.err()
+}
+
+func (r *replayDevice) simple(want recordedOp) error {
+	op, err := r.next(want)
+	if err != nil {
+		return err
+	}
+	return op.err()
+}
+
+func (r *replayDevice) SetControlTimeout(time.Duration) {}
+
+func (r *replayDevice) Reset() error {
+	return r.simple(recordedOp{Op: "reset"})
+}
+
+func (r *replayDevice) SetAutoDetach(autodetach bool) error {
+	arg := 0
+	if autodetach {
+		arg = 1
+	}
+	return r.simple(recordedOp{Op: "set_auto_detach", Args: []int{arg}})
+}
+
+func (r *replayDevice) Config(cfgNum int) (usbConfig, error) {
+	if err := r.simple(recordedOp{Op: "config", Args: []int{cfgNum}}); err != nil {
+		return nil, err
+	}
+	return replayConfig{r}, nil
+}
+
+func (r *replayDevice) str(want recordedOp) (string, error) {
+	op, err := r.next(want)
+	if err != nil {
+		return "", err
+	}
+	return op.Result, op.err()
+}
+
+func (r *replayDevice) InterfaceDriver(intf int) (string, error) {
+	return r.str(recordedOp{Op: "interface_driver", Args: []int{intf}})
+}
+
+func (r *replayDevice) SerialNumber() (string, error) {
+	return r.str(recordedOp{Op: "serial_number"})
+}
+
+func (r *replayDevice) Product() (string, error) {
+	return r.str(recordedOp{Op: "product"})
+}
+
+func (r *replayDevice) Manufacturer() (string, error) {
+	return r.str(recordedOp{Op: "manufacturer"})
+}
+
+func (r *replayDevice) ConfigDescription(cfgNum int) (string, error) {
+	return r.str(recordedOp{Op: "config_description", Args: []int{cfgNum}})
+}
+
+func (r *replayDevice) InterfaceDescription(cfgNum, intfNum, altNum int) (string, error) {
+	return r.str(recordedOp{Op: "interface_description", Args: []int{cfgNum, intfNum, altNum}})
+}
+
+func (r *replayDevice) Close() error {
+	return r.simple(recordedOp{Op: "close"})
+}
+
+// remaining returns the number of recorded operations not yet replayed.
+func (r *replayDevice) remaining() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.ops) - r.pos
+}
+
+type replayConfig struct {
+	dev *replayDevice
+}
+
+func (c replayConfig) Interface(num, alt int) (io.Closer, error) {
+	if err := c.dev.simple(recordedOp{Op: "interface", Args: []int{num, alt}}); err != nil {
+		return nil, err
+	}
+	return replayInterface{c.dev}, nil
+}
+
+func (c replayConfig) Close() error {
+	return c.dev.simple(recordedOp{Op: "config_close"})
+}
+
+type replayInterface struct {
+	dev *replayDevice
+}
+
+func (i replayInterface) Close() error {
+	return i.dev.simple(recordedOp{Op: "interface_close"})
+}
+
+// OpenReplay opens a device that replays a session recorded with
+// WithRecording instead of talking to hardware. Operations must be issued
+// in the recorded order with the recorded arguments; any deviation fails
+// with ErrReplayMismatch. Locking is disabled for replayed devices.
+//
+// Use it in tests to lock in the exact transfer sequences of switching and
+// other operations.
+func OpenReplay(path string, opts ...Option) (*SDWire, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read USB recording: %w", err)
+	}
+	var fx fixture
+	if err := json.Unmarshal(data, &fx); err != nil {
+		return nil, fmt.Errorf("failed to parse USB recording: %w", err)
+	}
+	dev := &replayDevice{
+		desc: &deviceDesc{
+			Bus:     fx.Descriptor.Bus,
+			Address: fx.Descriptor.Address,
+			Port:    fx.Descriptor.Port,
+			Path:    fx.Descriptor.Path,
+			Vendor:  fx.Descriptor.Vendor,
+			Product: fx.Descriptor.Product,
+			Device:  fx.Descriptor.Device,
+		},
+		ops: fx.Ops,
+	}
+	o := newOptions(append(opts, WithoutLock()))
+	o.record = ""
+	return open(dev, fx.Serial, o)
+}
+
+// ReplayRemaining returns the number of recorded operations a device
+// opened with OpenReplay has not yet replayed, so tests can check that a
+// session ran to completion. It returns 0 for other devices.
+func (s *SDWire) ReplayRemaining() int {
+	if r, ok := s.device.(*replayDevice); ok {
+		return r.remaining()
+	}
+	return 0
+}
@@ -0,0 +1,120 @@
+package sdwire
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Identity describes the lab role of a device, as configured in a Registry.
+type Identity struct {
+	// Name is the lab name of the device, e.g. "rpi4-bench-03".
+	Name string `json:"name"`
+	// Model is the model of the DUT the device serves.
+	Model string `json:"model,omitempty"`
+	// Rack is the physical position of the device, e.g. "rack3/u12".
+	Rack string `json:"rack,omitempty"`
+	// Tags holds arbitrary site-specific metadata.
+	Tags map[string]string `json:"tags,omitempty"`
+	// Timeouts overrides the generation's default timeouts for the device.
+	Timeouts *Timeouts `json:"timeouts,omitempty"`
+	// Quirks are worked around in addition to those set with WithQuirks,
+	// e.g. "ResetBitmode" for a known clone.
+	Quirks Quirks `json:"quirks,omitempty"`
+}
+
+// RegistryEntry maps a device, identified by serial number or port path,
+// to its lab identity.
+type RegistryEntry struct {
+	Serial   string `json:"serial,omitempty"`
+	PortPath string `json:"port_path,omitempty"`
+	Identity
+}
+
+// Registry maps devices to lab identities.
+type Registry struct {
+	bySerial map[string]Identity
+	byPort   map[string]Identity
+}
+
+// NewRegistry creates a registry from the given entries. Every entry must
+// have a name and a serial number or port path.
+func NewRegistry(entries []RegistryEntry) (*Registry, error) {
+	r := &Registry{
+		bySerial: make(map[string]Identity),
+		byPort:   make(map[string]Identity),
+	}
+	for i, e := range entries {
+		if e.Name == "" {
+			return nil, fmt.Errorf("registry entry %d: missing name", i)
+		}
+		switch {
+		case e.Serial != "":
+			r.bySerial[e.Serial] = e.Identity
+		case e.PortPath != "":
+			r.byPort[e.PortPath] = e.Identity
+		default:
+			return nil, fmt.Errorf("registry entry %q: missing serial or port_path", e.Name)
+		}
+	}
+	return r, nil
+}
+
+// ParseRegistry reads a registry from JSON of the form:
+//
+//	{"devices": [{"serial": "sdw-0001", "name": "rpi4-bench-03", "model": "rpi4", "tags": {"rack": "3"}}]}
+func ParseRegistry(rd io.Reader) (*Registry, error) {
+	var doc struct {
+		Devices []RegistryEntry `json:"devices"`
+	}
+	if err := json.NewDecoder(rd).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse registry: %w", err)
+	}
+	return NewRegistry(doc.Devices)
+}
+
+// LoadRegistry reads a registry from a JSON file; see ParseRegistry.
+func LoadRegistry(path string) (*Registry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open registry: %w", err)
+	}
+	defer f.Close()
+	return ParseRegistry(f)
+}
+
+// Lookup returns the identity for a device. A serial number match takes
+// precedence over a port path match.
+func (r *Registry) Lookup(serial, portPath string) (Identity, bool) {
+	if r == nil {
+		return Identity{}, false
+	}
+	if id, ok := r.bySerial[serial]; ok {
+		return id, true
+	}
+	id, ok := r.byPort[portPath]
+	return id, ok
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   *Registry
+)
+
+// SetRegistry installs the registry used to fill in device identities.
+// Pass nil to remove it.
+func SetRegistry(r *Registry) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = r
+}
+
+// lookupIdentity resolves a device against the installed registry.
+func lookupIdentity(serial, portPath string) Identity {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	id, _ := registry.Lookup(serial, portPath)
+	return id
+}
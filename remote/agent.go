@@ -0,0 +1,283 @@
+package remote
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/fcjr/sdwire"
+)
+
+// The agent protocol is a line-oriented form of the API for hosts too small
+// or too far away for HTTP, such as routers next to the DUTs reached over
+// ssh. Each request is one JSON object on a line, answered by one JSON
+// object on a line:
+//
+//	{"op": "list"}                                     → {"devices": [...]}
+//	{"op": "get", "device": "ID"}                      → {"device": {...}}
+//	{"op": "status", "device": "ID"}                   → {"mode": "host"}
+//	{"op": "switch", "device": "ID", "mode": "target"} → {"mode": "target"}
+//	{"op": "probe", "device": "ID"}                    → {}
+//
+// Failures are answered with {"error": "...", "code": "NOT_FOUND"}.
+
+// DefaultAgentPort is the conventional TCP port of sdwire-agent.
+const DefaultAgentPort = "8422"
+
+type agentRequest struct {
+	Op     string `json:"op"`
+	Device string `json:"device,omitempty"`
+	Mode   string `json:"mode,omitempty"`
+}
+
+type agentResponse struct {
+	Devices []device `json:"devices,omitempty"`
+	Device  *device  `json:"device,omitempty"`
+	Mode    string   `json:"mode,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Code    string   `json:"code,omitempty"`
+}
+
+// ServeConn answers agent protocol requests read from rw until it reaches
+// end of file.
+func (s *Server) ServeConn(rw io.ReadWriter) error {
+	scanner := bufio.NewScanner(rw)
+	enc := json.NewEncoder(rw)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var (
+			req  agentRequest
+			resp *agentResponse
+		)
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			resp = agentError(sdwire.WithCode(sdwire.CodeInvalidArgument, err))
+		} else {
+			resp = s.handleAgent(req)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *Server) handleAgent(req agentRequest) *agentResponse {
+	switch req.Op {
+	case "list":
+		infos, err := s.Manager.ListDevices()
+		if err != nil {
+			return agentError(err)
+		}
+		devices := make([]device, 0, len(infos))
+		for _, info := range infos {
+			devices = append(devices, toWire(info))
+		}
+		return &agentResponse{Devices: devices}
+	case "get":
+		info, err := s.find(req.Device)
+		if err != nil {
+			return agentError(err)
+		}
+		d := toWire(info)
+		return &agentResponse{Device: &d}
+	case "status":
+		mode, err := s.getMode(context.Background(), req.Device)
+		if err != nil {
+			return agentError(err)
+		}
+		return &agentResponse{Mode: strings.ToLower(mode.String())}
+	case "switch":
+		mode, err := parseMode(req.Mode)
+		if err != nil {
+			return agentError(err)
+		}
+		if err := s.setMode(context.Background(), req.Device, mode); err != nil {
+			return agentError(err)
+		}
+		return &agentResponse{Mode: req.Mode}
+	case "probe":
+		if err := s.probe(context.Background(), req.Device); err != nil {
+			return agentError(err)
+		}
+		return &agentResponse{}
+	}
+	return agentError(sdwire.WithCode(sdwire.CodeInvalidArgument, fmt.Errorf("unknown op %q", req.Op)))
+}
+
+func agentError(err error) *agentResponse {
+	return &agentResponse{Error: err.Error(), Code: sdwire.CodeOf(err).String()}
+}
+
+// Controller is implemented by Client and AgentClient.
+type Controller interface {
+	sdwire.Manager
+	SetMode(ctx context.Context, id string, mode sdwire.SwitchMode) error
+	GetMode(ctx context.Context, id string) (sdwire.SwitchMode, error)
+	Probe(ctx context.Context, id string) error
+	io.Closer
+}
+
+var (
+	_ Controller = (*Client)(nil)
+	_ Controller = (*AgentClient)(nil)
+)
+
+// AgentClient talks the agent protocol to sdwire-agent. Requests are sent
+// one at a time.
+type AgentClient struct {
+	mu      sync.Mutex
+	conn    io.ReadWriteCloser
+	scanner *bufio.Scanner
+	wait    func() error
+}
+
+// NewAgentClient returns a client speaking the agent protocol over conn.
+func NewAgentClient(conn io.ReadWriteCloser) *AgentClient {
+	return &AgentClient{conn: conn, scanner: bufio.NewScanner(conn)}
+}
+
+// DialAgent connects to an agent listening on a TCP address. The port
+// defaults to DefaultAgentPort.
+func DialAgent(addr string) (*AgentClient, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, DefaultAgentPort)
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to agent: %w", err)
+	}
+	return NewAgentClient(conn), nil
+}
+
+// DialAgentCommand starts a command serving the agent protocol on its
+// standard input and output, such as "ssh router sdwire-agent".
+func DialAgentCommand(name string, args ...string) (*AgentClient, error) {
+	cmd := exec.Command(name, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start agent: %w", err)
+	}
+	c := NewAgentClient(commandConn{stdout, stdin})
+	c.wait = cmd.Wait
+	return c, nil
+}
+
+// commandConn joins a command's standard output and input.
+type commandConn struct {
+	io.ReadCloser
+	io.WriteCloser
+}
+
+// Close closes the command's input, which ends the agent.
+func (c commandConn) Close() error {
+	return c.WriteCloser.Close()
+}
+
+// Close ends the connection to the agent.
+func (c *AgentClient) Close() error {
+	err := c.conn.Close()
+	if c.wait != nil {
+		if werr := c.wait(); err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// ListDevices lists the devices of the agent's host.
+func (c *AgentClient) ListDevices() ([]*sdwire.DeviceInfo, error) {
+	resp, err := c.do(agentRequest{Op: "list"})
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*sdwire.DeviceInfo, len(resp.Devices))
+	for i, d := range resp.Devices {
+		infos[i] = fromWire(d)
+	}
+	return infos, nil
+}
+
+// Open returns a handle for the device with the given ID or serial number.
+// The options are ignored; the agent opens devices with its own.
+func (c *AgentClient) Open(id string, _ ...sdwire.Option) (sdwire.Device, error) {
+	resp, err := c.do(agentRequest{Op: "get", Device: id})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Device == nil {
+		return nil, errors.New("agent: missing device in response")
+	}
+	return &remoteDevice{client: c, info: fromWire(*resp.Device)}, nil
+}
+
+// SetMode switches the device with the given ID or serial number. The
+// agent protocol has no cancellation, so ctx is only checked before
+// sending.
+func (c *AgentClient) SetMode(ctx context.Context, id string, mode sdwire.SwitchMode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := c.do(agentRequest{Op: "switch", Device: id, Mode: strings.ToLower(mode.String())})
+	return err
+}
+
+// GetMode reads the mode of the device with the given ID or serial number.
+func (c *AgentClient) GetMode(ctx context.Context, id string) (sdwire.SwitchMode, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	resp, err := c.do(agentRequest{Op: "status", Device: id})
+	if err != nil {
+		return 0, err
+	}
+	return parseMode(resp.Mode)
+}
+
+// Probe checks that the device with the given ID or serial number responds.
+func (c *AgentClient) Probe(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := c.do(agentRequest{Op: "probe", Device: id})
+	return err
+}
+
+func (c *AgentClient) do(req agentRequest) (*agentResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := json.NewEncoder(c.conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("agent: %w", err)
+	}
+	if !c.scanner.Scan() {
+		err := c.scanner.Err()
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("agent: %w", err)
+	}
+	var resp agentResponse
+	if err := json.Unmarshal(c.scanner.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("agent: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, sdwire.WithCode(sdwire.ParseErrorCode(resp.Code), fmt.Errorf("agent: %s", resp.Error))
+	}
+	return &resp, nil
+}
@@ -0,0 +1,122 @@
+package remote_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/remote"
+)
+
+func TestServeConnProtocol(t *testing.T) {
+	s := remote.NewServer()
+	s.Manager = newManager()
+	requests := strings.Join([]string{
+		`{"op":"list"}`,
+		``,
+		`{"op":"get","device":"a"}`,
+		`{"op":"switch","device":"a","mode":"host"}`,
+		`{"op":"switch","device":"a","mode":"sideways"}`,
+		`{"op":"status","device":"a"}`,
+		`{"op":"probe","device":"z"}`,
+		`{"op":"reboot"}`,
+		`not json`,
+	}, "\n") + "\n"
+	var out strings.Builder
+	if err := s.ServeConn(struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader(requests), &out}); err != nil {
+		t.Fatalf("ServeConn: %v", err)
+	}
+
+	// Each response is checked for the keys it must have.
+	want := []map[string]string{
+		{"devices": ""},
+		{"device": ""},
+		{"mode": "host"},
+		{"code": "INVALID_ARGUMENT"},
+		{"code": "UNSUPPORTED"},
+		{"code": "NOT_FOUND"},
+		{"code": "INVALID_ARGUMENT"},
+		{"code": "INVALID_ARGUMENT"},
+	}
+	scanner := bufio.NewScanner(strings.NewReader(out.String()))
+	for i := 0; scanner.Scan(); i++ {
+		if i >= len(want) {
+			t.Fatalf("unexpected response %s", scanner.Text())
+		}
+		var resp map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("response %d: %v", i, err)
+		}
+		for key, value := range want[i] {
+			got, ok := resp[key]
+			if !ok || value != "" && got != value {
+				t.Errorf("response %d: %s, want %s %q", i, scanner.Text(), key, value)
+			}
+		}
+		if _, failed := want[i]["code"]; failed && resp["error"] == "" {
+			t.Errorf("response %d: %s has no error message", i, scanner.Text())
+		}
+	}
+}
+
+func TestAgentClientRoundTrip(t *testing.T) {
+	m := newManager()
+	s := remote.NewServer()
+	s.Manager = m
+	server, conn := net.Pipe()
+	served := make(chan error, 1)
+	go func() {
+		served <- s.ServeConn(server)
+		server.Close()
+	}()
+	c := remote.NewAgentClient(conn)
+	ctx := context.Background()
+
+	infos, err := c.ListDevices()
+	if err != nil {
+		t.Fatalf("ListDevices: %v", err)
+	}
+	if len(infos) != 2 || infos[0].ID != "a" || infos[0].Tags["board"] != "rpi4" {
+		t.Fatalf("ListDevices = %+v", infos)
+	}
+	dev, err := c.Open("a")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := dev.SetMode(sdwire.ModeHost); err != nil {
+		t.Fatalf("SetMode: %v", err)
+	}
+	if mode, ok := m.Device("a").Mode(); !ok || mode != sdwire.ModeHost {
+		t.Errorf("device mode %v, want host", mode)
+	}
+	if err := c.Probe(ctx, "b"); err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+
+	if _, err := c.Open("z"); sdwire.CodeOf(err) != sdwire.CodeNotFound {
+		t.Errorf("Open(z) = %v, want CodeNotFound", err)
+	}
+	if _, err := c.GetMode(ctx, "a"); sdwire.CodeOf(err) != sdwire.CodeUnsupported {
+		t.Errorf("GetMode = %v, want CodeUnsupported", err)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := c.SetMode(canceled, "a", sdwire.ModeTarget); err != context.Canceled {
+		t.Errorf("SetMode with a canceled context = %v, want context.Canceled", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("ServeConn after the client closed: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/fcjr/sdwire"
+)
+
+// Client talks to a remote Server. It implements sdwire.Manager, so code
+// written against a Manager works unchanged inside a container.
+type Client struct {
+	// BaseURL is the server address, e.g. "http://lab-host:8421". For
+	// Unix sockets, see DialUnix.
+	BaseURL string
+	// HTTP is used for requests; nil means http.DefaultClient.
+	HTTP *http.Client
+}
+
+var _ sdwire.Manager = (*Client)(nil)
+
+// DialUnix returns a client for a server listening on the Unix socket at
+// path.
+func DialUnix(path string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	return &Client{BaseURL: "http://sdwire", HTTP: &http.Client{Transport: transport}}
+}
+
+// Dial returns a client for addr: a Unix socket path, or an http:// URL.
+func Dial(addr string) *Client {
+	if strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://") {
+		return &Client{BaseURL: addr}
+	}
+	return DialUnix(addr)
+}
+
+// Close releases idle connections to the server.
+func (c *Client) Close() error {
+	if c.HTTP != nil {
+		c.HTTP.CloseIdleConnections()
+	}
+	return nil
+}
+
+// ListDevices lists the devices of the remote host.
+func (c *Client) ListDevices() ([]*sdwire.DeviceInfo, error) {
+	var devices []device
+	if err := c.do(context.Background(), http.MethodGet, "/v1/devices", nil, &devices); err != nil {
+		return nil, err
+	}
+	infos := make([]*sdwire.DeviceInfo, len(devices))
+	for i, d := range devices {
+		infos[i] = fromWire(d)
+	}
+	return infos, nil
+}
+
+// Open returns a handle for the remote device with the given ID or serial
+// number. The options are ignored; the server opens devices with its own.
+func (c *Client) Open(id string, _ ...sdwire.Option) (sdwire.Device, error) {
+	var d device
+	if err := c.do(context.Background(), http.MethodGet, devicePath(id, ""), nil, &d); err != nil {
+		return nil, err
+	}
+	return &remoteDevice{client: c, info: fromWire(d)}, nil
+}
+
+// SetMode switches the remote device with the given ID or serial number.
+func (c *Client) SetMode(ctx context.Context, id string, mode sdwire.SwitchMode) error {
+	body := modeBody{Mode: strings.ToLower(mode.String())}
+	return c.do(ctx, http.MethodPut, devicePath(id, "/mode"), body, nil)
+}
+
+// GetMode reads the mode of the remote device with the given ID or serial
+// number.
+func (c *Client) GetMode(ctx context.Context, id string) (sdwire.SwitchMode, error) {
+	var body modeBody
+	if err := c.do(ctx, http.MethodGet, devicePath(id, "/mode"), nil, &body); err != nil {
+		return 0, err
+	}
+	return parseMode(body.Mode)
+}
+
+// Probe checks that the remote device with the given ID or serial number
+// responds.
+func (c *Client) Probe(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPost, devicePath(id, "/probe"), nil, nil)
+}
+
+func devicePath(id, suffix string) string {
+	return "/v1/devices/" + url.PathEscape(id) + suffix
+}
+
+func fromWire(d device) *sdwire.DeviceInfo {
+	info := &sdwire.DeviceInfo{
+		ID:              d.ID,
+		Serial:          d.Serial,
+		Product:         d.Product,
+		PortPath:        d.PortPath,
+		FirmwareVersion: d.Firmware,
+		Identity:        sdwire.Identity{Name: d.Name, Tags: d.Tags},
+	}
+	if d.Generation == sdwire.GenerationSDWire3.String() {
+		info.Generation = sdwire.GenerationSDWire3
+	}
+	return info
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.BaseURL, "/")+path, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		var e errorResponse
+		json.NewDecoder(resp.Body).Decode(&e)
+		return sdwire.WithCode(sdwire.ParseErrorCode(e.Code), fmt.Errorf("remote: %s: %s", resp.Status, e.Error))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// remoteDevice is an sdwire.Device switched through a Client or
+// AgentClient.
+type remoteDevice struct {
+	client Controller
+	info   *sdwire.DeviceInfo
+}
+
+func (d *remoteDevice) SetMode(mode sdwire.SwitchMode) error {
+	return d.client.SetMode(context.Background(), d.info.ID, mode)
+}
+
+// GetMode reads the mode of the device.
+func (d *remoteDevice) GetMode() (sdwire.SwitchMode, error) {
+	return d.client.GetMode(context.Background(), d.info.ID)
+}
+
+func (d *remoteDevice) Probe() error {
+	return d.client.Probe(context.Background(), d.info.ID)
+}
+
+func (d *remoteDevice) GetSerial() string                      { return d.info.Serial }
+func (d *remoteDevice) GetPortPath() string                    { return d.info.PortPath }
+func (d *remoteDevice) GetGeneration() sdwire.DeviceGeneration { return d.info.Generation }
+func (d *remoteDevice) Close() error                           { return nil }
@@ -0,0 +1,26 @@
+package remote
+
+import "net"
+
+// ListenTCP listens on addr for Server or ServeConn. Neither protocol has
+// authentication or encryption, so an address without a host, such as
+// ":8422", listens on the loopback interface only. exposed reports whether
+// the listener accepts connections from other hosts; callers should warn
+// that such an address must be firewalled.
+func ListenTCP(addr string) (ln net.Listener, exposed bool, err error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, false, err
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	ln, err = net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, false, err
+	}
+	if tcp, ok := ln.Addr().(*net.TCPAddr); ok && !tcp.IP.IsLoopback() {
+		exposed = true
+	}
+	return ln, exposed, nil
+}
@@ -0,0 +1,28 @@
+package remote
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenTCPDefaultsToLoopback(t *testing.T) {
+	ln, exposed, err := ListenTCP(":0")
+	if err != nil {
+		t.Fatalf("ListenTCP: %v", err)
+	}
+	defer ln.Close()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	if !addr.IP.IsLoopback() {
+		t.Errorf("listening on %v, want a loopback address", addr)
+	}
+	if exposed {
+		t.Error("loopback listener reported as exposed")
+	}
+}
+
+func TestListenTCPInvalid(t *testing.T) {
+	if _, _, err := ListenTCP("8422"); err == nil {
+		t.Error("ListenTCP accepted an address without a port")
+	}
+}
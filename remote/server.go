@@ -0,0 +1,380 @@
+// Package remote lets processes without USB access, such as containers,
+// control the devices of the host they run on. A server on the host
+// exposes the devices over a small HTTP API, usually on a Unix socket
+// shared into the container, and Client implements sdwire.Manager on top
+// of it.
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcjr/sdwire"
+)
+
+// DefaultSocket is the conventional path of the server's Unix socket.
+const DefaultSocket = "/run/sdwire.sock"
+
+// device is the wire form of sdwire.DeviceInfo.
+type device struct {
+	ID         string            `json:"id"`
+	StableID   string            `json:"stable_id"`
+	Serial     string            `json:"serial"`
+	Name       string            `json:"name,omitempty"`
+	Product    string            `json:"product,omitempty"`
+	PortPath   string            `json:"port_path"`
+	Generation string            `json:"generation"`
+	Firmware   string            `json:"firmware,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+// modeBody carries a switch mode, "host" or "target".
+type modeBody struct {
+	Mode string `json:"mode"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+	// Code is the sdwire.ErrorCode name, e.g. "NOT_FOUND".
+	Code string `json:"code,omitempty"`
+}
+
+// modeReader is implemented by devices that can report their mode, such
+// as *sdwire.SDWire.
+type modeReader interface {
+	GetMode() (sdwire.SwitchMode, error)
+}
+
+// Server is an http.Handler serving the remote control API:
+//
+//	GET  /v1/devices             list devices
+//	GET  /v1/devices/{id}        describe a device
+//	GET  /v1/devices/{id}/mode   read the mode ({"mode": "host"})
+//	PUT  /v1/devices/{id}/mode   switch ({"mode": "target"})
+//	POST /v1/devices/{id}/probe  check the device responds
+//	GET  /healthz                liveness
+//
+// The {id} is a device ID, serial number or stable ID ("serial@port"), which
+// tells apart devices sharing a serial number.
+//
+// Devices are opened for each request and closed again, so other processes
+// on the host can use them in between. Requests for the same device are
+// served one at a time, in the order they arrive, rather than failing
+// while another request has it open.
+type Server struct {
+	// Manager discovers and opens devices. It defaults to sdwire.USB.
+	Manager sdwire.Manager
+	// Options are used when opening devices.
+	Options []sdwire.Option
+	// Debounce is the minimum interval between switches of a device, like
+	// sdwire.WithDebounce across requests, so that a buggy client flapping
+	// a mux cannot confuse the DUT or corrupt the card. A switch requested
+	// sooner waits for the interval to pass, and a repeat of the previous
+	// switch is answered without switching.
+	Debounce time.Duration
+	// State, if set, records the mode requested for each device, for
+	// Restore.
+	State *StateFile
+	// ErrorLog logs failures no client hears of, such as failing to save
+	// the State. If nil, the log package's standard logger is used.
+	ErrorLog *log.Logger
+
+	mux *http.ServeMux
+
+	mu      sync.Mutex
+	devices map[string]*deviceState
+}
+
+// deviceState serializes the requests for a device and remembers its last
+// switch.
+type deviceState struct {
+	// lock is held, by sending to it, while a request uses the device.
+	lock       chan struct{}
+	lastSwitch time.Time
+	lastMode   sdwire.SwitchMode
+}
+
+// NewServer creates a server for the devices attached to this host.
+func NewServer() *Server {
+	s := &Server{
+		Manager: sdwire.USB,
+		mux:     http.NewServeMux(),
+		devices: make(map[string]*deviceState),
+	}
+	s.mux.HandleFunc("GET /v1/devices", s.handleList)
+	s.mux.HandleFunc("GET /v1/devices/{id}", s.handleGet)
+	s.mux.HandleFunc("GET /v1/devices/{id}/mode", s.handleGetMode)
+	s.mux.HandleFunc("PUT /v1/devices/{id}/mode", s.handleSetMode)
+	s.mux.HandleFunc("POST /v1/devices/{id}/probe", s.handleProbe)
+	s.mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	infos, err := s.Manager.ListDevices()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	devices := make([]device, 0, len(infos))
+	for _, info := range infos {
+		devices = append(devices, toWire(info))
+	}
+	writeJSON(w, http.StatusOK, devices)
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	info, err := s.find(r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toWire(info))
+}
+
+func (s *Server) handleGetMode(w http.ResponseWriter, r *http.Request) {
+	mode, err := s.getMode(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, modeBody{Mode: strings.ToLower(mode.String())})
+}
+
+func (s *Server) handleSetMode(w http.ResponseWriter, r *http.Request) {
+	var body modeBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, sdwire.WithCode(sdwire.CodeInvalidArgument, err))
+		return
+	}
+	mode, err := parseMode(body.Mode)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.setMode(r.Context(), r.PathValue("id"), mode); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, modeBody{Mode: body.Mode})
+}
+
+func (s *Server) handleProbe(w http.ResponseWriter, r *http.Request) {
+	if err := s.probe(r.Context(), r.PathValue("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getMode reads the mode of a device.
+func (s *Server) getMode(ctx context.Context, id string) (sdwire.SwitchMode, error) {
+	var mode sdwire.SwitchMode
+	err := s.withDevice(ctx, id, func(dev sdwire.Device) error {
+		reader, ok := dev.(modeReader)
+		if !ok {
+			return sdwire.WithCode(sdwire.CodeUnsupported, errors.New("the device cannot report its mode"))
+		}
+		var err error
+		mode, err = reader.GetMode()
+		return err
+	})
+	return mode, err
+}
+
+// setMode switches a device, waiting out the Debounce interval.
+func (s *Server) setMode(ctx context.Context, id string, mode sdwire.SwitchMode) error {
+	info, state, release, err := s.acquire(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if s.Debounce > 0 && !state.lastSwitch.IsZero() {
+		if wait := s.Debounce - time.Since(state.lastSwitch); wait > 0 {
+			if mode == state.lastMode {
+				s.record(info, mode)
+				return nil
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+	dev, err := s.openInfo(info)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+	if err := dev.SetMode(mode); err != nil {
+		return err
+	}
+	state.lastSwitch, state.lastMode = time.Now(), mode
+	s.record(info, mode)
+	return nil
+}
+
+// record saves the mode requested for a device to the State, if any. The
+// switch has been made by then, so a failure is only logged.
+func (s *Server) record(info *sdwire.DeviceInfo, mode sdwire.SwitchMode) {
+	if s.State == nil {
+		return
+	}
+	if err := s.State.Set(info.StableID(), mode); err != nil {
+		s.logf("%s: %v", info.StableID(), err)
+	}
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// probe checks that a device responds.
+func (s *Server) probe(ctx context.Context, id string) error {
+	return s.withDevice(ctx, id, func(dev sdwire.Device) error {
+		return dev.Probe()
+	})
+}
+
+// withDevice runs fn with a device opened for the request.
+func (s *Server) withDevice(ctx context.Context, id string, fn func(sdwire.Device) error) error {
+	info, _, release, err := s.acquire(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer release()
+	dev, err := s.openInfo(info)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+	return fn(dev)
+}
+
+// acquire finds a device and waits until no other request uses it. Call
+// release once done with it.
+func (s *Server) acquire(ctx context.Context, id string) (info *sdwire.DeviceInfo, state *deviceState, release func(), err error) {
+	info, err = s.find(id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	s.mu.Lock()
+	state, ok := s.devices[info.StableID()]
+	if !ok {
+		state = &deviceState{lock: make(chan struct{}, 1)}
+		s.devices[info.StableID()] = state
+	}
+	s.mu.Unlock()
+	select {
+	case state.lock <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, nil, ctx.Err()
+	}
+	return info, state, func() { <-state.lock }, nil
+}
+
+// find looks up a device by ID, stable ID or serial number; see
+// sdwire.DeviceID. A serial number shared by several devices fails with a
+// *sdwire.MultipleMatchesError.
+func (s *Server) find(id string) (*sdwire.DeviceInfo, error) {
+	infos, err := s.Manager.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	var bySerial []*sdwire.DeviceInfo
+	for _, info := range infos {
+		if info.ID == id || info.StableID() == id {
+			return info, nil
+		}
+		if info.Serial == id {
+			bySerial = append(bySerial, info)
+		}
+	}
+	switch len(bySerial) {
+	case 0:
+		return nil, sdwire.WithCode(sdwire.CodeNotFound, errors.New("device "+id+" not found"))
+	case 1:
+		return bySerial[0], nil
+	}
+	merr := &sdwire.MultipleMatchesError{Serial: id}
+	for _, info := range bySerial {
+		merr.PortPaths = append(merr.PortPaths, info.PortPath)
+	}
+	return nil, merr
+}
+
+func (s *Server) openInfo(info *sdwire.DeviceInfo) (sdwire.Device, error) {
+	if info.DuplicateSerial {
+		return s.Manager.Open(sdwire.PortIDPrefix+info.PortPath, s.Options...)
+	}
+	return s.Manager.Open(info.ID, s.Options...)
+}
+
+func toWire(info *sdwire.DeviceInfo) device {
+	return device{
+		ID:         info.ID,
+		StableID:   info.StableID(),
+		Serial:     info.Serial,
+		Name:       info.Name,
+		Product:    info.Product,
+		PortPath:   info.PortPath,
+		Generation: info.Generation.String(),
+		Firmware:   info.FirmwareVersion,
+		Tags:       info.Tags,
+	}
+}
+
+func parseMode(s string) (sdwire.SwitchMode, error) {
+	switch s {
+	case "host":
+		return sdwire.ModeHost, nil
+	case "target":
+		return sdwire.ModeTarget, nil
+	}
+	return 0, sdwire.WithCode(sdwire.CodeInvalidArgument, errors.New("unknown mode "+s+", expected host or target"))
+}
+
+// statusCodes maps error codes to HTTP statuses; others are 500.
+var statusCodes = map[sdwire.ErrorCode]int{
+	sdwire.CodeNotFound:        http.StatusNotFound,
+	sdwire.CodeBusy:            http.StatusConflict,
+	sdwire.CodeInvalidArgument: http.StatusBadRequest,
+	sdwire.CodePermission:      http.StatusForbidden,
+	sdwire.CodeUnsupported:     http.StatusNotImplemented,
+	sdwire.CodeAmbiguous:       http.StatusConflict,
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := sdwire.CodeOf(err)
+	status, ok := statusCodes[code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error(), Code: code.String()})
+}
@@ -0,0 +1,283 @@
+package remote_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/remote"
+	"github.com/fcjr/sdwire/sdwiretest"
+)
+
+// newManager returns a fake manager with devices a and b.
+func newManager() *sdwiretest.Manager {
+	m := sdwiretest.NewManager()
+	m.Add(sdwire.DeviceInfo{ID: "a", Serial: "a", Identity: sdwire.Identity{Tags: map[string]string{"board": "rpi4"}}}, 0)
+	m.Add(sdwire.DeviceInfo{ID: "b", Serial: "b"}, 0)
+	return m
+}
+
+// sharedSerial reports both of its devices with serial "shared".
+type sharedSerial struct {
+	*sdwiretest.Manager
+}
+
+func (m sharedSerial) ListDevices() ([]*sdwire.DeviceInfo, error) {
+	infos, err := m.Manager.ListDevices()
+	for _, info := range infos {
+		info.Serial = "shared"
+	}
+	return infos, err
+}
+
+func newServer(m sdwire.Manager) *httptest.Server {
+	s := remote.NewServer()
+	s.Manager = m
+	return httptest.NewServer(s)
+}
+
+func TestServerStatusCodes(t *testing.T) {
+	tests := []struct {
+		name         string
+		setup        func(m *sdwiretest.Manager)
+		method, path string
+		body         string
+		status       int
+		// code is the error code of a failed request.
+		code string
+	}{
+		{name: "health", method: "GET", path: "/healthz", status: http.StatusNoContent},
+		{name: "list", method: "GET", path: "/v1/devices", status: http.StatusOK},
+		{name: "get", method: "GET", path: "/v1/devices/a", status: http.StatusOK},
+		{name: "get unknown", method: "GET", path: "/v1/devices/z", status: http.StatusNotFound, code: "NOT_FOUND"},
+		{name: "switch", method: "PUT", path: "/v1/devices/a/mode", body: `{"mode":"target"}`, status: http.StatusOK},
+		{name: "switch bad mode", method: "PUT", path: "/v1/devices/a/mode", body: `{"mode":"sideways"}`, status: http.StatusBadRequest, code: "INVALID_ARGUMENT"},
+		{name: "switch bad body", method: "PUT", path: "/v1/devices/a/mode", body: `{`, status: http.StatusBadRequest, code: "INVALID_ARGUMENT"},
+		{name: "switch unknown", method: "PUT", path: "/v1/devices/z/mode", body: `{"mode":"host"}`, status: http.StatusNotFound, code: "NOT_FOUND"},
+		{
+			name:   "switch busy",
+			setup:  func(m *sdwiretest.Manager) { m.Open("a") },
+			method: "PUT", path: "/v1/devices/a/mode", body: `{"mode":"host"}`,
+			status: http.StatusConflict, code: "BUSY",
+		},
+		{
+			name: "switch denied",
+			setup: func(m *sdwiretest.Manager) {
+				m.Device("a").FailNext(sdwire.WithCode(sdwire.CodePermission, errors.New("denied")))
+			},
+			method: "PUT", path: "/v1/devices/a/mode", body: `{"mode":"host"}`,
+			status: http.StatusForbidden, code: "PERMISSION",
+		},
+		{name: "mode unsupported", method: "GET", path: "/v1/devices/a/mode", status: http.StatusNotImplemented, code: "UNSUPPORTED"},
+		{name: "probe", method: "POST", path: "/v1/devices/a/probe", status: http.StatusNoContent},
+		{
+			name:   "probe failure",
+			setup:  func(m *sdwiretest.Manager) { m.Device("a").FailNext(nil, errors.New("no response")) },
+			method: "POST", path: "/v1/devices/a/probe",
+			status: http.StatusInternalServerError, code: "UNKNOWN",
+		},
+		{
+			name:   "list failure",
+			setup:  func(m *sdwiretest.Manager) { m.FailList(errors.New("usb gone")) },
+			method: "GET", path: "/v1/devices",
+			status: http.StatusInternalServerError, code: "UNKNOWN",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newManager()
+			if tt.setup != nil {
+				tt.setup(m)
+			}
+			ts := newServer(m)
+			defer ts.Close()
+
+			req, err := http.NewRequest(tt.method, ts.URL+tt.path, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.code == "" {
+				return
+			}
+			var body struct{ Error, Code string }
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("decoding error body: %v", err)
+			}
+			if body.Code != tt.code || body.Error == "" {
+				t.Errorf("error body %+v, want code %s and a message", body, tt.code)
+			}
+		})
+	}
+}
+
+func TestServerSharedSerial(t *testing.T) {
+	ts := newServer(sharedSerial{newManager()})
+	defer ts.Close()
+	c := &remote.Client{BaseURL: ts.URL}
+
+	_, err := c.Open("shared")
+	if sdwire.CodeOf(err) != sdwire.CodeAmbiguous {
+		t.Fatalf("Open(shared) = %v, want CodeAmbiguous", err)
+	}
+	if _, err := c.Open("a"); err != nil {
+		t.Fatalf("Open by ID: %v", err)
+	}
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	m := newManager()
+	ts := newServer(m)
+	defer ts.Close()
+	c := &remote.Client{BaseURL: ts.URL + "/"}
+	defer c.Close()
+	ctx := context.Background()
+
+	infos, err := c.ListDevices()
+	if err != nil {
+		t.Fatalf("ListDevices: %v", err)
+	}
+	if len(infos) != 2 || infos[0].ID != "a" || infos[0].Tags["board"] != "rpi4" || infos[1].ID != "b" {
+		t.Fatalf("ListDevices = %+v", infos)
+	}
+
+	dev, err := c.Open("a")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if dev.GetSerial() != "a" || dev.GetPortPath() != infos[0].PortPath {
+		t.Errorf("opened %s at %s, want a at %s", dev.GetSerial(), dev.GetPortPath(), infos[0].PortPath)
+	}
+	if err := dev.SetMode(sdwire.ModeTarget); err != nil {
+		t.Fatalf("SetMode: %v", err)
+	}
+	if mode, ok := m.Device("a").Mode(); !ok || mode != sdwire.ModeTarget {
+		t.Errorf("device mode %v, want target", mode)
+	}
+	if m.Device("a").IsOpen() {
+		t.Error("server left the device open")
+	}
+	if err := c.Probe(ctx, "b"); err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+
+	if _, err := c.Open("z"); sdwire.CodeOf(err) != sdwire.CodeNotFound {
+		t.Errorf("Open(z) = %v, want CodeNotFound", err)
+	}
+	if _, err := c.GetMode(ctx, "a"); sdwire.CodeOf(err) != sdwire.CodeUnsupported {
+		t.Errorf("GetMode = %v, want CodeUnsupported", err)
+	}
+	if err := c.SetMode(ctx, "a", sdwire.SwitchMode(9)); sdwire.CodeOf(err) != sdwire.CodeInvalidArgument {
+		t.Errorf("SetMode(9) = %v, want CodeInvalidArgument", err)
+	}
+}
+
+// slowSwitches makes switches of its devices take a while, so that
+// concurrent requests overlap.
+type slowSwitches struct {
+	*sdwiretest.Manager
+}
+
+type slowDevice struct {
+	sdwire.Device
+}
+
+func (m slowSwitches) Open(id string, opts ...sdwire.Option) (sdwire.Device, error) {
+	dev, err := m.Manager.Open(id, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return slowDevice{dev}, nil
+}
+
+func (d slowDevice) SetMode(mode sdwire.SwitchMode) error {
+	time.Sleep(2 * time.Millisecond)
+	return d.Device.SetMode(mode)
+}
+
+// TestServerSerializes checks that concurrent requests for a device wait
+// for each other instead of failing on the device lock.
+func TestServerSerializes(t *testing.T) {
+	m := newManager()
+	ts := newServer(slowSwitches{m})
+	defer ts.Close()
+	c := &remote.Client{BaseURL: ts.URL}
+
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- c.SetMode(context.Background(), "a", sdwire.SwitchMode(i%2))
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("SetMode: %v", err)
+		}
+	}
+	if got := len(m.Device("a").Switches()); got != n {
+		t.Errorf("%d switches, want %d", got, n)
+	}
+}
+
+func TestServerDebounce(t *testing.T) {
+	const window = 100 * time.Millisecond
+	m := newManager()
+	s := remote.NewServer()
+	s.Manager = m
+	s.Debounce = window
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	c := &remote.Client{BaseURL: ts.URL}
+	ctx := context.Background()
+
+	start := time.Now()
+	for _, mode := range []sdwire.SwitchMode{sdwire.ModeTarget, sdwire.ModeTarget} {
+		if err := c.SetMode(ctx, "a", mode); err != nil {
+			t.Fatalf("SetMode(%v): %v", mode, err)
+		}
+	}
+	if err := c.SetMode(ctx, "b", sdwire.ModeHost); err != nil {
+		t.Fatalf("SetMode on another device: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= window {
+		t.Errorf("repeated switch and another device took %v, want no wait", elapsed)
+	}
+
+	short, cancel := context.WithTimeout(ctx, window/10)
+	err := c.SetMode(short, "a", sdwire.ModeHost)
+	cancel()
+	if err == nil {
+		t.Fatal("SetMode within the window returned before it passed")
+	}
+
+	if err := c.SetMode(ctx, "a", sdwire.ModeHost); err != nil {
+		t.Fatalf("SetMode(host): %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < window {
+		t.Errorf("opposite switch after %v, want it to wait for the %v window", elapsed, window)
+	}
+	if got, want := m.Device("a").Switches(), []sdwire.SwitchMode{sdwire.ModeTarget, sdwire.ModeHost}; !slices.Equal(got, want) {
+		t.Errorf("switches %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,142 @@
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcjr/sdwire"
+)
+
+// desiredMode is the mode last requested for a device.
+type desiredMode struct {
+	Mode string    `json:"mode"`
+	Time time.Time `json:"time"`
+}
+
+// StateFile keeps the mode last requested for each device in a JSON file,
+// keyed by stable ID, so that a server restarted after a host reboot or a
+// hub power cycle can switch the devices back; see Server.Restore.
+type StateFile struct {
+	path string
+
+	mu    sync.Mutex
+	modes map[string]desiredMode
+}
+
+// LoadStateFile reads the state file at path. A missing file is empty.
+func LoadStateFile(path string) (*StateFile, error) {
+	f := &StateFile{path: path, modes: make(map[string]desiredMode)}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return f, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	if err := json.Unmarshal(data, &f.modes); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	return f, nil
+}
+
+// Mode returns the mode last requested for the device with the given
+// stable ID.
+func (f *StateFile) Mode(stableID string) (sdwire.SwitchMode, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d, ok := f.modes[stableID]
+	if !ok {
+		return 0, false
+	}
+	mode, err := parseMode(d.Mode)
+	return mode, err == nil
+}
+
+// Set records the mode requested for a device and saves the file.
+func (f *StateFile) Set(stableID string, mode sdwire.SwitchMode) error {
+	name := strings.ToLower(mode.String())
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.modes[stableID].Mode == name {
+		return nil
+	}
+	f.modes[stableID] = desiredMode{Mode: name, Time: time.Now()}
+	return f.save()
+}
+
+// save replaces the file, so that a crash never leaves a partial write.
+func (f *StateFile) save() error {
+	data, err := json.MarshalIndent(f.modes, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// Restore switches devices back to the mode last requested for them in
+// the State file whenever they appear, polling the Manager every interval
+// until ctx is done. Devices present when it starts count as appearing,
+// so a restarted server re-asserts every mode; devices that already report
+// the requested mode are left alone. A device that fails to switch is
+// retried on the next poll.
+func (s *Server) Restore(ctx context.Context, interval time.Duration) error {
+	if s.State == nil {
+		return errors.New("no state file to restore from")
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	present := make(map[string]bool)
+	for {
+		// A failed listing is retried rather than taken as removals.
+		if infos, err := s.Manager.ListDevices(); err == nil {
+			current := make(map[string]bool, len(infos))
+			for _, info := range infos {
+				id := info.StableID()
+				current[id] = true
+				mode, ok := s.State.Mode(id)
+				if present[id] || !ok {
+					continue
+				}
+				if err := s.restore(ctx, id, mode); err != nil {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					s.logf("failed to restore %s to %s: %v", id, strings.ToLower(mode.String()), err)
+					delete(current, id)
+				}
+			}
+			present = current
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// restore switches a device to mode unless it reports being in it.
+func (s *Server) restore(ctx context.Context, id string, mode sdwire.SwitchMode) error {
+	if current, err := s.getMode(ctx, id); err == nil && current == mode {
+		return nil
+	}
+	return s.setMode(ctx, id, mode)
+}
@@ -0,0 +1,108 @@
+package remote_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/remote"
+	"github.com/fcjr/sdwire/sdwiretest"
+)
+
+func TestStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "modes.json")
+	f, err := remote.LoadStateFile(path)
+	if err != nil {
+		t.Fatalf("LoadStateFile of a missing file: %v", err)
+	}
+	if _, ok := f.Mode("a@1-1"); ok {
+		t.Error("empty state file has a mode")
+	}
+	if err := f.Set("a@1-1", sdwire.ModeTarget); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	f, err = remote.LoadStateFile(path)
+	if err != nil {
+		t.Fatalf("LoadStateFile: %v", err)
+	}
+	if mode, ok := f.Mode("a@1-1"); !ok || mode != sdwire.ModeTarget {
+		t.Errorf("reloaded mode %v, %t, want target", mode, ok)
+	}
+
+	os.WriteFile(path, []byte("{"), 0o644)
+	if _, err := remote.LoadStateFile(path); err == nil {
+		t.Error("LoadStateFile accepted a corrupt file")
+	}
+}
+
+// waitForMode polls until the device with the given serial is in mode.
+func waitForMode(t *testing.T, m *sdwiretest.Manager, serial string, want sdwire.SwitchMode) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if d := m.Device(serial); d != nil {
+			if mode, ok := d.Mode(); ok && mode == want {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s not switched to %v", serial, want)
+		}
+	}
+}
+
+func TestServerRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "modes.json")
+	newStateServer := func(m sdwire.Manager) *remote.Server {
+		state, err := remote.LoadStateFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := remote.NewServer()
+		s.Manager = m
+		s.State = state
+		s.ErrorLog = log.New(io.Discard, "", 0)
+		return s
+	}
+
+	ts := httptest.NewServer(newStateServer(newManager()))
+	c := &remote.Client{BaseURL: ts.URL}
+	if err := c.SetMode(context.Background(), "a", sdwire.ModeTarget); err != nil {
+		t.Fatalf("SetMode: %v", err)
+	}
+	ts.Close()
+
+	// After a reboot the devices come up in their default mode, and the
+	// first attempt to restore a fails.
+	m := newManager()
+	m.Device("a").FailNext(errors.New("busy"), errors.New("busy"))
+	s := newStateServer(m)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Restore(ctx, 10*time.Millisecond) }()
+	waitForMode(t, m, "a", sdwire.ModeTarget)
+	if _, ok := m.Device("b").Mode(); ok {
+		t.Error("b was switched without a requested mode")
+	}
+
+	// Plugged in again after a hub power cycle.
+	m.Remove("a")
+	time.Sleep(30 * time.Millisecond)
+	m.Add(sdwire.DeviceInfo{ID: "a", Serial: "a", PortPath: "1-1"}, 0)
+	waitForMode(t, m, "a", sdwire.ModeTarget)
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Restore = %v, want context.Canceled", err)
+	}
+	if got := len(m.Device("a").Switches()); got != 1 {
+		t.Errorf("replugged device switched %d times, want once", got)
+	}
+}
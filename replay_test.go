@@ -0,0 +1,81 @@
+package sdwire_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fcjr/sdwire"
+)
+
+// testdata/sdwirec-switch.json records opening an SDWireC, switching to the
+// host and back, reading the mode after each switch, and closing it.
+
+func TestReplaySwitch(t *testing.T) {
+	s, err := sdwire.OpenReplay("testdata/sdwirec-switch.json")
+	if err != nil {
+		t.Fatalf("OpenReplay: %v", err)
+	}
+	if got := s.GetGeneration(); got != sdwire.GenerationSDWireC {
+		t.Errorf("generation = %v, want %v", got, sdwire.GenerationSDWireC)
+	}
+
+	for _, mode := range []sdwire.SwitchMode{sdwire.ModeHost, sdwire.ModeTarget} {
+		if err := s.SetMode(mode); err != nil {
+			t.Fatalf("SetMode(%v): %v", mode, err)
+		}
+		got, err := s.GetMode()
+		if err != nil {
+			t.Fatalf("GetMode: %v", err)
+		}
+		if got != mode {
+			t.Errorf("GetMode = %v, want %v", got, mode)
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if n := s.ReplayRemaining(); n != 0 {
+		t.Errorf("%d recorded operations not replayed", n)
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	s, err := sdwire.OpenReplay("testdata/sdwirec-switch.json")
+	if err != nil {
+		t.Fatalf("OpenReplay: %v", err)
+	}
+	defer s.Close()
+
+	// The recording switches to the host first.
+	err = s.SetMode(sdwire.ModeTarget)
+	if !errors.Is(err, sdwire.ErrReplayMismatch) {
+		t.Fatalf("SetMode(ModeTarget) = %v, want ErrReplayMismatch", err)
+	}
+}
+
+func TestReplayDoubleClose(t *testing.T) {
+	s, err := sdwire.OpenReplay("testdata/sdwirec-switch.json")
+	if err != nil {
+		t.Fatalf("OpenReplay: %v", err)
+	}
+	for _, mode := range []sdwire.SwitchMode{sdwire.ModeHost, sdwire.ModeTarget} {
+		if err := s.SetMode(mode); err != nil {
+			t.Fatalf("SetMode(%v): %v", mode, err)
+		}
+		if _, err := s.GetMode(); err != nil {
+			t.Fatalf("GetMode: %v", err)
+		}
+	}
+
+	// The recording closes the device once; a second Close must not
+	// reach it.
+	for i := 0; i < 2; i++ {
+		if err := s.Close(); err != nil {
+			t.Fatalf("Close #%d: %v", i+1, err)
+		}
+	}
+	if err := s.SetMode(sdwire.ModeHost); !errors.Is(err, sdwire.ErrClosed) {
+		t.Errorf("SetMode after Close = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,188 @@
+// Package rig switches the muxes of a DUT rig together, such as an SDWire
+// and a USB mux routing the DUT's OTG port, so that the card and the
+// flashing port always face the same side.
+package rig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/fcjr/sdwire"
+)
+
+// Mux is a switch between the host and the target. Implement it to add
+// third-party muxes to a rig.
+type Mux interface {
+	SetMode(ctx context.Context, mode sdwire.SwitchMode) error
+}
+
+// SDMux adapts an open SDWire, or any sdwire.Device, to Mux.
+func SDMux(dev sdwire.Device) Mux {
+	return sdMux{dev}
+}
+
+type sdMux struct {
+	dev sdwire.Device
+}
+
+func (m sdMux) SetMode(ctx context.Context, mode sdwire.SwitchMode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.dev.SetMode(mode)
+}
+
+// CommandMux switches a mux by running a command, e.g. the vendor tool of
+// a USB mux.
+type CommandMux struct {
+	// Host and Target are the commands switching to each side.
+	Host   []string
+	Target []string
+}
+
+// SetMode runs the command for mode.
+func (m *CommandMux) SetMode(ctx context.Context, mode sdwire.SwitchMode) error {
+	command := m.Host
+	if mode == sdwire.ModeTarget {
+		command = m.Target
+	}
+	if len(command) == 0 {
+		return sdwire.WithCode(sdwire.CodeInvalidArgument, fmt.Errorf("no command to switch to %v", mode))
+	}
+	out, err := exec.CommandContext(ctx, command[0], command[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", strings.Join(command, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Member is a named mux of a rig.
+type Member struct {
+	Name string
+	Mux  Mux
+}
+
+// Rig switches its muxes together. Switching to the host goes through the
+// members in order, and switching to the target in reverse order, so the
+// first member, usually the SD mux, is the first to reach the host and the
+// last to leave it.
+type Rig struct {
+	members []Member
+
+	mu    sync.Mutex
+	modes map[string]sdwire.SwitchMode
+}
+
+// New creates a rig of the given members. Names must be unique.
+func New(members ...Member) (*Rig, error) {
+	seen := make(map[string]bool)
+	for _, m := range members {
+		if m.Name == "" || m.Mux == nil {
+			return nil, errors.New("rig member without a name or mux")
+		}
+		if seen[m.Name] {
+			return nil, fmt.Errorf("duplicate rig member %q", m.Name)
+		}
+		seen[m.Name] = true
+	}
+	return &Rig{members: slices.Clone(members), modes: make(map[string]sdwire.SwitchMode)}, nil
+}
+
+// SwitchError reports a rig switch that failed part way.
+type SwitchError struct {
+	// Member is the name of the mux that failed to switch.
+	Member string
+	Err    error
+	// RollbackErr is set if switching the other muxes back failed too,
+	// leaving the rig split between sides.
+	RollbackErr error
+}
+
+func (e *SwitchError) Error() string {
+	msg := fmt.Sprintf("failed to switch %s: %v", e.Member, e.Err)
+	if e.RollbackErr != nil {
+		msg += fmt.Sprintf(" (rollback failed: %v)", e.RollbackErr)
+	}
+	return msg
+}
+
+func (e *SwitchError) Unwrap() error { return e.Err }
+
+// SetMode switches every mux to mode. If one fails, the muxes already
+// switched are switched back to the mode the rig last set them to, so the
+// rig is not left split between sides. Muxes the rig has not switched
+// before are left alone.
+func (r *Rig) SetMode(ctx context.Context, mode sdwire.SwitchMode) error {
+	if mode != sdwire.ModeHost && mode != sdwire.ModeTarget {
+		return sdwire.WithCode(sdwire.CodeInvalidArgument, fmt.Errorf("invalid switch mode: %v", mode))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	order := r.members
+	if mode == sdwire.ModeTarget {
+		order = make([]Member, len(r.members))
+		for i, m := range r.members {
+			order[len(order)-1-i] = m
+		}
+	}
+	for i, m := range order {
+		if err := m.Mux.SetMode(ctx, mode); err != nil {
+			return &SwitchError{Member: m.Name, Err: err, RollbackErr: r.rollback(order[:i])}
+		}
+	}
+	for _, m := range order {
+		r.modes[m.Name] = mode
+	}
+	return nil
+}
+
+// rollback switches the members back to their previous modes, in reverse
+// order. It does not stop at the first failure.
+func (r *Rig) rollback(switched []Member) error {
+	// The original context may be what made the switch fail.
+	ctx := context.Background()
+	var errs []error
+	for i := len(switched) - 1; i >= 0; i-- {
+		m := switched[i]
+		prev, ok := r.modes[m.Name]
+		if !ok {
+			continue
+		}
+		if err := m.Mux.SetMode(ctx, prev); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", m.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SwitchAllToHost switches every mux to the host, e.g. for flashing.
+func (r *Rig) SwitchAllToHost(ctx context.Context) error {
+	return r.SetMode(ctx, sdwire.ModeHost)
+}
+
+// SwitchAllToTarget switches every mux to the target, e.g. for booting.
+func (r *Rig) SwitchAllToTarget(ctx context.Context) error {
+	return r.SetMode(ctx, sdwire.ModeTarget)
+}
+
+// Mode returns the mode the rig last switched every mux to, and false if
+// it has not switched them all to the same side.
+func (r *Rig) Mode() (sdwire.SwitchMode, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var mode sdwire.SwitchMode
+	for i, m := range r.members {
+		got, ok := r.modes[m.Name]
+		if !ok || (i > 0 && got != mode) {
+			return 0, false
+		}
+		mode = got
+	}
+	return mode, len(r.members) > 0
+}
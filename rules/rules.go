@@ -0,0 +1,621 @@
+// Package rules automates common lab policies, such as alerting when a mux
+// is unplugged, parking a DUT's card on the host when the DUT powers off,
+// flashing a nightly image, or notifying chat when that flash fails,
+// without bespoke cron scripts. Rules are declared in JSON and run by
+// "sdwire serve -rules".
+package rules
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/power"
+	"github.com/fcjr/sdwire/topology"
+	"github.com/fcjr/sdwire/workflow"
+)
+
+// DefaultPowerInterval is how often DUT power is polled for PowerOff
+// triggers.
+const DefaultPowerInterval = 10 * time.Second
+
+// Rule runs an action when its trigger fires.
+type Rule struct {
+	Name string  `json:"name"`
+	When Trigger `json:"when"`
+	Then Action  `json:"then"`
+}
+
+// Trigger says when a rule fires. Exactly one field must be set.
+type Trigger struct {
+	// DeviceAdded and DeviceRemoved fire when a device matching the
+	// selector expression is plugged in or unplugged, e.g.
+	// "serial=sdw-0001". "*" matches every device.
+	DeviceAdded   string `json:"device_added,omitempty"`
+	DeviceRemoved string `json:"device_removed,omitempty"`
+	// PowerOff fires when the named DUT of the topology is powered off.
+	// Its power controller must report its state; see power.StateReader.
+	PowerOff string `json:"power_off,omitempty"`
+	// At fires every day at this local time, e.g. "02:00".
+	At string `json:"at,omitempty"`
+	// ModeChanged fires when a device matching the selector expression is
+	// switched by this process, such as through the API of "sdwire serve"
+	// or by another rule. Its rules cannot switch or flash devices.
+	ModeChanged string `json:"mode_changed,omitempty"`
+	// RuleDone and RuleFailed fire when the action of the named rule
+	// succeeds or fails, with that rule's devices, e.g. to report on a
+	// scheduled flash. The named rule cannot itself have one of these
+	// triggers.
+	RuleDone   string `json:"rule_done,omitempty"`
+	RuleFailed string `json:"rule_failed,omitempty"`
+}
+
+// Action is what a rule does when it fires. Exactly one of Alert, Switch,
+// Flash and Command must be set.
+type Action struct {
+	// Alert logs the message and, if Webhook is set, posts it there as
+	// JSON. With Secret, the body is signed with HMAC-SHA256 in the
+	// SignatureHeader.
+	Alert   string `json:"alert,omitempty"`
+	Webhook string `json:"webhook,omitempty"`
+	Secret  string `json:"secret,omitempty"`
+	// Switch switches the devices to "host" or "target".
+	Switch string `json:"switch,omitempty"`
+	// Flash writes the image at this path to the devices' cards and
+	// switches them back to the target.
+	Flash string `json:"flash,omitempty"`
+	// Command runs a command, with the rule name and the serial numbers
+	// of the devices in SDWIRE_RULE and SDWIRE_SERIALS.
+	Command []string `json:"command,omitempty"`
+	// Devices selects the devices Switch, Flash and Command act on, e.g.
+	// "model=rpi4". It defaults to the device that fired the trigger, or
+	// the mux of the DUT.
+	Devices string `json:"devices,omitempty"`
+}
+
+// Parse reads rules from JSON of the form:
+//
+//	{"rules": [
+//	  {"name": "mux unplugged", "when": {"device_removed": "*"},
+//	   "then": {"alert": "SDWire unplugged", "webhook": "https://chat.example.com/hook"}},
+//	  {"name": "park card", "when": {"power_off": "rpi4-03"}, "then": {"switch": "host"}},
+//	  {"name": "nightly", "when": {"at": "02:00"},
+//	   "then": {"flash": "/srv/images/nightly.img.xz", "devices": "model=rpi4"}},
+//	  {"name": "nightly failed", "when": {"rule_failed": "nightly"},
+//	   "then": {"alert": "nightly flash failed", "webhook": "https://chat.example.com/hook", "secret": "s3cret"}}
+//	]}
+func Parse(r io.Reader) ([]Rule, error) {
+	var doc struct {
+		Rules []Rule `json:"rules"`
+	}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %w", err)
+	}
+	return doc.Rules, nil
+}
+
+// Load reads rules from a JSON file; see Parse.
+func Load(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rules: %w", err)
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Option configures an Engine.
+type Option func(*Engine)
+
+// WithTopology resolves the DUTs named by PowerOff triggers. Rules with
+// PowerOff triggers need it.
+func WithTopology(t *topology.Topology) Option {
+	return func(e *Engine) {
+		e.topo = t
+	}
+}
+
+// WithLogger sets the logger rule firings and failures are logged to. The
+// default is slog.Default().
+func WithLogger(l *slog.Logger) Option {
+	return func(e *Engine) {
+		e.log = l
+	}
+}
+
+// WithPowerInterval sets how often DUT power is polled. The default is
+// DefaultPowerInterval.
+func WithPowerInterval(d time.Duration) Option {
+	return func(e *Engine) {
+		e.powerInterval = d
+	}
+}
+
+// WithDeviceOptions sets options used when opening devices.
+func WithDeviceOptions(opts ...sdwire.Option) Option {
+	return func(e *Engine) {
+		e.deviceOpts = opts
+	}
+}
+
+// rule is a validated Rule.
+type rule struct {
+	Rule
+	match   *sdwire.Selector
+	devices *sdwire.Selector
+	mode    sdwire.SwitchMode
+	power   power.StateReader
+	hour    int
+	minute  int
+	// running is set while the action runs, so a slow action is not
+	// started again before it finishes.
+	running atomic.Bool
+}
+
+// Engine runs rules.
+type Engine struct {
+	rules         []*rule
+	topo          *topology.Topology
+	log           *slog.Logger
+	powerInterval time.Duration
+	deviceOpts    []sdwire.Option
+	// listDevices lists the devices attached.
+	listDevices func() ([]*sdwire.DeviceInfo, error)
+	// watchDevices reports device arrivals and removals.
+	watchDevices func(context.Context) (<-chan sdwire.DeviceEvent, error)
+	// modeChanges reports switches made by this process.
+	modeChanges func(context.Context) <-chan sdwire.ModeChange
+	// after holds the RuleDone and RuleFailed rules by the rule they
+	// follow.
+	after map[string][]*rule
+
+	wg sync.WaitGroup
+}
+
+// New validates the rules and returns an engine running them. Call Run to
+// start it.
+func New(rules []Rule, opts ...Option) (*Engine, error) {
+	e := &Engine{
+		log:           slog.Default(),
+		powerInterval: DefaultPowerInterval,
+		listDevices:   sdwire.ListDevices,
+		watchDevices:  sdwire.Watch,
+		modeChanges:   sdwire.Subscribe,
+		after:         make(map[string][]*rule),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	names := make(map[string]bool)
+	for _, r := range rules {
+		if r.Name == "" {
+			return nil, errors.New("rule without a name")
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("duplicate rule %q", r.Name)
+		}
+		names[r.Name] = true
+		compiled, err := e.compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+		e.rules = append(e.rules, compiled)
+	}
+	chained := make(map[string]bool)
+	for _, r := range e.rules {
+		if name := cmp.Or(r.When.RuleDone, r.When.RuleFailed); name != "" {
+			chained[r.Name] = true
+			e.after[name] = append(e.after[name], r)
+		}
+	}
+	for _, r := range e.rules {
+		name := cmp.Or(r.When.RuleDone, r.When.RuleFailed)
+		switch {
+		case name == "":
+		case !names[name]:
+			return nil, fmt.Errorf("rule %q: no rule %q", r.Name, name)
+		case chained[name]:
+			return nil, fmt.Errorf("rule %q: rule %q is itself triggered by a rule", r.Name, name)
+		}
+	}
+	return e, nil
+}
+
+func (e *Engine) compile(r Rule) (*rule, error) {
+	c := &rule{Rule: r}
+	triggers := 0
+	for _, set := range []bool{
+		r.When.DeviceAdded != "", r.When.DeviceRemoved != "", r.When.PowerOff != "", r.When.At != "",
+		r.When.ModeChanged != "", r.When.RuleDone != "", r.When.RuleFailed != "",
+	} {
+		if set {
+			triggers++
+		}
+	}
+	if triggers != 1 {
+		return nil, errors.New("exactly one trigger must be set")
+	}
+
+	var err error
+	if expr := cmp.Or(r.When.DeviceAdded, r.When.DeviceRemoved, r.When.ModeChanged); expr != "" && expr != "*" {
+		if c.match, err = sdwire.ParseSelector(expr); err != nil {
+			return nil, err
+		}
+	}
+	if r.When.PowerOff != "" {
+		if e.topo == nil {
+			return nil, errors.New("power_off needs a topology")
+		}
+		ctrl, err := e.topo.PowerController(r.When.PowerOff)
+		if err != nil {
+			return nil, err
+		}
+		reader, ok := ctrl.(power.StateReader)
+		if !ok {
+			return nil, fmt.Errorf("the power controller of DUT %q cannot report its state", r.When.PowerOff)
+		}
+		c.power = reader
+	}
+	if r.When.At != "" {
+		at, err := time.Parse("15:04", r.When.At)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time %q, expected HH:MM", r.When.At)
+		}
+		c.hour, c.minute = at.Hour(), at.Minute()
+	}
+
+	actions := 0
+	for _, set := range []bool{r.Then.Alert != "", r.Then.Switch != "", r.Then.Flash != "", len(r.Then.Command) > 0} {
+		if set {
+			actions++
+		}
+	}
+	if actions != 1 {
+		return nil, errors.New("exactly one action must be set")
+	}
+	if r.Then.Switch != "" {
+		if c.mode, err = parseMode(r.Then.Switch); err != nil {
+			return nil, err
+		}
+	}
+	if r.Then.Secret != "" && r.Then.Webhook == "" {
+		return nil, errors.New("secret without a webhook")
+	}
+	if r.When.ModeChanged != "" && (r.Then.Switch != "" || r.Then.Flash != "") {
+		// Their switches would fire them again.
+		return nil, errors.New("mode changes cannot switch or flash devices")
+	}
+	if r.Then.Devices != "" {
+		if c.devices, err = sdwire.ParseSelector(r.Then.Devices); err != nil {
+			return nil, err
+		}
+	}
+	if r.Then.Alert == "" && r.Then.Devices == "" {
+		switch {
+		case r.When.At != "":
+			return nil, errors.New("scheduled actions need devices")
+		case r.When.DeviceRemoved != "" && len(r.Then.Command) == 0:
+			return nil, errors.New("removed devices cannot be switched or flashed")
+		}
+	}
+	return c, nil
+}
+
+func parseMode(s string) (sdwire.SwitchMode, error) {
+	switch s {
+	case "host":
+		return sdwire.ModeHost, nil
+	case "target":
+		return sdwire.ModeTarget, nil
+	}
+	return 0, sdwire.WithCode(sdwire.CodeInvalidArgument, errors.New("unknown mode "+s+", expected host or target"))
+}
+
+// Run runs the rules until ctx is done, then waits for running actions to
+// finish.
+func (e *Engine) Run(ctx context.Context) error {
+	defer e.wg.Wait()
+	var devices, powered, scheduled, modes []*rule
+	for _, r := range e.rules {
+		switch {
+		case r.power != nil:
+			powered = append(powered, r)
+		case r.When.At != "":
+			scheduled = append(scheduled, r)
+		case r.When.ModeChanged != "":
+			modes = append(modes, r)
+		case r.When.RuleDone != "" || r.When.RuleFailed != "":
+			// Fired by the rules they follow.
+		default:
+			devices = append(devices, r)
+		}
+	}
+	for _, r := range scheduled {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.schedule(ctx, r)
+		}()
+	}
+	if len(powered) > 0 {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.pollPower(ctx, powered)
+		}()
+	}
+	if len(modes) > 0 {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.watchModes(ctx, modes)
+		}()
+	}
+	if len(devices) > 0 {
+		if err := e.watch(ctx, devices); err != nil {
+			return err
+		}
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// watch fires device rules on arrivals and removals. Devices already
+// present when watching starts were not plugged in and do not fire.
+func (e *Engine) watch(ctx context.Context, rules []*rule) error {
+	events, err := e.watchDevices(ctx)
+	if err != nil {
+		return err
+	}
+	for ev := range events {
+		if ev.Initial {
+			continue
+		}
+		for _, r := range rules {
+			expr := r.When.DeviceAdded
+			if ev.Type == sdwire.DeviceRemoved {
+				expr = r.When.DeviceRemoved
+			}
+			if expr == "" || (r.match != nil && !r.match.Match(ev.Info)) {
+				continue
+			}
+			e.fire(ctx, r, fmt.Sprintf("%s %s", ev.Info.DeviceID(), strings.ToLower(ev.Type.String())), func() ([]*sdwire.DeviceInfo, error) {
+				return []*sdwire.DeviceInfo{ev.Info}, nil
+			})
+		}
+	}
+	return nil
+}
+
+// watchModes fires ModeChanged rules on switches made by this process.
+func (e *Engine) watchModes(ctx context.Context, rules []*rule) {
+	for c := range e.modeChanges(ctx) {
+		info := e.modeChangeInfo(c)
+		for _, r := range rules {
+			if r.match != nil && !r.match.Match(info) {
+				continue
+			}
+			e.fire(ctx, r, fmt.Sprintf("%s switched to %s", info.DeviceID(), strings.ToLower(c.Mode.String())), func() ([]*sdwire.DeviceInfo, error) {
+				return []*sdwire.DeviceInfo{info}, nil
+			})
+		}
+	}
+}
+
+// modeChangeInfo returns the listed device that switched, so that selectors
+// can match its tags, or what the change tells of it if it is not listed.
+func (e *Engine) modeChangeInfo(c sdwire.ModeChange) *sdwire.DeviceInfo {
+	if all, err := e.listDevices(); err == nil {
+		for _, info := range all {
+			if info.Serial == c.Serial && info.PortPath == c.PortPath {
+				return info
+			}
+		}
+	}
+	info := &sdwire.DeviceInfo{ID: c.Serial, Serial: c.Serial, PortPath: c.PortPath}
+	info.Name = c.Name
+	return info
+}
+
+// pollPower fires PowerOff rules when their DUT's power goes from on to
+// off. A DUT already off when polling starts does not fire.
+func (e *Engine) pollPower(ctx context.Context, rules []*rule) {
+	on := make(map[*rule]bool)
+	ticker := time.NewTicker(e.powerInterval)
+	defer ticker.Stop()
+	for {
+		for _, r := range rules {
+			state, err := r.power.State(ctx)
+			if err != nil {
+				e.log.Debug("failed to read DUT power", "dut", r.When.PowerOff, "error", err)
+				continue
+			}
+			if on[r] && !state {
+				dut := r.When.PowerOff
+				e.fire(ctx, r, "DUT "+dut+" powered off", func() ([]*sdwire.DeviceInfo, error) {
+					info, err := e.topo.FindMux(dut)
+					if err != nil {
+						return nil, err
+					}
+					return []*sdwire.DeviceInfo{info}, nil
+				})
+			}
+			on[r] = state
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// schedule fires an At rule every day at its time.
+func (e *Engine) schedule(ctx context.Context, r *rule) {
+	for {
+		timer := time.NewTimer(time.Until(nextAt(time.Now(), r.hour, r.minute)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		e.fire(ctx, r, "scheduled at "+r.When.At, nil)
+	}
+}
+
+// nextAt returns the first time after now that the clock shows hour:minute.
+func nextAt(now time.Time, hour, minute int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if !next.After(now) {
+		next = time.Date(now.Year(), now.Month(), now.Day()+1, hour, minute, 0, 0, now.Location())
+	}
+	return next
+}
+
+// fire runs the rule's action in the background. subject describes what
+// fired it, and devices, if not nil, returns the devices it concerns.
+func (e *Engine) fire(ctx context.Context, r *rule, subject string, devices func() ([]*sdwire.DeviceInfo, error)) {
+	if !r.running.CompareAndSwap(false, true) {
+		e.log.Warn("rule still running, skipping", "rule", r.Name, "trigger", subject)
+		return
+	}
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		defer r.running.Store(false)
+		e.log.Info("rule fired", "rule", r.Name, "trigger", subject)
+		targets, err := e.run(ctx, r, subject, devices)
+		if err != nil {
+			e.log.Error("rule failed", "rule", r.Name, "trigger", subject, "error", err)
+		}
+		for _, next := range e.after[r.Name] {
+			switch {
+			case err == nil && next.When.RuleDone != "":
+				e.fire(ctx, next, fmt.Sprintf("rule %s done (%s)", r.Name, subject), func() ([]*sdwire.DeviceInfo, error) {
+					return targets, nil
+				})
+			case err != nil && next.When.RuleFailed != "":
+				e.fire(ctx, next, fmt.Sprintf("rule %s failed (%s): %v", r.Name, subject, err), func() ([]*sdwire.DeviceInfo, error) {
+					return targets, nil
+				})
+			}
+		}
+	}()
+}
+
+// run runs the rule's action, returning the devices it acted on.
+func (e *Engine) run(ctx context.Context, r *rule, subject string, devices func() ([]*sdwire.DeviceInfo, error)) (sdwire.Group, error) {
+	var targets sdwire.Group
+	if r.devices != nil {
+		all, err := e.listDevices()
+		if err != nil {
+			return nil, err
+		}
+		targets = r.devices.Filter(all)
+	} else if devices != nil {
+		var err error
+		if targets, err = devices(); err != nil {
+			// An alert is still worth sending without its devices.
+			if r.Then.Alert == "" {
+				return nil, err
+			}
+			e.log.Debug("failed to find the devices of an alert", "rule", r.Name, "error", err)
+		}
+	}
+	return targets, e.act(ctx, r, subject, targets)
+}
+
+func (e *Engine) act(ctx context.Context, r *rule, subject string, targets sdwire.Group) error {
+	switch {
+	case r.Then.Alert != "":
+		return e.alert(ctx, r, subject, targets)
+	case r.Then.Switch != "":
+		return targets.SetMode(r.mode, e.deviceOpts...)
+	case r.Then.Flash != "":
+		var errs []error
+		for _, res := range workflow.FlashAll(ctx, targets, r.Then.Flash, workflow.DefaultLimits, e.deviceOpts...) {
+			if res.Err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", res.Info.Serial, res.Err))
+			}
+		}
+		return errors.Join(errs...)
+	default:
+		cmd := exec.CommandContext(ctx, r.Then.Command[0], r.Then.Command[1:]...)
+		cmd.Env = append(os.Environ(), "SDWIRE_RULE="+r.Name, "SDWIRE_SERIALS="+strings.Join(targets.Serials(), " "))
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s: %w: %s", strings.Join(r.Then.Command, " "), err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+}
+
+// alertTimeout bounds webhook requests.
+const alertTimeout = 10 * time.Second
+
+// SignatureHeader carries the signature of webhook bodies of actions with a
+// Secret: "sha256=" and the hex HMAC-SHA256 of the body keyed with the
+// secret.
+const SignatureHeader = "X-Sdwire-Signature"
+
+// Sign returns the SignatureHeader value for a webhook body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (e *Engine) alert(ctx context.Context, r *rule, subject string, targets sdwire.Group) error {
+	e.log.Warn(r.Then.Alert, "rule", r.Name, "trigger", subject, "devices", targets.Serials())
+	if r.Then.Webhook == "" {
+		return nil
+	}
+	body, err := json.Marshal(struct {
+		Rule    string    `json:"rule"`
+		Message string    `json:"message"`
+		Trigger string    `json:"trigger"`
+		Devices []string  `json:"devices,omitempty"`
+		Time    time.Time `json:"time"`
+	}{r.Name, r.Then.Alert, subject, targets.Serials(), time.Now()})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, alertTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.Then.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.Then.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(r.Then.Secret, body))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post alert: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to post alert: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fcjr/sdwire"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+		// err is a substring of the expected error, "" for none.
+		err string
+	}{
+		{"alert on any removal", Rule{When: Trigger{DeviceRemoved: "*"}, Then: Action{Alert: "gone"}}, ""},
+		{"switch on arrival", Rule{When: Trigger{DeviceAdded: "model=rpi4"}, Then: Action{Switch: "host"}}, ""},
+		{"scheduled flash", Rule{When: Trigger{At: "02:00"}, Then: Action{Flash: "nightly.img", Devices: "model=rpi4"}}, ""},
+		{"command on removal", Rule{When: Trigger{DeviceRemoved: "*"}, Then: Action{Command: []string{"true"}}}, ""},
+		{"no trigger", Rule{Then: Action{Alert: "x"}}, "exactly one trigger"},
+		{"two triggers", Rule{When: Trigger{DeviceAdded: "*", At: "02:00"}, Then: Action{Alert: "x"}}, "exactly one trigger"},
+		{"bad selector", Rule{When: Trigger{DeviceAdded: "(model=rpi4"}, Then: Action{Alert: "x"}}, "invalid selector"},
+		{"power off without topology", Rule{When: Trigger{PowerOff: "dut"}, Then: Action{Alert: "x"}}, "needs a topology"},
+		{"bad time", Rule{When: Trigger{At: "25:00"}, Then: Action{Alert: "x"}}, "expected HH:MM"},
+		{"time without minutes", Rule{When: Trigger{At: "2am"}, Then: Action{Alert: "x"}}, "expected HH:MM"},
+		{"no action", Rule{When: Trigger{DeviceAdded: "*"}}, "exactly one action"},
+		{"two actions", Rule{When: Trigger{DeviceAdded: "*"}, Then: Action{Alert: "x", Switch: "host"}}, "exactly one action"},
+		{"bad mode", Rule{When: Trigger{DeviceAdded: "*"}, Then: Action{Switch: "sideways"}}, "unknown mode"},
+		{"bad devices", Rule{When: Trigger{DeviceAdded: "*"}, Then: Action{Switch: "host", Devices: "rack="}}, "invalid selector"},
+		{"scheduled without devices", Rule{When: Trigger{At: "02:00"}, Then: Action{Switch: "host"}}, "need devices"},
+		{"switch removed device", Rule{When: Trigger{DeviceRemoved: "*"}, Then: Action{Switch: "host"}}, "cannot be switched"},
+		{"alert on switch", Rule{When: Trigger{ModeChanged: "rack=3"}, Then: Action{Alert: "switched"}}, ""},
+		{"switch on switch", Rule{When: Trigger{ModeChanged: "*"}, Then: Action{Switch: "host", Devices: "rack=3"}}, "cannot switch or flash"},
+		{"signed webhook", Rule{When: Trigger{DeviceRemoved: "*"}, Then: Action{Alert: "x", Webhook: "http://hook", Secret: "s"}}, ""},
+		{"secret without webhook", Rule{When: Trigger{DeviceRemoved: "*"}, Then: Action{Alert: "x", Secret: "s"}}, "secret without a webhook"},
+		{"unknown rule", Rule{When: Trigger{RuleFailed: "nightly"}, Then: Action{Alert: "x"}}, `no rule "nightly"`},
+		{"follows itself", Rule{When: Trigger{RuleDone: "follows itself"}, Then: Action{Alert: "x"}}, "itself triggered by a rule"},
+	}
+	for _, tt := range tests {
+		tt.rule.Name = tt.name
+		_, err := New([]Rule{tt.rule})
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s: error %v, want one containing %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestNewRuleNames(t *testing.T) {
+	r := Rule{When: Trigger{DeviceRemoved: "*"}, Then: Action{Alert: "gone"}}
+	if _, err := New([]Rule{r}); err == nil {
+		t.Error("New accepted a rule without a name")
+	}
+	r.Name = "gone"
+	if _, err := New([]Rule{r, r}); err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Errorf("New with a duplicate rule = %v, want a duplicate error", err)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for s, want := range map[string]sdwire.SwitchMode{"host": sdwire.ModeHost, "target": sdwire.ModeTarget} {
+		if got, err := parseMode(s); err != nil || got != want {
+			t.Errorf("parseMode(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "Host", "dut"} {
+		if _, err := parseMode(s); sdwire.CodeOf(err) != sdwire.CodeInvalidArgument {
+			t.Errorf("parseMode(%q) = %v, want CodeInvalidArgument", s, err)
+		}
+	}
+}
+
+func TestNextAt(t *testing.T) {
+	loc := time.FixedZone("lab", 2*60*60)
+	at := func(month time.Month, day, hour, minute, sec int) time.Time {
+		return time.Date(2024, month, day, hour, minute, sec, 0, loc)
+	}
+	tests := []struct {
+		now          time.Time
+		hour, minute int
+		want         time.Time
+	}{
+		{at(3, 10, 1, 0, 0), 2, 0, at(3, 10, 2, 0, 0)},
+		{at(3, 10, 2, 0, 0), 2, 0, at(3, 11, 2, 0, 0)},
+		{at(3, 10, 2, 0, 1), 2, 0, at(3, 11, 2, 0, 0)},
+		{at(3, 10, 23, 59, 0), 0, 0, at(3, 11, 0, 0, 0)},
+		{at(1, 31, 23, 0, 0), 2, 30, at(2, 1, 2, 30, 0)},
+		{at(12, 31, 23, 0, 0), 2, 0, time.Date(2025, 1, 1, 2, 0, 0, 0, loc)},
+	}
+	for _, tt := range tests {
+		if got := nextAt(tt.now, tt.hour, tt.minute); !got.Equal(tt.want) {
+			t.Errorf("nextAt(%v, %02d:%02d) = %v, want %v", tt.now, tt.hour, tt.minute, got, tt.want)
+		}
+	}
+}
+
+// TestWatchSkipsInitial checks that devices present when watching starts
+// do not fire arrival rules, while their removal and later arrivals do.
+func TestWatchSkipsInitial(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		fired []string
+	)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Rule    string `json:"rule"`
+			Trigger string `json:"trigger"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		fired = append(fired, body.Rule+": "+body.Trigger)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	rules := []Rule{
+		{Name: "a added", When: Trigger{DeviceAdded: "serial=a"}, Then: Action{Alert: "a added", Webhook: hook.URL}},
+		{Name: "b added", When: Trigger{DeviceAdded: "serial=b"}, Then: Action{Alert: "b added", Webhook: hook.URL}},
+		{Name: "removed", When: Trigger{DeviceRemoved: "*"}, Then: Action{Alert: "removed", Webhook: hook.URL}},
+	}
+	events := make(chan sdwire.DeviceEvent)
+	e, err := New(rules, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	e.watchDevices = func(context.Context) (<-chan sdwire.DeviceEvent, error) {
+		return events, nil
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- e.watch(context.Background(), e.rules) }()
+	a := &sdwire.DeviceInfo{ID: "a", Serial: "a", PortPath: "1-1"}
+	b := &sdwire.DeviceInfo{ID: "b", Serial: "b", PortPath: "1-2"}
+	events <- sdwire.DeviceEvent{Type: sdwire.DeviceArrived, Info: a, Initial: true}
+	events <- sdwire.DeviceEvent{Type: sdwire.DeviceRemoved, Info: a}
+	events <- sdwire.DeviceEvent{Type: sdwire.DeviceArrived, Info: b}
+	close(events)
+	if err := <-done; err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	e.wg.Wait()
+
+	slices.Sort(fired)
+	want := []string{"b added: b@1-2 arrived", "removed: a@1-1 removed"}
+	if !slices.Equal(fired, want) {
+		t.Errorf("fired %q, want %q", fired, want)
+	}
+}
+
+// newTestEngine returns an engine for rules whose device events and mode
+// changes come from the returned channels.
+func newTestEngine(t *testing.T, rules []Rule) (*Engine, chan sdwire.DeviceEvent, chan sdwire.ModeChange) {
+	t.Helper()
+	e, err := New(rules, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	events := make(chan sdwire.DeviceEvent)
+	changes := make(chan sdwire.ModeChange)
+	e.listDevices = func() ([]*sdwire.DeviceInfo, error) {
+		a := &sdwire.DeviceInfo{ID: "a", Serial: "a", PortPath: "1-1"}
+		a.Name = "bench-a"
+		return []*sdwire.DeviceInfo{a}, nil
+	}
+	e.watchDevices = func(context.Context) (<-chan sdwire.DeviceEvent, error) {
+		return events, nil
+	}
+	e.modeChanges = func(context.Context) <-chan sdwire.ModeChange {
+		return changes
+	}
+	return e, events, changes
+}
+
+// hookBody is a webhook request as received.
+type hookBody struct {
+	Rule      string   `json:"rule"`
+	Trigger   string   `json:"trigger"`
+	Devices   []string `json:"devices"`
+	Signature string   `json:"-"`
+}
+
+// newHook returns a webhook server and the requests it received.
+func newHook(t *testing.T) (*httptest.Server, func() []hookBody) {
+	var (
+		mu       sync.Mutex
+		received []hookBody
+	)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		var body hookBody
+		json.Unmarshal(raw, &body)
+		if sig := r.Header.Get(SignatureHeader); sig != "" {
+			body.Signature = "invalid"
+			if sig == Sign("s3cret", raw) {
+				body.Signature = "valid"
+			}
+		}
+		mu.Lock()
+		received = append(received, body)
+		mu.Unlock()
+	}))
+	t.Cleanup(hook.Close)
+	return hook, func() []hookBody {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(received)
+	}
+}
+
+func TestModeChanged(t *testing.T) {
+	hook, received := newHook(t)
+	e, _, changes := newTestEngine(t, []Rule{
+		{Name: "a switched", When: Trigger{ModeChanged: "name=bench-a"}, Then: Action{Alert: "a switched", Webhook: hook.URL, Secret: "s3cret"}},
+	})
+	done := make(chan struct{})
+	go func() {
+		e.watchModes(context.Background(), e.rules)
+		close(done)
+	}()
+	changes <- sdwire.ModeChange{Serial: "b", PortPath: "1-2", Mode: sdwire.ModeTarget}
+	changes <- sdwire.ModeChange{Serial: "a", PortPath: "1-1", Mode: sdwire.ModeTarget}
+	close(changes)
+	<-done
+	e.wg.Wait()
+
+	want := []hookBody{{Rule: "a switched", Trigger: "a@1-1 switched to target", Devices: []string{"a"}, Signature: "valid"}}
+	if got := received(); !reflect.DeepEqual(got, want) {
+		t.Errorf("received %+v, want %+v", got, want)
+	}
+}
+
+func TestRuleChains(t *testing.T) {
+	hook, received := newHook(t)
+	e, events, _ := newTestEngine(t, []Rule{
+		{Name: "check", When: Trigger{DeviceAdded: "*"}, Then: Action{Command: []string{"sh", "-c", `test "$SDWIRE_SERIALS" = good`}}},
+		{Name: "passed", When: Trigger{RuleDone: "check"}, Then: Action{Alert: "passed", Webhook: hook.URL}},
+		{Name: "failed", When: Trigger{RuleFailed: "check"}, Then: Action{Alert: "failed", Webhook: hook.URL}},
+	})
+	done := make(chan error, 1)
+	go func() { done <- e.watch(context.Background(), e.rules) }()
+	events <- sdwire.DeviceEvent{Type: sdwire.DeviceArrived, Info: &sdwire.DeviceInfo{ID: "good", Serial: "good", PortPath: "1-1"}}
+	waitFor(t, func() bool { return len(received()) == 1 })
+	events <- sdwire.DeviceEvent{Type: sdwire.DeviceArrived, Info: &sdwire.DeviceInfo{ID: "bad", Serial: "bad", PortPath: "1-2"}}
+	waitFor(t, func() bool { return len(received()) == 2 })
+	close(events)
+	if err := <-done; err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	e.wg.Wait()
+
+	got := received()
+	if got[0].Rule != "passed" || got[0].Trigger != "rule check done (good@1-1 arrived)" || !slices.Equal(got[0].Devices, []string{"good"}) {
+		t.Errorf("first webhook %+v, want the passed rule for good", got[0])
+	}
+	if got[1].Rule != "failed" || !strings.HasPrefix(got[1].Trigger, "rule check failed (bad@1-2 arrived): ") || !slices.Equal(got[1].Devices, []string{"bad"}) {
+		t.Errorf("second webhook %+v, want the failed rule for bad", got[1])
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after 5 seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package sdwire
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrSwitchCanceled is reported by a ScheduledSwitch that was canceled
+// before it ran.
+var ErrSwitchCanceled = errors.New("scheduled switch canceled")
+
+// ScheduledSwitch is a mode switch that will happen in the future.
+type ScheduledSwitch struct {
+	device *SDWire
+	mode   SwitchMode
+	at     time.Time
+	timer  *time.Timer
+	done   chan struct{}
+
+	once sync.Once
+	err  error
+}
+
+// SetModeAt switches the SD card to mode at time t. Pending switches are
+// canceled when the device is closed.
+func (s *SDWire) SetModeAt(t time.Time, mode SwitchMode) *ScheduledSwitch {
+	return s.SetModeAfter(time.Until(t), mode)
+}
+
+// SetModeAfter switches the SD card to mode once d has elapsed. Pending
+// switches are canceled when the device is closed; on a closed device the
+// switch fails at once with ErrClosed.
+func (s *SDWire) SetModeAfter(d time.Duration, mode SwitchMode) *ScheduledSwitch {
+	sw := &ScheduledSwitch{
+		device: s,
+		mode:   mode,
+		at:     time.Now().Add(d),
+		done:   make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		sw.timer = time.NewTimer(0)
+		sw.timer.Stop()
+		sw.finish(ErrClosed)
+		return sw
+	}
+	if s.scheduled == nil {
+		s.scheduled = make(map[*ScheduledSwitch]struct{})
+	}
+	s.scheduled[sw] = struct{}{}
+	// The timer is set while s.mu is held, so cancelScheduled sees it.
+	sw.timer = time.AfterFunc(d, func() {
+		sw.finish(s.SetMode(mode))
+	})
+	s.mu.Unlock()
+	return sw
+}
+
+// Mode returns the mode the device will be switched to.
+func (sw *ScheduledSwitch) Mode() SwitchMode {
+	return sw.mode
+}
+
+// When returns the time the switch is scheduled for.
+func (sw *ScheduledSwitch) When() time.Time {
+	return sw.at
+}
+
+// Cancel stops the switch from happening. It reports whether the switch was
+// stopped, or false if it already ran or was canceled.
+func (sw *ScheduledSwitch) Cancel() bool {
+	if !sw.timer.Stop() {
+		return false
+	}
+	sw.finish(ErrSwitchCanceled)
+	return true
+}
+
+// Done returns a channel that is closed once the switch has run or been canceled.
+func (sw *ScheduledSwitch) Done() <-chan struct{} {
+	return sw.done
+}
+
+// Err returns the result of the switch once Done is closed, and nil before then.
+func (sw *ScheduledSwitch) Err() error {
+	select {
+	case <-sw.done:
+		return sw.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the switch has run or been canceled and returns its result.
+func (sw *ScheduledSwitch) Wait() error {
+	<-sw.done
+	return sw.err
+}
+
+func (sw *ScheduledSwitch) finish(err error) {
+	sw.once.Do(func() {
+		sw.err = err
+		close(sw.done)
+
+		sw.device.mu.Lock()
+		delete(sw.device.scheduled, sw)
+		sw.device.mu.Unlock()
+	})
+}
+
+// cancelScheduled cancels all pending switches.
+func (s *SDWire) cancelScheduled() {
+	s.mu.Lock()
+	pending := make([]*ScheduledSwitch, 0, len(s.scheduled))
+	for sw := range s.scheduled {
+		pending = append(pending, sw)
+	}
+	s.mu.Unlock()
+
+	for _, sw := range pending {
+		sw.Cancel()
+	}
+}
@@ -4,9 +4,18 @@
 package sdwire
 
 import (
+	"errors"
 	"fmt"
-
-	"github.com/google/gousb"
+	"io"
+	"log/slog"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -26,6 +35,9 @@ const (
 	GenerationSDWireC DeviceGeneration = iota
 	// GenerationSDWire3 represents the SDWire3 device using kernel driver attach/detach.
 	GenerationSDWire3
+	// GenerationGPIO represents a home-made mux driven by GPIO lines; see
+	// OpenGPIO.
+	GenerationGPIO
 )
 
 // String returns a human-readable description of the device generation.
@@ -35,6 +47,8 @@ func (g DeviceGeneration) String() string {
 		return "SDWireC"
 	case GenerationSDWire3:
 		return "SDWire3"
+	case GenerationGPIO:
+		return "GPIO"
 	default:
 		return "Unknown"
 	}
@@ -63,47 +77,162 @@ func (m SwitchMode) String() string {
 }
 
 const (
+	usbRequestGetStatus = 0x00
+
 	ftdiSioSetBitmodeRequest = 0x0B
 	ftdiSioBitmodeCbus       = 0x20
+	ftdiSioReadPinsRequest   = 0x0C
+	ftdiSioResetRequest      = 0x00
 )
 
+// PortIDPrefix marks device IDs made from a port path; see DeviceInfo.ID.
+const PortIDPrefix = "port:"
+
+// ErrModeMismatch is returned by SetModeVerified when the hardware does not
+// report the mode it was switched to.
+var ErrModeMismatch = errors.New("device did not switch")
+
+// ErrClosed is returned by operations on a device that has been closed.
+var ErrClosed = errors.New("device is closed")
+
 // DeviceController defines the interface for controlling different SDWire device generations.
 type DeviceController interface {
 	SetMode(mode SwitchMode) error
 }
 
+// modeReader is implemented by controllers that can read the switch state
+// back from the hardware.
+type modeReader interface {
+	GetMode() (SwitchMode, error)
+}
+
 // SDWire represents a connected SDWire device that can switch an SD card
 // between a target device and host computer.
 type SDWire struct {
-	device       *gousb.Device
+	device       usbDevice
 	serial       string
 	product      string
 	manufacturer string
+	portPath     string
+	identity     Identity
 	generation   DeviceGeneration
 	controller   DeviceController
+	lock         *deviceLock
+	log          *slog.Logger
+	metrics      MetricsRecorder
+	listeners    modeListeners
+	audit        AuditSink
+	actor        string
+	stats        *deviceStats
+	diag         *transferRing
+	quirks       Quirks
+	settle       time.Duration
+	debounce     time.Duration
+	force        bool
+	skipNoop     bool
+	handle       *handleRecord
+
+	// mu serializes operations on the device.
+	mu         sync.Mutex
+	scheduled  map[*ScheduledSwitch]struct{}
+	lastSwitch time.Time
+	lastMode   SwitchMode
+	closed     bool
+	// gone is set once the device has disappeared; see checkGone.
+	gone           *DeviceGoneError
+	watchdog       *watchdog
+	driftListeners listeners[Drift]
 }
 
 // DeviceInfo contains identifying information about an SDWire device.
 type DeviceInfo struct {
+	// ID identifies the device for NewWithID: the serial number, or the
+	// port path prefixed with "port:" for devices whose serial number
+	// cannot be read or is shared with another device. It is unique among
+	// the devices listed together.
+	ID           string
 	Serial       string
 	Product      string
 	Manufacturer string
-	Generation   DeviceGeneration
+	// PortPath is the physical USB location in Linux sysfs notation,
+	// e.g. "1-2.3" for port 3 of a hub on port 2 of bus 1.
+	PortPath   string
+	Generation DeviceGeneration
+	// FirmwareVersion is the bcdDevice release number; see Firmware.
+	FirmwareVersion string
+	// DuplicateSerial is set when another connected device reports the
+	// same serial number, as clones often do. Such devices can only be
+	// opened with NewWithPortPath.
+	DuplicateSerial bool
+	// Identity is the lab identity from the registry installed with
+	// SetRegistry, or empty if the device is not registered.
+	Identity
+}
+
+// StableID identifies the device by serial number and port path; see
+// DeviceID.String. Unlike ID it tells apart devices sharing a serial
+// number, which suits keys for inventories and dashboards.
+func (d *DeviceInfo) StableID() string {
+	return d.DeviceID().String()
+}
+
+// isSDWire reports whether the descriptor belongs to a supported SDWire device.
+func isSDWire(desc *deviceDesc) bool {
+	return (desc.Vendor == SDWireCVID && desc.Product == SDWireCPID) ||
+		(desc.Vendor == SDWire3VID && desc.Product == SDWire3PID)
+}
+
+// generationOf determines the device generation based on VID/PID.
+func generationOf(desc *deviceDesc) DeviceGeneration {
+	if desc.Vendor == SDWire3VID && desc.Product == SDWire3PID {
+		return GenerationSDWire3
+	}
+	return GenerationSDWireC
+}
+
+// portPathOf formats the physical port path of a device the way Linux sysfs
+// names USB devices, so the result can be matched against /sys/bus/usb/devices.
+func portPathOf(desc *deviceDesc) string {
+	if len(desc.Path) == 0 {
+		return strconv.Itoa(desc.Bus) + "-0"
+	}
+	ports := make([]string, len(desc.Path))
+	for i, p := range desc.Path {
+		ports[i] = strconv.Itoa(p)
+	}
+	return strconv.Itoa(desc.Bus) + "-" + strings.Join(ports, ".")
 }
 
 // ListDevices discovers all connected SDWire devices and returns their information.
 // This is useful for device enumeration before connecting to a specific device.
+// Devices are sorted by port path, then serial (see SortByPortPath), so
+// the order is stable across runs and reboots.
 func ListDevices() ([]*DeviceInfo, error) {
-	ctx := gousb.NewContext()
-	defer ctx.Close()
+	return ListDevicesFields(AllFields)
+}
 
-	var devices []*DeviceInfo
+// DeviceFields selects the optional DeviceInfo fields read during
+// enumeration. Each is a USB string request, which adds up on a rack of
+// devices.
+type DeviceFields uint
 
-	devs, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
-		return (desc.Vendor == SDWireCVID && desc.Product == SDWireCPID) ||
-			(desc.Vendor == SDWire3VID && desc.Product == SDWire3PID)
-	})
+const (
+	// FieldProduct reads DeviceInfo.Product.
+	FieldProduct DeviceFields = 1 << iota
+	// FieldManufacturer reads DeviceInfo.Manufacturer.
+	FieldManufacturer
+
+	// AllFields reads every field, as ListDevices does.
+	AllFields = FieldProduct | FieldManufacturer
+)
+
+// ListDevicesFields is like ListDevices but only reads the optional fields
+// in fields, leaving the others empty. The serial number is always read.
+func ListDevicesFields(fields DeviceFields) ([]*DeviceInfo, error) {
+	log := packageLogger()
+	devs, err := openSDWires()
 	if err != nil {
+		log.Debug("device enumeration failed", "error", err)
 		return nil, fmt.Errorf("failed to find USB devices: %w", err)
 	}
 	defer func() {
@@ -112,120 +241,416 @@ func ListDevices() ([]*DeviceInfo, error) {
 		}
 	}()
 
-	for _, dev := range devs {
-		serial, err := dev.SerialNumber()
-		if err != nil {
-			serial = "unknown"
-		}
+	// String requests are slow, so devices are described in parallel;
+	// sorting below keeps the order deterministic.
+	devices := make([]*DeviceInfo, len(devs))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, enumerationWorkers)
+	for i, dev := range devs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			devices[i] = describeDevice(dev, fields)
+			log.Debug("found device", "serial", devices[i].Serial, "port", devices[i].PortPath, "generation", devices[i].Generation)
+		}()
+	}
+	wg.Wait()
 
-		product, err := dev.Product()
-		if err != nil {
+	SortByPortPath(devices)
+	markDuplicateSerials(devices)
+	log.Debug("device enumeration complete", "count", len(devices))
+	return devices, nil
+}
+
+// enumerationWorkers bounds how many devices ListDevicesFields describes
+// at once.
+const enumerationWorkers = 8
+
+// describeDevice reads the information ListDevicesFields reports for dev.
+// Devices without a readable serial number are identified by port.
+func describeDevice(dev usbDevice, fields DeviceFields) *DeviceInfo {
+	desc := dev.Descriptor()
+	portPath := portPathOf(desc)
+	id := PortIDPrefix + portPath
+	serial, ok := serialOf(dev)
+	if ok {
+		id = serial
+	} else {
+		serial = "unknown"
+	}
+
+	var err error
+	var product, manufacturer string
+	if fields&FieldProduct != 0 {
+		if product, err = dev.Product(); err != nil {
 			product = "unknown"
 		}
-
-		manufacturer, err := dev.Manufacturer()
-		if err != nil {
+	}
+	if fields&FieldManufacturer != 0 {
+		if manufacturer, err = dev.Manufacturer(); err != nil {
 			manufacturer = "unknown"
 		}
+	}
 
-		// Determine generation based on VID/PID
-		desc := dev.Desc
-		generation := GenerationSDWireC // Default to SDWireC
-		if desc.Vendor == SDWire3VID && desc.Product == SDWire3PID {
-			generation = GenerationSDWire3
-		}
-
-		devices = append(devices, &DeviceInfo{
-			Serial:       serial,
-			Product:      product,
-			Manufacturer: manufacturer,
-			Generation:   generation,
-		})
+	return &DeviceInfo{
+		ID:              id,
+		Serial:          serial,
+		Product:         product,
+		Manufacturer:    manufacturer,
+		PortPath:        portPath,
+		Generation:      generationOf(desc),
+		FirmwareVersion: bcdString(desc.Device),
+		Identity:        lookupIdentity(serial, portPath),
 	}
+}
 
-	return devices, nil
+// serialOf returns the serial number of dev. ok is false if it cannot be
+// read or the device has none, as with a blank EEPROM.
+func serialOf(dev usbDevice) (serial string, ok bool) {
+	serial, err := dev.SerialNumber()
+	return serial, err == nil && serial != ""
 }
 
-// New connects to the first available SDWire device.
-// This is a convenience function for single-device setups.
+// New connects to the first available SDWire device, in the order of
+// ListDevices.
+// This is a convenience function for single-device setups. Devices that are
+// locked by another process are skipped unless locking is disabled.
 // The returned SDWire must be closed with Close() when done.
-func New() (*SDWire, error) {
-	devices, err := ListDevices()
+func New(opts ...Option) (*SDWire, error) {
+	devices, err := ListDevicesFields(0)
 	if err != nil {
 		return nil, err
 	}
 	if len(devices) == 0 {
-		return nil, fmt.Errorf("no SDWire devices found")
+		return nil, WithCode(CodeNotFound, fmt.Errorf("no SDWire devices found"))
+	}
+	for _, info := range devices {
+		s, err := OpenInfo(info, opts...)
+		if errors.Is(err, ErrDeviceLocked) {
+			continue
+		}
+		return s, err
+	}
+	return nil, fmt.Errorf("all %d SDWire devices are in use: %w", len(devices), ErrDeviceLocked)
+}
+
+// NewWithID connects to the device with the given ID, as reported in
+// DeviceInfo.ID: a serial number, or "port:" followed by a port path.
+// The returned SDWire must be closed with Close() when done.
+func NewWithID(id string, opts ...Option) (*SDWire, error) {
+	if portPath, ok := strings.CutPrefix(id, PortIDPrefix); ok {
+		return NewWithPortPath(portPath, opts...)
+	}
+	return NewWithSerial(id, opts...)
+}
+
+// OpenInfo connects to a listed device by whatever identifies it uniquely:
+// its port path if its serial number is shared, otherwise its ID. Prefer it
+// to NewWithSerial(info.Serial), which fails for such devices.
+// The returned SDWire must be closed with Close() when done.
+func OpenInfo(info *DeviceInfo, opts ...Option) (*SDWire, error) {
+	switch {
+	case info.DuplicateSerial:
+		return NewWithPortPath(info.PortPath, opts...)
+	case info.ID != "":
+		return NewWithID(info.ID, opts...)
 	}
-	return NewWithSerial(devices[0].Serial)
+	return NewWithSerial(info.Serial, opts...)
 }
 
 // NewWithSerial connects to a specific SDWire device by its serial number.
 // Use ListDevices() first to discover available devices and their serial numbers.
+// By default the device is locked against concurrent use by other processes
+// and ErrDeviceLocked is returned if it is already held; see WithBlockingLock
+// and WithoutLock to change this.
 // The returned SDWire must be closed with Close() when done.
-func NewWithSerial(serial string) (*SDWire, error) {
-	ctx := gousb.NewContext()
-	defer ctx.Close()
+func NewWithSerial(serial string, opts ...Option) (*SDWire, error) {
+	o := newOptions(opts)
 
-	devs, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
-		return (desc.Vendor == SDWireCVID && desc.Product == SDWireCPID) ||
-			(desc.Vendor == SDWire3VID && desc.Product == SDWire3PID)
-	})
+	// Where sysfs knows the serial numbers, open only the devices that
+	// have this one, leaving devices other jobs are using alone.
+	var match func(*deviceDesc) bool
+	if paths, ok := serialPortPaths(serial); ok {
+		match = func(desc *deviceDesc) bool {
+			return slices.Contains(paths, portPathOf(desc))
+		}
+	}
+	devs, err := openDetected(match)
 	if err != nil {
+		for _, dev := range devs {
+			dev.Close()
+		}
+		o.logger.Debug("device enumeration failed", "error", err)
 		return nil, fmt.Errorf("failed to find USB devices: %w", err)
 	}
 
+	var matches []usbDevice
 	for _, dev := range devs {
-		deviceSerial, err := dev.SerialNumber()
-		if err != nil {
+		deviceSerial, ok := serialOf(dev)
+		if ok && deviceSerial == serial {
+			matches = append(matches, dev)
+			continue
+		}
+		dev.Close()
+	}
+	switch len(matches) {
+	case 0:
+		o.logger.Debug("device not found", "serial", serial)
+		return nil, WithCode(CodeNotFound, fmt.Errorf("SDWire device with serial %s not found", serial))
+	case 1:
+		return open(matches[0], serial, o)
+	}
+
+	merr := &MultipleMatchesError{Serial: serial}
+	for _, dev := range matches {
+		merr.PortPaths = append(merr.PortPaths, portPathOf(dev.Descriptor()))
+		dev.Close()
+	}
+	sort.Slice(merr.PortPaths, func(i, j int) bool {
+		return ComparePortPaths(merr.PortPaths[i], merr.PortPaths[j]) < 0
+	})
+	o.logger.Debug("serial is ambiguous", "serial", serial, "ports", merr.PortPaths)
+	return nil, merr
+}
+
+// NewWithPortPath connects to the SDWire device plugged into the given
+// physical USB port, e.g. "1-2.3". This addresses devices whose serial
+// number is missing or shared with another device.
+// The returned SDWire must be closed with Close() when done.
+func NewWithPortPath(portPath string, opts ...Option) (*SDWire, error) {
+	return newAtPort(portPath, "", opts)
+}
+
+// NewWithSerialAt connects to the device with the given serial number
+// plugged into the given port, resolving a MultipleMatchesError from
+// NewWithSerial. It fails with CodeNotFound if the device at the port
+// reports a different serial number.
+// The returned SDWire must be closed with Close() when done.
+func NewWithSerialAt(serial, portPath string, opts ...Option) (*SDWire, error) {
+	return newAtPort(portPath, serial, opts)
+}
+
+// newAtPort opens the device at portPath, checking its serial number
+// against serial unless that is empty.
+func newAtPort(portPath, serial string, opts []Option) (*SDWire, error) {
+	o := newOptions(opts)
+
+	devs, err := openDetected(func(desc *deviceDesc) bool {
+		return portPathOf(desc) == portPath
+	})
+	if err != nil {
+		for _, dev := range devs {
 			dev.Close()
+		}
+		o.logger.Debug("device enumeration failed", "error", err)
+		return nil, fmt.Errorf("failed to find USB devices: %w", err)
+	}
+
+	var match usbDevice
+	for _, dev := range devs {
+		if match == nil && portPathOf(dev.Descriptor()) == portPath {
+			match = dev
 			continue
 		}
+		dev.Close()
+	}
+	if match == nil {
+		o.logger.Debug("device not found", "port", portPath)
+		return nil, WithCode(CodeNotFound, fmt.Errorf("SDWire device at port %s not found", portPath))
+	}
 
-		if deviceSerial == serial {
-			product, _ := dev.Product()
-			manufacturer, _ := dev.Manufacturer()
+	deviceSerial, ok := serialOf(match)
+	if !ok {
+		deviceSerial = "unknown"
+	}
+	if serial != "" && deviceSerial != serial {
+		match.Close()
+		o.logger.Debug("device not found", "serial", serial, "port", portPath, "found", deviceSerial)
+		return nil, WithCode(CodeNotFound, fmt.Errorf("SDWire device at port %s has serial %s, not %s", portPath, deviceSerial, serial))
+	}
+	return open(match, deviceSerial, o)
+}
 
-			// Determine generation based on VID/PID
-			desc := dev.Desc
-			generation := GenerationSDWireC // Default to SDWireC
-			if desc.Vendor == SDWire3VID && desc.Product == SDWire3PID {
-				generation = GenerationSDWire3
-			}
+// NewWithName connects to the device registered under the given lab name.
+// See SetRegistry.
+// The returned SDWire must be closed with Close() when done.
+func NewWithName(name string, opts ...Option) (*SDWire, error) {
+	devices, err := ListDevicesFields(0)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range devices {
+		if info.Name == name {
+			return OpenInfo(info, opts...)
+		}
+	}
+	return nil, WithCode(CodeNotFound, fmt.Errorf("SDWire device named %s not found", name))
+}
+
+// open wraps an opened USB device in an SDWire, taking the device lock and
+// selecting the controller for its generation. The device is closed on error.
+func open(dev usbDevice, serial string, o options) (*SDWire, error) {
+	gpio, isGPIO := dev.(*gpioDevice)
+	if o.faults != nil {
+		dev = &faultDevice{usbDevice: dev, faults: o.faults}
+	}
+	if o.record != "" {
+		dev = newRecordingDevice(dev, serial, o.record)
+	}
+	product, _ := dev.Product()
+	manufacturer, _ := dev.Manufacturer()
+	portPath := portPathOf(dev.Descriptor())
+	generation := generationOf(dev.Descriptor())
+	if isGPIO {
+		generation = GenerationGPIO
+	}
+	identity := lookupIdentity(serial, portPath)
+	timeouts := o.timeouts
+	if identity.Timeouts != nil {
+		timeouts = timeouts.merge(*identity.Timeouts)
+	}
+	timeouts = timeouts.merge(DefaultTimeouts(generation))
+	quirks := o.quirks | identity.Quirks
+	dev.SetControlTimeout(time.Duration(timeouts.Control))
+	log := o.logger.With("serial", serial, "port", portPath)
+	diag := newTransferRing(o.diag)
 
-			// Create appropriate controller based on generation
-			var controller DeviceController
-			switch generation {
-			case GenerationSDWireC:
-				controller = &sdwireCController{device: dev}
-			case GenerationSDWire3:
-				controller = &sdwire3Controller{device: dev}
-			default:
+	// Create appropriate controller based on generation
+	var controller DeviceController
+	switch generation {
+	case GenerationSDWireC:
+		c := &sdwireCController{
+			device:       dev,
+			log:          log,
+			diag:         diag,
+			quirks:       quirks,
+			stallRetries: o.stallRetries,
+			retry:        func() { o.metrics.Retry(serial, "control") },
+		}
+		if driver, _ := dev.InterfaceDriver(0); driver == ftdiSerialDriver {
+			if !o.detachSerial {
+				log.Warn("ftdi_sio is bound to the device; mode switches may fail intermittently", "hint", "open with WithDetachSerialDriver")
+			} else if err := c.detachSerialDriver(); err != nil {
 				dev.Close()
-				return nil, fmt.Errorf("unsupported device generation: %v", generation)
+				return nil, err
 			}
-
-			return &SDWire{
-				device:       dev,
-				serial:       deviceSerial,
-				product:      product,
-				manufacturer: manufacturer,
-				generation:   generation,
-				controller:   controller,
-			}, nil
 		}
+		controller = c
+	case GenerationSDWire3:
+		controller = &sdwire3Controller{
+			device:       dev,
+			log:          log,
+			diag:         diag,
+			resetTimeout: time.Duration(timeouts.Reset),
+			portPath:     portPath,
+			sysfs:        o.sysfsControl,
+		}
+	case GenerationGPIO:
+		controller = &gpioController{lines: gpio.lines, log: log, diag: diag}
+	default:
 		dev.Close()
+		return nil, WithCode(CodeUnsupported, fmt.Errorf("unsupported device generation: %v", generation))
+	}
+
+	key := lockKey(newDeviceID(serial, portPath, generation))
+	var lock *deviceLock
+	if o.lock {
+		var err error
+		lock, err = lockDevice(o.lockDir, key, o.lockWait, time.Duration(timeouts.Open))
+		if err != nil {
+			releaseController(controller)
+			dev.Close()
+			log.Debug("failed to lock device", "error", err)
+			if !errors.Is(err, ErrDeviceLocked) {
+				o.metrics.Error(serial, "open")
+			}
+			return nil, fmt.Errorf("failed to lock SDWire device %s: %w", serial, err)
+		}
+	}
+
+	log.Debug("opened device", "generation", generation, "locked", lock != nil)
+
+	s := &SDWire{
+		device:       dev,
+		serial:       serial,
+		product:      product,
+		manufacturer: manufacturer,
+		portPath:     portPath,
+		identity:     identity,
+		generation:   generation,
+		controller:   controller,
+		lock:         lock,
+		log:          log,
+		metrics:      o.metrics,
+		audit:        o.audit,
+		actor:        o.actor,
+		stats:        statsFor(key),
+		diag:         diag,
+		quirks:       quirks,
+		settle:       o.settle,
+		debounce:     o.debounce,
+		force:        o.force,
+		skipNoop:     o.skipNoop,
+	}
+	s.stats.handles(1)
+	trackHandle(s)
+	if o.watchdog > 0 {
+		s.StartWatchdog(o.watchdog)
+	}
+
+	if o.initialMode != nil {
+		if err := s.initialize(*o.initialMode); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to put SDWire device %s in %v mode: %w", serial, *o.initialMode, err)
+		}
 	}
+	return s, nil
+}
 
-	return nil, fmt.Errorf("SDWire device with serial %s not found", serial)
+// initialize drives a newly opened device to mode, verifying the result
+// where the generation supports reading the mode back.
+func (s *SDWire) initialize(mode SwitchMode) error {
+	err := s.SetModeVerified(mode)
+	if CodeOf(err) == CodeUnsupported {
+		s.log.Debug("initial mode set but not verified", "mode", mode, "error", err)
+		return nil
+	}
+	return err
 }
 
-// Close releases the USB device connection. Always call this when done with the device.
+// Close releases the USB device connection and the device lock.
+// Always call this when done with the device. Closing a closed device
+// does nothing.
 func (s *SDWire) Close() error {
+	s.cancelScheduled()
+	s.stopWatchdog()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	var err error
+	releaseController(s.controller)
 	if s.device != nil {
-		return s.device.Close()
+		err = s.device.Close()
 	}
-	return nil
+	if unlockErr := s.lock.unlock(); err == nil {
+		err = unlockErr
+	}
+	s.lock = nil
+	s.closed = true
+	s.stats.handles(-1)
+	if s.handle != nil {
+		untrackHandle(s.handle)
+		runtime.SetFinalizer(s, nil)
+		s.handle = nil
+	}
+	s.log.Debug("closed device", "error", err)
+	return err
 }
 
 // GetSerial returns the device's USB serial number.
@@ -243,20 +668,334 @@ func (s *SDWire) GetManufacturer() string {
 	return s.manufacturer
 }
 
-// String returns a formatted string with device information.
+// GetID returns the device ID; see DeviceInfo.ID.
+func (s *SDWire) GetID() string {
+	if s.serial == "" || s.serial == "unknown" {
+		return PortIDPrefix + s.portPath
+	}
+	return s.serial
+}
+
+// GetPortPath returns the device's physical USB port path, e.g. "1-2.3".
+func (s *SDWire) GetPortPath() string {
+	return s.portPath
+}
+
+// GetIdentity returns the device's lab identity from the registry.
+func (s *SDWire) GetIdentity() Identity {
+	return s.identity
+}
+
+// GetGeneration returns the device's hardware generation.
+func (s *SDWire) GetGeneration() DeviceGeneration {
+	return s.generation
+}
+
+// String describes the device on one line of space-separated key=value
+// fields, in a fixed order, e.g.
+//
+//	serial=bdgrd_sdwirec_522 generation=SDWireC port=1-2.3 mode=Host product="sd-wire" manufacturer="SRPOL"
+//
+// The mode is the last one this process switched the device to, and is
+// omitted if it has not switched it. See Verbose for a full dump.
 func (s *SDWire) String() string {
-	return fmt.Sprintf("%s\t[%s::%s]", s.serial, s.product, s.manufacturer)
+	var b strings.Builder
+	fmt.Fprintf(&b, "serial=%s generation=%s port=%s", s.serial, s.generation, s.portPath)
+	if stats := s.stats.snapshot(); !stats.LastModeChange.IsZero() {
+		fmt.Fprintf(&b, " mode=%s", stats.Mode)
+	}
+	fmt.Fprintf(&b, " product=%q manufacturer=%q", s.product, s.manufacturer)
+	return b.String()
+}
+
+// Verbose describes the device for diagnostic dumps, one "key: value" line
+// per field. Unlike String it reads the mode and firmware from the device.
+func (s *SDWire) Verbose() string {
+	var b strings.Builder
+	field := func(key string, value any) {
+		fmt.Fprintf(&b, "%-13s %v\n", key+":", value)
+	}
+	field("id", s.GetID())
+	field("serial", s.serial)
+	field("product", s.product)
+	field("manufacturer", s.manufacturer)
+	field("generation", s.generation)
+	field("port", s.portPath)
+	if s.identity.Name != "" {
+		field("name", s.identity.Name)
+	}
+	if mode, err := s.GetMode(); err != nil {
+		field("mode", "unknown ("+err.Error()+")")
+	} else {
+		field("mode", mode)
+	}
+	if fw, err := s.Firmware(); err != nil {
+		field("firmware", "unknown ("+err.Error()+")")
+	} else {
+		field("firmware", fw.Version)
+	}
+	field("quirks", s.quirks)
+	stats := s.stats.snapshot()
+	field("switches", stats.Switches)
+	field("errors", stats.Errors)
+	if !stats.LastModeChange.IsZero() {
+		field("last switch", fmt.Sprintf("%s at %s", stats.Mode, stats.LastModeChange.Format(time.RFC3339)))
+	}
+	return b.String()
 }
 
-// SetMode switches the SD card to the specified mode.
+// SetMode switches the SD card to the specified mode. Listeners registered
+// with OnModeChange are notified after a successful switch.
+//
+// With WithDebounce, switches issued too soon after the previous one wait
+// for the debounce window, and a repeat of the previous switch within the
+// window is skipped. With WithSettleDelay, SetMode returns only once the
+// card and DUT have had time to settle. With WithSkipNoop, a switch to the
+// mode the hardware already reports is skipped.
+//
+// Switching to ModeTarget fails with ErrCardBusy while the card is mounted
+// or open on the host, unless the device was opened WithForce.
 func (s *SDWire) SetMode(mode SwitchMode) error {
-	return s.controller.SetMode(mode)
+	switched, err := s.setMode(mode)
+	if !switched && err == nil {
+		return nil
+	}
+	s.Audit("set_mode", mode.String(), err)
+	if err != nil {
+		return s.withDiagnostics(err)
+	}
+	s.notifyModeChange(mode)
+	return nil
+}
+
+// setMode switches the card, reporting whether a switch was made.
+func (s *SDWire) setMode(mode SwitchMode) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.usable(); err != nil {
+		return false, err
+	}
+	if s.skipNoop {
+		if r, ok := s.controller.(modeReader); ok {
+			current, err := r.GetMode()
+			if err == nil && current == mode {
+				s.log.Debug("already in mode, not switching", "mode", mode)
+				return false, nil
+			}
+		}
+	}
+	if s.debounce > 0 && !s.lastSwitch.IsZero() {
+		if since := time.Since(s.lastSwitch); since < s.debounce {
+			if mode == s.lastMode {
+				s.log.Debug("coalesced repeated switch", "mode", mode, "since", since)
+				return false, nil
+			}
+			s.log.Debug("debouncing switch", "mode", mode, "wait", s.debounce-since)
+			time.Sleep(s.debounce - since)
+		}
+	}
+
+	if mode == ModeTarget && !s.force {
+		if err := s.cardBusy(); err != nil {
+			s.log.Debug("refusing to switch", "mode", mode, "error", err)
+			return false, err
+		}
+	}
+
+	start := time.Now()
+	err := s.controller.SetMode(mode)
+	elapsed := time.Since(start)
+	s.metrics.Latency("set_mode", elapsed)
+	RecordPerf("set_mode/"+s.generation.String(), elapsed)
+	if err != nil {
+		s.log.Debug("mode switch failed", "mode", mode, "duration", elapsed, "error", err)
+		s.metrics.Error(s.serial, "set_mode")
+		s.stats.failed(err)
+		return false, s.checkGone("set_mode", err)
+	}
+	s.log.Debug("switched mode", "mode", mode, "duration", elapsed)
+	s.metrics.Switch(s.serial, mode)
+	s.stats.switched(mode)
+	if s.settle > 0 {
+		time.Sleep(s.settle)
+	}
+	s.lastSwitch, s.lastMode = time.Now(), mode
+	return true, nil
+}
+
+// GetMode reads the current switch position back from the hardware, rather
+// than reporting the last mode set. On SDWireC the CBUS pin levels are read
+// from the FTDI chip. On SDWire3 the mode is derived from whether a storage
+// driver is bound to the card reader, which is possible on Linux, macOS
+// and FreeBSD; elsewhere an error with CodeUnsupported is returned.
+func (s *SDWire) GetMode() (SwitchMode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.usable(); err != nil {
+		return 0, err
+	}
+	r, ok := s.controller.(modeReader)
+	if !ok {
+		return 0, WithCode(CodeUnsupported, fmt.Errorf("reading the mode is not supported on %v", s.generation))
+	}
+	mode, err := r.GetMode()
+	if err != nil {
+		s.metrics.Error(s.serial, "get_mode")
+		return 0, s.withDiagnostics(s.checkGone("get_mode", err))
+	}
+	return mode, nil
+}
+
+// SetModeVerified is like SetMode, but then reads the switch position back
+// with GetMode and fails with ErrModeMismatch if the device did not switch.
+// Units have been seen to silently ignore the switch request after a
+// brown-out.
+func (s *SDWire) SetModeVerified(mode SwitchMode) error {
+	if err := s.SetMode(mode); err != nil {
+		return err
+	}
+	got, err := s.GetMode()
+	if err != nil {
+		return err
+	}
+	if got != mode {
+		err := WithCode(CodeVerifyFailed, fmt.Errorf("%w: switched to %v but device reports %v", ErrModeMismatch, mode, got))
+		s.log.Debug("mode verification failed", "mode", mode, "reported", got)
+		s.metrics.Error(s.serial, "set_mode")
+		s.Audit("verify_mode", mode.String(), err)
+		return s.withDiagnostics(err)
+	}
+	return nil
+}
+
+// Probe checks that the device still responds on the bus by issuing a
+// standard GET_STATUS request. It does not affect the switch state.
+func (s *SDWire) Probe() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.device == nil {
+		return fmt.Errorf("device not initialized")
+	}
+	if err := s.usable(); err != nil {
+		return err
+	}
+	status := make([]byte, 2)
+	start := time.Now()
+	n, err := s.device.Control(
+		controlIn|controlDevice,
+		usbRequestGetStatus,
+		0,
+		0,
+		status,
+	)
+	s.diag.add(Transfer{
+		Time:        start,
+		Op:          "control",
+		RequestType: controlIn | controlDevice,
+		Request:     usbRequestGetStatus,
+		Length:      n,
+		Duration:    time.Since(start),
+		Err:         err,
+	})
+	s.metrics.Latency("probe", time.Since(start))
+	RecordPerf("probe/"+s.generation.String(), time.Since(start))
+	s.log.Debug("control transfer", "request", "GET_STATUS", "error", err)
+	if err != nil {
+		s.metrics.Error(s.serial, "probe")
+		s.stats.failed(err)
+		return s.withDiagnostics(s.checkGone("probe", fmt.Errorf("failed to probe SDWire device: %w", err)))
+	}
+	return nil
 }
 
+// Control issues a raw USB control transfer to the device, for packages
+// such as eeprom that talk to the SDWireC's FTDI chip directly.
+func (s *SDWire) Control(rType, request uint8, value, index uint16, data []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.device == nil {
+		return 0, fmt.Errorf("device not initialized")
+	}
+	if err := s.usable(); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	n, err := s.device.Control(rType, request, value, index, data)
+	s.diag.add(Transfer{
+		Time:        start,
+		Op:          "control",
+		RequestType: rType,
+		Request:     request,
+		Value:       value,
+		Index:       index,
+		Length:      n,
+		Duration:    time.Since(start),
+		Err:         err,
+	})
+	s.log.Debug("control transfer", "type", rType, "request", request, "value", value, "index", index, "error", err)
+	return n, s.checkGone("control", err)
+}
 
 // sdwireCController implements DeviceController for SDWireC devices using FTDI control.
 type sdwireCController struct {
-	device *gousb.Device
+	device       usbDevice
+	log          *slog.Logger
+	diag         *transferRing
+	quirks       Quirks
+	stallRetries int
+	// retry is called before each stall recovery attempt.
+	retry func()
+	// cfg and intf hold the claim that keeps ftdi_sio detached.
+	cfg  usbConfig
+	intf io.Closer
+}
+
+// ftdiSerialDriver is the Linux kernel driver that binds to FTDI chips and
+// creates a ttyUSB device for them.
+const ftdiSerialDriver = "ftdi_sio"
+
+// detachSerialDriver detaches ftdi_sio by claiming the control interface
+// with auto-detach enabled. The claim is held until release, which lets
+// the kernel reattach the driver.
+func (c *sdwireCController) detachSerialDriver() error {
+	if err := c.device.SetAutoDetach(true); err != nil {
+		return fmt.Errorf("failed to enable auto-detach: %w", err)
+	}
+	cfg, err := c.device.Config(1)
+	if err == nil {
+		var intf io.Closer
+		if intf, err = cfg.Interface(0, 0); err == nil {
+			c.cfg, c.intf = cfg, intf
+			c.log.Debug("detached kernel driver", "driver", ftdiSerialDriver)
+			return nil
+		}
+		cfg.Close()
+	}
+	if code := CodeOf(err); code == CodePermission || code == CodeBusy {
+		return WithCode(code, fmt.Errorf("not permitted to detach %s from the SDWireC; run with access to the device or unbind the driver via sysfs: %w", ftdiSerialDriver, err))
+	}
+	return fmt.Errorf("failed to detach %s: %w", ftdiSerialDriver, err)
+}
+
+// releaseController gives up any interface claims the controller holds.
+func releaseController(c DeviceController) {
+	if r, ok := c.(interface{ release() }); ok {
+		r.release()
+	}
+}
+
+// release gives up the claim taken by detachSerialDriver, if any.
+func (c *sdwireCController) release() {
+	if c.intf != nil {
+		c.intf.Close()
+		c.cfg.Close()
+		c.intf, c.cfg = nil, nil
+		c.log.Debug("reattached kernel driver", "driver", ftdiSerialDriver)
+	}
 }
 
 // SetMode switches the SD card using FTDI bitmode control.
@@ -272,7 +1011,7 @@ func (c *sdwireCController) SetMode(mode SwitchMode) error {
 	case ModeHost:
 		target = 1
 	default:
-		return fmt.Errorf("invalid switch mode: %v", mode)
+		return WithCode(CodeInvalidArgument, fmt.Errorf("invalid switch mode: %v", mode))
 	}
 
 	// The Python code uses: ftdi.set_bitmode(0xF0 | target, Ftdi.BitMode.CBUS)
@@ -280,14 +1019,20 @@ func (c *sdwireCController) SetMode(mode SwitchMode) error {
 	// where mode = FTDI_SIO_BITMODE_CBUS (0x20) and mask = 0xF0 | target
 	value := uint16(ftdiSioBitmodeCbus<<8) | uint16(0xF0|target)
 
-	_, err := c.device.Control(
-		gousb.ControlOut|gousb.ControlVendor|gousb.ControlDevice,
-		ftdiSioSetBitmodeRequest,
-		value,
-		0,
-		nil,
-	)
+	if c.quirks.Has(QuirkResetBitmode) {
+		// Some clones only latch a CBUS bitmode after a bitmode reset.
+		if _, err := c.device.Control(
+			controlOut|controlVendor|controlDevice,
+			ftdiSioSetBitmodeRequest,
+			0,
+			0,
+			nil,
+		); err != nil {
+			return fmt.Errorf("failed to reset SDWire bitmode: %w", err)
+		}
+	}
 
+	_, err := c.control("SET_BITMODE", controlOut|controlVendor|controlDevice, ftdiSioSetBitmodeRequest, value, nil)
 	if err != nil {
 		return fmt.Errorf("failed to set SDWire mode: %w", err)
 	}
@@ -295,49 +1040,257 @@ func (c *sdwireCController) SetMode(mode SwitchMode) error {
 	return nil
 }
 
-// sdwire3Controller implements DeviceController for SDWire3 devices using kernel driver attach/detach.
+// GetMode reads the CBUS pin levels. CBUS0 selects the host when high.
+func (c *sdwireCController) GetMode() (SwitchMode, error) {
+	if c.device == nil {
+		return 0, fmt.Errorf("device not initialized")
+	}
+
+	pins := make([]byte, 1)
+	n, err := c.control("READ_PINS", controlIn|controlVendor|controlDevice, ftdiSioReadPinsRequest, 0, pins)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read SDWire pins: %w", err)
+	}
+	if n < 1 {
+		return 0, fmt.Errorf("failed to read SDWire pins: no data")
+	}
+	if pins[0]&0x01 != 0 {
+		return ModeHost, nil
+	}
+	return ModeTarget, nil
+}
+
+// control issues a control transfer to the FTDI chip. A transfer that
+// fails with a pipe error (a stall) is retried up to stallRetries times
+// after resetting the chip, which clears most intermittent failures.
+func (c *sdwireCController) control(name string, rType, request uint8, value uint16, data []byte) (int, error) {
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		n, err := c.device.Control(rType, request, value, 0, data)
+		c.diag.add(Transfer{
+			Time:        start,
+			Op:          "control",
+			RequestType: rType,
+			Request:     request,
+			Value:       value,
+			Length:      n,
+			Duration:    time.Since(start),
+			Err:         err,
+		})
+		c.log.Debug("control transfer", "request", name, "value", value, "error", err)
+		if CodeOf(err) != CodeUSBPipe || attempt >= c.stallRetries {
+			return n, err
+		}
+
+		c.log.Debug("recovering from stall", "request", name, "attempt", attempt+1)
+		c.retry()
+		start = time.Now()
+		_, rerr := c.device.Control(controlOut|controlVendor|controlDevice, ftdiSioResetRequest, 0, 0, nil)
+		c.diag.add(Transfer{
+			Time:        start,
+			Op:          "control",
+			RequestType: controlOut | controlVendor | controlDevice,
+			Request:     ftdiSioResetRequest,
+			Duration:    time.Since(start),
+			Err:         rerr,
+		})
+		if rerr != nil {
+			c.log.Debug("failed to reset FTDI chip", "error", rerr)
+			return n, err
+		}
+	}
+}
+
+// sdwire3Controller implements DeviceController for SDWire3 devices using
+// kernel driver attach/detach: the card reader serves the card to the host
+// while its storage driver is bound, and to the target while the driver is
+// detached.
 type sdwire3Controller struct {
-	device *gousb.Device
+	device       usbDevice
+	log          *slog.Logger
+	diag         *transferRing
+	resetTimeout time.Duration
+	portPath     string
+	// sysfs switches through the interface's authorized attribute; see
+	// WithSysfsControl.
+	sysfs bool
 }
 
+// driverPollInterval is how often the driver binding is checked while
+// waiting for an SDWire3 to settle after a switch.
+const driverPollInterval = 100 * time.Millisecond
+
 // SetMode switches the SD card using kernel driver attach/detach mechanism.
+// Where the driver binding can be read (Linux, macOS and FreeBSD), the
+// switch is skipped if the binding already matches mode, and verified
+// after switching.
 func (c *sdwire3Controller) SetMode(mode SwitchMode) error {
 	if c.device == nil {
 		return fmt.Errorf("device not initialized")
 	}
+	if mode != ModeHost && mode != ModeTarget {
+		return WithCode(CodeInvalidArgument, fmt.Errorf("invalid switch mode: %v", mode))
+	}
+	wantBound := mode == ModeHost
 
-	// Enable auto-detach so we can control kernel driver attachment
-	err := c.device.SetAutoDetach(true)
+	bound, err := c.driverBound()
+	if err == nil && bound == wantBound {
+		c.log.Debug("driver binding already matches mode", "mode", mode)
+		return nil
+	}
+
+	switch {
+	case c.sysfs:
+		err = c.authorize(wantBound)
+	case mode == ModeHost:
+		// Rebinding the storage driver takes milliseconds. Resetting
+		// re-enumerates the whole device, which takes seconds, so it is
+		// only the fallback.
+		if err = c.attach(); err != nil {
+			c.log.Debug("falling back to reset", "error", err)
+			err = c.reset()
+		}
+	default:
+		err = c.detach()
+	}
+	bindingChanges.Add(1)
 	if err != nil {
-		return fmt.Errorf("failed to enable auto-detach: %w", err)
+		return err
 	}
+	return c.waitForBinding(mode, wantBound)
+}
 
-	switch mode {
-	case ModeHost:
-		// Switch to TS mode: ensure kernel driver is attached (don't claim interface)
-		// Just reset the device - kernel driver should reattach automatically
-		return c.device.Reset()
+// bindingChanges counts the driver binding changes this process made, so
+// that platforms caching the bindings read them again afterwards.
+var bindingChanges atomic.Uint64
 
-	case ModeTarget:
-		// Switch to DUT mode: detach kernel driver by claiming interface 0, then reset
-		cfg, err := c.device.Config(1)
-		if err != nil {
-			// If we can't get config, just reset - might work anyway
-			return c.device.Reset()
-		}
-		defer cfg.Close()
+// sdwire3StorageDrivers are the kernel drivers that serve the SDWire3
+// card reader to the host.
+var sdwire3StorageDrivers = map[string]bool{
+	"usb-storage": true,
+	"uas":         true,
+	"rtsx_usb":    true,
+	// FreeBSD.
+	"umass": true,
+	// macOS IOKit classes.
+	"IOUSBMassStorageDriver":       true,
+	"IOUSBMassStorageInterfaceNub": true,
+	"IOUSBMassStorageUASDriver":    true,
+}
 
-		// Claim interface 0 to detach kernel driver
-		intf, err := cfg.Interface(0, 0)
-		if err == nil {
-			// Successfully claimed interface (kernel driver detached)
-			intf.Close() // Release interface but keep kernel driver detached
-		}
+// driverBound reports whether a storage driver is bound to the card
+// reader. Other drivers are reported as an error, since they leave the
+// mode undefined.
+func (c *sdwire3Controller) driverBound() (bool, error) {
+	driver, err := c.device.InterfaceDriver(0)
+	if err != nil {
+		return false, err
+	}
+	if driver != "" && !sdwire3StorageDrivers[driver] {
+		return false, fmt.Errorf("unexpected driver %q bound to SDWire3 card reader", driver)
+	}
+	return driver != "", nil
+}
 
-		// Reset the device
-		return c.device.Reset()
+// GetMode reads the mode from the card reader's driver binding in sysfs.
+func (c *sdwire3Controller) GetMode() (SwitchMode, error) {
+	if c.device == nil {
+		return 0, fmt.Errorf("device not initialized")
+	}
+	bound, err := c.driverBound()
+	if errors.Is(err, errDriverUnknown) {
+		return 0, WithCode(CodeUnsupported, fmt.Errorf("reading the SDWire3 mode is not supported on this platform: %w", err))
+	}
+	if err != nil {
+		return 0, err
+	}
+	c.log.Debug("read driver binding", "bound", bound)
+	if bound {
+		return ModeHost, nil
+	}
+	return ModeTarget, nil
+}
 
-	default:
-		return fmt.Errorf("invalid switch mode: %v", mode)
+// detach detaches the kernel driver from the card reader by claiming its
+// interface with auto-detach enabled. Auto-detach is disabled again before
+// the interface is released, so the driver stays detached without the
+// SDK holding a claim.
+func (c *sdwire3Controller) detach() error {
+	if err := c.device.SetAutoDetach(true); err != nil {
+		return fmt.Errorf("failed to enable auto-detach: %w", err)
 	}
+	cfg, err := c.device.Config(1)
+	if err != nil {
+		return fmt.Errorf("failed to claim SDWire3 configuration: %w", err)
+	}
+	defer cfg.Close()
+
+	intf, err := cfg.Interface(0, 0)
+	if err != nil {
+		return fmt.Errorf("failed to claim SDWire3 interface: %w", err)
+	}
+	if err := c.device.SetAutoDetach(false); err != nil {
+		intf.Close()
+		return fmt.Errorf("failed to disable auto-detach: %w", err)
+	}
+	return intf.Close()
+}
+
+// attach binds the storage driver to the card reader again, through the
+// backend where it supports this and through sysfs otherwise.
+func (c *sdwire3Controller) attach() error {
+	start := time.Now()
+	var err error
+	if a, ok := c.device.(driverAttacher); ok {
+		err = a.AttachDriver(0)
+	} else {
+		err = probeInterfaceDriver(c.portPath, 0)
+	}
+	c.diag.add(Transfer{Time: start, Op: "attach", Duration: time.Since(start), Err: err})
+	c.log.Debug("attached driver", "duration", time.Since(start), "error", err)
+	return err
+}
+
+// authorize deauthorizes the card reader interface, which unbinds its
+// driver and keeps it unbound, or authorizes it again so that the kernel
+// probes drivers for it.
+func (c *sdwire3Controller) authorize(authorized bool) error {
+	start := time.Now()
+	err := setInterfaceAuthorized(c.portPath, 0, authorized)
+	value := uint16(0)
+	if authorized {
+		value = 1
+	}
+	c.diag.add(Transfer{Time: start, Op: "authorize", Value: value, Duration: time.Since(start), Err: err})
+	c.log.Debug("wrote interface authorization", "authorized", authorized, "error", err)
+	return err
+}
+
+// waitForBinding waits for the driver binding to match mode, allowing the
+// device time to re-enumerate. It returns nil without waiting where the
+// binding cannot be read.
+func (c *sdwire3Controller) waitForBinding(mode SwitchMode, wantBound bool) error {
+	deadline := time.Now().Add(c.resetTimeout)
+	for {
+		bound, err := c.driverBound()
+		switch {
+		case errors.Is(err, errDriverUnknown):
+			return nil
+		case err == nil && bound == wantBound:
+			return nil
+		case time.Now().After(deadline):
+			c.log.Debug("driver binding did not settle", "mode", mode, "bound", bound, "error", err)
+			return WithCode(CodeVerifyFailed, fmt.Errorf("%w: driver binding did not match %v within %v", ErrModeMismatch, mode, c.resetTimeout))
+		}
+		time.Sleep(driverPollInterval)
+	}
+}
+
+// reset performs a USB port reset.
+func (c *sdwire3Controller) reset() error {
+	start := time.Now()
+	err := runWithTimeout(c.resetTimeout, "USB reset", c.device.Reset)
+	c.diag.add(Transfer{Time: start, Op: "reset", Duration: time.Since(start), Err: err})
+	c.log.Debug("reset device", "duration", time.Since(start), "error", err)
+	return err
 }
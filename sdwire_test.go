@@ -0,0 +1,29 @@
+package sdwire
+
+import "testing"
+
+func TestDescribeDeviceWithoutSerial(t *testing.T) {
+	// A blank EEPROM has no serial number string, which backends report
+	// as an empty serial rather than an error.
+	dev := &simDevice{sim: &Simulator{Path: []int{2, 3}}}
+	info := describeDevice(dev, 0)
+
+	if want := PortIDPrefix + "1-2.3"; info.ID != want {
+		t.Errorf("ID = %q, want %q", info.ID, want)
+	}
+	if info.Serial != "unknown" {
+		t.Errorf("Serial = %q, want %q", info.Serial, "unknown")
+	}
+}
+
+func TestDescribeDeviceWithSerial(t *testing.T) {
+	dev := &simDevice{sim: &Simulator{Serial: "sdwire_11", Path: []int{2, 3}}}
+	info := describeDevice(dev, 0)
+
+	if info.ID != "sdwire_11" || info.Serial != "sdwire_11" {
+		t.Errorf("ID, Serial = %q, %q, want sdwire_11 for both", info.ID, info.Serial)
+	}
+	if info.PortPath != "1-2.3" {
+		t.Errorf("PortPath = %q, want %q", info.PortPath, "1-2.3")
+	}
+}
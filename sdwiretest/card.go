@@ -0,0 +1,94 @@
+package sdwiretest
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/fcjr/sdwire"
+)
+
+// ErrCardNotOnHost is returned when the card is accessed while it is not
+// switched to the host.
+var ErrCardNotOnHost = errors.New("SD card is not connected to the host")
+
+// Card is an in-memory SD card. It implements io.ReaderAt and io.WriterAt,
+// and only allows access while its device is switched to the host, like
+// the block device a real SDWire exposes.
+type Card struct {
+	device *Device
+
+	mu   sync.Mutex
+	data []byte
+}
+
+// NewCard creates a zero-filled card of size bytes, not attached to any
+// device and therefore always accessible.
+func NewCard(size int) *Card {
+	return &Card{data: make([]byte, size)}
+}
+
+// Size returns the card capacity in bytes.
+func (c *Card) Size() int64 {
+	return int64(len(c.data))
+}
+
+// Bytes returns a copy of the card contents regardless of mode, for
+// inspecting results.
+func (c *Card) Bytes() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte(nil), c.data...)
+}
+
+func (c *Card) accessible() error {
+	if c.device == nil {
+		return nil
+	}
+	mode, ok := c.device.Mode()
+	if !ok || mode != sdwire.ModeHost {
+		return ErrCardNotOnHost
+	}
+	return nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (c *Card) ReadAt(p []byte, off int64) (int, error) {
+	if err := c.accessible(); err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= int64(len(c.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, c.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt implements io.WriterAt. Writes past the end of the card fail
+// with io.ErrShortWrite.
+func (c *Card) WriteAt(p []byte, off int64) (int, error) {
+	if err := c.accessible(); err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= int64(len(c.data)) {
+		return 0, io.ErrShortWrite
+	}
+	n := copy(c.data[off:], p)
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
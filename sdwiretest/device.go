@@ -0,0 +1,137 @@
+package sdwiretest
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/fcjr/sdwire"
+)
+
+// ErrUnplugged is returned by operations on a device that was removed.
+var ErrUnplugged = sdwire.WithCode(sdwire.CodeDeviceGone, errors.New("device was unplugged"))
+
+// Device is a fake SDWire device.
+type Device struct {
+	info sdwire.DeviceInfo
+	card *Card
+
+	mu        sync.Mutex
+	mode      sdwire.SwitchMode
+	modeSet   bool
+	switches  []sdwire.SwitchMode
+	errs      []error
+	open      bool
+	unplugged bool
+}
+
+// Info returns the device's information.
+func (d *Device) Info() sdwire.DeviceInfo {
+	return d.info
+}
+
+// Card returns the device's SD card.
+func (d *Device) Card() *Card {
+	return d.card
+}
+
+// Mode returns the current mode, and false if the device has not been
+// switched yet.
+func (d *Device) Mode() (sdwire.SwitchMode, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.mode, d.modeSet
+}
+
+// SetInitialMode sets the mode without recording a switch, as if the
+// device had been switched before the test started.
+func (d *Device) SetInitialMode(mode sdwire.SwitchMode) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.mode, d.modeSet = mode, true
+}
+
+// Switches returns the modes switched to so far, in order.
+func (d *Device) Switches() []sdwire.SwitchMode {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]sdwire.SwitchMode(nil), d.switches...)
+}
+
+// IsOpen reports whether the device is currently open.
+func (d *Device) IsOpen() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.open
+}
+
+// FailNext makes the next operations on the device (Open, SetMode or
+// Probe) fail with the given errors, one per operation.
+func (d *Device) FailNext(errs ...error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.errs = append(d.errs, errs...)
+}
+
+// takeErr returns the next injected error. Callers must hold d.mu.
+func (d *Device) takeErr() error {
+	if d.unplugged {
+		return ErrUnplugged
+	}
+	if len(d.errs) == 0 {
+		return nil
+	}
+	err := d.errs[0]
+	d.errs = d.errs[1:]
+	return err
+}
+
+// handle is an open fake device.
+type handle struct {
+	device *Device
+	closed bool
+}
+
+var _ sdwire.Device = (*handle)(nil)
+
+func (h *handle) SetMode(mode sdwire.SwitchMode) error {
+	d := h.device
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if h.closed {
+		return errors.New("device not initialized")
+	}
+	if mode != sdwire.ModeHost && mode != sdwire.ModeTarget {
+		return sdwire.WithCode(sdwire.CodeInvalidArgument, errors.New("invalid switch mode"))
+	}
+	if err := d.takeErr(); err != nil {
+		return err
+	}
+	d.mode, d.modeSet = mode, true
+	d.switches = append(d.switches, mode)
+	return nil
+}
+
+func (h *handle) Probe() error {
+	d := h.device
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if h.closed {
+		return errors.New("device not initialized")
+	}
+	return d.takeErr()
+}
+
+func (h *handle) GetSerial() string                      { return h.device.info.Serial }
+func (h *handle) GetPortPath() string                    { return h.device.info.PortPath }
+func (h *handle) GetGeneration() sdwire.DeviceGeneration { return h.device.info.Generation }
+
+func (h *handle) Close() error {
+	d := h.device
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !h.closed {
+		h.closed = true
+		d.open = false
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package sdwiretest
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/fcjr/sdwire"
+)
+
+// RequireHardwareEnv is the environment variable that turns the skips of
+// RequireDevice into failures, for CI runners that are known to have
+// hardware attached.
+const RequireHardwareEnv = "SDWIRE_REQUIRE_HARDWARE"
+
+// RequireOptions selects the device for RequireDevice.
+type RequireOptions struct {
+	// Selector restricts the device by tags and identity; see
+	// sdwire.Selector. Empty matches any device.
+	Selector string
+	// Restore is the mode the device is switched to when the test ends.
+	Restore sdwire.SwitchMode
+	// Options are passed on to open the device.
+	Options []sdwire.Option
+}
+
+// RequireDevice opens a real device for a hardware-in-the-loop test. The
+// first matching device that is not locked by another process is used,
+// and it stays locked for the duration of the test. The test is skipped if
+// no device matches or all matching devices are in use, unless
+// SDWIRE_REQUIRE_HARDWARE is set, in which case it fails.
+//
+// When the test ends the device is switched to opts.Restore and closed.
+func RequireDevice(t testing.TB, opts RequireOptions) *sdwire.SDWire {
+	t.Helper()
+	sel, err := sdwire.ParseSelector(opts.Selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := sdwire.ListDevices()
+	if err != nil {
+		skipOrFail(t, "cannot list SDWire devices: %v", err)
+	}
+	candidates := sel.Filter(devices)
+	if len(candidates) == 0 {
+		skipOrFail(t, "no SDWire device matches %q", opts.Selector)
+	}
+
+	for _, info := range candidates {
+		dev, err := sdwire.NewWithPortPath(info.PortPath, opts.Options...)
+		if errors.Is(err, sdwire.ErrDeviceLocked) {
+			continue
+		}
+		if err != nil {
+			t.Fatalf("failed to open SDWire device %s: %v", info.Serial, err)
+		}
+		t.Cleanup(func() {
+			if err := dev.SetMode(opts.Restore); err != nil {
+				t.Errorf("failed to restore SDWire device %s to %v: %v", dev.GetSerial(), opts.Restore, err)
+			}
+			dev.Close()
+		})
+		return dev
+	}
+	skipOrFail(t, "all SDWire devices matching %q are in use", opts.Selector)
+	return nil
+}
+
+func skipOrFail(t testing.TB, format string, args ...any) {
+	t.Helper()
+	if os.Getenv(RequireHardwareEnv) != "" {
+		t.Fatalf(format, args...)
+	}
+	t.Skipf(format, args...)
+}
@@ -0,0 +1,212 @@
+package sdwiretest
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fcjr/sdwire"
+)
+
+// ErrTimeout is a convenience error for scenarios, classified like a USB
+// transfer timeout.
+var ErrTimeout = sdwire.WithCode(sdwire.CodeUSBTimeout, errors.New("simulated USB timeout"))
+
+// Scenario is a scripted fake controller. It is built fluently, e.g.
+//
+//	s := sdwiretest.NewScenario().
+//		Succeed(2).
+//		Fail(sdwiretest.ErrTimeout).
+//		Delay(200 * time.Millisecond)
+//
+// Each SetMode call consumes the next scripted step; once the script is
+// exhausted switches succeed. A Scenario implements both
+// sdwire.DeviceController and sdwire.Device, and records the switches made
+// so tests can assert on them afterwards.
+type Scenario struct {
+	mu       sync.Mutex
+	info     sdwire.DeviceInfo
+	steps    []scenarioStep
+	delay    time.Duration
+	mode     sdwire.SwitchMode
+	modeSet  bool
+	calls    int
+	switches []sdwire.SwitchMode
+	closed   bool
+}
+
+type scenarioStep struct {
+	err    error
+	report *sdwire.SwitchMode
+}
+
+var (
+	_ sdwire.DeviceController = (*Scenario)(nil)
+	_ sdwire.Device           = (*Scenario)(nil)
+)
+
+// NewScenario creates a scenario for an SDWireC with serial "scenario" and
+// an empty script.
+func NewScenario() *Scenario {
+	return &Scenario{info: sdwire.DeviceInfo{
+		Serial:     "scenario",
+		PortPath:   "1-1",
+		Generation: sdwire.GenerationSDWireC,
+	}}
+}
+
+// WithInfo sets the identity the scenario reports.
+func (s *Scenario) WithInfo(info sdwire.DeviceInfo) *Scenario {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.info = info
+	return s
+}
+
+// Succeed scripts n successful switches.
+func (s *Scenario) Succeed(n int) *Scenario {
+	return s.add(n, scenarioStep{})
+}
+
+// Fail scripts one switch failing with err.
+func (s *Scenario) Fail(err error) *Scenario {
+	return s.add(1, scenarioStep{err: err})
+}
+
+// FailTimes scripts n switches failing with err.
+func (s *Scenario) FailTimes(n int, err error) *Scenario {
+	return s.add(n, scenarioStep{err: err})
+}
+
+// Report scripts one switch that succeeds but leaves the device in mode,
+// like hardware that ignored the request.
+func (s *Scenario) Report(mode sdwire.SwitchMode) *Scenario {
+	return s.add(1, scenarioStep{report: &mode})
+}
+
+// StartIn sets the mode the device is in before the first switch.
+func (s *Scenario) StartIn(mode sdwire.SwitchMode) *Scenario {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mode, s.modeSet = mode, true
+	return s
+}
+
+// Delay makes every switch take d.
+func (s *Scenario) Delay(d time.Duration) *Scenario {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+	return s
+}
+
+func (s *Scenario) add(n int, step scenarioStep) *Scenario {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for range n {
+		s.steps = append(s.steps, step)
+	}
+	return s
+}
+
+// SetMode runs the next step of the script.
+func (s *Scenario) SetMode(mode sdwire.SwitchMode) error {
+	s.mu.Lock()
+	delay := s.delay
+	s.mu.Unlock()
+	time.Sleep(delay)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("device not initialized")
+	}
+	if mode != sdwire.ModeHost && mode != sdwire.ModeTarget {
+		return sdwire.WithCode(sdwire.CodeInvalidArgument, fmt.Errorf("invalid switch mode: %v", mode))
+	}
+	s.calls++
+	var step scenarioStep
+	if len(s.steps) > 0 {
+		step, s.steps = s.steps[0], s.steps[1:]
+	}
+	if step.err != nil {
+		return step.err
+	}
+	s.switches = append(s.switches, mode)
+	s.mode, s.modeSet = mode, true
+	if step.report != nil {
+		s.mode = *step.report
+	}
+	return nil
+}
+
+// Probe succeeds while the scenario is open.
+func (s *Scenario) Probe() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("device not initialized")
+	}
+	return nil
+}
+
+func (s *Scenario) GetSerial() string                      { return s.info.Serial }
+func (s *Scenario) GetPortPath() string                    { return s.info.PortPath }
+func (s *Scenario) GetGeneration() sdwire.DeviceGeneration { return s.info.Generation }
+
+// Close closes the scenario; later switches fail.
+func (s *Scenario) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+// Mode returns the mode the device reports, and false if it has not been
+// switched or started in a mode.
+func (s *Scenario) Mode() (sdwire.SwitchMode, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mode, s.modeSet
+}
+
+// Calls returns the number of SetMode calls with a valid mode, including
+// failed ones.
+func (s *Scenario) Calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+// AssertSwitches fails the test unless exactly the given successful
+// switches were made, in order.
+func (s *Scenario) AssertSwitches(t testing.TB, modes ...sdwire.SwitchMode) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !slices.Equal(s.switches, modes) {
+		t.Errorf("switches = %v, want %v", s.switches, modes)
+	}
+}
+
+// AssertMode fails the test unless the device reports mode.
+func (s *Scenario) AssertMode(t testing.TB, mode sdwire.SwitchMode) {
+	t.Helper()
+	got, ok := s.Mode()
+	if !ok || got != mode {
+		t.Errorf("mode = %v, want %v", got, mode)
+	}
+}
+
+// AssertDone fails the test if scripted steps were not consumed.
+func (s *Scenario) AssertDone(t testing.TB) {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.steps) > 0 {
+		t.Errorf("%d scripted steps were not run", len(s.steps))
+	}
+}
@@ -0,0 +1,112 @@
+// Package sdwiretest provides in-memory fakes of SDWire devices for testing
+// code built on this SDK without hardware.
+//
+// A Manager holds a scriptable list of fake devices. Each Device tracks its
+// mode, can be told to fail, and carries an in-memory Card that is only
+// accessible while switched to the host.
+//
+// For hardware-in-the-loop tests, RequireDevice acquires a real device.
+package sdwiretest
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/fcjr/sdwire"
+)
+
+// Manager is a fake sdwire.Manager.
+type Manager struct {
+	mu      sync.Mutex
+	devices map[string]*Device
+	listErr error
+}
+
+var _ sdwire.Manager = (*Manager)(nil)
+
+// NewManager creates a manager with no devices.
+func NewManager() *Manager {
+	return &Manager{devices: make(map[string]*Device)}
+}
+
+// Add plugs in a fake device with a card of cardSize bytes. The port path
+// and generation default to a unique port and SDWireC.
+func (m *Manager) Add(info sdwire.DeviceInfo, cardSize int) *Device {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if info.PortPath == "" {
+		info.PortPath = fmt.Sprintf("1-%d", len(m.devices)+1)
+	}
+	if info.Product == "" {
+		info.Product = sdwire.SDWireCProductName
+	}
+	d := &Device{info: info, card: NewCard(cardSize)}
+	d.card.device = d
+	m.devices[info.Serial] = d
+	return d
+}
+
+// Remove unplugs the device with the given serial. Operations on an open
+// handle fail afterwards.
+func (m *Manager) Remove(serial string) {
+	m.mu.Lock()
+	d, ok := m.devices[serial]
+	delete(m.devices, serial)
+	m.mu.Unlock()
+	if ok {
+		d.mu.Lock()
+		d.unplugged = true
+		d.mu.Unlock()
+	}
+}
+
+// Device returns the fake device with the given serial, or nil.
+func (m *Manager) Device(serial string) *Device {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.devices[serial]
+}
+
+// FailList makes ListDevices return err until it is called with nil.
+func (m *Manager) FailList(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listErr = err
+}
+
+// ListDevices returns the plugged-in devices in the same order as
+// sdwire.ListDevices.
+func (m *Manager) ListDevices() ([]*sdwire.DeviceInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.listErr != nil {
+		return nil, m.listErr
+	}
+	devices := make([]*sdwire.DeviceInfo, 0, len(m.devices))
+	for _, d := range m.devices {
+		info := d.info
+		devices = append(devices, &info)
+	}
+	sdwire.SortByPortPath(devices)
+	return devices, nil
+}
+
+// Open opens the device with the given serial. Options are ignored. Like
+// real devices, a device can only be open once at a time; a second Open
+// fails with sdwire.ErrDeviceLocked.
+func (m *Manager) Open(serial string, opts ...sdwire.Option) (sdwire.Device, error) {
+	d := m.Device(serial)
+	if d == nil {
+		return nil, sdwire.WithCode(sdwire.CodeNotFound, fmt.Errorf("SDWire device with serial %s not found", serial))
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.open {
+		return nil, fmt.Errorf("failed to lock SDWire device %s: %w", serial, sdwire.ErrDeviceLocked)
+	}
+	if err := d.takeErr(); err != nil {
+		return nil, err
+	}
+	d.open = true
+	return &handle{device: d}, nil
+}
@@ -0,0 +1,257 @@
+package sdwire
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Selector matches devices against an expression over their tags and
+// identity, such as:
+//
+//	model=rpi4 && rack=3
+//	team=bsp && !(generation=SDWire3 || retired)
+//
+// Comparisons use = (or ==) and !=. A bare key matches devices that have
+// the tag. Besides registry tags, the keys serial, name, model, rack,
+// generation and port_path refer to the corresponding DeviceInfo fields;
+// a registry tag with the same name takes precedence.
+type Selector struct {
+	expr string
+	root selectorNode
+}
+
+// ParseSelector compiles a selector expression. An empty expression matches
+// every device.
+func ParseSelector(expr string) (*Selector, error) {
+	p := &selectorParser{tokens: tokenizeSelector(expr)}
+	s := &Selector{expr: expr}
+	if len(p.tokens) == 0 {
+		return s, nil
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, WithCode(CodeInvalidArgument, fmt.Errorf("invalid selector %q: %w", expr, err))
+	}
+	if p.pos < len(p.tokens) {
+		return nil, WithCode(CodeInvalidArgument, fmt.Errorf("invalid selector %q: unexpected %q", expr, p.tokens[p.pos]))
+	}
+	s.root = root
+	return s, nil
+}
+
+// Match reports whether the device satisfies the selector.
+func (s *Selector) Match(info *DeviceInfo) bool {
+	if s.root == nil {
+		return true
+	}
+	return s.root.match(info)
+}
+
+// String returns the source expression.
+func (s *Selector) String() string {
+	return s.expr
+}
+
+// Filter returns the devices matching the selector, preserving order.
+func (s *Selector) Filter(devices []*DeviceInfo) []*DeviceInfo {
+	var matched []*DeviceInfo
+	for _, d := range devices {
+		if s.Match(d) {
+			matched = append(matched, d)
+		}
+	}
+	return matched
+}
+
+// selectorValue looks up a key for a device.
+func selectorValue(info *DeviceInfo, key string) (string, bool) {
+	if v, ok := info.Tags[key]; ok {
+		return v, true
+	}
+	switch key {
+	case "serial":
+		return info.Serial, true
+	case "name":
+		return info.Name, info.Name != ""
+	case "model":
+		return info.Model, info.Model != ""
+	case "rack":
+		return info.Rack, info.Rack != ""
+	case "generation":
+		return info.Generation.String(), true
+	case "port_path":
+		return info.PortPath, true
+	}
+	return "", false
+}
+
+type selectorNode interface {
+	match(info *DeviceInfo) bool
+}
+
+type (
+	orNode  struct{ left, right selectorNode }
+	andNode struct{ left, right selectorNode }
+	notNode struct{ node selectorNode }
+	hasNode struct{ key string }
+	cmpNode struct {
+		key, value string
+		negate     bool
+	}
+)
+
+func (n orNode) match(info *DeviceInfo) bool  { return n.left.match(info) || n.right.match(info) }
+func (n andNode) match(info *DeviceInfo) bool { return n.left.match(info) && n.right.match(info) }
+func (n notNode) match(info *DeviceInfo) bool { return !n.node.match(info) }
+
+func (n hasNode) match(info *DeviceInfo) bool {
+	_, ok := selectorValue(info, n.key)
+	return ok
+}
+
+func (n cmpNode) match(info *DeviceInfo) bool {
+	v, _ := selectorValue(info, n.key)
+	return (v == n.value) != n.negate
+}
+
+// tokenizeSelector splits an expression into operators and words.
+// Words may be double-quoted to include spaces or operator characters.
+func tokenizeSelector(expr string) []string {
+	var tokens []string
+	rs := []rune(expr)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case strings.HasPrefix(string(rs[i:]), "&&"), strings.HasPrefix(string(rs[i:]), "||"),
+			strings.HasPrefix(string(rs[i:]), "=="), strings.HasPrefix(string(rs[i:]), "!="):
+			tokens = append(tokens, string(rs[i:i+2]))
+			i += 2
+		case r == '(' || r == ')' || r == '!' || r == '=':
+			tokens = append(tokens, string(r))
+			i++
+		case r == '"':
+			j := i + 1
+			for j < len(rs) && rs[j] != '"' {
+				j++
+			}
+			// Keep the opening quote so quoted words are never taken as operators.
+			tokens = append(tokens, string(rs[i:j]))
+			i = j + 1
+		default:
+			j := i
+			for j < len(rs) && !unicode.IsSpace(rs[j]) && !strings.ContainsRune("()!=&|\"", rs[j]) {
+				j++
+			}
+			if j == i {
+				// A lone '&' or '|'.
+				j++
+			}
+			tokens = append(tokens, string(rs[i:j]))
+			i = j
+		}
+	}
+	return tokens
+}
+
+type selectorParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *selectorParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *selectorParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *selectorParser) parseOr() (selectorNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *selectorParser) parseAnd() (selectorNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *selectorParser) parseUnary() (selectorNode, error) {
+	switch p.peek() {
+	case "!":
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	case "(":
+		p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("missing )")
+		}
+		return n, nil
+	}
+
+	key, err := p.word()
+	if err != nil {
+		return nil, err
+	}
+	switch p.peek() {
+	case "=", "==", "!=":
+		negate := p.next() == "!="
+		value, err := p.word()
+		if err != nil {
+			return nil, err
+		}
+		return cmpNode{key: key, value: value, negate: negate}, nil
+	}
+	return hasNode{key: key}, nil
+}
+
+func (p *selectorParser) word() (string, error) {
+	t := p.next()
+	switch t {
+	case "", "&&", "||", "==", "!=", "(", ")", "!", "=":
+		if t == "" {
+			return "", errors.New("unexpected end of expression")
+		}
+		return "", fmt.Errorf("unexpected %q", t)
+	}
+	return strings.TrimPrefix(t, `"`), nil
+}
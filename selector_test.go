@@ -0,0 +1,57 @@
+package sdwire_test
+
+import (
+	"testing"
+
+	"github.com/fcjr/sdwire"
+)
+
+func TestSelector(t *testing.T) {
+	info := &sdwire.DeviceInfo{
+		Serial:     "sdwire_11",
+		Generation: sdwire.GenerationSDWireC,
+		PortPath:   "1-2",
+		Identity:   sdwire.Identity{Model: "rpi4", Rack: "3", Tags: map[string]string{"spare": ""}},
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"", true},
+		{"model=rpi4", true},
+		{"model==rpi4", true},
+		{"model!=rpi4", false},
+		{"model=rpi4 && rack=3", true},
+		{"model=rpi4 && rack=4", false},
+		{"model=rpi5 || rack=3", true},
+		{"spare", true},
+		{"retired", false},
+		{"!retired && serial=sdwire_11", true},
+		{"!(generation=SDWireC || retired)", false},
+		{"port_path=1-2", true},
+	}
+	for _, tt := range tests {
+		s, err := sdwire.ParseSelector(tt.expr)
+		if err != nil {
+			t.Errorf("ParseSelector(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := s.Match(info); got != tt.want {
+			t.Errorf("%q matched = %t, want %t", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestSelectorInvalid(t *testing.T) {
+	for _, expr := range []string{"model=", "(rack=3", "rack=3 &&", "rack=3 )"} {
+		_, err := sdwire.ParseSelector(expr)
+		if err == nil {
+			t.Errorf("ParseSelector(%q) succeeded, want an error", expr)
+			continue
+		}
+		if code := sdwire.CodeOf(err); code != sdwire.CodeInvalidArgument {
+			t.Errorf("ParseSelector(%q) code = %v, want %v", expr, code, sdwire.CodeInvalidArgument)
+		}
+	}
+}
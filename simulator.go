@@ -0,0 +1,242 @@
+package sdwire
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Simulator emulates an SDWire and its SD card without hardware. The card
+// is backed by a regular file; while the simulator is switched to the host
+// the card appears at DevicePath, standing in for the block device a real
+// SDWire exposes, and while switched to the target it disappears again.
+// This allows flashing and provisioning workflows to run end to end on a
+// laptop or in CI.
+//
+// Open a simulated device with OpenSimulator. The zero value of each field
+// other than CardPath and DevicePath has a usable default.
+type Simulator struct {
+	// Serial is the serial number the device reports. It defaults to "sim".
+	Serial string
+	// Generation selects the emulated hardware. It defaults to SDWireC.
+	Generation DeviceGeneration
+	// Path is the USB port path, e.g. []int{1, 4}. It defaults to port 1
+	// on bus 1.
+	Path []int
+	// CardPath is the file backing the card. It is created if missing and
+	// extended to CardSize if shorter.
+	CardPath string
+	// CardSize is the card capacity in bytes.
+	CardSize int64
+	// DevicePath is where the card appears while switched to the host. It
+	// is created as a symbolic link to CardPath.
+	DevicePath string
+	// SwitchLatency delays every mode switch, like the USB round trips of
+	// real hardware.
+	SwitchLatency time.Duration
+	// AppearLatency delays the card appearing at DevicePath after a switch
+	// to the host, like kernel enumeration of a real card reader.
+	AppearLatency time.Duration
+
+	mu     sync.Mutex
+	mode   SwitchMode
+	appear *time.Timer
+}
+
+// Mode returns the simulated switch position. A new simulator starts in
+// ModeTarget.
+func (sim *Simulator) Mode() SwitchMode {
+	sim.mu.Lock()
+	defer sim.mu.Unlock()
+	return sim.mode
+}
+
+// Attached reports whether the card is currently visible at DevicePath.
+func (sim *Simulator) Attached() bool {
+	_, err := os.Lstat(sim.DevicePath)
+	return err == nil
+}
+
+// OpenSimulator opens a simulated device. Device locking is keyed by the
+// simulated serial and port path, like real devices.
+func OpenSimulator(sim *Simulator, opts ...Option) (*SDWire, error) {
+	if sim.CardPath == "" || sim.DevicePath == "" {
+		return nil, WithCode(CodeInvalidArgument, errors.New("simulator needs a card path and device path"))
+	}
+	if sim.Serial == "" {
+		sim.Serial = "sim"
+	}
+	if len(sim.Path) == 0 {
+		sim.Path = []int{1}
+	}
+	if err := sim.createCard(); err != nil {
+		return nil, err
+	}
+	return open(&simDevice{sim: sim}, sim.Serial, newOptions(opts))
+}
+
+func (sim *Simulator) createCard() error {
+	f, err := os.OpenFile(sim.CardPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create simulated card: %w", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to create simulated card: %w", err)
+	}
+	if fi.Size() < sim.CardSize {
+		if err := f.Truncate(sim.CardSize); err != nil {
+			return fmt.Errorf("failed to create simulated card: %w", err)
+		}
+	}
+	return nil
+}
+
+// switchTo moves the card to mode. Callers must hold sim.mu.
+func (sim *Simulator) switchTo(mode SwitchMode) error {
+	time.Sleep(sim.SwitchLatency)
+	sim.mode = mode
+	if sim.appear != nil {
+		sim.appear.Stop()
+		sim.appear = nil
+	}
+	if mode == ModeTarget {
+		if err := os.Remove(sim.DevicePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to detach simulated card: %w", err)
+		}
+		return nil
+	}
+	sim.appear = time.AfterFunc(sim.AppearLatency, func() {
+		sim.mu.Lock()
+		defer sim.mu.Unlock()
+		if sim.mode == ModeHost {
+			os.Symlink(sim.CardPath, sim.DevicePath)
+		}
+	})
+	return nil
+}
+
+// simDevice implements the USB protocol of the simulated hardware: the
+// FTDI CBUS bitmode request for SDWireC, and driver detach and reset for
+// SDWire3.
+type simDevice struct {
+	sim *Simulator
+}
+
+func (d *simDevice) Descriptor() *deviceDesc {
+	desc := &deviceDesc{
+		Bus:     1,
+		Address: 2,
+		Port:    d.sim.Path[len(d.sim.Path)-1],
+		Path:    d.sim.Path,
+		Vendor:  SDWireCVID,
+		Product: SDWireCPID,
+		Device:  0x1000,
+	}
+	if d.sim.Generation == GenerationSDWire3 {
+		desc.Vendor, desc.Product, desc.Device = SDWire3VID, SDWire3PID, 0x0100
+	}
+	return desc
+}
+
+func (d *simDevice) Control(rType, request uint8, value, index uint16, data []byte) (int, error) {
+	if d.sim.Generation == GenerationSDWireC && request == ftdiSioSetBitmodeRequest && value>>8 == ftdiSioBitmodeCbus {
+		mode := ModeTarget
+		if value&1 != 0 {
+			mode = ModeHost
+		}
+		d.sim.mu.Lock()
+		defer d.sim.mu.Unlock()
+		return 0, d.sim.switchTo(mode)
+	}
+	if d.sim.Generation == GenerationSDWireC && request == ftdiSioReadPinsRequest && len(data) > 0 {
+		d.sim.mu.Lock()
+		defer d.sim.mu.Unlock()
+		data[0] = 0xF0
+		if d.sim.mode == ModeHost {
+			data[0] |= 0x01
+		}
+		return 1, nil
+	}
+	// Everything else, including probes and EEPROM reads, succeeds and
+	// reads as zeros.
+	if rType&controlIn != 0 {
+		clear(data)
+		return len(data), nil
+	}
+	return len(data), nil
+}
+
+// Reset re-enumerates the simulated SDWire3, which binds its storage
+// driver and so gives the card to the host.
+func (d *simDevice) Reset() error {
+	if d.sim.Generation != GenerationSDWire3 {
+		return nil
+	}
+	d.sim.mu.Lock()
+	defer d.sim.mu.Unlock()
+	return d.sim.switchTo(ModeHost)
+}
+
+func (d *simDevice) InterfaceDriver(int) (string, error) {
+	if d.sim.Generation != GenerationSDWire3 {
+		return "", nil
+	}
+	d.sim.mu.Lock()
+	defer d.sim.mu.Unlock()
+	if d.sim.mode == ModeHost {
+		return "usb-storage", nil
+	}
+	return "", nil
+}
+
+func (d *simDevice) SetAutoDetach(bool) error { return nil }
+
+func (d *simDevice) SetControlTimeout(time.Duration) {}
+
+func (d *simDevice) Config(int) (usbConfig, error) {
+	return simConfig{d.sim}, nil
+}
+
+func (d *simDevice) SerialNumber() (string, error) { return d.sim.Serial, nil }
+
+func (d *simDevice) Product() (string, error) {
+	if d.sim.Generation == GenerationSDWire3 {
+		return "USB3.0 Card Reader", nil
+	}
+	return SDWireCProductName, nil
+}
+
+func (d *simDevice) Manufacturer() (string, error) {
+	if d.sim.Generation == GenerationSDWire3 {
+		return "Generic", nil
+	}
+	return "SRPOL", nil
+}
+
+func (d *simDevice) ConfigDescription(int) (string, error) { return "", nil }
+
+func (d *simDevice) InterfaceDescription(int, int, int) (string, error) { return "", nil }
+
+func (d *simDevice) Close() error { return nil }
+
+type simConfig struct {
+	sim *Simulator
+}
+
+// Interface claims an interface, which detaches the simulated SDWire3's
+// storage driver and so gives the card to the target.
+func (c simConfig) Interface(int, int) (io.Closer, error) {
+	if c.sim.Generation != GenerationSDWire3 {
+		return io.NopCloser(nil), nil
+	}
+	c.sim.mu.Lock()
+	defer c.sim.mu.Unlock()
+	return io.NopCloser(nil), c.sim.switchTo(ModeTarget)
+}
+
+func (c simConfig) Close() error { return nil }
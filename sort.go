@@ -0,0 +1,106 @@
+package sdwire
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ComparePortPaths orders port paths such as "1-2.4" numerically by bus
+// and then by each port along the path, so "1-2" sorts before "1-10" and
+// a hub's port before the ports behind it. It returns -1, 0 or +1.
+func ComparePortPaths(a, b string) int {
+	as, bs := portPathNumbers(a), portPathNumbers(b)
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			if as[i] < bs[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// portPathNumbers splits a port path into its bus and port numbers.
+// Components that are not numbers sort as -1.
+func portPathNumbers(path string) []int {
+	fields := strings.FieldsFunc(path, func(r rune) bool { return r == '-' || r == '.' })
+	nums := make([]int, len(fields))
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			n = -1
+		}
+		nums[i] = n
+	}
+	return nums
+}
+
+// SortByPortPath sorts devices by port path, then serial. This is the
+// order ListDevices returns, and it only changes when devices are moved
+// between ports.
+func SortByPortPath(devices []*DeviceInfo) {
+	sort.SliceStable(devices, func(i, j int) bool {
+		if c := ComparePortPaths(devices[i].PortPath, devices[j].PortPath); c != 0 {
+			return c < 0
+		}
+		return devices[i].Serial < devices[j].Serial
+	})
+}
+
+// SortBySerial sorts devices by serial number, then port path.
+func SortBySerial(devices []*DeviceInfo) {
+	sort.SliceStable(devices, func(i, j int) bool {
+		if devices[i].Serial != devices[j].Serial {
+			return devices[i].Serial < devices[j].Serial
+		}
+		return ComparePortPaths(devices[i].PortPath, devices[j].PortPath) < 0
+	})
+}
+
+// FilterDevices returns the devices for which keep returns true, in order.
+func FilterDevices(devices []*DeviceInfo, keep func(*DeviceInfo) bool) []*DeviceInfo {
+	var kept []*DeviceInfo
+	for _, d := range devices {
+		if keep(d) {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+// FilterByGeneration returns the devices of the given generation, in order.
+func FilterByGeneration(devices []*DeviceInfo, generation DeviceGeneration) []*DeviceInfo {
+	return FilterDevices(devices, func(d *DeviceInfo) bool { return d.Generation == generation })
+}
+
+// HubPath returns the port path of the hub a device is plugged into, e.g.
+// "1-2" for "1-2.3". Devices on a root hub port belong to the root hub,
+// which is named after its bus as in sysfs, e.g. "usb1" for "1-2".
+func HubPath(portPath string) string {
+	if i := strings.LastIndexByte(portPath, '.'); i >= 0 {
+		return portPath[:i]
+	}
+	if bus, _, ok := strings.Cut(portPath, "-"); ok {
+		return "usb" + bus
+	}
+	return portPath
+}
+
+// GroupByHub groups devices by the hub they are plugged into; see HubPath.
+// Each group keeps the order of devices.
+func GroupByHub(devices []*DeviceInfo) map[string][]*DeviceInfo {
+	groups := make(map[string][]*DeviceInfo)
+	for _, d := range devices {
+		hub := HubPath(d.PortPath)
+		groups[hub] = append(groups[hub], d)
+	}
+	return groups
+}
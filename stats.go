@@ -0,0 +1,169 @@
+package sdwire
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// Stats summarizes how a device has been used by this process. Statistics
+// are kept per device, so they carry over when a device is closed and
+// opened again.
+type Stats struct {
+	// Switches counts successful mode switches.
+	Switches uint64
+	// Errors counts failed switches and probes.
+	Errors uint64
+	// BytesFlashed counts bytes written to the card, as reported through
+	// RecordBytesFlashed or RecordFlash.
+	BytesFlashed int64
+	// FlashTime is the time spent writing the bytes reported through
+	// RecordFlash.
+	FlashTime time.Duration
+	// LastError is the message of the last failed switch or probe, at
+	// LastErrorTime.
+	LastError     string
+	LastErrorTime time.Time
+	// OpenHandles counts the handles to the device open in this process.
+	OpenHandles int
+	// CardDirty, if not empty, says why the card holds a partly written
+	// image; see MarkCardDirty.
+	CardDirty string
+	// Mode is the last mode switched to. It is only meaningful when
+	// LastModeChange is set.
+	Mode           SwitchMode
+	LastModeChange time.Time
+	// TimeInMode is the cumulative time spent in each mode since the first
+	// switch, including the current one.
+	TimeInMode map[SwitchMode]time.Duration
+}
+
+type deviceStats struct {
+	mu    sync.Mutex
+	stats Stats
+	// timedBytes counts the bytes reported with a duration, over which
+	// FlashTime was spent.
+	timedBytes int64
+	// card is the CID of the card last identified in the device.
+	card string
+}
+
+var (
+	statsMu       sync.Mutex
+	statsByDevice = make(map[string]*deviceStats)
+)
+
+// statsFor returns the statistics of the device with the given lock key.
+func statsFor(key string) *deviceStats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	d, ok := statsByDevice[key]
+	if !ok {
+		d = &deviceStats{stats: Stats{TimeInMode: make(map[SwitchMode]time.Duration)}}
+		statsByDevice[key] = d
+	}
+	return d
+}
+
+func (d *deviceStats) switched(mode SwitchMode) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	if !d.stats.LastModeChange.IsZero() {
+		d.stats.TimeInMode[d.stats.Mode] += now.Sub(d.stats.LastModeChange)
+	}
+	d.stats.Switches++
+	d.stats.Mode = mode
+	d.stats.LastModeChange = now
+}
+
+func (d *deviceStats) failed(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stats.Errors++
+	d.stats.LastError = err.Error()
+	d.stats.LastErrorTime = time.Now()
+}
+
+func (d *deviceStats) flashed(n int64, elapsed time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stats.BytesFlashed += n
+	if elapsed > 0 {
+		d.stats.FlashTime += elapsed
+		d.timedBytes += n
+	}
+}
+
+// flashRate returns the bytes per second written by timed flashes, or 0
+// if there were none.
+func (d *deviceStats) flashRate() float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stats.FlashTime <= 0 {
+		return 0
+	}
+	return float64(d.timedBytes) / d.stats.FlashTime.Seconds()
+}
+
+// handles adjusts the count of open handles by delta.
+func (d *deviceStats) handles(delta int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stats.OpenHandles += delta
+}
+
+// setDirty records why the card is dirty, or that it is clean.
+func (d *deviceStats) setDirty(reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stats.CardDirty = reason
+}
+
+// swapCard records cid as the card in the device and returns the card
+// recorded before, if any.
+func (d *deviceStats) swapCard(cid string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	prev := d.card
+	d.card = cid
+	return prev
+}
+
+func (d *deviceStats) snapshot() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := d.stats
+	s.TimeInMode = maps.Clone(d.stats.TimeInMode)
+	if !s.LastModeChange.IsZero() {
+		s.TimeInMode[s.Mode] += time.Since(s.LastModeChange)
+	}
+	return s
+}
+
+// Stats returns the usage statistics of the device.
+func (s *SDWire) Stats() Stats {
+	return s.stats.snapshot()
+}
+
+// RecordBytesFlashed adds n to the bytes flashed to the device's card.
+// It is called by code writing images, such as the workflow package. The
+// flash is also added to the card's wear if a store is installed with
+// SetWearStore, after identifying the card; see IdentifyCard.
+func (s *SDWire) RecordBytesFlashed(n int64) {
+	if s == nil {
+		return
+	}
+	s.stats.flashed(n, 0)
+	s.recordCard(n)
+}
+
+// RecordFlash is like RecordBytesFlashed but also records how long writing
+// took, from which the flash throughput is derived.
+func (s *SDWire) RecordFlash(n int64, elapsed time.Duration) {
+	if s == nil {
+		return
+	}
+	s.stats.flashed(n, elapsed)
+	s.recordCard(n)
+}
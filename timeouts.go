@@ -0,0 +1,101 @@
+package sdwire
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Timeouts bounds how long device operations may take. Zero fields fall
+// back to the defaults for the device generation; see DefaultTimeouts.
+type Timeouts struct {
+	// Open bounds waiting for the device lock with WithBlockingLock.
+	Open Duration `json:"open,omitempty"`
+	// Control bounds each USB control transfer.
+	Control Duration `json:"control,omitempty"`
+	// Reset bounds a USB port reset, including the device re-enumerating.
+	Reset Duration `json:"reset,omitempty"`
+}
+
+// Duration is a time.Duration that is written to JSON as a string such as
+// "1.5s".
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", text, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// DefaultTimeouts returns the timeouts used for a device generation when
+// none are configured. libusb itself never gives up on a control transfer,
+// so a wedged unit would otherwise hang its caller forever. Waiting for the
+// device lock is not bounded by default. On Raspberry Pi hosts the timeouts
+// are doubled.
+func DefaultTimeouts(g DeviceGeneration) Timeouts {
+	var t Timeouts
+	switch g {
+	case GenerationSDWire3:
+		// The card reader re-enumerates after a reset, which takes a few
+		// seconds on loaded hubs.
+		t = Timeouts{Control: Duration(time.Second), Reset: Duration(10 * time.Second)}
+	default:
+		t = Timeouts{Control: Duration(time.Second), Reset: Duration(5 * time.Second)}
+	}
+	if raspberryPi() {
+		t.Control *= 2
+		t.Reset *= 2
+	}
+	return t
+}
+
+// raspberryPi reports whether the host is a Raspberry Pi. Their USB
+// controllers, the dwc_otg of older models in particular, complete
+// transfers slowly while the shared bus is busy with Ethernet or storage.
+var raspberryPi = sync.OnceValue(func() bool {
+	model, err := os.ReadFile("/proc/device-tree/model")
+	return err == nil && bytes.HasPrefix(model, []byte("Raspberry Pi"))
+})
+
+// merge returns t with its zero fields taken from fallback.
+func (t Timeouts) merge(fallback Timeouts) Timeouts {
+	if t.Open == 0 {
+		t.Open = fallback.Open
+	}
+	if t.Control == 0 {
+		t.Control = fallback.Control
+	}
+	if t.Reset == 0 {
+		t.Reset = fallback.Reset
+	}
+	return t
+}
+
+// runWithTimeout runs fn, giving up after d. libusb cannot cancel a
+// running operation, so on timeout fn keeps running in the background.
+func runWithTimeout(d time.Duration, op string, fn func() error) error {
+	if d <= 0 {
+		return fn()
+	}
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return WithCode(CodeTimeout, fmt.Errorf("%s timed out after %v", op, d))
+	}
+}
@@ -0,0 +1,205 @@
+// Package topology records how lab equipment is wired together: which SDWire
+// serves which DUT, which power outlet feeds it and where its serial console
+// is attached. Tools can then address everything by DUT name.
+package topology
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/power"
+)
+
+// MuxRef identifies the SDWire serving a DUT. Exactly one field should be set.
+type MuxRef struct {
+	Serial   string `json:"serial,omitempty"`
+	PortPath string `json:"port_path,omitempty"`
+	// Name is a lab name resolved through the sdwire registry.
+	Name string `json:"name,omitempty"`
+}
+
+// PowerRef identifies the power outlet feeding a DUT.
+type PowerRef struct {
+	// Controller names the power controller, e.g. "pdu-rack3".
+	Controller string `json:"controller"`
+	// Outlet is the controller-specific outlet or port identifier.
+	Outlet string `json:"outlet"`
+}
+
+// ConsoleRef identifies the serial console of a DUT.
+type ConsoleRef struct {
+	Device string `json:"device"`
+	Baud   int    `json:"baud,omitempty"`
+}
+
+// DUT describes a device under test and the equipment attached to it.
+type DUT struct {
+	Name    string      `json:"name"`
+	Mux     MuxRef      `json:"mux"`
+	Power   *PowerRef   `json:"power,omitempty"`
+	Console *ConsoleRef `json:"console,omitempty"`
+	// Peripherals maps free-form roles to device identifiers,
+	// e.g. "usb-mux" to a serial number.
+	Peripherals map[string]string `json:"peripherals,omitempty"`
+}
+
+// Topology is a set of DUTs indexed by name.
+type Topology struct {
+	duts  map[string]DUT
+	power map[string]power.Spec
+}
+
+// New creates a topology from the given DUTs and named power controllers.
+// DUT names must be unique.
+func New(duts []DUT, powerControllers map[string]power.Spec) (*Topology, error) {
+	t := &Topology{
+		duts:  make(map[string]DUT, len(duts)),
+		power: powerControllers,
+	}
+	for _, d := range duts {
+		if d.Name == "" {
+			return nil, fmt.Errorf("DUT without a name")
+		}
+		if _, ok := t.duts[d.Name]; ok {
+			return nil, fmt.Errorf("duplicate DUT %q", d.Name)
+		}
+		if d.Mux == (MuxRef{}) {
+			return nil, fmt.Errorf("DUT %q: missing mux", d.Name)
+		}
+		if d.Power != nil {
+			if _, ok := powerControllers[d.Power.Controller]; !ok {
+				return nil, fmt.Errorf("DUT %q: unknown power controller %q", d.Name, d.Power.Controller)
+			}
+		}
+		t.duts[d.Name] = d
+	}
+	return t, nil
+}
+
+// Parse reads a topology from JSON of the form:
+//
+//	{"power_controllers": {"pdu-rack3": {"type": "snmp", "host": "10.0.3.2",
+//	  "oid": "1.3.6.1.4.1.318.1.1.12.3.3.1.1.4"}},
+//	 "duts": [{"name": "rpi4-03", "mux": {"serial": "sdw-0001"},
+//	  "power": {"controller": "pdu-rack3", "outlet": "4"},
+//	  "console": {"device": "/dev/ttyUSB3", "baud": 115200}}]}
+//
+// See power.Spec for the power controller fields.
+func Parse(r io.Reader) (*Topology, error) {
+	var doc struct {
+		PowerControllers map[string]power.Spec `json:"power_controllers"`
+		DUTs             []DUT                 `json:"duts"`
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse topology: %w", err)
+	}
+	return New(doc.DUTs, doc.PowerControllers)
+}
+
+// Load reads a topology from a JSON file; see Parse.
+func Load(path string) (*Topology, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open topology: %w", err)
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// DUT returns the DUT with the given name.
+func (t *Topology) DUT(name string) (DUT, bool) {
+	d, ok := t.duts[name]
+	return d, ok
+}
+
+// DUTs returns all DUTs sorted by name.
+func (t *Topology) DUTs() []DUT {
+	duts := make([]DUT, 0, len(t.duts))
+	for _, d := range t.duts {
+		duts = append(duts, d)
+	}
+	sort.Slice(duts, func(i, j int) bool {
+		return duts[i].Name < duts[j].Name
+	})
+	return duts
+}
+
+// DUTForMux returns the DUT served by the given connected device.
+func (t *Topology) DUTForMux(info *sdwire.DeviceInfo) (DUT, bool) {
+	for _, d := range t.duts {
+		if d.Mux.matches(info) {
+			return d, true
+		}
+	}
+	return DUT{}, false
+}
+
+// OpenMux opens the SDWire serving the named DUT.
+// The returned SDWire must be closed with Close() when done.
+func (t *Topology) OpenMux(dut string, opts ...sdwire.Option) (*sdwire.SDWire, error) {
+	info, err := t.FindMux(dut)
+	if err != nil {
+		return nil, err
+	}
+	return sdwire.OpenInfo(info, opts...)
+}
+
+// FindMux returns the connected SDWire serving the named DUT. It fails with
+// sdwire.CodeAmbiguous if the DUT's mux matches more than one device, as a
+// serial number shared by clones does.
+func (t *Topology) FindMux(dut string) (*sdwire.DeviceInfo, error) {
+	d, ok := t.duts[dut]
+	if !ok {
+		return nil, fmt.Errorf("unknown DUT %q", dut)
+	}
+	devices, err := sdwire.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	var found []*sdwire.DeviceInfo
+	for _, info := range devices {
+		if d.Mux.matches(info) {
+			found = append(found, info)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("mux for DUT %q is not connected", dut)
+	case 1:
+		return found[0], nil
+	}
+	return nil, sdwire.WithCode(sdwire.CodeAmbiguous, fmt.Errorf("%d connected devices match the mux for DUT %q; give its port path", len(found), dut))
+}
+
+// PowerController returns the power controller for the named DUT's outlet.
+func (t *Topology) PowerController(dut string) (power.Controller, error) {
+	d, ok := t.duts[dut]
+	if !ok {
+		return nil, fmt.Errorf("unknown DUT %q", dut)
+	}
+	if d.Power == nil {
+		return nil, fmt.Errorf("DUT %q has no power controller", dut)
+	}
+	c, err := power.New(t.power[d.Power.Controller], d.Power.Outlet)
+	if err != nil {
+		return nil, fmt.Errorf("DUT %q: %w", dut, err)
+	}
+	return c, nil
+}
+
+func (m MuxRef) matches(info *sdwire.DeviceInfo) bool {
+	switch {
+	case m.Serial != "":
+		return info.Serial == m.Serial
+	case m.PortPath != "":
+		return info.PortPath == m.PortPath
+	case m.Name != "":
+		return info.Name == m.Name
+	default:
+		return false
+	}
+}
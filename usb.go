@@ -0,0 +1,141 @@
+package sdwire
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// usbDevice is the subset of a USB device handle the SDK uses. Controllers
+// depend on it rather than on a particular USB library so that other
+// backends and fakes can be substituted.
+type usbDevice interface {
+	Descriptor() *deviceDesc
+	Control(rType, request uint8, value, index uint16, data []byte) (int, error)
+	Reset() error
+	SetControlTimeout(d time.Duration)
+	// InterfaceDriver returns the kernel driver bound to an interface, ""
+	// if none is, or errDriverUnknown where this cannot be determined.
+	InterfaceDriver(intf int) (string, error)
+	SetAutoDetach(autodetach bool) error
+	Config(cfgNum int) (usbConfig, error)
+	SerialNumber() (string, error)
+	Product() (string, error)
+	Manufacturer() (string, error)
+	ConfigDescription(cfgNum int) (string, error)
+	InterfaceDescription(cfgNum, intfNum, altNum int) (string, error)
+	Close() error
+}
+
+// openSDWires opens every connected SDWire device; see openDetected.
+func openSDWires() ([]usbDevice, error) {
+	return openDetected(nil)
+}
+
+// driverAttacher is implemented by backends that can ask the kernel to
+// bind a driver to an interface again without resetting the device.
+type driverAttacher interface {
+	AttachDriver(intf int) error
+}
+
+// errDriverUnknown is returned by InterfaceDriver on platforms without a
+// way to see kernel driver bindings.
+var errDriverUnknown = errors.New("kernel driver binding is unknown")
+
+// errNoSerial is returned by SerialNumber for devices without a serial
+// number string.
+var errNoSerial = errors.New("device has no serial number")
+
+// usbConfig is a claimed device configuration.
+type usbConfig interface {
+	Interface(num, alt int) (io.Closer, error)
+	Close() error
+}
+
+// deviceDesc is the part of a USB device descriptor the SDK uses, along
+// with the device's position on the bus.
+type deviceDesc struct {
+	Bus     int
+	Address int
+	Port    int
+	Path    []int
+	Vendor  uint16
+	Product uint16
+	// Device is the bcdDevice release number.
+	Device uint16
+	// Configs maps configuration numbers to their interface settings.
+	Configs map[int][]interfaceSetting
+}
+
+// interfaceSetting identifies an alternate setting of an interface.
+type interfaceSetting struct {
+	Number    int
+	Alternate int
+}
+
+// bcdString formats a binary-coded decimal version number, e.g. "1.00".
+func bcdString(v uint16) string {
+	major := 10*int(v>>12) + int(v>>8&0xf)
+	minor := 10*int(v>>4&0xf) + int(v&0xf)
+	return fmt.Sprintf("%d.%02d", major, minor)
+}
+
+// Control request type bits.
+const (
+	controlOut    = 0x00
+	controlIn     = 0x80
+	controlVendor = 0x40
+	controlDevice = 0x00
+)
+
+// usbError is a backend-independent USB error. The values are the libusb
+// error codes, so recordings made with any backend replay the same way.
+type usbError int
+
+const (
+	errUSBIO           usbError = -1
+	errUSBInvalidParam usbError = -2
+	errUSBAccess       usbError = -3
+	errUSBNoDevice     usbError = -4
+	errUSBNotFound     usbError = -5
+	errUSBBusy         usbError = -6
+	errUSBTimeout      usbError = -7
+	errUSBOverflow     usbError = -8
+	errUSBPipe         usbError = -9
+	errUSBInterrupted  usbError = -10
+	errUSBNotSupported usbError = -12
+)
+
+var usbErrorStrings = map[usbError]string{
+	errUSBIO:           "i/o error",
+	errUSBInvalidParam: "invalid param",
+	errUSBAccess:       "bad access",
+	errUSBNoDevice:     "no device",
+	errUSBNotFound:     "not found",
+	errUSBBusy:         "device or resource busy",
+	errUSBTimeout:      "timeout",
+	errUSBOverflow:     "overflow",
+	errUSBPipe:         "pipe error",
+	errUSBInterrupted:  "interrupted",
+	errUSBNotSupported: "not supported",
+}
+
+func (e usbError) Error() string {
+	s, ok := usbErrorStrings[e]
+	if !ok {
+		s = "unknown error"
+	}
+	return "usb: " + s + " [code " + strconv.Itoa(int(e)) + "]"
+}
+
+// usbErrorOf finds a USB error in err's chain, translating errors of the
+// active backend.
+func usbErrorOf(err error) (usbError, bool) {
+	var usbErr usbError
+	if errors.As(err, &usbErr) {
+		return usbErr, true
+	}
+	return backendUSBError(err)
+}
@@ -0,0 +1,247 @@
+//go:build cgo && !sdwire_usbfs
+
+package sdwire
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/gousb"
+)
+
+// The default backend talks to devices through libusb via gousb. Building
+// without cgo, or with the sdwire_usbfs tag, selects the pure-Go usbfs
+// backend instead.
+
+// contextIdleTimeout is how long the shared libusb context outlives its
+// last device, so that back-to-back calls do not each pay for creating one.
+const contextIdleTimeout = 5 * time.Second
+
+// gousbContext is the libusb context shared by all devices. It is created
+// on first use and closed once it has had no devices for
+// contextIdleTimeout.
+var gousbContext struct {
+	mu   sync.Mutex
+	ctx  *gousb.Context
+	refs int
+	idle *time.Timer
+}
+
+// acquireContext returns the shared context, creating it if needed. Each
+// call must be paired with releaseContext.
+func acquireContext() *gousb.Context {
+	c := &gousbContext
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.idle != nil {
+		c.idle.Stop()
+		c.idle = nil
+	}
+	if c.ctx == nil {
+		c.ctx = gousb.NewContext()
+	}
+	c.refs++
+	return c.ctx
+}
+
+func releaseContext() {
+	c := &gousbContext
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refs--
+	if c.refs > 0 {
+		return
+	}
+	var idle *time.Timer
+	idle = time.AfterFunc(contextIdleTimeout, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// A later acquire and release may have replaced this timer.
+		if c.idle == idle && c.refs == 0 {
+			c.ctx.Close()
+			c.ctx = nil
+			c.idle = nil
+		}
+	})
+	c.idle = idle
+}
+
+// openSDWiresWhere opens the connected SDWire devices for which match
+// returns true, or all of them if match is nil. Devices are matched before
+// they are opened. If a device cannot be opened for lack of permission,
+// the error is a *PermissionError.
+//
+// Like gousb.Context.OpenDevices, it may return devices alongside an error;
+// the caller must close them.
+func openSDWiresWhere(match func(*deviceDesc) bool) ([]usbDevice, error) {
+	ctx := acquireContext()
+	defer releaseContext()
+	var matched []*gousb.DeviceDesc
+	devs, err := ctx.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		d := convertDesc(desc)
+		if !isSDWire(d) || (match != nil && !match(d)) {
+			return false
+		}
+		matched = append(matched, desc)
+		return true
+	})
+	if errors.Is(err, gousb.ErrorAccess) {
+		// OpenDevices does not say which device failed; report the first
+		// one that was not opened.
+		for _, desc := range matched {
+			if !openedDesc(devs, desc) {
+				err = newPermissionError(convertDesc(desc), err)
+				break
+			}
+		}
+	}
+
+	opened := make([]usbDevice, len(devs))
+	for i, dev := range devs {
+		acquireContext()
+		opened[i] = &gousbDevice{Device: dev, desc: convertDesc(dev.Desc)}
+	}
+	return opened, err
+}
+
+func openedDesc(devs []*gousb.Device, desc *gousb.DeviceDesc) bool {
+	for _, dev := range devs {
+		if dev.Desc.Bus == desc.Bus && dev.Desc.Address == desc.Address {
+			return true
+		}
+	}
+	return false
+}
+
+func convertDesc(desc *gousb.DeviceDesc) *deviceDesc {
+	d := &deviceDesc{
+		Bus:     desc.Bus,
+		Address: desc.Address,
+		Port:    desc.Port,
+		Path:    desc.Path,
+		Vendor:  uint16(desc.Vendor),
+		Product: uint16(desc.Product),
+		Device:  uint16(desc.Device),
+		Configs: make(map[int][]interfaceSetting, len(desc.Configs)),
+	}
+	for n, cfg := range desc.Configs {
+		for _, intf := range cfg.Interfaces {
+			for _, alt := range intf.AltSettings {
+				d.Configs[n] = append(d.Configs[n], interfaceSetting{Number: intf.Number, Alternate: alt.Alternate})
+			}
+		}
+	}
+	return d
+}
+
+// backendUSBError translates libusb errors and transfer statuses.
+func backendUSBError(err error) (usbError, bool) {
+	var usbErr gousb.Error
+	if errors.As(err, &usbErr) {
+		return usbError(usbErr), true
+	}
+	var status gousb.TransferStatus
+	if errors.As(err, &status) {
+		switch status {
+		case gousb.TransferTimedOut:
+			return errUSBTimeout, true
+		case gousb.TransferStall:
+			return errUSBPipe, true
+		case gousb.TransferNoDevice:
+			return errUSBNoDevice, true
+		case gousb.TransferCancelled:
+			return errUSBInterrupted, true
+		case gousb.TransferOverflow:
+			return errUSBOverflow, true
+		default:
+			return errUSBIO, true
+		}
+	}
+	return 0, false
+}
+
+// checkBackend reports whether libusb can be initialized.
+func checkBackend() (f Finding) {
+	f.Check = "libusb"
+	defer func() {
+		// gousb panics if libusb cannot be initialized.
+		if r := recover(); r != nil {
+			f.Severity = SeverityError
+			f.Message = fmt.Sprintf("libusb cannot be initialized: %v", r)
+			f.Fix = "install libusb-1.0"
+		}
+	}()
+	acquireContext()
+	releaseContext()
+	f.Message = "libusb is available"
+	return f
+}
+
+// gousbDevice adapts *gousb.Device to usbDevice.
+type gousbDevice struct {
+	*gousb.Device
+	desc *deviceDesc
+
+	// close releases the device and its context reference only once.
+	close    sync.Once
+	closeErr error
+}
+
+func (d *gousbDevice) Descriptor() *deviceDesc {
+	return d.desc
+}
+
+func (d *gousbDevice) SetControlTimeout(timeout time.Duration) {
+	d.ControlTimeout = timeout
+}
+
+func (d *gousbDevice) InterfaceDriver(intf int) (string, error) {
+	if storageDriversOnly && generationOf(d.desc) != GenerationSDWire3 {
+		// Only the SDWire3 has a mass storage interface.
+		return "", nil
+	}
+	return interfaceDriver(portPathOf(d.desc), intf)
+}
+
+func (d *gousbDevice) Config(cfgNum int) (usbConfig, error) {
+	cfg, err := d.Device.Config(cfgNum)
+	if err != nil {
+		return nil, err
+	}
+	return gousbConfig{cfg}, nil
+}
+
+func (d *gousbDevice) Close() error {
+	d.close.Do(func() {
+		d.closeErr = d.Device.Close()
+		releaseContext()
+	})
+	return d.closeErr
+}
+
+// gousbConfig adapts *gousb.Config to usbConfig.
+type gousbConfig struct {
+	*gousb.Config
+}
+
+func (c gousbConfig) Interface(num, alt int) (io.Closer, error) {
+	intf, err := c.Config.Interface(num, alt)
+	if err != nil {
+		return nil, err
+	}
+	return gousbInterface{intf}, nil
+}
+
+// gousbInterface adapts *gousb.Interface, whose Close has no result, to
+// io.Closer.
+type gousbInterface struct {
+	*gousb.Interface
+}
+
+func (i gousbInterface) Close() error {
+	i.Interface.Close()
+	return nil
+}
@@ -0,0 +1,26 @@
+//go:build !linux && !(js && wasm) && (!cgo || sdwire_usbfs)
+
+package sdwire
+
+import "errors"
+
+// Without cgo there is no libusb, and usbfs exists only on Linux.
+
+var errNoBackend = WithCode(CodeUnsupported, errors.New("USB access on this platform requires building with cgo and libusb"))
+
+func openSDWiresWhere(func(*deviceDesc) bool) ([]usbDevice, error) {
+	return nil, errNoBackend
+}
+
+func backendUSBError(err error) (usbError, bool) {
+	return 0, false
+}
+
+func checkBackend() Finding {
+	return Finding{
+		Check:    "libusb",
+		Severity: SeverityError,
+		Message:  errNoBackend.Error(),
+		Fix:      "rebuild with CGO_ENABLED=1",
+	}
+}
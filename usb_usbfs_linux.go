@@ -0,0 +1,545 @@
+//go:build linux && (!cgo || sdwire_usbfs)
+
+package sdwire
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf16"
+	"unsafe"
+)
+
+// This backend talks to devices through the Linux usbfs ioctl interface
+// under /dev/bus/usb, so it needs neither cgo nor libusb. It is used when
+// building with CGO_ENABLED=0 or with the sdwire_usbfs tag.
+
+const (
+	usbSysfsDir = "/sys/bus/usb/devices"
+	usbfsDir    = "/dev/bus/usb"
+)
+
+// ioctl direction bits and size field width. These are the asm-generic
+// values; mips and powerpc use their own.
+var (
+	iocNone, iocWrite, iocRead uintptr = 0, 1, 2
+	iocSizeBits                uintptr = 14
+)
+
+func init() {
+	switch runtime.GOARCH {
+	case "mips", "mipsle", "mips64", "mips64le", "ppc64", "ppc64le":
+		iocNone, iocWrite, iocRead = 1, 4, 2
+		iocSizeBits = 13
+	}
+}
+
+// usbfsRequest encodes a usbfs ioctl request number, like the kernel's
+// _IOC('U', nr, size).
+func usbfsRequest(dir, nr, size uintptr) uintptr {
+	return dir<<(16+iocSizeBits) | size<<16 | 'U'<<8 | nr
+}
+
+// ioctl request numbers from linux/usbdevice_fs.h.
+const (
+	usbdevfsControl          = 0
+	usbdevfsSetInterface     = 4
+	usbdevfsSetConfiguration = 5
+	usbdevfsClaimInterface   = 15
+	usbdevfsReleaseInterface = 16
+	usbdevfsIoctl            = 18
+	usbdevfsReset            = 20
+	usbdevfsDisconnect       = 22
+	usbdevfsConnect          = 23
+)
+
+// usbfsCtrlTransfer is struct usbdevfs_ctrltransfer.
+type usbfsCtrlTransfer struct {
+	RequestType uint8
+	Request     uint8
+	Value       uint16
+	Index       uint16
+	Length      uint16
+	// Timeout is in milliseconds; 0 waits forever.
+	Timeout uint32
+	Data    unsafe.Pointer
+}
+
+// usbfsIoctlArg is struct usbdevfs_ioctl, which passes a request to the
+// driver of one interface.
+type usbfsIoctlArg struct {
+	Interface int32
+	Code      int32
+	Data      unsafe.Pointer
+}
+
+// usbfsSetInterface is struct usbdevfs_setinterface.
+type usbfsSetInterface struct {
+	Interface  uint32
+	AltSetting uint32
+}
+
+func usbfsIoctl(fd int, request uintptr, arg unsafe.Pointer) (int, error) {
+	r, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), request, uintptr(arg))
+	if errno != 0 {
+		return 0, usbfsError(errno)
+	}
+	return int(r), nil
+}
+
+// usbfsError translates an errno the way libusb's Linux backend does.
+func usbfsError(errno syscall.Errno) error {
+	switch errno {
+	case syscall.ETIMEDOUT:
+		return errUSBTimeout
+	case syscall.EPIPE:
+		return errUSBPipe
+	case syscall.EACCES, syscall.EPERM:
+		return errUSBAccess
+	case syscall.ENODEV, syscall.ESHUTDOWN:
+		return errUSBNoDevice
+	case syscall.ENOENT, syscall.ENODATA:
+		return errUSBNotFound
+	case syscall.EBUSY:
+		return errUSBBusy
+	case syscall.EINVAL:
+		return errUSBInvalidParam
+	case syscall.EINTR:
+		return errUSBInterrupted
+	case syscall.EOVERFLOW:
+		return errUSBOverflow
+	case syscall.ENOSYS, syscall.EOPNOTSUPP:
+		return errUSBNotSupported
+	}
+	return errUSBIO
+}
+
+// openSDWiresWhere opens the connected SDWire devices for which match
+// returns true, or all of them if match is nil. Devices are matched before
+// they are opened. If a device cannot be opened for lack of permission,
+// the error is a *PermissionError.
+//
+// It may return devices alongside an error; the caller must close them.
+func openSDWiresWhere(match func(*deviceDesc) bool) ([]usbDevice, error) {
+	entries, err := os.ReadDir(usbSysfsDir)
+	if errors.Is(err, os.ErrNotExist) {
+		// No USB host controller.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var (
+		devs     []usbDevice
+		firstErr error
+	)
+	for _, e := range entries {
+		// Skip interfaces ("1-2:1.0") and root hubs ("usb1").
+		name := e.Name()
+		if strings.ContainsRune(name, ':') || strings.HasPrefix(name, "usb") {
+			continue
+		}
+		dev, err := readUsbfsDevice(name)
+		if err != nil || !isSDWire(dev.desc) || (match != nil && !match(dev.desc)) {
+			continue
+		}
+		if err := dev.open(); err != nil {
+			if firstErr == nil {
+				if errors.Is(err, errUSBAccess) {
+					err = newPermissionError(dev.desc, err)
+				}
+				firstErr = err
+			}
+			continue
+		}
+		devs = append(devs, dev)
+	}
+	return devs, firstErr
+}
+
+// backendUSBError translates errors of this backend, which returns
+// usbError values directly.
+func backendUSBError(err error) (usbError, bool) {
+	return 0, false
+}
+
+// checkBackend reports whether usbfs is available.
+func checkBackend() Finding {
+	f := Finding{Check: "usbfs"}
+	if _, err := os.Stat(usbfsDir); err != nil {
+		f.Severity = SeverityError
+		f.Message = fmt.Sprintf("%s is not available: %v", usbfsDir, err)
+		f.Fix = "mount devtmpfs on /dev, or pass /dev/bus/usb into the container"
+		return f
+	}
+	f.Message = "usbfs is available"
+	return f
+}
+
+// usbfsDevice is a device opened through usbfs.
+type usbfsDevice struct {
+	name string
+	desc *deviceDesc
+	// configStrings and interfaceStrings hold string descriptor indexes,
+	// the latter keyed by configuration, interface and alternate setting.
+	configStrings    map[int]uint8
+	interfaceStrings map[[3]int]uint8
+
+	mu         sync.Mutex
+	fd         int
+	timeout    time.Duration
+	autodetach bool
+	// claimed maps claimed interfaces to whether their kernel driver was
+	// detached when claiming.
+	claimed map[int]bool
+	langID  uint16
+}
+
+// readUsbfsDevice describes the device with the given sysfs name, e.g.
+// "1-2.3", without opening it.
+func readUsbfsDevice(name string) (*usbfsDevice, error) {
+	dir := filepath.Join(usbSysfsDir, name)
+	attr := func(name string, base int) (uint64, error) {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseUint(strings.TrimSpace(string(data)), base, 16)
+	}
+	d := &usbfsDevice{
+		name:             name,
+		desc:             &deviceDesc{},
+		configStrings:    make(map[int]uint8),
+		interfaceStrings: make(map[[3]int]uint8),
+		fd:               -1,
+		claimed:          make(map[int]bool),
+	}
+	var vals [5]uint64
+	for i, a := range []struct {
+		name string
+		base int
+	}{{"busnum", 10}, {"devnum", 10}, {"idVendor", 16}, {"idProduct", 16}, {"bcdDevice", 16}} {
+		v, err := attr(a.name, a.base)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	d.desc.Bus, d.desc.Address = int(vals[0]), int(vals[1])
+	d.desc.Vendor, d.desc.Product, d.desc.Device = uint16(vals[2]), uint16(vals[3]), uint16(vals[4])
+
+	_, ports, _ := strings.Cut(name, "-")
+	for _, p := range strings.Split(ports, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sysfs device name %q", name)
+		}
+		d.desc.Path = append(d.desc.Path, n)
+	}
+	d.desc.Port = d.desc.Path[len(d.desc.Path)-1]
+
+	raw, err := os.ReadFile(filepath.Join(dir, "descriptors"))
+	if err != nil {
+		return nil, err
+	}
+	d.parseConfigs(raw)
+	return d, nil
+}
+
+// parseConfigs records the configurations and interfaces in the raw
+// descriptors sysfs exposes: the device descriptor followed by every
+// configuration descriptor with its interfaces and endpoints.
+func (d *usbfsDevice) parseConfigs(raw []byte) {
+	d.desc.Configs = make(map[int][]interfaceSetting)
+	cfg := -1
+	for i := 0; i+2 <= len(raw); {
+		length, typ := int(raw[i]), raw[i+1]
+		if length < 2 || i+length > len(raw) {
+			return
+		}
+		switch {
+		case typ == 2 && length >= 9: // configuration
+			cfg = int(raw[i+5])
+			d.desc.Configs[cfg] = nil
+			d.configStrings[cfg] = raw[i+6]
+		case typ == 4 && length >= 9 && cfg >= 0: // interface
+			s := interfaceSetting{Number: int(raw[i+2]), Alternate: int(raw[i+3])}
+			d.desc.Configs[cfg] = append(d.desc.Configs[cfg], s)
+			d.interfaceStrings[[3]int{cfg, s.Number, s.Alternate}] = raw[i+8]
+		}
+		i += length
+	}
+}
+
+func (d *usbfsDevice) open() error {
+	path := filepath.Join(usbfsDir, fmt.Sprintf("%03d", d.desc.Bus), fmt.Sprintf("%03d", d.desc.Address))
+	fd, err := syscall.Open(path, syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		var errno syscall.Errno
+		if errors.As(err, &errno) {
+			return usbfsError(errno)
+		}
+		return err
+	}
+	d.fd = fd
+	return nil
+}
+
+func (d *usbfsDevice) Descriptor() *deviceDesc {
+	return d.desc
+}
+
+func (d *usbfsDevice) Control(rType, request uint8, value, index uint16, data []byte) (int, error) {
+	d.mu.Lock()
+	timeout := d.timeout
+	d.mu.Unlock()
+
+	ctrl := usbfsCtrlTransfer{
+		RequestType: rType,
+		Request:     request,
+		Value:       value,
+		Index:       index,
+		Length:      uint16(len(data)),
+		Timeout:     uint32((timeout + time.Millisecond - 1) / time.Millisecond),
+	}
+	if len(data) > 0 {
+		ctrl.Data = unsafe.Pointer(&data[0])
+	}
+	n, err := usbfsIoctl(d.fd, usbfsRequest(iocRead|iocWrite, usbdevfsControl, unsafe.Sizeof(ctrl)), unsafe.Pointer(&ctrl))
+	runtime.KeepAlive(data)
+	return n, err
+}
+
+func (d *usbfsDevice) Reset() error {
+	_, err := usbfsIoctl(d.fd, usbfsRequest(iocNone, usbdevfsReset, 0), nil)
+	return err
+}
+
+func (d *usbfsDevice) SetControlTimeout(timeout time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.timeout = timeout
+}
+
+func (d *usbfsDevice) InterfaceDriver(intf int) (string, error) {
+	return interfaceDriver(d.name, intf)
+}
+
+// SetAutoDetach sets whether claiming an interface detaches its kernel
+// driver, which is then reattached when the interface is released.
+func (d *usbfsDevice) SetAutoDetach(autodetach bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.autodetach = autodetach
+	return nil
+}
+
+// Config selects configuration cfgNum if it is not already active.
+func (d *usbfsDevice) Config(cfgNum int) (usbConfig, error) {
+	data, err := os.ReadFile(filepath.Join(usbSysfsDir, d.name, "bConfigurationValue"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read active configuration: %w", err)
+	}
+	if strings.TrimSpace(string(data)) != strconv.Itoa(cfgNum) {
+		n := uint32(cfgNum)
+		if _, err := usbfsIoctl(d.fd, usbfsRequest(iocRead, usbdevfsSetConfiguration, unsafe.Sizeof(n)), unsafe.Pointer(&n)); err != nil {
+			return nil, err
+		}
+	}
+	return &usbfsConfig{dev: d}, nil
+}
+
+// driverIoctl sends a usbfs driver request (connect or disconnect) for
+// an interface.
+func (d *usbfsDevice) driverIoctl(intf int, code uintptr) error {
+	arg := usbfsIoctlArg{Interface: int32(intf), Code: int32(usbfsRequest(iocNone, code, 0))}
+	_, err := usbfsIoctl(d.fd, usbfsRequest(iocRead|iocWrite, usbdevfsIoctl, unsafe.Sizeof(arg)), unsafe.Pointer(&arg))
+	return err
+}
+
+// AttachDriver asks the kernel to probe drivers for an interface.
+func (d *usbfsDevice) AttachDriver(intf int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.driverIoctl(intf, usbdevfsConnect)
+}
+
+func (d *usbfsDevice) claim(intf, alt int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	detached := false
+	if d.autodetach {
+		// ENODATA means no driver is bound.
+		switch err := d.driverIoctl(intf, usbdevfsDisconnect); {
+		case err == nil:
+			detached = true
+		case !errors.Is(err, errUSBNotFound):
+			return err
+		}
+	}
+	n := uint32(intf)
+	if _, err := usbfsIoctl(d.fd, usbfsRequest(iocRead, usbdevfsClaimInterface, unsafe.Sizeof(n)), unsafe.Pointer(&n)); err != nil {
+		if detached {
+			d.driverIoctl(intf, usbdevfsConnect)
+		}
+		return err
+	}
+	d.claimed[intf] = detached
+
+	set := usbfsSetInterface{Interface: uint32(intf), AltSetting: uint32(alt)}
+	if _, err := usbfsIoctl(d.fd, usbfsRequest(iocRead, usbdevfsSetInterface, unsafe.Sizeof(set)), unsafe.Pointer(&set)); err != nil {
+		d.releaseLocked(intf)
+		return err
+	}
+	return nil
+}
+
+// releaseLocked releases a claimed interface, reattaching its kernel
+// driver if auto-detach is still enabled. Callers must hold d.mu.
+func (d *usbfsDevice) releaseLocked(intf int) error {
+	detached, ok := d.claimed[intf]
+	if !ok {
+		return nil
+	}
+	delete(d.claimed, intf)
+	n := uint32(intf)
+	_, err := usbfsIoctl(d.fd, usbfsRequest(iocRead, usbdevfsReleaseInterface, unsafe.Sizeof(n)), unsafe.Pointer(&n))
+	if detached && d.autodetach {
+		if cerr := d.driverIoctl(intf, usbdevfsConnect); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// SerialNumber returns errNoSerial for devices without a serial number
+// string, which sysfs shows by omitting the attribute.
+func (d *usbfsDevice) SerialNumber() (string, error) {
+	serial, err := d.sysfsString("serial")
+	if err == nil && serial == "" {
+		return "", errNoSerial
+	}
+	return serial, err
+}
+
+func (d *usbfsDevice) Product() (string, error) {
+	return d.sysfsString("product")
+}
+
+func (d *usbfsDevice) Manufacturer() (string, error) {
+	return d.sysfsString("manufacturer")
+}
+
+// sysfsString reads a string descriptor the kernel caches in sysfs. It
+// returns "" if the device does not have one.
+func (d *usbfsDevice) sysfsString(attr string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(usbSysfsDir, d.name, attr))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+func (d *usbfsDevice) ConfigDescription(cfgNum int) (string, error) {
+	return d.stringDescriptor(d.configStrings[cfgNum])
+}
+
+func (d *usbfsDevice) InterfaceDescription(cfgNum, intfNum, altNum int) (string, error) {
+	return d.stringDescriptor(d.interfaceStrings[[3]int{cfgNum, intfNum, altNum}])
+}
+
+// stringDescriptor reads a string descriptor in the device's first
+// language. Index 0 means the string is absent.
+func (d *usbfsDevice) stringDescriptor(index uint8) (string, error) {
+	if index == 0 {
+		return "", nil
+	}
+	const getDescriptor, stringType = 0x06, 0x03
+	d.mu.Lock()
+	langID := d.langID
+	d.mu.Unlock()
+	buf := make([]byte, 255)
+	if langID == 0 {
+		n, err := d.Control(controlIn|controlDevice, getDescriptor, stringType<<8, 0, buf)
+		if err != nil {
+			return "", err
+		}
+		if n < 4 {
+			return "", errUSBIO
+		}
+		langID = uint16(buf[2]) | uint16(buf[3])<<8
+		d.mu.Lock()
+		d.langID = langID
+		d.mu.Unlock()
+	}
+	n, err := d.Control(controlIn|controlDevice, getDescriptor, stringType<<8|uint16(index), langID, buf)
+	if err != nil {
+		return "", err
+	}
+	if n < 2 || buf[1] != stringType {
+		return "", errUSBIO
+	}
+	if int(buf[0]) < n {
+		n = int(buf[0])
+	}
+	units := make([]uint16, 0, (n-2)/2)
+	for i := 2; i+1 < n; i += 2 {
+		units = append(units, uint16(buf[i])|uint16(buf[i+1])<<8)
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+func (d *usbfsDevice) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.fd < 0 {
+		return nil
+	}
+	for intf := range d.claimed {
+		d.releaseLocked(intf)
+	}
+	err := syscall.Close(d.fd)
+	d.fd = -1
+	return err
+}
+
+// usbfsConfig is an active configuration. Claimed interfaces must be
+// closed separately.
+type usbfsConfig struct {
+	dev *usbfsDevice
+}
+
+func (c *usbfsConfig) Interface(num, alt int) (io.Closer, error) {
+	if err := c.dev.claim(num, alt); err != nil {
+		return nil, err
+	}
+	return &usbfsInterface{dev: c.dev, num: num}, nil
+}
+
+func (c *usbfsConfig) Close() error {
+	return nil
+}
+
+// usbfsInterface is a claimed interface.
+type usbfsInterface struct {
+	dev *usbfsDevice
+	num int
+}
+
+func (i *usbfsInterface) Close() error {
+	i.dev.mu.Lock()
+	defer i.dev.mu.Unlock()
+	return i.dev.releaseLocked(i.num)
+}
@@ -0,0 +1,180 @@
+//go:build linux && (!cgo || sdwire_usbfs)
+
+package sdwire
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// TestUsbfsRequests checks the ioctl request numbers against the values of
+// the kernel's USBDEVFS_* macros.
+func TestUsbfsRequests(t *testing.T) {
+	switch runtime.GOARCH {
+	case "mips", "mipsle", "mips64", "mips64le", "ppc64", "ppc64le":
+		t.Skip("request numbers checked for the asm-generic encoding only")
+	}
+	var ctrl usbfsCtrlTransfer
+	var arg usbfsIoctlArg
+	var set usbfsSetInterface
+	var n uint32
+	want64 := unsafe.Sizeof(uintptr(0)) == 8
+	pick := func(on64, on32 uintptr) uintptr {
+		if want64 {
+			return on64
+		}
+		return on32
+	}
+
+	tests := []struct {
+		name string
+		got  uintptr
+		want uintptr
+	}{
+		{"USBDEVFS_CONTROL", usbfsRequest(iocRead|iocWrite, usbdevfsControl, unsafe.Sizeof(ctrl)), pick(0xc0185500, 0xc0105500)},
+		{"USBDEVFS_SETINTERFACE", usbfsRequest(iocRead, usbdevfsSetInterface, unsafe.Sizeof(set)), 0x80085504},
+		{"USBDEVFS_SETCONFIGURATION", usbfsRequest(iocRead, usbdevfsSetConfiguration, unsafe.Sizeof(n)), 0x80045505},
+		{"USBDEVFS_CLAIMINTERFACE", usbfsRequest(iocRead, usbdevfsClaimInterface, unsafe.Sizeof(n)), 0x8004550f},
+		{"USBDEVFS_RELEASEINTERFACE", usbfsRequest(iocRead, usbdevfsReleaseInterface, unsafe.Sizeof(n)), 0x80045510},
+		{"USBDEVFS_IOCTL", usbfsRequest(iocRead|iocWrite, usbdevfsIoctl, unsafe.Sizeof(arg)), pick(0xc0105512, 0xc00c5512)},
+		{"USBDEVFS_RESET", usbfsRequest(iocNone, usbdevfsReset, 0), 0x5514},
+		{"USBDEVFS_DISCONNECT", usbfsRequest(iocNone, usbdevfsDisconnect, 0), 0x5516},
+		{"USBDEVFS_CONNECT", usbfsRequest(iocNone, usbdevfsConnect, 0), 0x5517},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %#x, want %#x", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+// TestUsbfsLayout checks the ioctl argument structs against the layout of
+// their C counterparts.
+func TestUsbfsLayout(t *testing.T) {
+	ptr := unsafe.Sizeof(uintptr(0))
+	type field struct {
+		name   string
+		offset uintptr
+	}
+	tests := []struct {
+		v      any
+		size   uintptr
+		fields []field
+	}{
+		{usbfsCtrlTransfer{}, 2*ptr + 8, []field{
+			{"RequestType", 0}, {"Request", 1}, {"Value", 2}, {"Index", 4},
+			{"Length", 6}, {"Timeout", 8}, {"Data", ptr + 8}, // aligned after Timeout
+		}},
+		{usbfsIoctlArg{}, 8 + ptr, []field{{"Interface", 0}, {"Code", 4}, {"Data", 8}}},
+		{usbfsSetInterface{}, 8, []field{{"Interface", 0}, {"AltSetting", 4}}},
+	}
+	for _, tt := range tests {
+		typ := reflect.TypeOf(tt.v)
+		if typ.Size() != tt.size {
+			t.Errorf("%s is %d bytes, want %d", typ.Name(), typ.Size(), tt.size)
+		}
+		for _, f := range tt.fields {
+			sf, ok := typ.FieldByName(f.name)
+			if !ok || sf.Offset != f.offset {
+				t.Errorf("%s.%s at offset %d, want %d", typ.Name(), f.name, sf.Offset, f.offset)
+			}
+		}
+	}
+}
+
+func TestParseConfigs(t *testing.T) {
+	raw := []byte{
+		// Device descriptor.
+		18, 1, 0x00, 0x02, 0, 0, 0, 64, 0x24, 0x04, 0x40, 0x25, 0x01, 0x00, 1, 2, 3, 1,
+		// Interface before any configuration, ignored.
+		9, 4, 7, 0, 0, 0, 0, 0, 0,
+		// Configuration 1, string 4.
+		9, 2, 32, 0, 2, 1, 4, 0x80, 50,
+		// Interface 0, alternate 0, string 5, with an endpoint.
+		9, 4, 0, 0, 1, 0xff, 0, 0, 5,
+		7, 5, 0x81, 2, 0x00, 0x02, 0,
+		// Interface 0, alternate 1, no string, with a class descriptor.
+		9, 4, 0, 1, 0, 0xff, 0, 0, 0,
+		9, 0x21, 0x11, 0x01, 0, 1, 0x22, 0x20, 0,
+		// Configuration 2, no string.
+		9, 2, 18, 0, 1, 2, 0, 0x80, 50,
+		// Interface 1, alternate 0, string 6.
+		9, 4, 1, 0, 0, 0x08, 6, 0x50, 6,
+	}
+	d := newParseTarget()
+	d.parseConfigs(raw)
+
+	wantConfigs := map[int][]interfaceSetting{
+		1: {{Number: 0, Alternate: 0}, {Number: 0, Alternate: 1}},
+		2: {{Number: 1, Alternate: 0}},
+	}
+	if !reflect.DeepEqual(d.desc.Configs, wantConfigs) {
+		t.Errorf("configs %v, want %v", d.desc.Configs, wantConfigs)
+	}
+	if want := map[int]uint8{1: 4, 2: 0}; !reflect.DeepEqual(d.configStrings, want) {
+		t.Errorf("configuration strings %v, want %v", d.configStrings, want)
+	}
+	wantStrings := map[[3]int]uint8{{1, 0, 0}: 5, {1, 0, 1}: 0, {2, 1, 0}: 6}
+	if !reflect.DeepEqual(d.interfaceStrings, wantStrings) {
+		t.Errorf("interface strings %v, want %v", d.interfaceStrings, wantStrings)
+	}
+}
+
+// TestParseConfigsTruncated checks that malformed descriptors end parsing
+// without reading past the data.
+func TestParseConfigsTruncated(t *testing.T) {
+	config := []byte{9, 2, 25, 0, 1, 1, 0, 0x80, 50}
+	intf := []byte{9, 4, 0, 0, 0, 0xff, 0, 0, 0}
+	tests := []struct {
+		name string
+		raw  []byte
+		want map[int][]interfaceSetting
+	}{
+		{"empty", nil, map[int][]interfaceSetting{}},
+		{"one byte", []byte{9}, map[int][]interfaceSetting{}},
+		{"cut interface", append(append([]byte{}, config...), intf[:5]...), map[int][]interfaceSetting{1: nil}},
+		{"zero length", append(append(append([]byte{}, config...), 0, 4), intf...), map[int][]interfaceSetting{1: nil}},
+		{"short interface", append(append([]byte{}, config...), 3, 4, 0), map[int][]interfaceSetting{1: nil}},
+	}
+	for _, tt := range tests {
+		d := newParseTarget()
+		d.parseConfigs(tt.raw)
+		if !reflect.DeepEqual(d.desc.Configs, tt.want) {
+			t.Errorf("%s: configs %v, want %v", tt.name, d.desc.Configs, tt.want)
+		}
+	}
+}
+
+func newParseTarget() *usbfsDevice {
+	return &usbfsDevice{
+		desc:             &deviceDesc{},
+		configStrings:    make(map[int]uint8),
+		interfaceStrings: make(map[[3]int]uint8),
+	}
+}
+
+func TestUsbfsError(t *testing.T) {
+	tests := []struct {
+		errno syscall.Errno
+		want  error
+	}{
+		{syscall.ETIMEDOUT, errUSBTimeout},
+		{syscall.EPIPE, errUSBPipe},
+		{syscall.EACCES, errUSBAccess},
+		{syscall.ENODEV, errUSBNoDevice},
+		{syscall.ENODATA, errUSBNotFound},
+		{syscall.EBUSY, errUSBBusy},
+		{syscall.EINVAL, errUSBInvalidParam},
+		{syscall.EOVERFLOW, errUSBOverflow},
+		{syscall.EIO, errUSBIO},
+	}
+	for _, tt := range tests {
+		if got := usbfsError(tt.errno); !errors.Is(got, tt.want) {
+			t.Errorf("usbfsError(%v) = %v, want %v", tt.errno, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,469 @@
+//go:build js && wasm
+
+package sdwire
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+// This backend talks to devices through the browser's WebUSB API, so a
+// page compiled with GOOS=js GOARCH=wasm can switch an SDWire attached to
+// the operator's machine.
+//
+// WebUSB calls return promises, which are awaited by blocking the calling
+// goroutine; SDK functions must therefore not be called directly from a
+// js.FuncOf callback. Browsers have no file system for device locks, so
+// open devices with WithoutLock. WebUSB does not expose bus topology, so
+// port paths are not meaningful and devices are best selected by serial.
+
+// errWebUSBUnavailable is returned when the browser does not support WebUSB
+// or the page is not served from a secure context.
+var errWebUSBUnavailable = WithCode(CodeUnsupported, errors.New("WebUSB is not available in this browser"))
+
+// webusbError is a rejected WebUSB promise.
+type webusbError struct {
+	Name    string
+	Message string
+}
+
+func (e *webusbError) Error() string {
+	return "webusb: " + e.Name + ": " + e.Message
+}
+
+// webusbErrors maps DOMException names to USB errors.
+var webusbErrors = map[string]usbError{
+	"NotFoundError":     errUSBNoDevice,
+	"SecurityError":     errUSBAccess,
+	"NotAllowedError":   errUSBAccess,
+	"InvalidStateError": errUSBBusy,
+	"NetworkError":      errUSBIO,
+	"AbortError":        errUSBInterrupted,
+	"DataError":         errUSBInvalidParam,
+	"TypeError":         errUSBInvalidParam,
+}
+
+func webusb() (js.Value, error) {
+	usb := js.Global().Get("navigator").Get("usb")
+	if usb.IsUndefined() {
+		return js.Value{}, errWebUSBUnavailable
+	}
+	return usb, nil
+}
+
+// await blocks until promise p settles, returning its value or rejection.
+// A timeout of zero waits forever.
+func await(p js.Value, timeout time.Duration) (js.Value, error) {
+	var (
+		done   = make(chan struct{})
+		result js.Value
+		err    error
+	)
+	resolve := js.FuncOf(func(_ js.Value, args []js.Value) any {
+		if len(args) > 0 {
+			result = args[0]
+		}
+		close(done)
+		return nil
+	})
+	reject := js.FuncOf(func(_ js.Value, args []js.Value) any {
+		err = &webusbError{Name: "Error"}
+		if len(args) > 0 && args[0].Type() == js.TypeObject {
+			err = &webusbError{Name: args[0].Get("name").String(), Message: args[0].Get("message").String()}
+		}
+		close(done)
+		return nil
+	})
+	release := func() {
+		resolve.Release()
+		reject.Release()
+	}
+	p.Call("then", resolve, reject)
+
+	if timeout <= 0 {
+		<-done
+		release()
+		return result, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		release()
+		return result, err
+	case <-timer.C:
+		// The callbacks must outlive the promise.
+		go func() {
+			<-done
+			release()
+		}()
+		return js.Value{}, errUSBTimeout
+	}
+}
+
+// webusbFilters returns the requestDevice filters matching SDWire devices.
+func webusbFilters() js.Value {
+	return js.ValueOf([]any{
+		map[string]any{"vendorId": SDWireCVID, "productId": SDWireCPID},
+		map[string]any{"vendorId": SDWire3VID, "productId": SDWire3PID},
+	})
+}
+
+// RequestWebUSBDevice shows the browser's device chooser so the user can
+// grant the page access to an SDWire. Browsers only allow this in response
+// to a user gesture, so call it from a click handler; since such handlers
+// must not block, the result is delivered on the returned channel.
+// Granted devices are then found by ListDevices and New.
+func RequestWebUSBDevice() <-chan error {
+	result := make(chan error, 1)
+	usb, err := webusb()
+	if err != nil {
+		result <- err
+		return result
+	}
+	p := usb.Call("requestDevice", map[string]any{"filters": webusbFilters()})
+	go func() {
+		_, err := await(p, 0)
+		if err != nil {
+			err = fmt.Errorf("failed to request device: %w", err)
+		}
+		result <- err
+	}()
+	return result
+}
+
+// openSDWiresWhere opens the SDWires the page has been granted access to
+// for which match returns true, or all of them if match is nil.
+//
+// It may return devices alongside an error; the caller must close them.
+func openSDWiresWhere(match func(*deviceDesc) bool) ([]usbDevice, error) {
+	usb, err := webusb()
+	if err != nil {
+		return nil, err
+	}
+	list, err := await(usb.Call("getDevices"), 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	var (
+		devs     []usbDevice
+		firstErr error
+	)
+	for i := 0; i < list.Length(); i++ {
+		dev := newWebUSBDevice(list.Index(i))
+		if !isSDWire(dev.desc) || (match != nil && !match(dev.desc)) {
+			continue
+		}
+		if err := dev.open(); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to open device: %w", err)
+			}
+			continue
+		}
+		devs = append(devs, dev)
+	}
+	return devs, firstErr
+}
+
+// backendUSBError translates rejected WebUSB promises.
+func backendUSBError(err error) (usbError, bool) {
+	var webErr *webusbError
+	if !errors.As(err, &webErr) {
+		return 0, false
+	}
+	if code, ok := webusbErrors[webErr.Name]; ok {
+		return code, true
+	}
+	return errUSBIO, true
+}
+
+// checkBackend reports whether the browser supports WebUSB.
+func checkBackend() Finding {
+	f := Finding{Check: "webusb"}
+	if _, err := webusb(); err != nil {
+		f.Severity = SeverityError
+		f.Message = err.Error()
+		f.Fix = "use a Chromium-based browser and serve the page over HTTPS or from localhost"
+		return f
+	}
+	f.Message = "WebUSB is available"
+	return f
+}
+
+// webusbDevice is a device opened through WebUSB.
+type webusbDevice struct {
+	dev  js.Value
+	desc *deviceDesc
+
+	mu      sync.Mutex
+	timeout time.Duration
+	claimed map[int]bool
+}
+
+func newWebUSBDevice(dev js.Value) *webusbDevice {
+	desc := &deviceDesc{
+		Vendor:  uint16(dev.Get("vendorId").Int()),
+		Product: uint16(dev.Get("productId").Int()),
+		Device: uint16(dev.Get("deviceVersionMajor").Int())<<8 |
+			uint16(dev.Get("deviceVersionMinor").Int())<<4 |
+			uint16(dev.Get("deviceVersionSubminor").Int()),
+		Configs: make(map[int][]interfaceSetting),
+	}
+	configs := dev.Get("configurations")
+	for i := 0; i < configs.Length(); i++ {
+		cfg := configs.Index(i)
+		num := cfg.Get("configurationValue").Int()
+		intfs := cfg.Get("interfaces")
+		for j := 0; j < intfs.Length(); j++ {
+			intf := intfs.Index(j)
+			alts := intf.Get("alternates")
+			for k := 0; k < alts.Length(); k++ {
+				desc.Configs[num] = append(desc.Configs[num], interfaceSetting{
+					Number:    intf.Get("interfaceNumber").Int(),
+					Alternate: alts.Index(k).Get("alternateSetting").Int(),
+				})
+			}
+		}
+	}
+	return &webusbDevice{dev: dev, desc: desc, claimed: make(map[int]bool)}
+}
+
+func (d *webusbDevice) open() error {
+	if d.dev.Get("opened").Bool() {
+		return nil
+	}
+	_, err := await(d.dev.Call("open"), 0)
+	return err
+}
+
+func (d *webusbDevice) Descriptor() *deviceDesc {
+	return d.desc
+}
+
+// webusbSetup converts a control request to a USBControlTransferParameters
+// dictionary.
+func webusbSetup(rType, request uint8, value, index uint16) map[string]any {
+	kind := [...]string{"standard", "class", "vendor", "standard"}[rType>>5&3]
+	recipient := "other"
+	switch rType & 0x1f {
+	case 0:
+		recipient = "device"
+	case 1:
+		recipient = "interface"
+	case 2:
+		recipient = "endpoint"
+	}
+	return map[string]any{
+		"requestType": kind,
+		"recipient":   recipient,
+		"request":     int(request),
+		"value":       int(value),
+		"index":       int(index),
+	}
+}
+
+func (d *webusbDevice) Control(rType, request uint8, value, index uint16, data []byte) (int, error) {
+	d.mu.Lock()
+	timeout := d.timeout
+	d.mu.Unlock()
+
+	setup := webusbSetup(rType, request, value, index)
+	if rType&controlIn != 0 {
+		res, err := await(d.dev.Call("controlTransferIn", setup, len(data)), timeout)
+		if err != nil {
+			return 0, err
+		}
+		if err := webusbStatus(res); err != nil {
+			return 0, err
+		}
+		view := res.Get("data")
+		if view.IsNull() || view.IsUndefined() {
+			return 0, nil
+		}
+		buf := js.Global().Get("Uint8Array").New(view.Get("buffer"), view.Get("byteOffset"), view.Get("byteLength"))
+		return js.CopyBytesToGo(data, buf), nil
+	}
+
+	buf := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(buf, data)
+	res, err := await(d.dev.Call("controlTransferOut", setup, buf), timeout)
+	if err != nil {
+		return 0, err
+	}
+	if err := webusbStatus(res); err != nil {
+		return 0, err
+	}
+	return res.Get("bytesWritten").Int(), nil
+}
+
+// webusbStatus converts the status of a USBInTransferResult or
+// USBOutTransferResult to an error.
+func webusbStatus(res js.Value) error {
+	switch res.Get("status").String() {
+	case "ok":
+		return nil
+	case "stall":
+		return errUSBPipe
+	case "babble":
+		return errUSBOverflow
+	default:
+		return errUSBIO
+	}
+}
+
+func (d *webusbDevice) Reset() error {
+	_, err := await(d.dev.Call("reset"), 0)
+	return err
+}
+
+func (d *webusbDevice) SetControlTimeout(timeout time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.timeout = timeout
+}
+
+// InterfaceDriver is unknown: the browser does not expose kernel drivers.
+func (d *webusbDevice) InterfaceDriver(intf int) (string, error) {
+	return "", errDriverUnknown
+}
+
+// SetAutoDetach is a no-op; whether the browser may take an interface from
+// a kernel driver is up to the browser.
+func (d *webusbDevice) SetAutoDetach(autodetach bool) error {
+	return nil
+}
+
+// Config selects configuration cfgNum if it is not already active.
+func (d *webusbDevice) Config(cfgNum int) (usbConfig, error) {
+	active := d.dev.Get("configuration")
+	if active.IsNull() || active.Get("configurationValue").Int() != cfgNum {
+		if _, err := await(d.dev.Call("selectConfiguration", cfgNum), 0); err != nil {
+			return nil, err
+		}
+	}
+	return &webusbConfig{dev: d}, nil
+}
+
+func (d *webusbDevice) claim(intf, alt int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := await(d.dev.Call("claimInterface", intf), 0); err != nil {
+		return err
+	}
+	d.claimed[intf] = true
+	if _, err := await(d.dev.Call("selectAlternateInterface", intf, alt), 0); err != nil {
+		d.releaseLocked(intf)
+		return err
+	}
+	return nil
+}
+
+// releaseLocked releases a claimed interface. Callers must hold d.mu.
+func (d *webusbDevice) releaseLocked(intf int) error {
+	if !d.claimed[intf] {
+		return nil
+	}
+	delete(d.claimed, intf)
+	_, err := await(d.dev.Call("releaseInterface", intf), 0)
+	return err
+}
+
+func (d *webusbDevice) SerialNumber() (string, error) {
+	return d.attrString("serialNumber"), nil
+}
+
+func (d *webusbDevice) Product() (string, error) {
+	return d.attrString("productName"), nil
+}
+
+func (d *webusbDevice) Manufacturer() (string, error) {
+	return d.attrString("manufacturerName"), nil
+}
+
+// attrString reads a string attribute of the USBDevice, returning "" if
+// the device does not have one.
+func (d *webusbDevice) attrString(name string) string {
+	return stringOrEmpty(d.dev.Get(name))
+}
+
+func (d *webusbDevice) ConfigDescription(cfgNum int) (string, error) {
+	configs := d.dev.Get("configurations")
+	for i := 0; i < configs.Length(); i++ {
+		cfg := configs.Index(i)
+		if cfg.Get("configurationValue").Int() == cfgNum {
+			return stringOrEmpty(cfg.Get("configurationName")), nil
+		}
+	}
+	return "", nil
+}
+
+func (d *webusbDevice) InterfaceDescription(cfgNum, intfNum, altNum int) (string, error) {
+	configs := d.dev.Get("configurations")
+	for i := 0; i < configs.Length(); i++ {
+		cfg := configs.Index(i)
+		if cfg.Get("configurationValue").Int() != cfgNum {
+			continue
+		}
+		intfs := cfg.Get("interfaces")
+		for j := 0; j < intfs.Length(); j++ {
+			intf := intfs.Index(j)
+			if intf.Get("interfaceNumber").Int() != intfNum {
+				continue
+			}
+			alts := intf.Get("alternates")
+			for k := 0; k < alts.Length(); k++ {
+				if alt := alts.Index(k); alt.Get("alternateSetting").Int() == altNum {
+					return stringOrEmpty(alt.Get("interfaceName")), nil
+				}
+			}
+		}
+	}
+	return "", nil
+}
+
+func stringOrEmpty(v js.Value) string {
+	if v.Type() != js.TypeString {
+		return ""
+	}
+	return v.String()
+}
+
+func (d *webusbDevice) Close() error {
+	d.mu.Lock()
+	for intf := range d.claimed {
+		d.releaseLocked(intf)
+	}
+	d.mu.Unlock()
+	_, err := await(d.dev.Call("close"), 0)
+	return err
+}
+
+type webusbConfig struct {
+	dev *webusbDevice
+}
+
+func (c *webusbConfig) Interface(num, alt int) (io.Closer, error) {
+	if err := c.dev.claim(num, alt); err != nil {
+		return nil, err
+	}
+	return &webusbInterface{dev: c.dev, num: num}, nil
+}
+
+func (c *webusbConfig) Close() error {
+	return nil
+}
+
+type webusbInterface struct {
+	dev *webusbDevice
+	num int
+}
+
+func (i *webusbInterface) Close() error {
+	i.dev.mu.Lock()
+	defer i.dev.mu.Unlock()
+	return i.dev.releaseLocked(i.num)
+}
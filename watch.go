@@ -0,0 +1,143 @@
+package sdwire
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultWatchInterval is the polling interval used by Watch.
+const DefaultWatchInterval = time.Second
+
+// DeviceEventType identifies what happened to a device.
+type DeviceEventType int
+
+const (
+	// DeviceArrived reports a device that was plugged in, or was present
+	// when watching started.
+	DeviceArrived DeviceEventType = iota
+	// DeviceRemoved reports a device that was unplugged.
+	DeviceRemoved
+)
+
+// String returns a human-readable description of the event type.
+func (t DeviceEventType) String() string {
+	switch t {
+	case DeviceArrived:
+		return "Arrived"
+	case DeviceRemoved:
+		return "Removed"
+	default:
+		return "Unknown"
+	}
+}
+
+// DeviceEvent reports a device arriving or being removed.
+type DeviceEvent struct {
+	Type DeviceEventType
+	// Info describes the device. For removals it is the information last
+	// seen while the device was present.
+	Info *DeviceInfo
+	Time time.Time
+	// Initial is set on arrivals of devices that were already present
+	// when watching started.
+	Initial bool
+}
+
+// Watch reports SDWire arrivals and removals until ctx is done, at which
+// point the channel is closed. Devices already connected are reported as
+// arrivals first, with Initial set.
+//
+// gousb does not expose libusb hotplug callbacks, so devices are detected
+// by polling every DefaultWatchInterval.
+func Watch(ctx context.Context) (<-chan DeviceEvent, error) {
+	return WatchInterval(ctx, DefaultWatchInterval)
+}
+
+// WatchInterval is like Watch but polls every interval.
+//
+// Only the serial number is read while polling, so Product and
+// Manufacturer are empty in events.
+func WatchInterval(ctx context.Context, interval time.Duration) (<-chan DeviceEvent, error) {
+	devices, err := ListDevicesFields(0)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan DeviceEvent)
+	go func() {
+		defer close(events)
+		known := make(map[DeviceID]*DeviceInfo)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for initial := true; ; initial = false {
+			current := make(map[DeviceID]*DeviceInfo, len(devices))
+			for _, info := range keepSerials(known, devices) {
+				current[info.DeviceID()] = info
+			}
+			for key, info := range current {
+				if _, ok := known[key]; !ok {
+					if !sendEvent(ctx, events, DeviceEvent{Type: DeviceArrived, Info: info, Initial: initial}) {
+						return
+					}
+				}
+			}
+			for key, info := range known {
+				if _, ok := current[key]; !ok {
+					if !sendEvent(ctx, events, DeviceEvent{Type: DeviceRemoved, Info: info}) {
+						return
+					}
+				}
+			}
+			known = current
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			// Transient enumeration failures are retried on the next tick
+			// rather than reported as removals.
+			for {
+				if devices, err = ListDevicesFields(0); err == nil {
+					break
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// keepSerials replaces devices whose serial number could not be read with
+// what was known of the device at the same port. A failed string request
+// on a busy device would otherwise look like the device being removed and
+// a serial-less one arriving.
+func keepSerials(known map[DeviceID]*DeviceInfo, devices []*DeviceInfo) []*DeviceInfo {
+	for i, info := range devices {
+		id := info.DeviceID()
+		if id.HasSerial() {
+			continue
+		}
+		for key, prev := range known {
+			if key.HasSerial() && key.PortPath == id.PortPath && key.Vendor == id.Vendor && key.Product == id.Product {
+				devices[i] = prev
+				break
+			}
+		}
+	}
+	return devices
+}
+
+func sendEvent(ctx context.Context, events chan<- DeviceEvent, ev DeviceEvent) bool {
+	ev.Time = time.Now()
+	select {
+	case events <- ev:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,26 @@
+package sdwire
+
+import "testing"
+
+func TestKeepSerials(t *testing.T) {
+	prev := &DeviceInfo{ID: "sdwire_11", Serial: "sdwire_11", PortPath: "1-2", Generation: GenerationSDWireC}
+	known := map[DeviceID]*DeviceInfo{prev.DeviceID(): prev}
+
+	devices := keepSerials(known, []*DeviceInfo{
+		{ID: PortIDPrefix + "1-2", Serial: "unknown", PortPath: "1-2", Generation: GenerationSDWireC},
+		{ID: PortIDPrefix + "1-3", Serial: "unknown", PortPath: "1-3", Generation: GenerationSDWireC},
+	})
+	if devices[0] != prev {
+		t.Errorf("device at 1-2 is %+v, want the one known there", devices[0])
+	}
+	if devices[1].ID != PortIDPrefix+"1-3" {
+		t.Errorf("device at 1-3 took ID %q", devices[1].ID)
+	}
+
+	devices = keepSerials(known, []*DeviceInfo{
+		{ID: PortIDPrefix + "1-2", Serial: "unknown", PortPath: "1-2", Generation: GenerationSDWire3},
+	})
+	if devices[0] == prev {
+		t.Error("a device of another generation took the known serial")
+	}
+}
@@ -0,0 +1,145 @@
+package sdwire
+
+import (
+	"sync"
+	"time"
+)
+
+// Drift reports that a watchdog found the device in a different mode than
+// it was last switched to.
+type Drift struct {
+	Serial   string
+	PortPath string
+	// Desired is the mode the device was last switched to, Actual the
+	// mode it was found in.
+	Desired, Actual SwitchMode
+	// Err is the error re-asserting Desired, or nil if that succeeded.
+	Err  error
+	Time time.Time
+}
+
+// watchdog periodically re-asserts a device's mode.
+type watchdog struct {
+	stop chan struct{}
+	once sync.Once
+	done chan struct{}
+}
+
+func (w *watchdog) halt() {
+	w.once.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+// StartWatchdog checks every interval that the device is still in the mode
+// it was last switched to and, if not, switches it back and notifies
+// OnDrift listeners. This guards long soak tests against brown-outs and
+// spontaneous resets flipping the mux. Nothing is checked until the first
+// successful SetMode.
+//
+// The watchdog needs to read the mode back (see GetMode) and stops on
+// generations or hosts where that is not possible, or when the device is
+// gone. It runs until the returned function is called or the device is
+// closed. Starting a watchdog replaces any running one; on a closed device
+// it does nothing.
+func (s *SDWire) StartWatchdog(interval time.Duration) (stop func()) {
+	w := &watchdog{stop: make(chan struct{}), done: make(chan struct{})}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		close(w.done)
+		return w.halt
+	}
+	prev := s.watchdog
+	s.watchdog = w
+	s.mu.Unlock()
+	if prev != nil {
+		prev.halt()
+	}
+
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+			}
+			drift, ok := s.checkDrift()
+			if !ok {
+				return
+			}
+			if drift != nil {
+				s.driftListeners.notify(*drift)
+			}
+		}
+	}()
+	return w.halt
+}
+
+// OnDrift registers fn to be called when the watchdog finds the device in
+// the wrong mode, and returns a function that unregisters it. fn runs on
+// the watchdog goroutine and should return quickly.
+func (s *SDWire) OnDrift(fn func(Drift)) (cancel func()) {
+	return s.driftListeners.add(fn)
+}
+
+// checkDrift runs one watchdog check. It returns false if the watchdog
+// should stop.
+func (s *SDWire) checkDrift() (*Drift, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, false
+	}
+	r, ok := s.controller.(modeReader)
+	if !ok || s.gone != nil {
+		s.log.Debug("watchdog stopped", "gone", s.gone != nil)
+		return nil, false
+	}
+	if s.lastSwitch.IsZero() {
+		return nil, true
+	}
+	actual, err := r.GetMode()
+	if err != nil {
+		if code := CodeOf(err); code == CodeDeviceGone || code == CodeUnsupported {
+			s.checkGone("watchdog", err)
+			s.log.Debug("watchdog stopped", "error", err)
+			return nil, false
+		}
+		s.log.Debug("watchdog check failed", "error", err)
+		return nil, true
+	}
+	if actual == s.lastMode {
+		return nil, true
+	}
+
+	s.log.Debug("mode drifted, re-asserting", "desired", s.lastMode, "actual", actual)
+	s.metrics.Retry(s.serial, "watchdog")
+	err = s.checkGone("watchdog", s.controller.SetMode(s.lastMode))
+	if err != nil {
+		s.metrics.Error(s.serial, "watchdog")
+	}
+	s.Audit("watchdog", "re-asserted "+s.lastMode.String(), err)
+	return &Drift{
+		Serial:   s.serial,
+		PortPath: s.portPath,
+		Desired:  s.lastMode,
+		Actual:   actual,
+		Err:      err,
+		Time:     time.Now(),
+	}, true
+}
+
+// stopWatchdog stops the running watchdog, if any.
+func (s *SDWire) stopWatchdog() {
+	s.mu.Lock()
+	w := s.watchdog
+	s.watchdog = nil
+	s.mu.Unlock()
+	if w != nil {
+		w.halt()
+	}
+}
@@ -0,0 +1,320 @@
+package sdwire
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CardWear is the wear recorded for a physical SD card, identified by its
+// CID register, across every device and process that flashed it.
+type CardWear struct {
+	// CID is the card identification register in hex, as Linux reports it.
+	CID          string `json:"cid"`
+	BytesWritten int64  `json:"bytes_written"`
+	FlashCycles  int    `json:"flash_cycles"`
+	// Device is the serial number of the device the card was last flashed
+	// in.
+	Device    string    `json:"device,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastFlash time.Time `json:"last_flash"`
+	// Dirty, if not empty, says why the card holds a partly written image;
+	// see SDWire.MarkCardDirty.
+	Dirty string `json:"dirty,omitempty"`
+}
+
+// WearLevel classifies card wear against WearThresholds.
+type WearLevel int
+
+const (
+	WearOK WearLevel = iota
+	// WearWarning means the card is close to a limit.
+	WearWarning
+	// WearExceeded means the card is past a limit and should be replaced.
+	WearExceeded
+)
+
+func (l WearLevel) String() string {
+	switch l {
+	case WearWarning:
+		return "warning"
+	case WearExceeded:
+		return "exceeded"
+	default:
+		return "ok"
+	}
+}
+
+// WearThresholds are the limits past which a card should be replaced.
+// Zero limits are not checked.
+type WearThresholds struct {
+	MaxBytesWritten int64 `json:"max_bytes_written,omitempty"`
+	MaxFlashCycles  int   `json:"max_flash_cycles,omitempty"`
+	// WarnAt is the fraction of a limit from which the card is reported
+	// as WearWarning. It defaults to 0.8.
+	WarnAt float64 `json:"warn_at,omitempty"`
+}
+
+// Level classifies w, taking the worse of the two limits.
+func (t WearThresholds) Level(w CardWear) WearLevel {
+	warnAt := t.WarnAt
+	if warnAt <= 0 {
+		warnAt = 0.8
+	}
+	level := WearOK
+	check := func(used, limit float64) {
+		switch {
+		case limit <= 0:
+		case used >= limit:
+			level = max(level, WearExceeded)
+		case used >= limit*warnAt:
+			level = max(level, WearWarning)
+		}
+	}
+	check(float64(w.BytesWritten), float64(t.MaxBytesWritten))
+	check(float64(w.FlashCycles), float64(t.MaxFlashCycles))
+	return level
+}
+
+// WearStore keeps card wear in a JSON file. The file is locked while it is
+// updated, so processes on a host can share it.
+type WearStore struct {
+	path       string
+	thresholds WearThresholds
+}
+
+// NewWearStore returns a store keeping card wear in the JSON file at path,
+// which is created on the first flash recorded.
+func NewWearStore(path string, thresholds WearThresholds) *WearStore {
+	return &WearStore{path: path, thresholds: thresholds}
+}
+
+// Thresholds returns the limits the store reports wear against.
+func (s *WearStore) Thresholds() WearThresholds {
+	return s.thresholds
+}
+
+// Record adds a flash of n bytes to the card with the given CID, flashed
+// in the device with the given serial number, and returns its wear.
+func (s *WearStore) Record(cid, device string, n int64) (CardWear, error) {
+	if cid == "" {
+		return CardWear{}, WithCode(CodeInvalidArgument, errors.New("card CID is empty"))
+	}
+	unlock, err := s.lock()
+	if err != nil {
+		return CardWear{}, err
+	}
+	defer unlock()
+
+	cards, err := s.load()
+	if err != nil {
+		return CardWear{}, err
+	}
+	now := time.Now().UTC()
+	w, ok := cards[cid]
+	if !ok {
+		w = CardWear{CID: cid, FirstSeen: now}
+	}
+	w.BytesWritten += n
+	w.FlashCycles++
+	w.Device = device
+	w.LastFlash = now
+	cards[cid] = w
+	if err := s.save(cards); err != nil {
+		return CardWear{}, err
+	}
+	return w, nil
+}
+
+// SetDirty records why the card with the given CID holds a partly written
+// image, or with an empty reason that it no longer does.
+func (s *WearStore) SetDirty(cid, reason string) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	cards, err := s.load()
+	if err != nil {
+		return err
+	}
+	w, ok := cards[cid]
+	if w.Dirty == reason {
+		return nil
+	}
+	if !ok {
+		w = CardWear{CID: cid, FirstSeen: time.Now().UTC()}
+	}
+	w.Dirty = reason
+	cards[cid] = w
+	return s.save(cards)
+}
+
+// Card returns the wear of the card with the given CID.
+func (s *WearStore) Card(cid string) (CardWear, bool, error) {
+	cards, err := s.load()
+	if err != nil {
+		return CardWear{}, false, err
+	}
+	w, ok := cards[cid]
+	return w, ok, nil
+}
+
+// Cards returns the wear of every card recorded, sorted by CID.
+func (s *WearStore) Cards() ([]CardWear, error) {
+	cards, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]CardWear, 0, len(cards))
+	for _, w := range cards {
+		list = append(list, w)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CID < list[j].CID
+	})
+	return list, nil
+}
+
+// lastCardIn returns the card most recently flashed in the device with the
+// given serial number.
+func lastCardIn(cards []CardWear, device string) (CardWear, bool) {
+	var last CardWear
+	found := false
+	for _, w := range cards {
+		if w.Device == device && (!found || w.LastFlash.After(last.LastFlash)) {
+			last, found = w, true
+		}
+	}
+	return last, found
+}
+
+func (s *WearStore) lock() (unlock func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to lock wear store: %w", err)
+	}
+	f, err := os.OpenFile(s.path+".lock", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock wear store: %w", err)
+	}
+	if err := flock(f, true); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock wear store: %w", err)
+	}
+	return func() {
+		funlock(f)
+		f.Close()
+	}, nil
+}
+
+func (s *WearStore) load() (map[string]CardWear, error) {
+	cards := make(map[string]CardWear)
+	data, err := os.ReadFile(s.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return cards, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read wear store: %w", err)
+	}
+	var list []CardWear
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to parse wear store: %w", err)
+	}
+	for _, w := range list {
+		cards[w.CID] = w
+	}
+	return cards, nil
+}
+
+// save replaces the file, so readers never see a partial write.
+func (s *WearStore) save(cards map[string]CardWear) error {
+	list := make([]CardWear, 0, len(cards))
+	for _, w := range cards {
+		list = append(list, w)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CID < list[j].CID
+	})
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write wear store: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to write wear store: %w", err)
+	}
+	return nil
+}
+
+var (
+	wearMu    sync.RWMutex
+	wearStore *WearStore
+)
+
+// SetWearStore installs the store that flashes reported through
+// RecordFlash and RecordBytesFlashed are added to, and that Inventory
+// reports card wear from. Pass nil to stop tracking wear, which is the
+// default.
+func SetWearStore(s *WearStore) {
+	wearMu.Lock()
+	defer wearMu.Unlock()
+	wearStore = s
+}
+
+// packageWearStore returns the store installed with SetWearStore.
+func packageWearStore() *WearStore {
+	wearMu.RLock()
+	defer wearMu.RUnlock()
+	return wearStore
+}
+
+// CardCID returns the CID register of the card in the device's reader,
+// which identifies the physical card. The card must be switched to the
+// host. It is only available on Linux, from readers whose driver exposes
+// the card's registers; most USB mass storage readers hide them.
+func (s *SDWire) CardCID() (string, error) {
+	devices, err := s.BlockDevices()
+	if err != nil {
+		return "", err
+	}
+	if len(devices) == 0 {
+		return "", WithCode(CodeCardMissing, errors.New("no card found in the reader"))
+	}
+	return cardCID(devices[0])
+}
+
+// recordCard identifies the card flashed with n bytes and adds the flash
+// to its wear.
+func (s *SDWire) recordCard(n int64) {
+	if n <= 0 {
+		return
+	}
+	card, err := s.IdentifyCard()
+	if err != nil {
+		s.log.Debug("not identifying card", "error", err)
+		return
+	}
+	store := packageWearStore()
+	if store == nil {
+		return
+	}
+	w, err := store.Record(card.CID, s.serial, n)
+	if err != nil {
+		s.log.Warn("failed to record card wear", "error", err)
+		return
+	}
+	if level := store.thresholds.Level(w); level != WearOK {
+		s.log.Warn("SD card is wearing out", "card", cardName(card), "level", level,
+			"bytes_written", w.BytesWritten, "flash_cycles", w.FlashCycles)
+	}
+}
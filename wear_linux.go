@@ -0,0 +1,23 @@
+package sdwire
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cardCID reads the CID of the card behind a block device from sysfs. The
+// MMC layer publishes it; SCSI disks of USB mass storage readers do not.
+func cardCID(device string) (string, error) {
+	data, err := os.ReadFile(filepath.Join("/sys/class/block", filepath.Base(device), "device", "cid"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", WithCode(CodeUnsupported, fmt.Errorf("the card reader of %s does not expose the card's CID", device))
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read card CID: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
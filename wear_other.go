@@ -0,0 +1,9 @@
+//go:build !linux
+
+package sdwire
+
+import "errors"
+
+func cardCID(string) (string, error) {
+	return "", WithCode(CodeUnsupported, errors.New("reading the card CID is only supported on Linux"))
+}
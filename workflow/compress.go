@@ -0,0 +1,130 @@
+package workflow
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// imageFormats maps the magic numbers of compressed images to the command
+// that decompresses them. gzip and bzip2 are decompressed in process.
+var imageFormats = []struct {
+	magic   []byte
+	name    string
+	command []string
+}{
+	{magic: []byte{0x1f, 0x8b}, name: "gzip"},
+	{magic: []byte("BZh"), name: "bzip2"},
+	{magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, name: "xz", command: []string{"xz", "-dc"}},
+	{magic: []byte{0x28, 0xb5, 0x2f, 0xfd}, name: "zstd", command: []string{"zstd", "-dc"}},
+}
+
+// openImage opens the image at path, decompressing it as it is read if it
+// is compressed. The size is -1 for compressed images, whose decompressed
+// size is not known up front.
+func openImage(path string) (io.ReadCloser, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	br := bufio.NewReader(f)
+	head, _ := br.Peek(6)
+	for _, format := range imageFormats {
+		if !bytes.HasPrefix(head, format.magic) {
+			continue
+		}
+		switch {
+		case format.name == "gzip":
+			zr, err := gzip.NewReader(br)
+			if err != nil {
+				f.Close()
+				return nil, 0, fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			return readCloser{zr, f}, -1, nil
+		case format.name == "bzip2":
+			return readCloser{bzip2.NewReader(br), f}, -1, nil
+		}
+		f.Close()
+		r, err := startDecompressor(format.command, path)
+		return r, -1, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return readCloser{br, f}, info.Size(), nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// startDecompressor runs a decompression command on path and returns its
+// output.
+func startDecompressor(command []string, path string) (io.ReadCloser, error) {
+	cmd := exec.Command(command[0], append(command[1:], path)...)
+	r := &commandReader{cmd: cmd}
+	cmd.Stderr = &r.stderr
+	// Children of the command that outlive it must not hold up Close.
+	cmd.WaitDelay = time.Second
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", command[0], err)
+	}
+	r.out = out
+	return r, nil
+}
+
+// commandReader reads the output of a command, reporting its failure at
+// the end of the output.
+type commandReader struct {
+	cmd    *exec.Cmd
+	out    io.ReadCloser
+	stderr bytes.Buffer
+
+	// wait makes Read and Close, which may run concurrently when a flash
+	// is canceled, wait for the command only once.
+	wait    sync.Once
+	waitErr error
+}
+
+func (r *commandReader) Read(p []byte) (int, error) {
+	n, err := r.out.Read(p)
+	// Waiting for the command closes its output, so reads after the end
+	// fail with os.ErrClosed.
+	if err == io.EOF || errors.Is(err, os.ErrClosed) {
+		if werr := r.waitCmd(); werr != nil {
+			return n, fmt.Errorf("%s: %w: %s", r.cmd.Args[0], werr, strings.TrimSpace(r.stderr.String()))
+		}
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Close stops the command if it is still running.
+func (r *commandReader) Close() error {
+	r.cmd.Process.Kill()
+	r.waitCmd()
+	return nil
+}
+
+func (r *commandReader) waitCmd() error {
+	r.wait.Do(func() {
+		r.waitErr = r.cmd.Wait()
+	})
+	return r.waitErr
+}
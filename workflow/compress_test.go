@@ -0,0 +1,164 @@
+package workflow
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// xzMagic starts the images given to the stub decompressor.
+var xzMagic = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+
+// stubDecompressor makes openImage decompress xz images with a shell
+// script instead of xz. The script gets the image path as $0.
+func stubDecompressor(t *testing.T, script string) {
+	t.Helper()
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh to run the stub decompressor")
+	}
+	for i := range imageFormats {
+		if imageFormats[i].name == "xz" {
+			command := imageFormats[i].command
+			imageFormats[i].command = []string{"sh", "-c", script}
+			t.Cleanup(func() { imageFormats[i].command = command })
+			return
+		}
+	}
+	t.Fatal("no xz format")
+}
+
+// stripMagic is a stub decompressor whose output is its input without
+// the xz magic number.
+const stripMagic = `tail -c +7 "$0"`
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenImage(t *testing.T) {
+	image := randomImage(100000)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(image)
+	zw.Close()
+
+	tests := []struct {
+		name     string
+		data     []byte
+		wantSize int64
+	}{
+		{"plain", image, int64(len(image))},
+		{"gzip", gz.Bytes(), -1},
+	}
+	for _, tt := range tests {
+		r, size, err := openImage(writeFile(t, tt.name, tt.data))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if size != tt.wantSize || !bytes.Equal(got, image) {
+			t.Errorf("%s: size %d, want %d; image differs: %t", tt.name, size, tt.wantSize, !bytes.Equal(got, image))
+		}
+	}
+}
+
+func TestFlashDecompressed(t *testing.T) {
+	stubDecompressor(t, stripMagic)
+	image := randomImage(3*4096 + 10)
+	path := writeFile(t, "image.xz", append(append([]byte{}, xzMagic...), image...))
+	device := writeFile(t, "card", nil)
+
+	var (
+		totals []int64
+		stats  *FlashStats
+	)
+	cfg := FlashConfig{
+		BlockSize: 4096,
+		Progress:  func(_, total int64) { totals = append(totals, total) },
+		Stats:     func(s FlashStats) { stats = &s },
+	}
+	n, err := flash(context.Background(), path, device, cfg)
+	if err != nil {
+		t.Fatalf("flash: %v", err)
+	}
+	got, _ := os.ReadFile(device)
+	if n != int64(len(image)) || !bytes.Equal(got, image) {
+		t.Fatalf("flashed %d bytes; card differs from the image: %t", n, !bytes.Equal(got, image))
+	}
+	for _, total := range totals {
+		if total != -1 {
+			t.Errorf("progress total %d for a compressed image, want -1", total)
+		}
+	}
+	if stats == nil || stats.Bytes != n || stats.Total < stats.Write {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestDecompressorFailure(t *testing.T) {
+	stubDecompressor(t, stripMagic+`; echo corrupt input >&2; exit 3`)
+	path := writeFile(t, "image.xz", append(append([]byte{}, xzMagic...), randomImage(100)...))
+	r, _, err := openImage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	got, err := io.ReadAll(r)
+	if len(got) != 100 || err == nil || !strings.Contains(err.Error(), "corrupt input") {
+		t.Fatalf("ReadAll = %d bytes, %v, want 100 bytes and the command's error", len(got), err)
+	}
+	// The failure is still reported to reads after the command was waited
+	// for.
+	if _, err2 := r.Read(make([]byte, 10)); err2 == nil || err2.Error() != err.Error() {
+		t.Errorf("second read at EOF = %v, want %v", err2, err)
+	}
+}
+
+// TestDecompressorCloseDuringRead closes the image, as a canceled flash
+// does, while a read waits for output.
+func TestDecompressorCloseDuringRead(t *testing.T) {
+	stubDecompressor(t, `sleep 10`)
+	path := writeFile(t, "image.xz", xzMagic)
+	r, _, err := openImage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(r)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("read of a killed decompressor succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read still blocked after Close")
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
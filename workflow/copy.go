@@ -0,0 +1,299 @@
+package workflow
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// FlashConfig tunes how FlashWith copies an image to a card. The zero value
+// copies like Flash.
+type FlashConfig struct {
+	// BlockSize is the size of each write. Zero means 4 MiB.
+	BlockSize int
+	// QueueDepth is the number of blocks buffered between reading the
+	// image and writing the card, so a slow read does not stall the card.
+	// Zero still overlaps reading the next block with each write.
+	QueueDepth int
+	// AutoTune measures the throughput of a few block sizes over the start
+	// of the image and uses the fastest for the rest. BlockSize is then
+	// ignored.
+	AutoTune bool
+	// SyncEvery flushes the device every SyncEvery bytes. Zero leaves
+	// write-back to the kernel.
+	SyncEvery int64
+	// Direct opens the device with O_DIRECT, bypassing the page cache so
+	// that host memory use stays flat however large the image. Writes then
+	// wait for the card, so combine it with a QueueDepth to keep reading
+	// ahead. BlockSize must be a multiple of 4 KiB. Only Linux and FreeBSD
+	// support it.
+	Direct bool
+	// ZeroOnAbort zeroes the start of the card, where the partition table
+	// and boot loader live, when a flash is canceled or fails part way,
+	// so the DUT cannot boot a half-written image.
+	ZeroOnAbort bool
+	// Progress, if not nil, is called after each block with the number of
+	// bytes written so far and the size of the image, or -1 for compressed
+	// images.
+	Progress func(written, total int64)
+	// Stats, if not nil, is called with the stage timings of a successful
+	// flash.
+	Stats func(FlashStats)
+}
+
+// FlashStats reports where a flash spent its time. Reading and writing
+// overlap, so the slower stage is the bottleneck: reading for slow or
+// heavily compressed image sources, writing for slow cards.
+type FlashStats struct {
+	Bytes int64
+	// Read is the time spent reading and decompressing the image.
+	Read time.Duration
+	// Write is the time spent writing and syncing the card.
+	Write time.Duration
+	// Total is the wall time of the copy.
+	Total time.Duration
+}
+
+// Bottleneck returns "read" or "write", whichever stage took longer.
+func (s FlashStats) Bottleneck() string {
+	if s.Read > s.Write {
+		return "read"
+	}
+	return "write"
+}
+
+func (c FlashConfig) blockSize() int {
+	if c.BlockSize > 0 {
+		return c.BlockSize
+	}
+	return copyBufferSize
+}
+
+// autoTuneSizes are the block sizes AutoTune tries, each for autoTuneSpan
+// bytes. Every size divides autoTuneSpan.
+var autoTuneSizes = []int{256 << 10, 1 << 20, 4 << 20, 16 << 20}
+
+const autoTuneSpan = 64 << 20
+
+// directAlignment is the buffer and length alignment O_DIRECT requires.
+const directAlignment = 4096
+
+// bufferPools holds reusable copy buffers, one sync.Pool per size, so that
+// back-to-back flashes do not each allocate their buffers. The buffers are
+// aligned for O_DIRECT.
+var bufferPools sync.Map
+
+func getBuffer(size int) []byte {
+	p, _ := bufferPools.LoadOrStore(size, &sync.Pool{
+		New: func() any {
+			b := make([]byte, size+directAlignment)
+			off := directAlignment - int(uintptr(unsafe.Pointer(&b[0]))%directAlignment)
+			b = b[off : off+size : off+size]
+			return &b
+		},
+	})
+	return *p.(*sync.Pool).Get().(*[]byte)
+}
+
+func putBuffer(b []byte) {
+	if p, ok := bufferPools.Load(cap(b)); ok {
+		b = b[:cap(b)]
+		p.(*sync.Pool).Put(&b)
+	}
+}
+
+// block is a chunk of the image on its way to the card.
+type block struct {
+	buf []byte
+	n   int
+	err error
+}
+
+// syncWriter is the device being written.
+type syncWriter interface {
+	io.Writer
+	Sync() error
+}
+
+// copyImage copies src to dst as cfg describes, checking ctx between
+// blocks. If progress is not nil it is called with the total written after
+// each block. The stats are filled in if the copy succeeds.
+func copyImage(ctx context.Context, dst syncWriter, src io.Reader, cfg FlashConfig, progress func(int64), stats *FlashStats) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	start := time.Now()
+	blocks := make(chan block, cfg.QueueDepth)
+	tuned := make(chan int, 1)
+	var readTime time.Duration
+	go readBlocks(ctx, src, cfg, blocks, tuned, &readTime)
+
+	var (
+		written, unsynced int64
+		tuning            = cfg.AutoTune
+		phaseStart        = time.Now()
+		bestSize          int
+		bestRate          float64
+	)
+	for b := range blocks {
+		if b.err != nil {
+			return written, b.err
+		}
+		if err := ctx.Err(); err != nil {
+			putBuffer(b.buf)
+			return written, err
+		}
+		writeStart := time.Now()
+		m, err := dst.Write(b.buf[:b.n])
+		putBuffer(b.buf)
+		written += int64(m)
+		unsynced += int64(m)
+		if err == nil && m < b.n {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			return written, err
+		}
+
+		if tuning && written%autoTuneSpan == 0 {
+			// Syncing ends each phase so the page cache does not hide
+			// the card's speed.
+			if err := dst.Sync(); err != nil {
+				return written, err
+			}
+			unsynced = 0
+			phase := int(written/autoTuneSpan) - 1
+			if rate := autoTuneSpan / time.Since(phaseStart).Seconds(); rate > bestRate {
+				bestSize, bestRate = autoTuneSizes[phase], rate
+			}
+			if phase == len(autoTuneSizes)-1 {
+				tuned <- bestSize
+				tuning = false
+			}
+			phaseStart = time.Now()
+		}
+		if cfg.SyncEvery > 0 && unsynced >= cfg.SyncEvery {
+			if err := dst.Sync(); err != nil {
+				return written, err
+			}
+			unsynced = 0
+		}
+		stats.Write += time.Since(writeStart)
+		if progress != nil {
+			progress(written)
+		}
+	}
+	// readBlocks is done with readTime once blocks is closed.
+	stats.Bytes = written
+	stats.Read = readTime
+	stats.Total = time.Since(start)
+	return written, ctx.Err()
+}
+
+// readBlocks reads src into pooled buffers and sends them on blocks, which
+// it closes at the end of src. With AutoTune, the block size follows
+// autoTuneSizes until the writer sends its choice on tuned. The time spent
+// reading src is added to readTime.
+func readBlocks(ctx context.Context, src io.Reader, cfg FlashConfig, blocks chan<- block, tuned <-chan int, readTime *time.Duration) {
+	defer close(blocks)
+	size := cfg.blockSize()
+	tuning := cfg.AutoTune
+	var offset int64
+	for {
+		if tuning {
+			if phase := int(offset / autoTuneSpan); phase < len(autoTuneSizes) {
+				size = autoTuneSizes[phase]
+			} else {
+				select {
+				case size = <-tuned:
+				case <-ctx.Done():
+					return
+				}
+				tuning = false
+			}
+		}
+
+		buf := getBuffer(size)
+		readStart := time.Now()
+		n, err := io.ReadFull(src, buf)
+		*readTime += time.Since(readStart)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+			if n == 0 {
+				putBuffer(buf)
+				return
+			}
+		} else if err != nil {
+			putBuffer(buf)
+			n, buf = 0, nil
+		}
+		select {
+		case blocks <- block{buf: buf, n: n, err: err}:
+		case <-ctx.Done():
+			putBuffer(buf)
+			return
+		}
+		if err != nil || n < size {
+			return
+		}
+		offset += int64(n)
+	}
+}
+
+// directFile writes to a device opened with O_DIRECT. The unaligned tail of
+// the image, which O_DIRECT cannot write, goes through a second, buffered
+// descriptor.
+type directFile struct {
+	*os.File
+	path   string
+	offset int64
+	tail   *os.File
+}
+
+// openDirect opens path for writing with O_DIRECT.
+func openDirect(path string) (*directFile, error) {
+	if directFlag == 0 {
+		return nil, errDirectUnsupported
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|directFlag, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &directFile{File: f, path: path}, nil
+}
+
+func (f *directFile) Write(p []byte) (int, error) {
+	aligned := len(p) - len(p)%directAlignment
+	n, err := f.File.Write(p[:aligned])
+	f.offset += int64(n)
+	if err != nil || aligned == len(p) {
+		return n, err
+	}
+	if f.tail == nil {
+		if f.tail, err = os.OpenFile(f.path, os.O_WRONLY, 0); err != nil {
+			return n, err
+		}
+	}
+	m, err := f.tail.WriteAt(p[aligned:], f.offset)
+	f.offset += int64(m)
+	return n + m, err
+}
+
+func (f *directFile) Sync() error {
+	if f.tail != nil {
+		if err := f.tail.Sync(); err != nil {
+			return err
+		}
+	}
+	return f.File.Sync()
+}
+
+func (f *directFile) Close() error {
+	if f.tail != nil {
+		f.tail.Close()
+	}
+	return f.File.Close()
+}
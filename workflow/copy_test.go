@@ -0,0 +1,315 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/fcjr/sdwire"
+)
+
+// card is an in-memory device that records its writes and syncs.
+type card struct {
+	bytes.Buffer
+	writes []int
+	syncs  int
+	// limit, if positive, is the number of bytes accepted before writes
+	// come up short; err is then returned with them, if not nil.
+	limit int
+	err   error
+}
+
+func (c *card) Write(p []byte) (int, error) {
+	c.writes = append(c.writes, len(p))
+	if c.limit > 0 && c.Len()+len(p) > c.limit {
+		n, _ := c.Buffer.Write(p[:c.limit-c.Len()])
+		return n, c.err
+	}
+	return c.Buffer.Write(p)
+}
+
+func (c *card) Sync() error {
+	c.syncs++
+	return nil
+}
+
+func randomImage(size int) []byte {
+	image := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(image)
+	return image
+}
+
+func TestCopyImage(t *testing.T) {
+	const blockSize = 4096
+	for _, size := range []int{0, 1, blockSize - 1, blockSize, 3*blockSize + 7} {
+		for _, depth := range []int{0, 4} {
+			image := randomImage(size)
+			dst := &card{}
+			var progress []int64
+			var stats FlashStats
+			cfg := FlashConfig{BlockSize: blockSize, QueueDepth: depth}
+			n, err := copyImage(context.Background(), dst, bytes.NewReader(image), cfg, func(w int64) { progress = append(progress, w) }, &stats)
+			if err != nil {
+				t.Fatalf("size %d, depth %d: %v", size, depth, err)
+			}
+			if n != int64(size) || !bytes.Equal(dst.Bytes(), image) {
+				t.Fatalf("size %d, depth %d: copied %d bytes, image differs: %t", size, depth, n, !bytes.Equal(dst.Bytes(), image))
+			}
+			if blocks := (size + blockSize - 1) / blockSize; len(dst.writes) != blocks || len(progress) != blocks {
+				t.Errorf("size %d: %d writes, %d progress calls, want %d", size, len(dst.writes), len(progress), blocks)
+			}
+			for i, w := range progress {
+				if want := min(int64(i+1)*blockSize, int64(size)); w != want {
+					t.Errorf("size %d: progress %v, want multiples of the block size up to the image size", size, progress)
+					break
+				}
+			}
+			if stats.Bytes != int64(size) {
+				t.Errorf("size %d: stats report %d bytes", size, stats.Bytes)
+			}
+			if dst.syncs != 0 {
+				t.Errorf("size %d: %d syncs without SyncEvery", size, dst.syncs)
+			}
+		}
+	}
+}
+
+func TestCopyImageSyncEvery(t *testing.T) {
+	dst := &card{}
+	cfg := FlashConfig{BlockSize: 4096, SyncEvery: 3 * 4096}
+	if _, err := copyImage(context.Background(), dst, bytes.NewReader(randomImage(10*4096)), cfg, nil, &FlashStats{}); err != nil {
+		t.Fatal(err)
+	}
+	if dst.syncs != 3 {
+		t.Errorf("%d syncs of 10 blocks, syncing every 3, want 3", dst.syncs)
+	}
+}
+
+func TestCopyImageShortWrite(t *testing.T) {
+	failure := errors.New("card removed")
+	for _, werr := range []error{nil, failure} {
+		dst := &card{limit: 5000, err: werr}
+		cfg := FlashConfig{BlockSize: 4096}
+		n, err := copyImage(context.Background(), dst, bytes.NewReader(randomImage(4*4096)), cfg, nil, &FlashStats{})
+		want := werr
+		if want == nil {
+			want = io.ErrShortWrite
+		}
+		if !errors.Is(err, want) {
+			t.Errorf("short write returning %v: copyImage = %v, want %v", werr, err, want)
+		}
+		if n != 5000 {
+			t.Errorf("short write returning %v: %d bytes written, want 5000", werr, n)
+		}
+		if len(dst.writes) != 2 {
+			t.Errorf("short write returning %v: %d writes, want the copy to stop after 2", werr, len(dst.writes))
+		}
+	}
+}
+
+// failingReader returns err after n bytes.
+type failingReader struct {
+	n   int
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, r.err
+	}
+	n := min(len(p), r.n)
+	clear(p[:n])
+	r.n -= n
+	return n, nil
+}
+
+func TestCopyImageReadError(t *testing.T) {
+	failure := errors.New("read failed")
+	dst := &card{}
+	cfg := FlashConfig{BlockSize: 4096, QueueDepth: 2}
+	n, err := copyImage(context.Background(), dst, &failingReader{n: 2*4096 + 100, err: failure}, cfg, nil, &FlashStats{})
+	if !errors.Is(err, failure) {
+		t.Fatalf("copyImage = %v, want the read error", err)
+	}
+	// The partial block read before the error is dropped with it.
+	if n != 2*4096 || dst.Len() != 2*4096 {
+		t.Errorf("%d bytes written, card holds %d, want the 2 whole blocks", n, dst.Len())
+	}
+}
+
+func TestCopyImageCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dst := &card{}
+	cfg := FlashConfig{BlockSize: 4096, QueueDepth: 2}
+	progress := func(written int64) {
+		if written == 2*4096 {
+			cancel()
+		}
+	}
+	n, err := copyImage(ctx, dst, bytes.NewReader(randomImage(16*4096)), cfg, progress, &FlashStats{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("copyImage = %v, want context.Canceled", err)
+	}
+	if n != 2*4096 {
+		t.Errorf("%d bytes written, want the copy to stop after 2 blocks", n)
+	}
+}
+
+func TestBufferPool(t *testing.T) {
+	for _, size := range []int{4096, 12345, 1 << 20} {
+		for i := 0; i < 3; i++ {
+			b := getBuffer(size)
+			if len(b) != size || cap(b) != size {
+				t.Fatalf("getBuffer(%d) has len %d, cap %d", size, len(b), cap(b))
+			}
+			if addr := uintptr(unsafe.Pointer(&b[0])); addr%directAlignment != 0 {
+				t.Fatalf("getBuffer(%d) at %#x is not aligned to %d", size, addr, directAlignment)
+			}
+			// A short final block goes back to the pool for its size.
+			putBuffer(b[:size/2])
+		}
+	}
+	// Buffers of sizes never handed out are dropped.
+	putBuffer(make([]byte, 777))
+	if _, ok := bufferPools.Load(777); ok {
+		t.Error("putBuffer created a pool for a foreign buffer")
+	}
+}
+
+// zeros is an endless image of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// slowCard is a device where every write costs the same, so the fewer,
+// larger writes of the biggest block size are the fastest.
+type slowCard struct {
+	writes []int
+	syncs  []int
+}
+
+func (c *slowCard) Write(p []byte) (int, error) {
+	c.writes = append(c.writes, len(p))
+	time.Sleep(time.Millisecond)
+	return len(p), nil
+}
+
+func (c *slowCard) Sync() error {
+	c.syncs = append(c.syncs, len(c.writes))
+	return nil
+}
+
+func TestCopyImageAutoTune(t *testing.T) {
+	if testing.Short() {
+		t.Skip("copies 300 MiB")
+	}
+	const rest = 40 << 20
+	size := int64(len(autoTuneSizes))*autoTuneSpan + rest
+	dst := &slowCard{}
+	cfg := FlashConfig{AutoTune: true, BlockSize: 4096, QueueDepth: 2}
+	n, err := copyImage(context.Background(), dst, io.LimitReader(zeros{}, size), cfg, nil, &FlashStats{})
+	if err != nil || n != size {
+		t.Fatalf("copyImage = %d, %v, want %d bytes", n, err, size)
+	}
+
+	var want []int
+	for _, s := range autoTuneSizes {
+		for i := 0; i < autoTuneSpan/s; i++ {
+			want = append(want, s)
+		}
+	}
+	// The largest size wins and is used for the rest of the image.
+	best := autoTuneSizes[len(autoTuneSizes)-1]
+	want = append(want, best, best, rest-2*best)
+	if !slices.Equal(dst.writes, want) {
+		t.Fatalf("wrote %d blocks, want %d: the sizes tried in turn, then %d", len(dst.writes), len(want), best)
+	}
+	// Each phase ends with a sync.
+	var phaseEnds []int
+	writes := 0
+	for _, s := range autoTuneSizes {
+		writes += autoTuneSpan / s
+		phaseEnds = append(phaseEnds, writes)
+	}
+	if !slices.Equal(dst.syncs, phaseEnds) {
+		t.Errorf("synced after writes %v, want after each phase %v", dst.syncs, phaseEnds)
+	}
+}
+
+func TestDirectFileTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "card")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A plain descriptor stands in for the O_DIRECT one, which tmpfs
+	// refuses.
+	d := &directFile{File: f, path: path}
+	image := randomImage(2*8192 + 5000)
+	for _, block := range [][]byte{image[:8192], image[8192 : 2*8192], image[2*8192:]} {
+		if n, err := d.Write(block); n != len(block) || err != nil {
+			t.Fatalf("Write(%d bytes) = %d, %v", len(block), n, err)
+		}
+	}
+	if d.tail == nil {
+		t.Error("unaligned tail not written through the buffered descriptor")
+	}
+	if err := d.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, image) {
+		t.Fatalf("card holds %d bytes differing from the %d byte image", len(got), len(image))
+	}
+}
+
+func TestOpenDirect(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "card")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d, err := openDirect(path)
+	switch {
+	case directFlag == 0:
+		if sdwire.CodeOf(err) != sdwire.CodeUnsupported {
+			t.Fatalf("openDirect without O_DIRECT = %v, want CodeUnsupported", err)
+		}
+	case errors.Is(err, syscall.EINVAL):
+		t.Skip("the temporary directory does not support O_DIRECT")
+	case err != nil:
+		t.Fatal(err)
+	default:
+		d.Close()
+	}
+}
+
+func TestFlashDirectBlockSize(t *testing.T) {
+	dir := t.TempDir()
+	image := filepath.Join(dir, "image")
+	if err := os.WriteFile(image, randomImage(8192), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := flash(context.Background(), image, filepath.Join(dir, "card"), FlashConfig{Direct: true, BlockSize: 5000})
+	if sdwire.CodeOf(err) != sdwire.CodeInvalidArgument {
+		t.Fatalf("flash with an unaligned direct block size = %v, want CodeInvalidArgument", err)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !freebsd
+
+package workflow
+
+import (
+	"errors"
+
+	"github.com/fcjr/sdwire"
+)
+
+// directFlag is zero where O_DIRECT is not available.
+const directFlag = 0
+
+var errDirectUnsupported = sdwire.WithCode(sdwire.CodeUnsupported, errors.New("direct I/O is not supported on this platform"))
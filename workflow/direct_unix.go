@@ -0,0 +1,9 @@
+//go:build linux || freebsd
+
+package workflow
+
+import "syscall"
+
+const directFlag = syscall.O_DIRECT
+
+var errDirectUnsupported error
@@ -0,0 +1,154 @@
+package workflow
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/fcjr/sdwire"
+)
+
+// ErrDUTDown is returned by WaitForDUT when the DUT does not come up: the
+// image was written, but it never booted.
+var ErrDUTDown = errors.New("DUT did not come up")
+
+// dutPollInterval is the pause between rounds of WaitForDUT checks.
+const dutPollInterval = 2 * time.Second
+
+// dutCheckTimeout bounds each attempt of a check.
+const dutCheckTimeout = 5 * time.Second
+
+// Check tells whether a DUT is up. Run returns nil once it is.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// CheckFunc wraps an arbitrary function as a check.
+func CheckFunc(name string, fn func(ctx context.Context) error) Check {
+	return Check{Name: name, Run: fn}
+}
+
+// Ping checks that host answers an ICMP echo request. It runs the system
+// ping command, which can send them without privileges.
+func Ping(host string) Check {
+	return CheckFunc("ping "+host, func(ctx context.Context) error {
+		out, err := exec.CommandContext(ctx, "ping", "-c", "1", host).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	})
+}
+
+// TCPPort checks that addr, a host and port, accepts TCP connections.
+func TCPPort(addr string) Check {
+	return CheckFunc("tcp "+addr, func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+}
+
+// SSHBanner checks that an SSH server answers at addr. The port defaults
+// to 22. Waiting for the banner rather than the port catches servers that
+// accept connections before they are ready to log in.
+func SSHBanner(addr string) Check {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	return CheckFunc("ssh "+addr, func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetReadDeadline(deadline)
+		}
+		banner, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read SSH banner from %s: %w", addr, err)
+		}
+		if !strings.HasPrefix(banner, "SSH-") {
+			return fmt.Errorf("%s is not an SSH server: %q", addr, strings.TrimSpace(banner))
+		}
+		return nil
+	})
+}
+
+// HTTPHealth checks that a GET of url succeeds with a 2xx status, e.g. from
+// a health endpoint of the DUT's application.
+func HTTPHealth(url string) Check {
+	return CheckFunc("http "+url, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("GET %s: %s", url, resp.Status)
+		}
+		return nil
+	})
+}
+
+// WaitForDUT runs the checks every few seconds until they all pass, and
+// fails with ErrDUTDown when ctx is done first. Checks that passed once
+// are not run again.
+func WaitForDUT(ctx context.Context, checks ...Check) error {
+	if len(checks) == 0 {
+		return sdwire.WithCode(sdwire.CodeInvalidArgument, errors.New("no DUT checks given"))
+	}
+	pending := checks
+	for {
+		var failed []Check
+		var lastErr error
+		for _, check := range pending {
+			if err := runCheck(ctx, check); err != nil {
+				failed = append(failed, check)
+				lastErr = fmt.Errorf("%s: %w", check.Name, err)
+			}
+		}
+		if len(failed) == 0 {
+			return nil
+		}
+		pending = failed
+
+		select {
+		case <-ctx.Done():
+			return sdwire.WithCode(sdwire.CodeTimeout, fmt.Errorf("%w: %v", ErrDUTDown, lastErr))
+		case <-time.After(dutPollInterval):
+		}
+	}
+}
+
+func runCheck(ctx context.Context, check Check) error {
+	ctx, cancel := context.WithTimeout(ctx, dutCheckTimeout)
+	defer cancel()
+	return check.Run(ctx)
+}
+
+// WaitForBoot waits for the DUT to come up after switching to the target;
+// see WaitForDUT. It is usually the last step, with a timeout:
+//
+//	workflow.WaitForBoot(workflow.SSHBanner("10.0.3.14")).WithTimeout(3 * time.Minute)
+func WaitForBoot(checks ...Check) Step {
+	return Func("wait for DUT", func(ctx context.Context) error {
+		return WaitForDUT(ctx, checks...)
+	})
+}
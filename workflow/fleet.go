@@ -0,0 +1,188 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcjr/sdwire"
+)
+
+// Limits bounds how many devices RunAll works on at once. Devices behind
+// the same root port share its USB bandwidth, so flashing many of them at
+// once makes each slower without finishing the batch sooner.
+type Limits struct {
+	// PerHost is the maximum number of concurrent runs. Zero means no
+	// limit.
+	PerHost int
+	// PerHub is the maximum number of concurrent runs on devices behind the
+	// same root port. Zero means no limit.
+	PerHub int
+}
+
+// DefaultLimits suits USB 3 card readers behind a few hubs.
+var DefaultLimits = Limits{PerHost: 8, PerHub: 2}
+
+// Result is the outcome of running a workflow on one device of a batch.
+type Result struct {
+	Info   *sdwire.DeviceInfo
+	Report *Report
+	Err    error
+}
+
+// RunAll runs the workflow on every device, concurrently within limits,
+// and returns the results in the order of devices. Devices are started
+// round-robin across hubs so that every hub is kept busy. Devices not yet
+// started when ctx is done fail with its error.
+func (w *Workflow) RunAll(ctx context.Context, devices []*sdwire.DeviceInfo, limits Limits, opts ...sdwire.Option) []Result {
+	results := make([]Result, len(devices))
+	for i, info := range devices {
+		results[i].Info = info
+	}
+
+	var (
+		mu      sync.Mutex
+		cond    = sync.NewCond(&mu)
+		running int
+		perHub  = make(map[string]int)
+		wg      sync.WaitGroup
+	)
+	// Wake the dispatcher when ctx is done.
+	stop := context.AfterFunc(ctx, func() {
+		mu.Lock()
+		cond.Broadcast()
+		mu.Unlock()
+	})
+	defer stop()
+
+	pending := interleaveHubs(devices)
+	mu.Lock()
+	for len(pending) > 0 && ctx.Err() == nil {
+		next := -1
+		for j, i := range pending {
+			hub := rootPort(devices[i].PortPath)
+			if (limits.PerHost == 0 || running < limits.PerHost) && (limits.PerHub == 0 || perHub[hub] < limits.PerHub) {
+				next = j
+				break
+			}
+		}
+		if next < 0 {
+			cond.Wait()
+			continue
+		}
+		i := pending[next]
+		pending = append(pending[:next], pending[next+1:]...)
+		hub := rootPort(devices[i].PortPath)
+		running++
+		perHub[hub]++
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i].Report, results[i].Err = w.runOn(ctx, devices[i], opts)
+			mu.Lock()
+			running--
+			perHub[hub]--
+			cond.Broadcast()
+			mu.Unlock()
+		}()
+	}
+	for _, i := range pending {
+		results[i].Err = ctx.Err()
+	}
+	mu.Unlock()
+	wg.Wait()
+	return results
+}
+
+// runOn opens a listed device and runs the workflow on it.
+func (w *Workflow) runOn(ctx context.Context, info *sdwire.DeviceInfo, opts []sdwire.Option) (*Report, error) {
+	dev, err := w.open(info, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer dev.Close()
+	return w.Run(ctx, dev)
+}
+
+// interleaveHubs returns the indexes of devices ordered round-robin across
+// their root ports, keeping the given order within each hub.
+func interleaveHubs(devices []*sdwire.DeviceInfo) []int {
+	var (
+		hubs  []string
+		queue = make(map[string][]int)
+	)
+	for i, info := range devices {
+		hub := rootPort(info.PortPath)
+		if _, ok := queue[hub]; !ok {
+			hubs = append(hubs, hub)
+		}
+		queue[hub] = append(queue[hub], i)
+	}
+	order := make([]int, 0, len(devices))
+	for len(order) < len(devices) {
+		for _, hub := range hubs {
+			if q := queue[hub]; len(q) > 0 {
+				order = append(order, q[0])
+				queue[hub] = q[1:]
+			}
+		}
+	}
+	return order
+}
+
+// rootPort returns the bus and root hub port of a port path, e.g. "1-2"
+// for "1-2.3.1".
+func rootPort(portPath string) string {
+	if i := strings.IndexByte(portPath, '.'); i >= 0 {
+		return portPath[:i]
+	}
+	return portPath
+}
+
+// FlashCard polls for the card's block device every cardPollInterval for
+// up to cardWaitTimeout.
+const (
+	cardPollInterval = 250 * time.Millisecond
+	cardWaitTimeout  = 30 * time.Second
+)
+
+// FlashCard is like Flash but writes to the card's block device, waiting
+// for it to appear after switching to the host.
+func FlashCard(imagePath string) Step {
+	return Step{
+		Name: "flash",
+		Run: func(ctx context.Context, dev *sdwire.SDWire) error {
+			var devices []string
+			deadline := time.Now().Add(cardWaitTimeout)
+			for {
+				var err error
+				devices, err = dev.BlockDevices()
+				if err != nil {
+					return err
+				}
+				if len(devices) > 0 {
+					break
+				}
+				if time.Now().After(deadline) {
+					return sdwire.WithCode(sdwire.CodeCardMissing, errors.New("no block device found for the card"))
+				}
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(cardPollInterval):
+				}
+			}
+			return Flash(imagePath, devices[0]).Run(ctx, dev)
+		},
+	}
+}
+
+// FlashAll switches every device to the host, writes the image to its card
+// and switches it back to the target, scheduling the devices within limits.
+func FlashAll(ctx context.Context, devices []*sdwire.DeviceInfo, imagePath string, limits Limits, opts ...sdwire.Option) []Result {
+	w := New(SwitchToHost(), FlashCard(imagePath), SwitchToTarget())
+	return w.RunAll(ctx, devices, limits, opts...)
+}
@@ -0,0 +1,171 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fcjr/sdwire"
+)
+
+// simulate makes w open simulated devices in place of listed ones.
+func simulate(t *testing.T, w *Workflow) {
+	dir := t.TempDir()
+	w.open = func(info *sdwire.DeviceInfo, opts ...sdwire.Option) (*sdwire.SDWire, error) {
+		if info.Serial == "unplugged" {
+			return nil, sdwire.WithCode(sdwire.CodeNotFound, errors.New("device unplugged"))
+		}
+		return sdwire.OpenSimulator(&sdwire.Simulator{
+			Serial:     info.Serial,
+			CardPath:   filepath.Join(dir, info.Serial+".img"),
+			DevicePath: filepath.Join(dir, info.Serial+".dev"),
+		}, append(opts, sdwire.WithoutLock())...)
+	}
+}
+
+// fleet returns n devices behind each of the given root ports, named
+// after their port paths.
+func fleet(hubs []string, n int) []*sdwire.DeviceInfo {
+	var devices []*sdwire.DeviceInfo
+	for _, hub := range hubs {
+		for i := 1; i <= n; i++ {
+			port := fmt.Sprintf("%s.%d", hub, i)
+			devices = append(devices, &sdwire.DeviceInfo{ID: port, Serial: port, PortPath: port})
+		}
+	}
+	return devices
+}
+
+// inFlight counts the runs in progress, in total and per hub, and the
+// maxima reached.
+type inFlight struct {
+	mu             sync.Mutex
+	total, maxTot  int
+	perHub, maxHub map[string]int
+}
+
+func (f *inFlight) step(d time.Duration) Step {
+	return Step{
+		Name: "work",
+		Run: func(ctx context.Context, dev *sdwire.SDWire) error {
+			// Simulated devices are named after their port paths.
+			hub := rootPort(dev.GetSerial())
+			f.mu.Lock()
+			f.total++
+			f.perHub[hub]++
+			f.maxTot = max(f.maxTot, f.total)
+			f.maxHub[hub] = max(f.maxHub[hub], f.perHub[hub])
+			f.mu.Unlock()
+
+			time.Sleep(d)
+
+			f.mu.Lock()
+			f.total--
+			f.perHub[hub]--
+			f.mu.Unlock()
+			if dev.GetSerial() == "1-2.2" {
+				return errors.New("card write failed")
+			}
+			return nil
+		},
+	}
+}
+
+func TestRunAllLimits(t *testing.T) {
+	hubs := []string{"1-1", "1-2", "2-1"}
+	tests := []struct {
+		limits  Limits
+		maxTot  int
+		maxHubs int
+	}{
+		{Limits{PerHost: 4, PerHub: 2}, 4, 2},
+		// Round-robin across hubs puts two runs behind different hubs.
+		{Limits{PerHost: 2}, 2, 1},
+		{Limits{PerHub: 1}, 3, 1},
+		{Limits{}, 12, 4},
+	}
+	for _, tt := range tests {
+		f := &inFlight{perHub: make(map[string]int), maxHub: make(map[string]int)}
+		w := New(f.step(20 * time.Millisecond))
+		simulate(t, w)
+		devices := fleet(hubs, 4)
+		results := w.RunAll(context.Background(), devices, tt.limits)
+
+		if f.maxTot != tt.maxTot {
+			t.Errorf("%+v: %d runs at once, want %d", tt.limits, f.maxTot, tt.maxTot)
+		}
+		for _, hub := range hubs {
+			if f.maxHub[hub] != tt.maxHubs {
+				t.Errorf("%+v: %d runs at once behind %s, want %d", tt.limits, f.maxHub[hub], hub, tt.maxHubs)
+			}
+		}
+		for i, r := range results {
+			if r.Info != devices[i] || r.Report == nil {
+				t.Fatalf("%+v: result %d is %+v, want a report on %s", tt.limits, i, r, devices[i].PortPath)
+			}
+		}
+	}
+}
+
+func TestRunAllErrors(t *testing.T) {
+	f := &inFlight{perHub: make(map[string]int), maxHub: make(map[string]int)}
+	w := New(f.step(0), SwitchToHost())
+	simulate(t, w)
+	devices := fleet([]string{"1-1", "1-2"}, 2)
+	devices[1].Serial = "unplugged"
+	results := w.RunAll(context.Background(), devices, DefaultLimits)
+
+	for i, r := range results {
+		switch port := devices[i].PortPath; port {
+		case "1-1.2":
+			if sdwire.CodeOf(r.Err) != sdwire.CodeNotFound || r.Report != nil {
+				t.Errorf("%s: %v, %+v, want the open error and no report", port, r.Err, r.Report)
+			}
+		case "1-2.2":
+			if r.Err == nil || r.Report == nil || r.Report.Failed() == nil || r.Report.Failed().Name != "work" || len(r.Report.Steps) != 1 {
+				t.Errorf("%s: %v, %+v, want a failed work step and nothing after it", port, r.Err, r.Report)
+			}
+		default:
+			if r.Err != nil || r.Report == nil || len(r.Report.Steps) != 2 {
+				t.Errorf("%s: %v, %+v, want both steps to succeed", port, r.Err, r.Report)
+			}
+		}
+	}
+}
+
+func TestRunAllCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := New(Step{Name: "wait", Run: func(ctx context.Context, _ *sdwire.SDWire) error {
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	simulate(t, w)
+	results := w.RunAll(ctx, fleet([]string{"1-1"}, 3), Limits{PerHost: 1})
+
+	if !errors.Is(results[0].Err, context.Canceled) || results[0].Report == nil {
+		t.Errorf("running device: %v, %+v, want a report of the canceled step", results[0].Err, results[0].Report)
+	}
+	for _, r := range results[1:] {
+		if !errors.Is(r.Err, context.Canceled) || r.Report != nil {
+			t.Errorf("%s: %v, %+v, want context.Canceled without starting", r.Info.PortPath, r.Err, r.Report)
+		}
+	}
+}
+
+func TestInterleaveHubs(t *testing.T) {
+	devices := []*sdwire.DeviceInfo{
+		{PortPath: "1-1.1"}, {PortPath: "1-1.2"}, {PortPath: "1-1.3"},
+		{PortPath: "1-2"}, {PortPath: "2-1.4.1"}, {PortPath: "2-1.4.2"},
+	}
+	want := []int{0, 3, 4, 1, 5, 2}
+	if got := interleaveHubs(devices); !slices.Equal(got, want) {
+		t.Errorf("interleaveHubs = %v, want %v", got, want)
+	}
+}
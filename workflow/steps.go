@@ -0,0 +1,342 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/fcjr/sdwire"
+	"github.com/fcjr/sdwire/power"
+)
+
+// copyBufferSize is the chunk size used when writing and verifying images.
+const copyBufferSize = 4 << 20
+
+// FlashLowMemory copies in smaller chunks and flushes the device every
+// lowMemorySyncInterval bytes.
+const (
+	lowMemoryBufferSize   = 256 << 10
+	lowMemorySyncInterval = 32 << 20
+)
+
+// Func wraps an arbitrary function as a step.
+func Func(name string, fn func(ctx context.Context) error) Step {
+	return Step{
+		Name: name,
+		Run: func(ctx context.Context, _ *sdwire.SDWire) error {
+			return fn(ctx)
+		},
+	}
+}
+
+// SwitchToHost connects the SD card to the host.
+func SwitchToHost() Step {
+	return switchTo(sdwire.ModeHost)
+}
+
+// SwitchToTarget connects the SD card to the DUT.
+func SwitchToTarget() Step {
+	return switchTo(sdwire.ModeTarget)
+}
+
+func switchTo(mode sdwire.SwitchMode) Step {
+	return Step{
+		Name: "switch-" + mode.String(),
+		Run: func(_ context.Context, dev *sdwire.SDWire) error {
+			return dev.SetMode(mode)
+		},
+	}
+}
+
+// PowerOn powers the DUT on.
+func PowerOn(c power.Controller) Step {
+	return Func("power-on", c.On)
+}
+
+// PowerOff powers the DUT off.
+func PowerOff(c power.Controller) Step {
+	return Func("power-off", c.Off)
+}
+
+// PowerCycle powers the DUT off and on again.
+func PowerCycle(c power.Controller) Step {
+	return Func("power-cycle", c.Cycle)
+}
+
+// Sleep pauses for d, e.g. to let the card reader enumerate after switching.
+func Sleep(d time.Duration) Step {
+	return Func("sleep", func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+			return nil
+		}
+	})
+}
+
+// Flash writes the image at imagePath to the block device at devicePath.
+// The card must already be switched to the host. Each attempt is recorded
+// in the device's audit log. Images compressed with gzip, bzip2, xz or zstd
+// are decompressed as they are written; xz and zstd need the xz and zstd
+// commands.
+func Flash(imagePath, devicePath string) Step {
+	return FlashWith(imagePath, devicePath, FlashConfig{})
+}
+
+// FlashWithProgress is like Flash but calls progress after each chunk with
+// the number of bytes written so far and the size of the image.
+func FlashWithProgress(imagePath, devicePath string, progress func(written, total int64)) Step {
+	return FlashWith(imagePath, devicePath, FlashConfig{Progress: progress})
+}
+
+// FlashLowMemory is like Flash but bounds memory use on small lab
+// controllers such as Raspberry Pis. Flash leaves write-back to the kernel,
+// which lets the whole image pile up as dirty pages in the page cache when
+// the card is slower than the image source; FlashLowMemory copies through
+// a small buffer and flushes the device every few megabytes.
+func FlashLowMemory(imagePath, devicePath string) Step {
+	return FlashWith(imagePath, devicePath, FlashConfig{
+		BlockSize: lowMemoryBufferSize,
+		SyncEvery: lowMemorySyncInterval,
+	})
+}
+
+// FlashWith is like Flash but copies as cfg describes, e.g. with larger
+// blocks and read-ahead to keep fast UHS readers busy.
+func FlashWith(imagePath, devicePath string, cfg FlashConfig) Step {
+	return Step{
+		Name: "flash",
+		Run: func(ctx context.Context, dev *sdwire.SDWire) error {
+			start := time.Now()
+			n, err := flash(ctx, imagePath, devicePath, cfg)
+			dev.RecordFlash(n, time.Since(start))
+			var aborted *FlashAbortedError
+			switch {
+			case errors.As(err, &aborted) && dev != nil:
+				dev.MarkCardDirty(fmt.Sprintf("flash of %s aborted after %d bytes", imagePath, n))
+				aborted.MarkedDirty = true
+			case err == nil:
+				dev.MarkCardClean()
+			}
+			dev.Audit("flash", imagePath+" -> "+devicePath, err)
+			return err
+		},
+	}
+}
+
+// zeroSize is how much of the card FlashConfig.ZeroOnAbort zeroes: enough
+// for an MBR or GPT and the boot loaders SoCs load from the first sectors.
+const zeroSize = 1 << 20
+
+// FlashAbortedError reports a flash that was canceled or failed after it
+// started writing the card, and the cleanup done afterwards. The card
+// holds part of the image, so it must not be booted before a complete
+// flash.
+type FlashAbortedError struct {
+	Device string
+	// Written is the number of bytes written before the flash stopped.
+	Written int64
+	// Synced is set if what was written was flushed to the card.
+	Synced bool
+	// Zeroed is set if the start of the card was zeroed; see
+	// FlashConfig.ZeroOnAbort.
+	Zeroed bool
+	// MarkedDirty is set once the card is marked dirty; see
+	// sdwire.SDWire.MarkCardDirty.
+	MarkedDirty bool
+	Err         error
+	// CleanupErr is set if syncing or zeroing the card failed.
+	CleanupErr error
+}
+
+func (e *FlashAbortedError) Error() string {
+	msg := fmt.Sprintf("flash aborted after %d bytes: %v", e.Written, e.Err)
+	if e.CleanupErr != nil {
+		msg += fmt.Sprintf(" (cleanup failed: %v)", e.CleanupErr)
+	}
+	return msg
+}
+
+func (e *FlashAbortedError) Unwrap() error { return e.Err }
+
+// flash copies the image to the device as cfg describes.
+func flash(ctx context.Context, imagePath, devicePath string, cfg FlashConfig) (int64, error) {
+	img, size, err := openImage(imagePath)
+	if err != nil {
+		return 0, err
+	}
+	defer img.Close()
+	var report func(int64)
+	if cfg.Progress != nil {
+		report = func(written int64) { cfg.Progress(written, size) }
+	}
+
+	var dst interface {
+		syncWriter
+		io.Closer
+	}
+	if cfg.Direct {
+		if cfg.BlockSize%directAlignment != 0 {
+			return 0, sdwire.WithCode(sdwire.CodeInvalidArgument, fmt.Errorf("block size %d is not a multiple of %d", cfg.BlockSize, directAlignment))
+		}
+		dst, err = openDirect(devicePath)
+	} else {
+		dst, err = os.OpenFile(devicePath, os.O_WRONLY, 0)
+	}
+	if err != nil {
+		return 0, err
+	}
+	var stats FlashStats
+	n, err := copyImage(ctx, dst, img, cfg, report, &stats)
+	if err != nil {
+		return n, abortFlash(dst, devicePath, n, cfg, fmt.Errorf("failed to write %s: %w", devicePath, err))
+	}
+	syncStart := time.Now()
+	if err := dst.Sync(); err != nil {
+		return n, abortFlash(dst, devicePath, n, cfg, fmt.Errorf("failed to sync %s: %w", devicePath, err))
+	}
+	stats.Write += time.Since(syncStart)
+	stats.Total += time.Since(syncStart)
+	sdwire.RecordPerf("flash/read", stats.Read)
+	sdwire.RecordPerf("flash/write", stats.Write)
+	sdwire.RecordPerf("flash/total", stats.Total)
+	if cfg.Stats != nil {
+		cfg.Stats(stats)
+	}
+	return n, dst.Close()
+}
+
+// abortFlash cleans up after a flash that stopped after writing n bytes,
+// and closes dst. Flashes that wrote nothing leave the card as it was.
+func abortFlash(dst interface {
+	syncWriter
+	io.Closer
+}, devicePath string, n int64, cfg FlashConfig, err error) error {
+	if n == 0 {
+		dst.Close()
+		return err
+	}
+	e := &FlashAbortedError{Device: devicePath, Written: n, Err: err}
+	if serr := dst.Sync(); serr != nil {
+		e.CleanupErr = fmt.Errorf("failed to sync %s: %w", devicePath, serr)
+	} else {
+		e.Synced = true
+	}
+	dst.Close()
+	if cfg.ZeroOnAbort {
+		if zerr := zeroStart(devicePath); zerr != nil {
+			e.CleanupErr = errors.Join(e.CleanupErr, zerr)
+		} else {
+			e.Zeroed = true
+		}
+	}
+	return e
+}
+
+// zeroStart zeroes the first zeroSize bytes of the device at devicePath.
+func zeroStart(devicePath string) error {
+	f, err := os.OpenFile(devicePath, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to zero %s: %w", devicePath, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt(make([]byte, zeroSize), 0); err != nil {
+		return fmt.Errorf("failed to zero %s: %w", devicePath, err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to zero %s: %w", devicePath, err)
+	}
+	return nil
+}
+
+// Dump reads the block device at devicePath into a file at imagePath, e.g.
+// to keep the state a DUT left its card in. A size of zero reads the whole
+// device. The card must already be switched to the host. The image is
+// written beside imagePath and only renamed into place once complete, so
+// a canceled dump leaves nothing behind.
+func Dump(devicePath, imagePath string, size int64) Step {
+	return Func("dump", func(ctx context.Context) error {
+		src, err := os.Open(devicePath)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		var r io.Reader = src
+		if size > 0 {
+			r = io.LimitReader(src, size)
+		}
+
+		partial := imagePath + ".partial"
+		dst, err := os.Create(partial)
+		if err != nil {
+			return err
+		}
+		var stats FlashStats
+		n, err := copyImage(ctx, dst, r, FlashConfig{}, nil, &stats)
+		if err == nil {
+			err = dst.Sync()
+		}
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(partial, imagePath)
+		}
+		if err != nil {
+			os.Remove(partial)
+			return fmt.Errorf("failed to dump %s after %d bytes: %w", devicePath, n, err)
+		}
+		return nil
+	})
+}
+
+// Verify compares the start of the block device at devicePath with the
+// image at imagePath, decompressing it like Flash.
+func Verify(imagePath, devicePath string) Step {
+	return Func("verify", func(ctx context.Context) error {
+		img, _, err := openImage(imagePath)
+		if err != nil {
+			return err
+		}
+		defer img.Close()
+
+		dev, err := os.Open(devicePath)
+		if err != nil {
+			return err
+		}
+		defer dev.Close()
+
+		want := getBuffer(copyBufferSize)
+		defer putBuffer(want)
+		got := getBuffer(copyBufferSize)
+		defer putBuffer(got)
+		var offset int64
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			n, err := io.ReadFull(img, want)
+			if n > 0 {
+				if _, err := io.ReadFull(dev, got[:n]); err != nil {
+					return fmt.Errorf("failed to read %s at offset %d: %w", devicePath, offset, err)
+				}
+				if !bytes.Equal(want[:n], got[:n]) {
+					return sdwire.WithCode(sdwire.CodeVerifyFailed,
+						fmt.Errorf("verification failed: %s differs from %s near offset %d", devicePath, imagePath, offset))
+				}
+				offset += int64(n)
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
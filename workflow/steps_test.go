@@ -0,0 +1,145 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fcjr/sdwire"
+)
+
+// blankCard creates a device file of size bytes of 0xff, like an erased
+// card.
+func blankCard(t *testing.T, size int) string {
+	t.Helper()
+	return writeFile(t, "card", bytes.Repeat([]byte{0xff}, size))
+}
+
+func openSimulator(t *testing.T) *sdwire.SDWire {
+	t.Helper()
+	dir := t.TempDir()
+	dev, err := sdwire.OpenSimulator(&sdwire.Simulator{
+		CardPath:   filepath.Join(dir, "sim.img"),
+		DevicePath: filepath.Join(dir, "sim.dev"),
+	}, sdwire.WithoutLock())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dev.Close() })
+	return dev
+}
+
+func TestFlashAborted(t *testing.T) {
+	const cardSize = 2 * zeroSize
+	for _, zero := range []bool{false, true} {
+		image := randomImage(16 * 4096)
+		imagePath := writeFile(t, "image", image)
+		device := blankCard(t, cardSize)
+		dev := openSimulator(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cfg := FlashConfig{
+			BlockSize:   4096,
+			ZeroOnAbort: zero,
+			Progress:    func(int64, int64) { cancel() },
+		}
+		err := FlashWith(imagePath, device, cfg).Run(ctx, dev)
+		cancel()
+
+		var aborted *FlashAbortedError
+		if !errors.As(err, &aborted) || !errors.Is(err, context.Canceled) {
+			t.Fatalf("zero %t: flash = %v, want a FlashAbortedError wrapping context.Canceled", zero, err)
+		}
+		if aborted.Device != device || aborted.Written != 4096 || !aborted.Synced || aborted.Zeroed != zero || !aborted.MarkedDirty || aborted.CleanupErr != nil {
+			t.Errorf("zero %t: %+v", zero, aborted)
+		}
+		if dev.Stats().CardDirty == "" {
+			t.Errorf("zero %t: card not marked dirty", zero)
+		}
+
+		want := bytes.Repeat([]byte{0xff}, cardSize)
+		if zero {
+			clear(want[:zeroSize])
+		} else {
+			copy(want, image[:4096])
+		}
+		if got, _ := os.ReadFile(device); !bytes.Equal(got, want) {
+			t.Errorf("zero %t: card contents differ from the expected cleanup", zero)
+		}
+
+		if err := FlashWith(imagePath, device, FlashConfig{}).Run(context.Background(), dev); err != nil {
+			t.Fatalf("zero %t: second flash: %v", zero, err)
+		}
+		if reason := dev.Stats().CardDirty; reason != "" {
+			t.Errorf("zero %t: card still dirty after a complete flash: %s", zero, reason)
+		}
+	}
+}
+
+// TestFlashCanceledBeforeWriting checks that a flash stopped before it
+// wrote anything leaves the card alone.
+func TestFlashCanceledBeforeWriting(t *testing.T) {
+	imagePath := writeFile(t, "image", randomImage(4096))
+	device := blankCard(t, 8192)
+	dev := openSimulator(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := FlashWith(imagePath, device, FlashConfig{ZeroOnAbort: true}).Run(ctx, dev)
+	var aborted *FlashAbortedError
+	if !errors.Is(err, context.Canceled) || errors.As(err, &aborted) {
+		t.Fatalf("flash = %v, want context.Canceled without cleanup", err)
+	}
+	if dev.Stats().CardDirty != "" {
+		t.Error("untouched card marked dirty")
+	}
+	if got, _ := os.ReadFile(device); !bytes.Equal(got, bytes.Repeat([]byte{0xff}, 8192)) {
+		t.Error("untouched card was written")
+	}
+}
+
+type closingCard struct{ card }
+
+func (*closingCard) Close() error { return nil }
+
+func TestAbortFlashCleanupError(t *testing.T) {
+	device := filepath.Join(t.TempDir(), "missing", "card")
+	failure := errors.New("write failed")
+	err := abortFlash(&closingCard{}, device, 10, FlashConfig{ZeroOnAbort: true}, failure)
+	var aborted *FlashAbortedError
+	if !errors.As(err, &aborted) || !errors.Is(err, failure) {
+		t.Fatalf("abortFlash = %v, want a FlashAbortedError wrapping the failure", err)
+	}
+	if aborted.Zeroed || aborted.CleanupErr == nil {
+		t.Errorf("zeroing a missing device: zeroed %t, cleanup error %v", aborted.Zeroed, aborted.CleanupErr)
+	}
+}
+
+func TestDump(t *testing.T) {
+	image := randomImage(3*4096 + 1)
+	device := writeFile(t, "card", image)
+	dir := t.TempDir()
+
+	out := filepath.Join(dir, "dump")
+	if err := Dump(device, out, 4096).Run(context.Background(), nil); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+	if got, _ := os.ReadFile(out); !bytes.Equal(got, image[:4096]) {
+		t.Errorf("dump of 4096 bytes holds %d bytes", len(got))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	canceled := filepath.Join(dir, "canceled")
+	if err := Dump(device, canceled, 0).Run(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled Dump = %v, want context.Canceled", err)
+	}
+	for _, path := range []string{canceled, canceled + ".partial"} {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("canceled dump left %s behind", filepath.Base(path))
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Package workflow runs the flash-and-boot pipeline most SDWire users build:
+// switch the card to the host, write an image, verify it, switch back to the
+// target and wait for the DUT, with per-step timeouts, retries and hooks.
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fcjr/sdwire"
+)
+
+// Step is a single unit of work in a workflow.
+type Step struct {
+	// Name identifies the step in reports and hooks.
+	Name string
+	// Run performs the step. It must honor ctx cancellation.
+	Run func(ctx context.Context, dev *sdwire.SDWire) error
+	// Timeout bounds each attempt. Zero means no timeout.
+	Timeout time.Duration
+	// Retries is the number of additional attempts after a failure.
+	Retries int
+	// RetryDelay is the pause between attempts.
+	RetryDelay time.Duration
+}
+
+// WithTimeout returns a copy of the step with the given per-attempt timeout.
+func (s Step) WithTimeout(d time.Duration) Step {
+	s.Timeout = d
+	return s
+}
+
+// WithRetries returns a copy of the step that is retried n times, pausing
+// delay between attempts.
+func (s Step) WithRetries(n int, delay time.Duration) Step {
+	s.Retries = n
+	s.RetryDelay = delay
+	return s
+}
+
+// Hooks are called around each step. Any of them may be nil.
+type Hooks struct {
+	BeforeStep func(step string)
+	AfterStep  func(result StepResult)
+	OnRetry    func(step string, attempt int, err error)
+}
+
+// StepResult is the outcome of a single step.
+type StepResult struct {
+	Name     string
+	Attempts int
+	Duration time.Duration
+	Err      error
+}
+
+// Report is the outcome of a workflow run.
+type Report struct {
+	Steps    []StepResult
+	Duration time.Duration
+}
+
+// Failed returns the first failed step, or nil if the workflow succeeded.
+func (r *Report) Failed() *StepResult {
+	for i := range r.Steps {
+		if r.Steps[i].Err != nil {
+			return &r.Steps[i]
+		}
+	}
+	return nil
+}
+
+// Workflow is an ordered list of steps.
+type Workflow struct {
+	steps []Step
+	hooks Hooks
+
+	// open opens the devices of RunAll; tests replace it.
+	open func(info *sdwire.DeviceInfo, opts ...sdwire.Option) (*sdwire.SDWire, error)
+}
+
+// New creates a workflow from steps.
+func New(steps ...Step) *Workflow {
+	return &Workflow{steps: steps, open: sdwire.OpenInfo}
+}
+
+// WithHooks sets the hooks called around each step.
+func (w *Workflow) WithHooks(h Hooks) *Workflow {
+	w.hooks = h
+	return w
+}
+
+// Run executes the steps in order, stopping at the first step that fails
+// after exhausting its retries.
+func (w *Workflow) Run(ctx context.Context, dev *sdwire.SDWire) (*Report, error) {
+	start := time.Now()
+	report := &Report{}
+	for _, step := range w.steps {
+		if w.hooks.BeforeStep != nil {
+			w.hooks.BeforeStep(step.Name)
+		}
+		result := w.runStep(ctx, dev, step)
+		report.Steps = append(report.Steps, result)
+		if w.hooks.AfterStep != nil {
+			w.hooks.AfterStep(result)
+		}
+		if result.Err != nil {
+			report.Duration = time.Since(start)
+			return report, fmt.Errorf("step %s failed: %w", step.Name, result.Err)
+		}
+	}
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+func (w *Workflow) runStep(ctx context.Context, dev *sdwire.SDWire, step Step) StepResult {
+	start := time.Now()
+	result := StepResult{Name: step.Name}
+	for {
+		result.Attempts++
+		result.Err = attempt(ctx, dev, step)
+		if result.Err == nil || result.Attempts > step.Retries || ctx.Err() != nil {
+			break
+		}
+		if w.hooks.OnRetry != nil {
+			w.hooks.OnRetry(step.Name, result.Attempts, result.Err)
+		}
+		select {
+		case <-ctx.Done():
+			result.Err = errors.Join(result.Err, ctx.Err())
+			result.Duration = time.Since(start)
+			return result
+		case <-time.After(step.RetryDelay):
+		}
+	}
+	result.Duration = time.Since(start)
+	return result
+}
+
+func attempt(ctx context.Context, dev *sdwire.SDWire, step Step) error {
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, step.Timeout)
+		defer cancel()
+	}
+	return step.Run(ctx, dev)
+}